@@ -0,0 +1,90 @@
+package s3
+
+import (
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/response_type"
+	"encoding/xml"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// AWS principals, like account or role arns and canonical user ids, to the GCS members they stand for
+var PrincipalMapKey = "gcp_destination_config.gcs_config.iam_principal_map"
+
+// Grant headers on a request, keyed by the permission they grant
+func GrantHeaders(header http.Header) map[s3.Permission]*string {
+	grants := make(map[s3.Permission]*string)
+	for permission, name := range map[s3.Permission]string{
+		s3.PermissionFullControl: "x-amz-grant-full-control",
+		s3.PermissionRead:        "x-amz-grant-read",
+		s3.PermissionReadAcp:     "x-amz-grant-read-acp",
+		s3.PermissionWrite:       "x-amz-grant-write",
+		s3.PermissionWriteAcp:    "x-amz-grant-write-acp",
+	} {
+		if value := header.Get(name); value != "" {
+			grants[permission] = &value
+		}
+	}
+	return grants
+}
+
+// Grant headers keyed by the permission they grant, from the grant fields of an S3 input
+func GrantFields(fullControl *string, read *string, readACP *string, write *string, writeACP *string) map[s3.Permission]*string {
+	return map[s3.Permission]*string{
+		s3.PermissionFullControl: fullControl,
+		s3.PermissionRead:        read,
+		s3.PermissionReadAcp:     readACP,
+		s3.PermissionWrite:       write,
+		s3.PermissionWriteAcp:    writeACP,
+	}
+}
+
+// Access control policy in a request body, nil when the body is empty
+func ParseAccessControlPolicy(body io.Reader) (*s3.AccessControlPolicy, error) {
+	if body == nil {
+		return nil, nil
+	}
+	bytes, err := ioutil.ReadAll(body)
+	if err != nil || len(bytes) == 0 {
+		return nil, err
+	}
+	var policy response_type.AccessControlPolicyInput
+	if err := xml.Unmarshal(bytes, &policy); err != nil {
+		return nil, err
+	}
+	return converter.ACLXMLToAWS(&policy), nil
+}
+
+// Replace the rules of a GCS ACL.  Entities no longer granted are removed and the rest set to their new role
+func (handler *Handler) ReplaceACL(acl *storage.ACLHandle, rules []storage.ACLRule) error {
+	existing, err := acl.List(*handler.Context)
+	if err != nil {
+		return err
+	}
+	granted := make(map[storage.ACLEntity]storage.ACLRole)
+	for _, rule := range rules {
+		granted[rule.Entity] = rule.Role
+	}
+	for _, rule := range existing {
+		role, ok := granted[rule.Entity]
+		if !ok {
+			if err := acl.Delete(*handler.Context, rule.Entity); err != nil {
+				return err
+			}
+		} else if role == rule.Role {
+			// already granted, nothing to set
+			delete(granted, rule.Entity)
+		}
+	}
+	for _, rule := range rules {
+		if _, ok := granted[rule.Entity]; ok {
+			if err := acl.Set(*handler.Context, rule.Entity, rule.Role); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package s3
+
+import (
+	"github.com/gorilla/mux"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Base domains that take virtual hosted style requests, like bucket.s3.local/key
+var virtualHostDomainsKey = "virtual_host_domains"
+
+// Routes for one addressing style.  Prefix is the part of the path before the key, which holds the bucket for
+// path style requests and only the credentials, if any, for virtual hosted style requests
+type Addressing struct {
+	Router *mux.Router
+	Prefix string
+}
+
+// Paths that address a bucket itself
+func (addressing Addressing) BucketPaths() []string {
+	if addressing.Prefix == "" {
+		return []string{"/"}
+	}
+	return []string{addressing.Prefix, addressing.Prefix + "/"}
+}
+
+// Path that addresses a key in a bucket
+func (addressing Addressing) KeyPath() string {
+	return addressing.Prefix + "/{key:[^#?\\s]+}"
+}
+
+// Configured virtual host base domains
+func (handler *Handler) VirtualHostDomains() []string {
+	if handler.Config == nil {
+		return nil
+	}
+	return handler.Config.GetStringSlice(virtualHostDomainsKey)
+}
+
+// Whether a request uses one of the virtual host domains
+func (handler *Handler) IsVirtualHost(request *http.Request) bool {
+	host := request.Host
+	if hostOnly, _, err := net.SplitHostPort(host); err == nil {
+		host = hostOnly
+	}
+	host = strings.ToLower(host)
+	for _, domain := range handler.VirtualHostDomains() {
+		if strings.HasSuffix(host, "."+strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Routers to register bucket and object handlers on.  Virtual hosted style requests take the bucket from the
+// host, and path style requests only match hosts that aren't virtual hosts so the two can't shadow each other.
+// Both keep the credentials at the start of the path when they come from the url
+func (handler *Handler) Addressing(router *mux.Router) []Addressing {
+	credsPrefix := ""
+	if handler.Config != nil {
+		keyFromUrl := handler.Config.Get("gcp_destination_config.key_from_url")
+		if keyFromUrl != nil && keyFromUrl == true {
+			credsPrefix = "/{creds}"
+		}
+	}
+	domains := handler.VirtualHostDomains()
+	if len(domains) == 0 {
+		return []Addressing{{Router: router, Prefix: credsPrefix + "/{bucket}"}}
+	}
+	addressing := make([]Addressing, 0, len(domains)+1)
+	for _, domain := range domains {
+		addressing = append(addressing, Addressing{
+			Router: router.Host("{bucket:.+}." + domain).Subrouter(),
+			Prefix: credsPrefix,
+		})
+	}
+	pathStyle := router.MatcherFunc(func(request *http.Request, match *mux.RouteMatch) bool {
+		return !handler.IsVirtualHost(request)
+	}).Subrouter()
+	return append(addressing, Addressing{Router: pathStyle, Prefix: credsPrefix + "/{bucket}"})
+}
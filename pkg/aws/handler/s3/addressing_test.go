@@ -0,0 +1,62 @@
+package s3
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func addressingRouter(config *viper.Viper) *mux.Router {
+	handler := NewHandler(config)
+	router := mux.NewRouter()
+	for _, addressing := range handler.Addressing(router) {
+		for _, bucketPath := range addressing.BucketPaths() {
+			addressing.Router.HandleFunc(bucketPath, func(writer http.ResponseWriter, request *http.Request) {
+				vars := mux.Vars(request)
+				writer.Write([]byte("bucket " + vars["creds"] + " " + vars["bucket"]))
+			}).Methods("GET")
+		}
+		addressing.Router.HandleFunc(addressing.KeyPath(), func(writer http.ResponseWriter, request *http.Request) {
+			vars := mux.Vars(request)
+			writer.Write([]byte("key " + vars["creds"] + " " + vars["bucket"] + " " + vars["key"]))
+		}).Methods("GET")
+	}
+	return router
+}
+
+func route(router *mux.Router, url string) string {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+	return recorder.Body.String()
+}
+
+func TestHandler_Addressing(t *testing.T) {
+	config := viper.New()
+	config.Set("virtual_host_domains", []string{"s3.local"})
+	router := addressingRouter(config)
+	assert.Equal(t, "key  meow a/b.txt", route(router, "http://meow.s3.local:3450/a/b.txt"))
+	assert.Equal(t, "key  cat.hat a", route(router, "http://cat.hat.s3.local/a"))
+	assert.Equal(t, "bucket  meow", route(router, "http://meow.s3.local:3450/"))
+	assert.Equal(t, "key  meow a/b.txt", route(router, "http://localhost:3450/meow/a/b.txt"))
+	assert.Equal(t, "bucket  meow", route(router, "http://localhost:3450/meow"))
+	assert.Equal(t, "bucket  meow", route(router, "http://s3.local:3450/meow/"))
+}
+
+func TestHandler_Addressing_KeyFromUrl(t *testing.T) {
+	config := viper.New()
+	config.Set("virtual_host_domains", []string{"s3.local"})
+	config.Set("gcp_destination_config.key_from_url", true)
+	router := addressingRouter(config)
+	assert.Equal(t, "key secret meow a", route(router, "http://meow.s3.local/secret/a"))
+	assert.Equal(t, "bucket secret meow", route(router, "http://meow.s3.local/secret"))
+	assert.Equal(t, "key secret meow a", route(router, "http://localhost/secret/meow/a"))
+}
+
+func TestHandler_Addressing_PathOnly(t *testing.T) {
+	router := addressingRouter(viper.New())
+	assert.Equal(t, "key  meow a", route(router, "http://meow.s3.local/meow/a"))
+	assert.Equal(t, "bucket  meow", route(router, "http://meow.s3.local/meow"))
+}
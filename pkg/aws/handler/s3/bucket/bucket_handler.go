@@ -10,7 +10,6 @@ import (
 	"cloudsidecar/pkg/response_type"
 	"encoding/xml"
 	"errors"
-	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gorilla/mux"
 	"net/http"
@@ -25,11 +24,41 @@ type Handler struct {
 // Interface for bucket functions
 type Bucket interface {
 	ListHandlev2(writer http.ResponseWriter, request *http.Request)
-	Listv2ParseInput(r *http.Request) (*s3.ListObjectsInput, error)
+	Listv2ParseInput(r *http.Request) (*s3.ListObjectsV2Input, error)
 	ListHandle(writer http.ResponseWriter, request *http.Request)
 	ListParseInput(r *http.Request) (*s3.ListObjectsInput, error)
 	ACLHandle(writer http.ResponseWriter, request *http.Request)
 	ACLParseInput(r *http.Request) (*s3.GetBucketAclInput, error)
+	PutACLHandle(writer http.ResponseWriter, request *http.Request)
+	PutACLParseInput(r *http.Request) (*s3.PutBucketAclInput, error)
+	VersionsHandle(writer http.ResponseWriter, request *http.Request)
+	VersionsParseInput(r *http.Request) (*s3.ListObjectVersionsInput, error)
+	VersioningHandle(writer http.ResponseWriter, request *http.Request)
+	VersioningParseInput(r *http.Request) (*s3.GetBucketVersioningInput, error)
+	PutVersioningHandle(writer http.ResponseWriter, request *http.Request)
+	PutVersioningParseInput(r *http.Request) (*s3.PutBucketVersioningInput, error)
+	LifecycleHandle(writer http.ResponseWriter, request *http.Request)
+	LifecycleParseInput(r *http.Request) (*s3.GetBucketLifecycleConfigurationInput, error)
+	PutLifecycleHandle(writer http.ResponseWriter, request *http.Request)
+	PutLifecycleParseInput(r *http.Request) (*s3.PutBucketLifecycleConfigurationInput, error)
+	DeleteLifecycleHandle(writer http.ResponseWriter, request *http.Request)
+	DeleteLifecycleParseInput(r *http.Request) (*s3.DeleteBucketLifecycleInput, error)
+	CORSHandle(writer http.ResponseWriter, request *http.Request)
+	CORSParseInput(r *http.Request) (*s3.GetBucketCorsInput, error)
+	PutCORSHandle(writer http.ResponseWriter, request *http.Request)
+	PutCORSParseInput(r *http.Request) (*s3.PutBucketCorsInput, error)
+	DeleteCORSHandle(writer http.ResponseWriter, request *http.Request)
+	DeleteCORSParseInput(r *http.Request) (*s3.DeleteBucketCorsInput, error)
+	PolicyHandle(writer http.ResponseWriter, request *http.Request)
+	PolicyParseInput(r *http.Request) (*s3.GetBucketPolicyInput, error)
+	PutPolicyHandle(writer http.ResponseWriter, request *http.Request)
+	PutPolicyParseInput(r *http.Request) (*s3.PutBucketPolicyInput, error)
+	DeletePolicyHandle(writer http.ResponseWriter, request *http.Request)
+	DeletePolicyParseInput(r *http.Request) (*s3.DeleteBucketPolicyInput, error)
+	ObjectLockHandle(writer http.ResponseWriter, request *http.Request)
+	ObjectLockParseInput(r *http.Request) (*s3.GetObjectLockConfigurationInput, error)
+	PutObjectLockHandle(writer http.ResponseWriter, request *http.Request)
+	PutObjectLockParseInput(r *http.Request) (*s3.PutObjectLockConfigurationInput, error)
 	Register(mux *mux.Router)
 	New(s3Handler *s3_handler.Handler) *Handler
 }
@@ -40,21 +69,28 @@ func New(s3Handler *s3_handler.Handler) *Handler {
 
 // Register HTTP patterns to functions
 func (wrapper *Handler) Register(mux *mux.Router) {
-	keyFromUrl := wrapper.Config.Get("gcp_destination_config.key_from_url")
-	if keyFromUrl != nil && keyFromUrl == true {
-		mux.HandleFunc("/{creds}/{bucket}", wrapper.ACLHandle).Queries("acl", "").Methods("GET")
-		mux.HandleFunc("/{creds}/{bucket}/", wrapper.ACLHandle).Queries("acl", "").Methods("GET")
-		mux.HandleFunc("/{creds}/{bucket}", wrapper.ListHandlev2).Queries("list-type", "2").Methods("GET")
-		mux.HandleFunc("/{creds}/{bucket}/", wrapper.ListHandlev2).Queries("list-type", "2").Methods("GET")
-		mux.HandleFunc("/{creds}/{bucket}", wrapper.ListHandle).Methods("GET")
-		mux.HandleFunc("/{creds}/{bucket}/", wrapper.ListHandle).Methods("GET")
-	} else {
-		mux.HandleFunc("/{bucket}", wrapper.ACLHandle).Queries("acl", "").Methods("GET")
-		mux.HandleFunc("/{bucket}/", wrapper.ACLHandle).Queries("acl", "").Methods("GET")
-		mux.HandleFunc("/{bucket}", wrapper.ListHandlev2).Queries("list-type", "2").Methods("GET")
-		mux.HandleFunc("/{bucket}/", wrapper.ListHandlev2).Queries("list-type", "2").Methods("GET")
-		mux.HandleFunc("/{bucket}", wrapper.ListHandle).Methods("GET")
-		mux.HandleFunc("/{bucket}/", wrapper.ListHandle).Methods("GET")
+	for _, addressing := range wrapper.Addressing(mux) {
+		router := addressing.Router
+		for _, bucketPath := range addressing.BucketPaths() {
+			router.HandleFunc(bucketPath, wrapper.ACLHandle).Queries("acl", "").Methods("GET")
+			router.HandleFunc(bucketPath, wrapper.PutACLHandle).Queries("acl", "").Methods("PUT")
+			router.HandleFunc(bucketPath, wrapper.VersionsHandle).Queries("versions", "").Methods("GET")
+			router.HandleFunc(bucketPath, wrapper.VersioningHandle).Queries("versioning", "").Methods("GET")
+			router.HandleFunc(bucketPath, wrapper.PutVersioningHandle).Queries("versioning", "").Methods("PUT")
+			router.HandleFunc(bucketPath, wrapper.LifecycleHandle).Queries("lifecycle", "").Methods("GET")
+			router.HandleFunc(bucketPath, wrapper.PutLifecycleHandle).Queries("lifecycle", "").Methods("PUT")
+			router.HandleFunc(bucketPath, wrapper.DeleteLifecycleHandle).Queries("lifecycle", "").Methods("DELETE")
+			router.HandleFunc(bucketPath, wrapper.CORSHandle).Queries("cors", "").Methods("GET")
+			router.HandleFunc(bucketPath, wrapper.PutCORSHandle).Queries("cors", "").Methods("PUT")
+			router.HandleFunc(bucketPath, wrapper.DeleteCORSHandle).Queries("cors", "").Methods("DELETE")
+			router.HandleFunc(bucketPath, wrapper.PolicyHandle).Queries("policy", "").Methods("GET")
+			router.HandleFunc(bucketPath, wrapper.PutPolicyHandle).Queries("policy", "").Methods("PUT")
+			router.HandleFunc(bucketPath, wrapper.DeletePolicyHandle).Queries("policy", "").Methods("DELETE")
+			router.HandleFunc(bucketPath, wrapper.ObjectLockHandle).Queries("object-lock", "").Methods("GET")
+			router.HandleFunc(bucketPath, wrapper.PutObjectLockHandle).Queries("object-lock", "").Methods("PUT")
+			router.HandleFunc(bucketPath, wrapper.ListHandlev2).Queries("list-type", "2").Methods("GET")
+			router.HandleFunc(bucketPath, wrapper.ListHandle).Methods("GET")
+		}
 	}
 }
 
@@ -90,16 +126,13 @@ func (wrapper *Handler) ListParseInput(request *http.Request) (*s3.ListObjectsIn
 func (wrapper *Handler) ListHandle(writer http.ResponseWriter, request *http.Request) {
 	input, err := wrapper.ListParseInput(request)
 	if err != nil {
-		writer.WriteHeader(400)
-		writer.Write([]byte(fmt.Sprint(err)))
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidBucketName, err.Error())
 		return
 	}
 	bucket := *input.Bucket
 	var response *response_type.AWSListBucketResponse
-	pageSize := 1000
-	if input.MaxKeys != nil {
-		pageSize = int(*input.MaxKeys)
-	}
+	pageSize := converter.ListPageSize(input.MaxKeys)
 	if wrapper.Config.IsSet("gcp_destination_config") {
 		// Use GCS
 		// Log that we are using GCP, get a client based on configurations.  This is from a pool
@@ -109,66 +142,56 @@ func (wrapper *Handler) ListHandle(writer http.ResponseWriter, request *http.Req
 			defer wrapper.ReturnConnection(client, request)
 		}
 		if err != nil {
-			writer.WriteHeader(400)
 			logging.Log.Error("Error a %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
 		bucket = wrapper.BucketRename(bucket)
-		bucketObject := wrapper.GCPClientToBucket(bucket, client)
-		it := bucketObject.Objects(*wrapper.Context, &storage.Query{
-			Delimiter: *input.Delimiter,
-			Prefix:    *input.Prefix,
-			Versions:  false,
-		})
-		response, err = converter.GCSListResponseToAWS(it, input, pageSize)
+		raw, err := wrapper.GCPClientToRaw(client)
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		call := raw.Objects.List(bucket).Projection("full").Delimiter(*input.Delimiter).Prefix(*input.Prefix).Context(*wrapper.Context)
+		response, err = converter.GCSListResponseToAWS(call, input, pageSize)
 		if err != nil {
 			logging.Log.Error("Error %s %s\n", request.RequestURI, err)
-			writer.WriteHeader(400)
-			writer.Write([]byte(fmt.Sprint(err)))
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
+		wrapper.StorageClasses().ContentsToS3(response.Contents)
 	} else {
 		logging.LogUsingAWS()
 		req := wrapper.S3Client.ListObjectsRequest(input)
 		resp, respError := req.Send()
 		if respError != nil {
 			logging.Log.Error("Error %s %s\n", request.RequestURI, respError)
-			writer.WriteHeader(400)
-			writer.Write([]byte(fmt.Sprint(err)))
-		}
-		var contents = make([]*response_type.BucketContent, len(resp.Contents))
-		for i, content := range resp.Contents {
-			contents[i] = &response_type.BucketContent{
-				Key:          *content.Key,
-				LastModified: content.LastModified.Format("2006-01-02T15:04:05.000Z"),
-				ETag:         *content.ETag,
-				Size:         *content.Size,
-				StorageClass: string(content.StorageClass),
-			}
-		}
-		var prefixes = make([]*response_type.BucketCommonPrefix, len(resp.CommonPrefixes))
-		for i, prefix := range resp.CommonPrefixes {
-			prefixes[i] = &response_type.BucketCommonPrefix{
-				Prefix: *prefix.Prefix,
-			}
+			s3_handler.WriteError(writer, request, respError)
+			return
 		}
+		contents := converter.AWSObjectsToContents(resp.Contents)
+		prefixes := converter.AWSPrefixesToXML(resp.CommonPrefixes)
 		response = &response_type.AWSListBucketResponse{
-			XmlNS:                 "http://s3.amazonaws.com/doc/2006-03-01/",
-			Name:                  resp.Name,
-			Prefix:                resp.Prefix,
-			Delimiter:             nil,
-			Marker:                resp.Marker,
-			KeyCount:              int64(len(contents)),
-			MaxKeys:               resp.MaxKeys,
-			IsTruncated:           resp.IsTruncated,
-			Contents:              contents,
-			CommonPrefixes:        prefixes,
-			NextContinuationToken: resp.NextMarker,
+			XmlNS:          "http://s3.amazonaws.com/doc/2006-03-01/",
+			Name:           resp.Name,
+			Prefix:         resp.Prefix,
+			Delimiter:      nil,
+			Marker:         resp.Marker,
+			NextMarker:     resp.NextMarker,
+			KeyCount:       int64(len(contents)),
+			MaxKeys:        resp.MaxKeys,
+			IsTruncated:    resp.IsTruncated,
+			Contents:       contents,
+			CommonPrefixes: prefixes,
 		}
 		if resp.Delimiter != nil && *resp.Delimiter != "" {
 			response.Delimiter = resp.Delimiter
 		}
+		if resp.EncodingType != "" {
+			encodingType := string(resp.EncodingType)
+			response.EncodingType = &encodingType
+		}
 	}
 	output, _ := xml.Marshal(response)
 	writer.Write([]byte(s3_handler.XmlHeader))
@@ -176,13 +199,13 @@ func (wrapper *Handler) ListHandle(writer http.ResponseWriter, request *http.Req
 }
 
 // Parse input for listv2 requests
-func (wrapper *Handler) Listv2ParseInput(request *http.Request) (*s3.ListObjectsInput, error) {
+func (wrapper *Handler) Listv2ParseInput(request *http.Request) (*s3.ListObjectsV2Input, error) {
 	vars := mux.Vars(request)
 	bucket := vars["bucket"]
 	if bucket == "" {
 		return nil, errors.New("no bucket present")
 	}
-	listRequest := &s3.ListObjectsInput{Bucket: &bucket}
+	listRequest := &s3.ListObjectsV2Input{Bucket: &bucket}
 	delim := request.URL.Query().Get("delimiter")
 	listRequest.Delimiter = &delim
 	if encodingType := request.URL.Query().Get("encoding-type"); encodingType == "url" {
@@ -196,32 +219,39 @@ func (wrapper *Handler) Listv2ParseInput(request *http.Request) (*s3.ListObjects
 		listRequest.MaxKeys = &maxKeyInt
 	}
 	if continuationToken := request.URL.Query().Get("continuation-token"); continuationToken != "" {
-		listRequest.Marker = &continuationToken
+		listRequest.ContinuationToken = &continuationToken
 	}
 	prefix := request.URL.Query().Get("prefix")
 	listRequest.Prefix = &prefix
 	if startAfter := request.URL.Query().Get("start-after"); startAfter != "" {
-		listRequest.Marker = &startAfter
+		listRequest.StartAfter = &startAfter
+	}
+	if fetchOwner := request.URL.Query().Get("fetch-owner"); fetchOwner != "" {
+		fetch := fetchOwner == "true"
+		listRequest.FetchOwner = &fetch
 	}
 	return listRequest, nil
 }
 
-// Handle listv2 requests.  These paginate using a token
+// Handle listv2 requests.  These paginate using an opaque token
 func (wrapper *Handler) ListHandlev2(writer http.ResponseWriter, request *http.Request) {
 	input, err := wrapper.Listv2ParseInput(request)
 	if err != nil {
-		writer.WriteHeader(400)
-		writer.Write([]byte(fmt.Sprint(err)))
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidBucketName, err.Error())
 		return
 	}
 	bucket := *input.Bucket
 	var response *response_type.AWSListBucketResponse
-	pageSize := 1000
-	if input.MaxKeys != nil {
-		pageSize = int(*input.MaxKeys)
-	}
+	pageSize := converter.ListPageSize(input.MaxKeys)
 	if wrapper.Config.IsSet("gcp_destination_config") {
 		// Use GCS
+		position, err := converter.ListV2Position(input)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+			return
+		}
 		// Log that we are using GCP, get a client based on configurations.  This is from a pool
 		client, err := wrapper.GCPRequestSetup(request)
 		if client != nil {
@@ -229,66 +259,57 @@ func (wrapper *Handler) ListHandlev2(writer http.ResponseWriter, request *http.R
 			defer wrapper.ReturnConnection(client, request)
 		}
 		if err != nil {
-			writer.WriteHeader(400)
 			logging.Log.Error("Error a %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
 		bucket = wrapper.BucketRename(bucket)
-		bucketObject := wrapper.GCPClientToBucket(bucket, client)
-		it := bucketObject.Objects(*wrapper.Context, &storage.Query{
-			Delimiter: *input.Delimiter,
-			Prefix:    *input.Prefix,
-			Versions:  false,
-		})
-		response, err = converter.GCSListResponseToAWSv2(it, input, pageSize)
+		raw, err := wrapper.GCPClientToRaw(client)
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		call := raw.Objects.List(bucket).Projection("full").Delimiter(*input.Delimiter).Prefix(*input.Prefix).Context(*wrapper.Context)
+		response, err = converter.GCSListResponseToAWSv2(call, input, position, pageSize)
 		if err != nil {
 			logging.Log.Error("Error %s %s\n", request.RequestURI, err)
-			writer.WriteHeader(400)
-			writer.Write([]byte(fmt.Sprint(err)))
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
+		wrapper.StorageClasses().ContentsToS3(response.Contents)
 	} else {
 		logging.LogUsingAWS()
-		req := wrapper.S3Client.ListObjectsRequest(input)
+		req := wrapper.S3Client.ListObjectsV2Request(input)
 		resp, respError := req.Send()
 		if respError != nil {
 			logging.Log.Error("Error %s %s\n", request.RequestURI, respError)
-			writer.WriteHeader(400)
-			writer.Write([]byte(fmt.Sprint(err)))
-		}
-		var contents = make([]*response_type.BucketContent, len(resp.Contents))
-		for i, content := range resp.Contents {
-			contents[i] = &response_type.BucketContent{
-				Key:          *content.Key,
-				LastModified: content.LastModified.Format("2006-01-02T15:04:05.000Z"),
-				ETag:         *content.ETag,
-				Size:         *content.Size,
-				StorageClass: string(content.StorageClass),
-			}
-		}
-		var prefixes = make([]*response_type.BucketCommonPrefix, len(resp.CommonPrefixes))
-		for i, prefix := range resp.CommonPrefixes {
-			prefixes[i] = &response_type.BucketCommonPrefix{
-				Prefix: *prefix.Prefix,
-			}
+			s3_handler.WriteError(writer, request, respError)
+			return
 		}
+		contents := converter.AWSObjectsToContents(resp.Contents)
+		prefixes := converter.AWSPrefixesToXML(resp.CommonPrefixes)
 		response = &response_type.AWSListBucketResponse{
 			XmlNS:                 "http://s3.amazonaws.com/doc/2006-03-01/",
 			Name:                  resp.Name,
 			Prefix:                resp.Prefix,
 			Delimiter:             nil,
-			Marker:                resp.Marker,
-			KeyCount:              int64(len(contents)),
+			StartAfter:            resp.StartAfter,
+			KeyCount:              int64(len(contents) + len(prefixes)),
 			MaxKeys:               resp.MaxKeys,
 			IsTruncated:           resp.IsTruncated,
 			Contents:              contents,
 			CommonPrefixes:        prefixes,
-			NextContinuationToken: resp.NextMarker,
+			ContinuationToken:     resp.ContinuationToken,
+			NextContinuationToken: resp.NextContinuationToken,
 		}
 		if resp.Delimiter != nil && *resp.Delimiter != "" {
 			response.Delimiter = resp.Delimiter
 		}
+		if resp.EncodingType != "" {
+			encodingType := string(resp.EncodingType)
+			response.EncodingType = &encodingType
+		}
 	}
 	output, _ := xml.Marshal(response)
 	writer.Write([]byte(s3_handler.XmlHeader))
@@ -314,9 +335,8 @@ func (wrapper *Handler) ACLHandle(writer http.ResponseWriter, request *http.Requ
 			defer wrapper.ReturnConnection(client, request)
 		}
 		if err != nil {
-			writer.WriteHeader(400)
 			logging.Log.Error("Error a %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
 		bucket := wrapper.BucketRename(*input.Bucket)
@@ -324,21 +344,8 @@ func (wrapper *Handler) ACLHandle(writer http.ResponseWriter, request *http.Requ
 		aclList, err := acl.List(*wrapper.Context)
 		if err != nil {
 			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
-			if strings.Contains(err.Error(), "Error 403") {
-				// 403 error used by spark to determine existence of path, need to return proper error
-				code := "AccessDenied"
-				message := "Access Denied"
-				xmlResponse := response_type.AWSACLResponseError{
-					Code:    &code,
-					Message: &message,
-				}
-				writer.WriteHeader(403)
-				output, _ := xml.MarshalIndent(xmlResponse, "  ", "    ")
-				writer.Write([]byte(s3_handler.XmlHeader))
-				writer.Write([]byte(string(output)))
-			} else {
-				writer.WriteHeader(404)
-			}
+			// 403 error used by spark to determine existence of path, need to return proper error
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
 		output, _ := xml.MarshalIndent(converter.GCSACLResponseToAWS(aclList), "  ", "    ")
@@ -349,29 +356,93 @@ func (wrapper *Handler) ACLHandle(writer http.ResponseWriter, request *http.Requ
 		req := wrapper.S3Client.GetBucketAclRequest(input)
 		resp, respError := req.Send()
 		if respError != nil {
-			panic(fmt.Sprintf("Error %s", respError))
-		}
-		var grants = make([]*response_type.Grant, len(resp.Grants))
-		for i, grant := range resp.Grants {
-			grants[i] = &response_type.Grant{
-				Grantee: &response_type.Grantee{
-					Id:          *grant.Grantee.ID,
-					DisplayName: *grant.Grantee.DisplayName,
-					XmlNS:       response_type.ACLXmlNs,
-					Xsi:         response_type.ACLXmlXsi,
-				},
-				Permission: string(grant.Permission),
-			}
-		}
-		s3Resp := &response_type.AWSACLResponse{
-			OwnerId:          *resp.Owner.ID,
-			OwnerDisplayName: *resp.Owner.DisplayName,
-			AccessControlList: &response_type.AccessControlList{
-				Grants: grants,
-			},
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
 		}
+		s3Resp := converter.AWSACLToXML(resp.Owner, resp.Grants)
 		output, _ := xml.MarshalIndent(s3Resp, "  ", "    ")
 		writer.Write([]byte(s3_handler.XmlHeader))
 		writer.Write([]byte(string(output)))
 	}
 }
+
+// Parse input for put ACL request, a canned ACL, grant headers or an access control policy body
+func (wrapper *Handler) PutACLParseInput(r *http.Request) (*s3.PutBucketAclInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	input := &s3.PutBucketAclInput{Bucket: &bucket, ACL: s3.BucketCannedACL(r.Header.Get("x-amz-acl"))}
+	grants := s3_handler.GrantHeaders(r.Header)
+	input.GrantFullControl = grants[s3.PermissionFullControl]
+	input.GrantRead = grants[s3.PermissionRead]
+	input.GrantReadACP = grants[s3.PermissionReadAcp]
+	input.GrantWrite = grants[s3.PermissionWrite]
+	input.GrantWriteACP = grants[s3.PermissionWriteAcp]
+	policy, err := s3_handler.ParseAccessControlPolicy(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	input.AccessControlPolicy = policy
+	return input, nil
+}
+
+// Handle put ACL request.  Canned ACLs become GCS predefined ACLs and grants replace the bucket ACL rules.  Nothing
+// is applied if any grant can't be translated
+func (wrapper *Handler) PutACLHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := wrapper.PutACLParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3MalformedACLError, "")
+		return
+	}
+	if wrapper.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		headers := s3_handler.GrantFields(input.GrantFullControl, input.GrantRead, input.GrantReadACP, input.GrantWrite, input.GrantWriteACP)
+		principals := wrapper.Config.GetStringMapString(s3_handler.PrincipalMapKey)
+		predefined, rules, unsupported, err := converter.AWSACLToGCS(string(input.ACL), input.AccessControlPolicy, headers, principals, false)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+			return
+		}
+		if len(unsupported) > 0 {
+			logging.Log.Error("Error %s unsupported ACL %s", request.RequestURI, unsupported)
+			s3_handler.WriteErrorCode(writer, request, response_type.S3NotImplemented, "Unsupported grants: "+strings.Join(unsupported, "; "))
+			return
+		}
+		if predefined == "" && rules == nil {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3MalformedACLError, "")
+			return
+		}
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucketHandle := wrapper.GCPClientToBucket(wrapper.BucketRename(*input.Bucket), client)
+		if predefined != "" {
+			_, err = bucketHandle.Update(*wrapper.Context, storage.BucketAttrsToUpdate{PredefinedACL: predefined})
+		} else {
+			err = wrapper.ReplaceACL(bucketHandle.ACL(), rules)
+		}
+		if err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := wrapper.S3Client.PutBucketAclRequest(input)
+		_, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusOK)
+}
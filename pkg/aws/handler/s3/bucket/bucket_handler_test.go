@@ -1,16 +1,23 @@
 package bucket
 
 import (
+	"bytes"
 	"cloud.google.com/go/storage"
 	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
 	"cloudsidecar/pkg/mock"
 	"context"
 	"fmt"
 	"github.com/golang/mock/gomock"
 	"github.com/gorilla/mux"
 	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
+	storagev1 "google.golang.org/api/storage/v1"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -88,6 +95,63 @@ func TestHandler_ListParseInput(t *testing.T) {
 	}
 }
 
+func TestHandler_Listv2ParseInput_Position(t *testing.T) {
+	valueMap := map[string]string{"bucket": "boops"}
+	testUrl, _ := url.ParseRequestURI("http://localhost:3450/beh?list-type=2&start-after=boo%2Fa&continuation-token=abc&fetch-owner=true&max-keys=10")
+	req := &http.Request{
+		URL: testUrl,
+	}
+	req = mux.SetURLVars(req, valueMap)
+	handler := New(nil)
+	result, _ := handler.Listv2ParseInput(req)
+	assert.Equal(t, "boo/a", *result.StartAfter)
+	assert.Equal(t, "abc", *result.ContinuationToken)
+	assert.True(t, *result.FetchOwner)
+	assert.Equal(t, int64(10), *result.MaxKeys)
+}
+
+// Handler listing through a server that answers storage/v1 listings with one page, recording the queries it was sent
+func listHandler(t *testing.T, ctrl *gomock.Controller, page string, queries *[]url.Values) (*Handler, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "/storage/v1/b/boops/o", request.URL.Path)
+		*queries = append(*queries, request.URL.Query())
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write([]byte(page))
+	}))
+	clientMock := s3_handler.NewMockGCPClient(ctrl)
+	ctx := context.Background()
+	s3Handler := &s3_handler.Handler{
+		GCPClient: func() (s3_handler.GCPClient, error) {
+			return clientMock, nil
+		},
+		GCPClientPool: make(map[string][]s3_handler.GCPClient),
+		GCPClientToRaw: func(client s3_handler.GCPClient) (*storagev1.Service, error) {
+			return storagev1.NewService(ctx, option.WithEndpoint(server.URL+"/storage/v1/"), option.WithHTTPClient(server.Client()))
+		},
+		Context: &ctx,
+		Config:  getConfig(),
+	}
+	return New(s3Handler), server
+}
+
+func TestHandler_ListHandle_Marker(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	var queries []url.Values
+	handler, server := listHandler(t, ctrl, `{"items":[{"name":"boo/m"},{"name":"boo/n"}]}`, &queries)
+	defer server.Close()
+	req := mux.SetURLVars(httptest.NewRequest("GET", "http://localhost:3450/beh?prefix=boo&marker=boo%2Fm", nil), map[string]string{"bucket": "boops"})
+	writer := httptest.NewRecorder()
+	handler.ListHandle(writer, req)
+	assert.Equal(t, 200, writer.Code)
+	// GCS starts the listing at the marker rather than from the start of the bucket
+	assert.Len(t, queries, 1)
+	assert.Equal(t, converter.KeyBefore("boo/m"), queries[0].Get("startOffset"))
+	assert.Equal(t, "boo", queries[0].Get("prefix"))
+	assert.NotContains(t, writer.Body.String(), "<Key>boo/m</Key>")
+	assert.Contains(t, writer.Body.String(), "<Key>boo/n</Key>")
+}
+
 func TestHandler_ListHandle_NoBucket(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -101,16 +165,46 @@ func TestHandler_ListHandle_NoBucket(t *testing.T) {
 	}
 	handler := New(s3Handler)
 	request := &http.Request{}
+	header := make(http.Header)
+	var body bytes.Buffer
+	writerMock.EXPECT().Header().Return(header).AnyTimes()
 	writerMock.EXPECT().WriteHeader(400)
-	writerMock.EXPECT().Write([]byte("no bucket present"))
+	writerMock.EXPECT().Write(gomock.Any()).DoAndReturn(body.Write).Times(2)
 	handler.ListHandlev2(writerMock, request)
+	assert.Equal(t, "application/xml", header.Get("Content-Type"))
+	assert.NotEmpty(t, header.Get("x-amz-request-id"))
+	assert.Contains(t, body.String(), "<Code>InvalidBucketName</Code>")
+	assert.Contains(t, body.String(), "<Message>no bucket present</Message>")
 }
 
 func TestHandler_ListHandle_Success(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	var queries []url.Values
+	handler, server := listHandler(t, ctrl, `{"items":[{"name":"boo"}],"prefixes":["boo/"]}`, &queries)
+	defer server.Close()
+	req := mux.SetURLVars(httptest.NewRequest("GET", "http://localhost:3450/beh?list-type=2&prefix=boo&delimiter=%2F&encoding-type=url", nil), map[string]string{"bucket": "boops"})
+	writer := httptest.NewRecorder()
+	handler.ListHandlev2(writer, req)
+	assert.Equal(t, 200, writer.Code)
+	assert.Len(t, queries, 1)
+	assert.Equal(t, "/", queries[0].Get("delimiter"))
+	assert.Equal(t, "boo", queries[0].Get("prefix"))
+	assert.Equal(t, "", queries[0].Get("startOffset"))
+	assert.Contains(t, writer.Body.String(), "<Key>boo</Key>")
+	assert.Contains(t, writer.Body.String(), "<Prefix>boo/</Prefix>")
+}
+
+func recoverFail() {
+	if r := recover(); r != nil {
+		fmt.Println("recovered from ", r)
+	}
+}
+
+func TestHandler_PutObjectLockHandle_Compliance(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 	bucketMock := s3_handler.NewMockGCPBucket(ctrl)
-	writerMock := mock.NewMockResponseWriter(ctrl)
 	clientMock := s3_handler.NewMockGCPClient(ctrl)
 	ctx := context.Background()
 	s3Handler := &s3_handler.Handler{
@@ -125,28 +219,12 @@ func TestHandler_ListHandle_Success(t *testing.T) {
 		Config:  getConfig(),
 	}
 	handler := New(s3Handler)
-	testUrl, _ := url.ParseRequestURI("http://localhost:3450/beh?list-type=2&prefix=boo&delimiter=%2F&encoding-type=url")
-	req := &http.Request{
-		URL: testUrl,
-	}
-	valueMap := map[string]string{"bucket": "boops"}
-	req = mux.SetURLVars(req, valueMap)
-	it := storage.ObjectIterator{}
-	bucketMock.EXPECT().Objects(ctx, &storage.Query{
-		Delimiter: "/",
-		Prefix:    "boo",
-		Versions:  false,
-	}).Return(&it)
-	listHandlerRecover(handler, writerMock, req)
-}
-
-func listHandlerRecover(handler *Handler, w http.ResponseWriter, r *http.Request) {
-	defer recoverFail()
-	handler.ListHandlev2(w, r)
-}
-
-func recoverFail() {
-	if r := recover(); r != nil {
-		fmt.Println("recovered from ", r)
-	}
+	body := `<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled><Rule><DefaultRetention><Mode>COMPLIANCE</Mode><Days>1</Days></DefaultRetention></Rule></ObjectLockConfiguration>`
+	req := httptest.NewRequest("PUT", "http://localhost:3450/boops?object-lock", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"bucket": "boops"})
+	writer := httptest.NewRecorder()
+	// locking is permanent, so nothing is touched without the opt in
+	handler.PutObjectLockHandle(writer, req)
+	assert.Equal(t, http.StatusNotImplemented, writer.Code)
+	assert.Contains(t, writer.Body.String(), "NotImplemented")
 }
@@ -0,0 +1,167 @@
+package bucket
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"cloudsidecar/pkg/response_type"
+	"encoding/xml"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+)
+
+// Parse input for get CORS requests
+func (wrapper *Handler) CORSParseInput(r *http.Request) (*s3.GetBucketCorsInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	return &s3.GetBucketCorsInput{Bucket: &bucket}, nil
+}
+
+// Handle get CORS requests
+func (wrapper *Handler) CORSHandle(writer http.ResponseWriter, request *http.Request) {
+	input, _ := wrapper.CORSParseInput(request)
+	var rules []s3.CORSRule
+	if wrapper.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucket := wrapper.BucketRename(*input.Bucket)
+		attrs, err := wrapper.GCPClientToBucket(bucket, client).Attrs(*wrapper.Context)
+		if err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		rules = converter.GCSCORSToAWS(attrs.CORS)
+	} else {
+		logging.LogUsingAWS()
+		req := wrapper.S3Client.GetBucketCorsRequest(input)
+		resp, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+		rules = resp.CORSRules
+	}
+	if len(rules) == 0 {
+		s3_handler.WriteErrorCode(writer, request, response_type.S3NoSuchCORS, "")
+		return
+	}
+	output, _ := xml.Marshal(converter.AWSCORSToXML(rules))
+	writer.Write([]byte(s3_handler.XmlHeader))
+	writer.Write(output)
+}
+
+// Parse input for put CORS requests
+func (wrapper *Handler) PutCORSParseInput(r *http.Request) (*s3.PutBucketCorsInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var configuration response_type.CORSConfiguration
+	if err := xml.Unmarshal(body, &configuration); err != nil {
+		return nil, err
+	}
+	return &s3.PutBucketCorsInput{
+		Bucket:            &bucket,
+		CORSConfiguration: &s3.CORSConfiguration{CORSRules: converter.CORSXMLToAWS(&configuration)},
+	}, nil
+}
+
+// Handle put CORS requests
+func (wrapper *Handler) PutCORSHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := wrapper.PutCORSParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3MalformedXML, "")
+		return
+	}
+	if wrapper.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucket := wrapper.BucketRename(*input.Bucket)
+		_, err = wrapper.GCPClientToBucket(bucket, client).Update(*wrapper.Context, storage.BucketAttrsToUpdate{
+			CORS: converter.AWSCORSToGCS(input.CORSConfiguration.CORSRules),
+		})
+		if err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := wrapper.S3Client.PutBucketCorsRequest(input)
+		_, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+// Parse input for delete CORS requests
+func (wrapper *Handler) DeleteCORSParseInput(r *http.Request) (*s3.DeleteBucketCorsInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	return &s3.DeleteBucketCorsInput{Bucket: &bucket}, nil
+}
+
+// Handle delete CORS requests
+func (wrapper *Handler) DeleteCORSHandle(writer http.ResponseWriter, request *http.Request) {
+	input, _ := wrapper.DeleteCORSParseInput(request)
+	if wrapper.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucket := wrapper.BucketRename(*input.Bucket)
+		// an empty list removes every rule
+		_, err = wrapper.GCPClientToBucket(bucket, client).Update(*wrapper.Context, storage.BucketAttrsToUpdate{
+			CORS: []storage.CORS{},
+		})
+		if err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := wrapper.S3Client.DeleteBucketCorsRequest(input)
+		_, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,186 @@
+package bucket
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"cloudsidecar/pkg/response_type"
+	"encoding/xml"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Parse input for get lifecycle requests
+func (wrapper *Handler) LifecycleParseInput(r *http.Request) (*s3.GetBucketLifecycleConfigurationInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	return &s3.GetBucketLifecycleConfigurationInput{Bucket: &bucket}, nil
+}
+
+// Handle get lifecycle requests
+func (wrapper *Handler) LifecycleHandle(writer http.ResponseWriter, request *http.Request) {
+	input, _ := wrapper.LifecycleParseInput(request)
+	var rules []s3.LifecycleRule
+	if wrapper.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucket := wrapper.BucketRename(*input.Bucket)
+		attrs, err := wrapper.GCPClientToBucket(bucket, client).Attrs(*wrapper.Context)
+		if err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		rules = converter.GCSLifecycleToAWS(attrs.Lifecycle)
+		if days := converter.GCSLabelsAbortDays(attrs.Labels); days > 0 {
+			rules = append(rules, converter.AbortMultipartRuleToAWS(days))
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := wrapper.S3Client.GetBucketLifecycleConfigurationRequest(input)
+		resp, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+		rules = resp.Rules
+	}
+	if len(rules) == 0 {
+		s3_handler.WriteErrorCode(writer, request, response_type.S3NoSuchLifecycle, "")
+		return
+	}
+	output, _ := xml.Marshal(converter.AWSLifecycleToXML(rules))
+	writer.Write([]byte(s3_handler.XmlHeader))
+	writer.Write(output)
+}
+
+// Parse input for put lifecycle requests
+func (wrapper *Handler) PutLifecycleParseInput(r *http.Request) (*s3.PutBucketLifecycleConfigurationInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var configuration response_type.LifecycleConfiguration
+	if err := xml.Unmarshal(body, &configuration); err != nil {
+		return nil, err
+	}
+	rules, err := converter.LifecycleXMLToAWS(&configuration)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 &bucket,
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: rules},
+	}, nil
+}
+
+// Handle put lifecycle requests.  The whole configuration is rejected if any rule can't be expressed in GCS.  Days
+// to abort incomplete multipart uploads after are kept in a bucket label the object handler expires uploads by
+func (wrapper *Handler) PutLifecycleHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := wrapper.PutLifecycleParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3MalformedXML, "")
+		return
+	}
+	if wrapper.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		lifecycle, unsupported := converter.AWSLifecycleToGCS(input.LifecycleConfiguration.Rules)
+		if len(unsupported) > 0 {
+			logging.Log.Error("Error %s unsupported lifecycle %s", request.RequestURI, unsupported)
+			s3_handler.WriteErrorCode(writer, request, response_type.S3NotImplemented, "Unsupported lifecycle rules: "+strings.Join(unsupported, "; "))
+			return
+		}
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucket := wrapper.BucketRename(*input.Bucket)
+		update := storage.BucketAttrsToUpdate{Lifecycle: lifecycle}
+		if days := converter.AWSLifecycleAbortDays(input.LifecycleConfiguration.Rules); days > 0 {
+			update.SetLabel(converter.AbortMultipartDaysLabel, strconv.FormatInt(days, 10))
+		} else {
+			update.DeleteLabel(converter.AbortMultipartDaysLabel)
+		}
+		_, err = wrapper.GCPClientToBucket(bucket, client).Update(*wrapper.Context, update)
+		if err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := wrapper.S3Client.PutBucketLifecycleConfigurationRequest(input)
+		_, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+// Parse input for delete lifecycle requests
+func (wrapper *Handler) DeleteLifecycleParseInput(r *http.Request) (*s3.DeleteBucketLifecycleInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	return &s3.DeleteBucketLifecycleInput{Bucket: &bucket}, nil
+}
+
+// Handle delete lifecycle requests
+func (wrapper *Handler) DeleteLifecycleHandle(writer http.ResponseWriter, request *http.Request) {
+	input, _ := wrapper.DeleteLifecycleParseInput(request)
+	if wrapper.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucket := wrapper.BucketRename(*input.Bucket)
+		update := storage.BucketAttrsToUpdate{Lifecycle: &storage.Lifecycle{}}
+		update.DeleteLabel(converter.AbortMultipartDaysLabel)
+		_, err = wrapper.GCPClientToBucket(bucket, client).Update(*wrapper.Context, update)
+		if err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := wrapper.S3Client.DeleteBucketLifecycleRequest(input)
+		_, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
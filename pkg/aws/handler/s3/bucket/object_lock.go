@@ -0,0 +1,162 @@
+package bucket
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"cloudsidecar/pkg/response_type"
+	"encoding/xml"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+)
+
+// Locking a GCS retention policy can never be undone, so compliance mode only locks when this is set
+var allowRetentionLockKey = "gcp_destination_config.gcs_config.allow_retention_lock"
+
+// Parse input for get object lock configuration requests
+func (wrapper *Handler) ObjectLockParseInput(r *http.Request) (*s3.GetObjectLockConfigurationInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	return &s3.GetObjectLockConfigurationInput{Bucket: &bucket}, nil
+}
+
+// Handle get object lock configuration requests.  A GCS retention policy is the default retention, in compliance
+// mode once it is locked
+func (wrapper *Handler) ObjectLockHandle(writer http.ResponseWriter, request *http.Request) {
+	input, _ := wrapper.ObjectLockParseInput(request)
+	var configuration *s3.ObjectLockConfiguration
+	if wrapper.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucket := wrapper.BucketRename(*input.Bucket)
+		attrs, err := wrapper.GCPClientToBucket(bucket, client).Attrs(*wrapper.Context)
+		if err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		configuration = converter.GCSRetentionPolicyToAWS(attrs.RetentionPolicy)
+	} else {
+		logging.LogUsingAWS()
+		req := wrapper.S3Client.GetObjectLockConfigurationRequest(input)
+		resp, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+		configuration = resp.ObjectLockConfiguration
+	}
+	if configuration == nil {
+		s3_handler.WriteErrorCode(writer, request, response_type.S3NoSuchObjectLock, "")
+		return
+	}
+	output, _ := xml.Marshal(converter.AWSObjectLockToXML(configuration))
+	writer.Write([]byte(s3_handler.XmlHeader))
+	writer.Write(output)
+}
+
+// Parse input for put object lock configuration requests
+func (wrapper *Handler) PutObjectLockParseInput(r *http.Request) (*s3.PutObjectLockConfigurationInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var input response_type.ObjectLockConfiguration
+	if err := xml.Unmarshal(body, &input); err != nil {
+		return nil, err
+	}
+	configuration, err := converter.ObjectLockXMLToAWS(&input)
+	if err != nil {
+		return nil, err
+	}
+	output := &s3.PutObjectLockConfigurationInput{Bucket: &bucket, ObjectLockConfiguration: configuration}
+	if token := r.Header.Get("x-amz-bucket-object-lock-token"); token != "" {
+		output.Token = &token
+	}
+	return output, nil
+}
+
+// Handle put object lock configuration requests.  The default retention becomes the GCS retention policy, and
+// compliance mode locks it.  Locking is permanent, so compliance mode is not implemented unless
+// allow_retention_lock is set.  The policy is set before it is locked, and if locking fails the policy is left
+// in place unlocked, so a retry locks it.  Locked policies can't be removed or shortened, so those are refused
+// up front
+func (wrapper *Handler) PutObjectLockHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := wrapper.PutObjectLockParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3MalformedXML, "")
+		return
+	}
+	if wrapper.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		period, compliance := converter.AWSObjectLockToGCS(input.ObjectLockConfiguration)
+		if compliance && !wrapper.Config.GetBool(allowRetentionLockKey) {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3NotImplemented, "COMPLIANCE mode locks the GCS retention policy permanently, set allow_retention_lock to allow it")
+			return
+		}
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucket := wrapper.BucketRename(*input.Bucket)
+		bucketHandle := wrapper.GCPClientToBucket(bucket, client)
+		attrs, err := bucketHandle.Attrs(*wrapper.Context)
+		if err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		if policy := attrs.RetentionPolicy; policy != nil && policy.IsLocked && (!compliance || period < policy.RetentionPeriod) {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidBucketState, "The bucket's retention is in compliance mode and can only be lengthened")
+			return
+		}
+		// a zero period removes the retention policy
+		updated, err := bucketHandle.Update(*wrapper.Context, storage.BucketAttrsToUpdate{
+			RetentionPolicy: &storage.RetentionPolicy{RetentionPeriod: period},
+		})
+		if err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		if compliance && !updated.RetentionPolicy.IsLocked {
+			// locking has to name the metageneration that holds the policy being locked
+			lockHandle := bucketHandle.If(storage.BucketConditions{MetagenerationMatch: updated.MetaGeneration})
+			if err := lockHandle.LockRetentionPolicy(*wrapper.Context); err != nil {
+				logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+				s3_handler.WriteError(writer, request, err)
+				return
+			}
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := wrapper.S3Client.PutObjectLockConfigurationRequest(input)
+		_, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,204 @@
+package bucket
+
+import (
+	"cloud.google.com/go/iam"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"cloudsidecar/pkg/response_type"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Parse input for get policy requests
+func (wrapper *Handler) PolicyParseInput(r *http.Request) (*s3.GetBucketPolicyInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	return &s3.GetBucketPolicyInput{Bucket: &bucket}, nil
+}
+
+// Handle get policy requests.  The policy is rebuilt from the bucket IAM bindings for the roles policies map to
+func (wrapper *Handler) PolicyHandle(writer http.ResponseWriter, request *http.Request) {
+	input, _ := wrapper.PolicyParseInput(request)
+	var policy string
+	if wrapper.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucket := wrapper.BucketRename(*input.Bucket)
+		iamPolicy, err := wrapper.GCPClientToBucket(bucket, client).IAM().Policy(*wrapper.Context)
+		if err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bindings := make(map[string][]string)
+		for _, role := range converter.PolicyRoles() {
+			bindings[role] = iamPolicy.Members(iam.RoleName(role))
+		}
+		s3Policy := converter.IAMToS3Policy(bindings, *input.Bucket, wrapper.Config.GetStringMapString(s3_handler.PrincipalMapKey))
+		if s3Policy == nil {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3NoSuchBucketPolicy, "")
+			return
+		}
+		output, _ := json.Marshal(s3Policy)
+		policy = string(output)
+	} else {
+		logging.LogUsingAWS()
+		req := wrapper.S3Client.GetBucketPolicyRequest(input)
+		resp, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+		if resp.Policy != nil {
+			policy = *resp.Policy
+		}
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write([]byte(policy))
+}
+
+// Parse input for put policy requests
+func (wrapper *Handler) PutPolicyParseInput(r *http.Request) (*s3.PutBucketPolicyInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	policy := string(body)
+	input := &s3.PutBucketPolicyInput{Bucket: &bucket, Policy: &policy}
+	if header := r.Header.Get("x-amz-confirm-remove-self-bucket-access"); header == "true" {
+		confirm := true
+		input.ConfirmRemoveSelfBucketAccess = &confirm
+	}
+	return input, nil
+}
+
+// Handle put policy requests.  Statements become bucket IAM bindings and replace the members of the roles policies
+// map to, other roles and the project convenience bindings are left alone.  Nothing is applied if any statement
+// can't be translated, and the error lists every one that couldn't
+func (wrapper *Handler) PutPolicyHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := wrapper.PutPolicyParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3MalformedPolicy, "")
+		return
+	}
+	if wrapper.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		var s3Policy response_type.BucketPolicy
+		if err := json.Unmarshal([]byte(*input.Policy), &s3Policy); err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteErrorCode(writer, request, response_type.S3MalformedPolicy, "")
+			return
+		}
+		bindings, unsupported := converter.S3PolicyToIAM(&s3Policy, *input.Bucket, wrapper.Config.GetStringMapString(s3_handler.PrincipalMapKey))
+		if len(unsupported) > 0 {
+			logging.Log.Error("Error %s unsupported policy %s", request.RequestURI, unsupported)
+			s3_handler.WriteErrorCode(writer, request, response_type.S3NotImplemented, "Unsupported policy statements: "+strings.Join(unsupported, "; "))
+			return
+		}
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucket := wrapper.BucketRename(*input.Bucket)
+		if err := wrapper.setPolicyBindings(bucket, client, bindings); err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := wrapper.S3Client.PutBucketPolicyRequest(input)
+		_, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// Parse input for delete policy requests
+func (wrapper *Handler) DeletePolicyParseInput(r *http.Request) (*s3.DeleteBucketPolicyInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	return &s3.DeleteBucketPolicyInput{Bucket: &bucket}, nil
+}
+
+// Handle delete policy requests by removing the members of the roles policies map to
+func (wrapper *Handler) DeletePolicyHandle(writer http.ResponseWriter, request *http.Request) {
+	input, _ := wrapper.DeletePolicyParseInput(request)
+	if wrapper.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucket := wrapper.BucketRename(*input.Bucket)
+		if err := wrapper.setPolicyBindings(bucket, client, nil); err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := wrapper.S3Client.DeleteBucketPolicyRequest(input)
+		_, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// Replace the members of the policy roles on a bucket, keeping project convenience bindings
+func (wrapper *Handler) setPolicyBindings(bucket string, client s3_handler.GCPClient, bindings map[string][]string) error {
+	handle := wrapper.GCPClientToBucket(bucket, client).IAM()
+	iamPolicy, err := handle.Policy(*wrapper.Context)
+	if err != nil {
+		return err
+	}
+	for _, role := range converter.PolicyRoles() {
+		roleName := iam.RoleName(role)
+		// copy the members since removing changes the binding
+		members := append([]string{}, iamPolicy.Members(roleName)...)
+		for _, member := range members {
+			if !converter.IsConvenienceMember(member) {
+				iamPolicy.Remove(member, roleName)
+			}
+		}
+		for _, member := range bindings[role] {
+			iamPolicy.Add(member, roleName)
+		}
+	}
+	return handle.SetPolicy(*wrapper.Context, iamPolicy)
+}
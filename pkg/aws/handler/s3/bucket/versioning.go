@@ -0,0 +1,262 @@
+package bucket
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"cloudsidecar/pkg/response_type"
+	"encoding/xml"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// Parse input for list object versions requests
+func (wrapper *Handler) VersionsParseInput(request *http.Request) (*s3.ListObjectVersionsInput, error) {
+	vars := mux.Vars(request)
+	bucket := vars["bucket"]
+	if bucket == "" {
+		return nil, errors.New("no bucket present")
+	}
+	query := request.URL.Query()
+	listRequest := &s3.ListObjectVersionsInput{Bucket: &bucket}
+	delim := query.Get("delimiter")
+	listRequest.Delimiter = &delim
+	if encodingType := query.Get("encoding-type"); encodingType == "url" {
+		listRequest.EncodingType = s3.EncodingTypeUrl
+	}
+	maxKeyInt := int64(1000)
+	if maxKeys := query.Get("max-keys"); maxKeys != "" {
+		maxKeyInt, _ = strconv.ParseInt(maxKeys, 10, 64)
+	}
+	listRequest.MaxKeys = &maxKeyInt
+	if keyMarker := query.Get("key-marker"); keyMarker != "" {
+		listRequest.KeyMarker = &keyMarker
+	}
+	if versionIdMarker := query.Get("version-id-marker"); versionIdMarker != "" {
+		listRequest.VersionIdMarker = &versionIdMarker
+	}
+	prefix := query.Get("prefix")
+	listRequest.Prefix = &prefix
+	return listRequest, nil
+}
+
+// Handle list object versions requests.  Every GCS generation is a version, and a key without a live
+// generation gets a delete marker on top
+func (wrapper *Handler) VersionsHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := wrapper.VersionsParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidBucketName, err.Error())
+		return
+	}
+	var response *response_type.ListVersionsResult
+	if wrapper.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucket := wrapper.BucketRename(*input.Bucket)
+		raw, err := wrapper.GCPClientToRaw(client)
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		call := raw.Objects.List(bucket).Projection("full").Delimiter(*input.Delimiter).Prefix(*input.Prefix).Versions(true).Context(*wrapper.Context)
+		response, err = converter.GCSVersionsToAWS(call, input)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		wrapper.StorageClasses().VersionsToS3(response.Versions)
+	} else {
+		logging.LogUsingAWS()
+		req := wrapper.S3Client.ListObjectVersionsRequest(input)
+		resp, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+		response = &response_type.ListVersionsResult{
+			XmlNS:               "http://s3.amazonaws.com/doc/2006-03-01/",
+			Name:                resp.Name,
+			Prefix:              resp.Prefix,
+			KeyMarker:           resp.KeyMarker,
+			VersionIdMarker:     resp.VersionIdMarker,
+			NextKeyMarker:       resp.NextKeyMarker,
+			NextVersionIdMarker: resp.NextVersionIdMarker,
+			MaxKeys:             resp.MaxKeys,
+			IsTruncated:         resp.IsTruncated != nil && *resp.IsTruncated,
+			Versions:            make([]*response_type.ObjectVersion, len(resp.Versions)),
+			DeleteMarkers:       make([]*response_type.DeleteMarkerEntry, len(resp.DeleteMarkers)),
+		}
+		if resp.Delimiter != nil && *resp.Delimiter != "" {
+			response.Delimiter = resp.Delimiter
+		}
+		for i, version := range resp.Versions {
+			response.Versions[i] = &response_type.ObjectVersion{
+				Key:          *version.Key,
+				VersionId:    *version.VersionId,
+				IsLatest:     *version.IsLatest,
+				LastModified: version.LastModified.Format("2006-01-02T15:04:05.000Z"),
+				ETag:         *version.ETag,
+				Size:         *version.Size,
+				StorageClass: string(version.StorageClass),
+			}
+		}
+		for i, marker := range resp.DeleteMarkers {
+			response.DeleteMarkers[i] = &response_type.DeleteMarkerEntry{
+				Key:          *marker.Key,
+				VersionId:    *marker.VersionId,
+				IsLatest:     *marker.IsLatest,
+				LastModified: marker.LastModified.Format("2006-01-02T15:04:05.000Z"),
+			}
+		}
+		for _, prefix := range resp.CommonPrefixes {
+			response.CommonPrefixes = append(response.CommonPrefixes, &response_type.BucketCommonPrefix{Prefix: *prefix.Prefix})
+		}
+	}
+	output, _ := xml.Marshal(response)
+	writer.Write([]byte(s3_handler.XmlHeader))
+	writer.Write(output)
+}
+
+// Parse input for get bucket versioning requests
+func (wrapper *Handler) VersioningParseInput(r *http.Request) (*s3.GetBucketVersioningInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	return &s3.GetBucketVersioningInput{Bucket: &bucket}, nil
+}
+
+// Handle get bucket versioning requests.  GCS versioning is either on or off, so a bucket that had it turned
+// off reports Suspended like S3 would
+func (wrapper *Handler) VersioningHandle(writer http.ResponseWriter, request *http.Request) {
+	input, _ := wrapper.VersioningParseInput(request)
+	response := &response_type.VersioningConfiguration{XmlNS: "http://s3.amazonaws.com/doc/2006-03-01/"}
+	if wrapper.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucket := wrapper.BucketRename(*input.Bucket)
+		attrs, err := wrapper.GCPClientToBucket(bucket, client).Attrs(*wrapper.Context)
+		if err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		if attrs.VersioningEnabled {
+			response.Status = string(s3.BucketVersioningStatusEnabled)
+		} else {
+			response.Status = string(s3.BucketVersioningStatusSuspended)
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := wrapper.S3Client.GetBucketVersioningRequest(input)
+		resp, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+		response.Status = string(resp.Status)
+		response.MfaDelete = string(resp.MFADelete)
+	}
+	output, _ := xml.Marshal(response)
+	writer.Write([]byte(s3_handler.XmlHeader))
+	writer.Write(output)
+}
+
+// Parse input for put bucket versioning requests
+func (wrapper *Handler) PutVersioningParseInput(r *http.Request) (*s3.PutBucketVersioningInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var configuration response_type.VersioningConfiguration
+	if err := xml.Unmarshal(body, &configuration); err != nil {
+		return nil, err
+	}
+	status := s3.BucketVersioningStatus(configuration.Status)
+	if status != s3.BucketVersioningStatusEnabled && status != s3.BucketVersioningStatusSuspended {
+		return nil, errors.New("invalid versioning status " + configuration.Status)
+	}
+	input := &s3.PutBucketVersioningInput{
+		Bucket: &bucket,
+		VersioningConfiguration: &s3.VersioningConfiguration{
+			Status:    status,
+			MFADelete: s3.MFADelete(configuration.MfaDelete),
+		},
+	}
+	if mfa := r.Header.Get("x-amz-mfa"); mfa != "" {
+		input.MFA = &mfa
+	}
+	return input, nil
+}
+
+// Handle put bucket versioning requests.  Suspended turns GCS versioning off, which keeps existing generations
+func (wrapper *Handler) PutVersioningHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := wrapper.PutVersioningParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3MalformedXML, "")
+		return
+	}
+	if wrapper.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		if input.VersioningConfiguration.MFADelete == s3.MFADeleteEnabled {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3NotImplemented, "MFA delete is not supported")
+			return
+		}
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucket := wrapper.BucketRename(*input.Bucket)
+		enabled := input.VersioningConfiguration.Status == s3.BucketVersioningStatusEnabled
+		_, err = wrapper.GCPClientToBucket(bucket, client).Update(*wrapper.Context, storage.BucketAttrsToUpdate{
+			VersioningEnabled: enabled,
+		})
+		if err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := wrapper.S3Client.PutBucketVersioningRequest(input)
+		_, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,59 @@
+package s3
+
+import (
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/response_type"
+	"encoding/xml"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+)
+
+// Request id in the same shape AWS uses
+func NewRequestId() string {
+	return strings.ToUpper(strings.Replace(uuid.New().String(), "-", "", -1))[:16]
+}
+
+// Write an S3 error response for an error that came from GCS or AWS
+func WriteError(writer http.ResponseWriter, request *http.Request, err error) {
+	code, message, requestId := converter.ErrorToS3(err)
+	WriteErrorResponse(writer, request, code, message, requestId)
+}
+
+// Write an S3 error response for a known error code.  An empty message uses the default one for the code
+func WriteErrorCode(writer http.ResponseWriter, request *http.Request, code response_type.S3ErrorCode, message string) {
+	if message == "" {
+		message = code.Message
+	}
+	WriteErrorResponse(writer, request, code, message, "")
+}
+
+// Write the <Error> document S3 clients parse.  HEAD and not modified responses can't have bodies so only
+// the status and request id are sent
+func WriteErrorResponse(writer http.ResponseWriter, request *http.Request, code response_type.S3ErrorCode, message string, requestId string) {
+	if requestId == "" {
+		requestId = NewRequestId()
+	}
+	writer.Header().Set("x-amz-request-id", requestId)
+	if request.Method == http.MethodHead || code.StatusCode == http.StatusNotModified {
+		writer.WriteHeader(code.StatusCode)
+		return
+	}
+	vars := mux.Vars(request)
+	s3Error := response_type.S3Error{
+		Code:       code.Code,
+		Message:    message,
+		BucketName: vars["bucket"],
+		Key:        vars["key"],
+		RequestId:  requestId,
+	}
+	if request.URL != nil {
+		s3Error.Resource = request.URL.Path
+	}
+	writer.Header().Set("Content-Type", "application/xml")
+	writer.WriteHeader(code.StatusCode)
+	output, _ := xml.Marshal(s3Error)
+	writer.Write([]byte(XmlHeader))
+	writer.Write(output)
+}
@@ -1,14 +1,18 @@
 package s3
 
 import (
+	"cloud.google.com/go/iam"
 	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/converter"
 	"cloudsidecar/pkg/logging"
 	"context"
 	"encoding/base64"
+	"errors"
 	"github.com/aws/aws-sdk-go-v2/service/s3/s3iface"
 	"github.com/gorilla/mux"
 	"github.com/spf13/viper"
 	"google.golang.org/api/option"
+	storagev1 "google.golang.org/api/storage/v1"
 	"net/http"
 	"strings"
 	"sync"
@@ -22,6 +26,7 @@ type Handler struct {
 	GCPClientToBucket func(bucket string, client GCPClient) GCPBucket
 	GCPBucketToObject func(name string, bucket GCPBucket) GCPObject
 	GCPObjectToWriter func(object GCPObject, ctx context.Context) GCPObjectWriter
+	GCPClientToRaw    func(client GCPClient) (*storagev1.Service, error)
 	GCPClientPerKey   map[string]GCPClient
 	gcpClientMapLock  sync.Mutex
 	GCPClientPool     map[string][]GCPClient
@@ -42,14 +47,53 @@ func NewHandler(config *viper.Viper) Handler {
 		GCPObjectToWriter: func(object GCPObject, ctx context.Context) GCPObjectWriter {
 			return object.NewWriter(ctx)
 		},
+		GCPClientToRaw: RawGCPClient,
 	}
 }
 
 type GCPClient interface {
 	Bucket(name string) *storage.BucketHandle
+	Buckets(ctx context.Context, projectID string) *storage.BucketIterator
 	Close() error
 }
 
+// GCS client that can also make storage/v1 calls, for list options the pinned storage client doesn't have
+type GCPRawClient interface {
+	GCPClient
+	Raw() *storagev1.Service
+}
+
+type gcpClientWithRaw struct {
+	*storage.Client
+	raw *storagev1.Service
+}
+
+func (client *gcpClientWithRaw) Raw() *storagev1.Service {
+	return client.raw
+}
+
+// GCS client along with a storage/v1 service made from the same options
+func NewGCPClient(ctx context.Context, opts ...option.ClientOption) (GCPClient, error) {
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := storagev1.NewService(ctx, opts...)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &gcpClientWithRaw{Client: client, raw: raw}, nil
+}
+
+// The storage/v1 service of a GCS client
+func RawGCPClient(client GCPClient) (*storagev1.Service, error) {
+	if rawClient, ok := client.(GCPRawClient); ok {
+		return rawClient.Raw(), nil
+	}
+	return nil, errors.New("GCS client can't make storage/v1 calls")
+}
+
 type GCPObject interface {
 	ACL() *storage.ACLHandle
 	Generation(gen int64) *storage.ObjectHandle
@@ -80,6 +124,7 @@ type GCPBucket interface {
 	Delete(ctx context.Context) (err error)
 	ACL() *storage.ACLHandle
 	DefaultObjectACL() *storage.ACLHandle
+	IAM() *iam.Handle
 	Object(name string) *storage.ObjectHandle
 	Attrs(ctx context.Context) (attrs *storage.BucketAttrs, err error)
 	Update(ctx context.Context, uattrs storage.BucketAttrsToUpdate) (attrs *storage.BucketAttrs, err error)
@@ -87,6 +132,9 @@ type GCPBucket interface {
 	UserProject(projectID string) *storage.BucketHandle
 	LockRetentionPolicy(ctx context.Context) error
 	Objects(ctx context.Context, q *storage.Query) *storage.ObjectIterator
+	AddNotification(ctx context.Context, n *storage.Notification) (ret *storage.Notification, err error)
+	Notifications(ctx context.Context) (n map[string]*storage.Notification, err error)
+	DeleteNotification(ctx context.Context, id string) (err error)
 }
 
 type HandlerInterface interface {
@@ -166,7 +214,7 @@ func (handler *Handler) SetGCPClientFromCreds(creds *string) (GCPClient, error)
 		var doubleCheck bool
 		var err error
 		if client, doubleCheck = handler.GCPClientPerKey[*creds]; !doubleCheck {
-			client, err = storage.NewClient(*handler.GetContext(), option.WithCredentialsJSON([]byte(decrypted)))
+			client, err = NewGCPClient(*handler.GetContext(), option.WithCredentialsJSON([]byte(decrypted)))
 		}
 		return client, err
 	}
@@ -222,6 +270,17 @@ func (handler *Handler) BucketRename(bucket string) string {
 	}
 }
 
+// Storage class mapping with overrides from storage_class_map (S3 to GCS) and gcs_storage_class_map (GCS to S3)
+func (handler *Handler) StorageClasses() *converter.StorageClassMap {
+	if handler.Config == nil {
+		return converter.DefaultStorageClasses
+	}
+	return converter.NewStorageClassMap(
+		handler.Config.GetStringMapString("gcp_destination_config.gcs_config.storage_class_map"),
+		handler.Config.GetStringMapString("gcp_destination_config.gcs_config.gcs_storage_class_map"),
+	)
+}
+
 const (
 	XmlHeader string = "<?xml version=\"1.0\" encoding=\"UTF-8\"?>"
 )
@@ -5,6 +5,7 @@
 package s3
 
 import (
+	iam "cloud.google.com/go/iam"
 	storage "cloud.google.com/go/storage"
 	context "context"
 	s3iface "github.com/aws/aws-sdk-go-v2/service/s3/s3iface"
@@ -51,6 +52,20 @@ func (mr *MockGCPClientMockRecorder) Bucket(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Bucket", reflect.TypeOf((*MockGCPClient)(nil).Bucket), arg0)
 }
 
+// Buckets mocks base method
+func (m *MockGCPClient) Buckets(arg0 context.Context, arg1 string) *storage.BucketIterator {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Buckets", arg0, arg1)
+	ret0, _ := ret[0].(*storage.BucketIterator)
+	return ret0
+}
+
+// Buckets indicates an expected call of Buckets
+func (mr *MockGCPClientMockRecorder) Buckets(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Buckets", reflect.TypeOf((*MockGCPClient)(nil).Buckets), arg0, arg1)
+}
+
 // Close mocks base method
 func (m *MockGCPClient) Close() error {
 	m.ctrl.T.Helper()
@@ -343,6 +358,21 @@ func (mr *MockGCPBucketMockRecorder) ACL() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ACL", reflect.TypeOf((*MockGCPBucket)(nil).ACL))
 }
 
+// AddNotification mocks base method
+func (m *MockGCPBucket) AddNotification(arg0 context.Context, arg1 *storage.Notification) (*storage.Notification, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddNotification", arg0, arg1)
+	ret0, _ := ret[0].(*storage.Notification)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddNotification indicates an expected call of AddNotification
+func (mr *MockGCPBucketMockRecorder) AddNotification(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddNotification", reflect.TypeOf((*MockGCPBucket)(nil).AddNotification), arg0, arg1)
+}
+
 // Attrs mocks base method
 func (m *MockGCPBucket) Attrs(arg0 context.Context) (*storage.BucketAttrs, error) {
 	m.ctrl.T.Helper()
@@ -400,6 +430,34 @@ func (mr *MockGCPBucketMockRecorder) Delete(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockGCPBucket)(nil).Delete), arg0)
 }
 
+// DeleteNotification mocks base method
+func (m *MockGCPBucket) DeleteNotification(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteNotification", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteNotification indicates an expected call of DeleteNotification
+func (mr *MockGCPBucketMockRecorder) DeleteNotification(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteNotification", reflect.TypeOf((*MockGCPBucket)(nil).DeleteNotification), arg0, arg1)
+}
+
+// IAM mocks base method
+func (m *MockGCPBucket) IAM() *iam.Handle {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IAM")
+	ret0, _ := ret[0].(*iam.Handle)
+	return ret0
+}
+
+// IAM indicates an expected call of IAM
+func (mr *MockGCPBucketMockRecorder) IAM() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IAM", reflect.TypeOf((*MockGCPBucket)(nil).IAM))
+}
+
 // If mocks base method
 func (m *MockGCPBucket) If(arg0 storage.BucketConditions) *storage.BucketHandle {
 	m.ctrl.T.Helper()
@@ -428,6 +486,21 @@ func (mr *MockGCPBucketMockRecorder) LockRetentionPolicy(arg0 interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LockRetentionPolicy", reflect.TypeOf((*MockGCPBucket)(nil).LockRetentionPolicy), arg0)
 }
 
+// Notifications mocks base method
+func (m *MockGCPBucket) Notifications(arg0 context.Context) (map[string]*storage.Notification, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Notifications", arg0)
+	ret0, _ := ret[0].(map[string]*storage.Notification)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Notifications indicates an expected call of Notifications
+func (mr *MockGCPBucketMockRecorder) Notifications(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Notifications", reflect.TypeOf((*MockGCPBucket)(nil).Notifications), arg0)
+}
+
 // Object mocks base method
 func (m *MockGCPBucket) Object(arg0 string) *storage.ObjectHandle {
 	m.ctrl.T.Helper()
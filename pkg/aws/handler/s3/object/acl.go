@@ -0,0 +1,175 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"cloudsidecar/pkg/response_type"
+	"encoding/xml"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+)
+
+// Parse input for object ACL request
+func (handler *Handler) ACLParseInput(r *http.Request) (*s3.GetObjectAclInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+	return &s3.GetObjectAclInput{Bucket: &bucket, Key: &key, VersionId: parseVersionId(r)}, nil
+}
+
+// Handle object ACL request
+func (handler *Handler) ACLHandle(writer http.ResponseWriter, request *http.Request) {
+	input, _ := handler.ACLParseInput(request)
+	var response response_type.AWSACLResponse
+	if handler.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		generation, deleteMarker, err := gcsVersion(input.VersionId)
+		if err != nil {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+			return
+		}
+		if deleteMarker {
+			writeDeleteMarkerError(writer, request, *input.VersionId)
+			return
+		}
+		client, err := handler.GCPRequestSetup(request)
+		if client != nil {
+			defer handler.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucketHandle := handler.GCPClientToBucket(handler.BucketRename(*input.Bucket), client)
+		objHandle := versionHandle(handler.GCPBucketToObject(*input.Key, bucketHandle), generation)
+		aclList, err := objHandle.ACL().List(*handler.Context)
+		if err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			writeVersionError(writer, request, err, generation)
+			return
+		}
+		response = converter.GCSACLResponseToAWS(aclList)
+	} else {
+		logging.LogUsingAWS()
+		req := handler.S3Client.GetObjectAclRequest(input)
+		resp, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+		response = converter.AWSACLToXML(resp.Owner, resp.Grants)
+	}
+	output, _ := xml.MarshalIndent(response, "  ", "    ")
+	writer.Write([]byte(s3_handler.XmlHeader))
+	writer.Write([]byte(string(output)))
+}
+
+// Parse input for put object ACL request, a canned ACL, grant headers or an access control policy body
+func (handler *Handler) PutACLParseInput(r *http.Request) (*s3.PutObjectAclInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+	input := &s3.PutObjectAclInput{
+		Bucket:    &bucket,
+		Key:       &key,
+		VersionId: parseVersionId(r),
+		ACL:       s3.ObjectCannedACL(r.Header.Get("x-amz-acl")),
+	}
+	grants := s3_handler.GrantHeaders(r.Header)
+	input.GrantFullControl = grants[s3.PermissionFullControl]
+	input.GrantRead = grants[s3.PermissionRead]
+	input.GrantReadACP = grants[s3.PermissionReadAcp]
+	input.GrantWrite = grants[s3.PermissionWrite]
+	input.GrantWriteACP = grants[s3.PermissionWriteAcp]
+	policy, err := s3_handler.ParseAccessControlPolicy(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	input.AccessControlPolicy = policy
+	return input, nil
+}
+
+// Handle put object ACL request.  Canned ACLs become GCS predefined ACLs and grants replace the object ACL rules
+func (handler *Handler) PutACLHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := handler.PutACLParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3MalformedACLError, "")
+		return
+	}
+	if handler.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		headers := s3_handler.GrantFields(input.GrantFullControl, input.GrantRead, input.GrantReadACP, input.GrantWrite, input.GrantWriteACP)
+		predefined, rules, ok := handler.objectACL(writer, request, string(input.ACL), input.AccessControlPolicy, headers)
+		if !ok {
+			return
+		}
+		if predefined == "" && rules == nil {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3MalformedACLError, "")
+			return
+		}
+		generation, deleteMarker, err := gcsVersion(input.VersionId)
+		if err != nil {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+			return
+		}
+		if deleteMarker {
+			writeDeleteMarkerError(writer, request, *input.VersionId)
+			return
+		}
+		client, err := handler.GCPRequestSetup(request)
+		if client != nil {
+			defer handler.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucketHandle := handler.GCPClientToBucket(handler.BucketRename(*input.Bucket), client)
+		objHandle := versionHandle(handler.GCPBucketToObject(*input.Key, bucketHandle), generation)
+		if predefined != "" {
+			_, err = objHandle.Update(*handler.Context, storage.ObjectAttrsToUpdate{PredefinedACL: predefined})
+		} else {
+			err = handler.ReplaceACL(objHandle.ACL(), rules)
+		}
+		if err != nil {
+			logging.Log.Error("Error with GCP %s %s", request.RequestURI, err)
+			writeVersionError(writer, request, err, generation)
+			return
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := handler.S3Client.PutObjectAclRequest(input)
+		_, respError := req.Send()
+		if respError != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+// GCS ACL for an object request, writing the error when it can't be translated
+func (handler *Handler) objectACL(writer http.ResponseWriter, request *http.Request, canned string, policy *s3.AccessControlPolicy, headers map[s3.Permission]*string) (string, []storage.ACLRule, bool) {
+	principals := handler.Config.GetStringMapString(s3_handler.PrincipalMapKey)
+	predefined, rules, unsupported, err := converter.AWSACLToGCS(canned, policy, headers, principals, true)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+		return "", nil, false
+	}
+	if len(unsupported) > 0 {
+		logging.Log.Error("Error %s unsupported ACL %s", request.RequestURI, unsupported)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3NotImplemented, "Unsupported grants: "+strings.Join(unsupported, "; "))
+		return "", nil, false
+	}
+	return predefined, rules, true
+}
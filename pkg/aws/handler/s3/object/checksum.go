@@ -0,0 +1,252 @@
+package object
+
+import (
+	"bytes"
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/response_type"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	checksumCRC32  = "CRC32"
+	checksumCRC32C = "CRC32C"
+	checksumSHA1   = "SHA1"
+	checksumSHA256 = "SHA256"
+	// Checksums GCS doesn't keep itself are stored in metadata under this prefix and the lower case algorithm
+	checksumMetadataPrefix = "cloudsidecar-checksum-"
+	checksumModeHeader     = "x-amz-checksum-mode"
+)
+
+var checksumAlgorithms = []string{checksumCRC32, checksumCRC32C, checksumSHA1, checksumSHA256}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksums an upload was sent with
+type checksum struct {
+	// CRC32, CRC32C, SHA1 or SHA256, empty when no additional checksum was asked for
+	Algorithm string
+	// base64 value from the x-amz-checksum header, empty when it is only computed or comes in a trailer
+	Expected   string
+	ContentMD5 []byte
+}
+
+// An upload that was rejected, reported with its S3 error code
+type uploadError struct {
+	code    response_type.S3ErrorCode
+	message string
+}
+
+func (e *uploadError) Error() string {
+	return e.message
+}
+
+// Header carrying the checksum for an algorithm
+func checksumHeader(algorithm string) string {
+	return "x-amz-checksum-" + strings.ToLower(algorithm)
+}
+
+func newChecksumHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case checksumCRC32:
+		return crc32.NewIEEE()
+	case checksumCRC32C:
+		return crc32.New(crc32cTable)
+	case checksumSHA1:
+		return sha1.New()
+	case checksumSHA256:
+		return sha256.New()
+	}
+	return nil
+}
+
+// Read Content-MD5, x-amz-sdk-checksum-algorithm, x-amz-checksum-* and the checksum named by x-amz-trailer.
+// Only one additional checksum may be sent
+func parseChecksum(header http.Header) (*checksum, error) {
+	sum := &checksum{}
+	if contentMD5 := header.Get("Content-MD5"); contentMD5 != "" {
+		decoded, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(decoded) != md5.Size {
+			return nil, &uploadError{response_type.S3InvalidDigest, response_type.S3InvalidDigest.Message}
+		}
+		sum.ContentMD5 = decoded
+	}
+	for _, algorithm := range checksumAlgorithms {
+		value := header.Get(checksumHeader(algorithm))
+		if value == "" {
+			continue
+		}
+		if sum.Algorithm != "" {
+			return nil, &uploadError{response_type.S3InvalidRequest, "Expecting a single x-amz-checksum- header. Multiple checksum Types are not allowed."}
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(decoded) != newChecksumHash(algorithm).Size() {
+			return nil, &uploadError{response_type.S3InvalidRequest, fmt.Sprintf("Value for %s header is invalid.", checksumHeader(algorithm))}
+		}
+		sum.Algorithm = algorithm
+		sum.Expected = value
+	}
+	if trailer := strings.ToLower(header.Get("x-amz-trailer")); strings.HasPrefix(trailer, "x-amz-checksum-") {
+		algorithm := strings.ToUpper(strings.TrimPrefix(trailer, "x-amz-checksum-"))
+		if newChecksumHash(algorithm) == nil {
+			return nil, &uploadError{response_type.S3InvalidRequest, "The value specified in the x-amz-trailer header is not supported"}
+		}
+		if sum.Algorithm != "" && sum.Algorithm != algorithm {
+			return nil, &uploadError{response_type.S3InvalidRequest, "Expecting a single x-amz-checksum- header. Multiple checksum Types are not allowed."}
+		}
+		sum.Algorithm = algorithm
+	}
+	if sdkAlgorithm := strings.ToUpper(header.Get("x-amz-sdk-checksum-algorithm")); sdkAlgorithm != "" {
+		if newChecksumHash(sdkAlgorithm) == nil {
+			return nil, &uploadError{response_type.S3InvalidRequest, "Checksum algorithm provided is unsupported. Please try again with any of the valid types: [CRC32, CRC32C, SHA1, SHA256]"}
+		}
+		if sum.Algorithm == "" {
+			sum.Algorithm = sdkAlgorithm
+		} else if sum.Algorithm != sdkAlgorithm {
+			return nil, &uploadError{response_type.S3InvalidRequest, "Value for x-amz-sdk-checksum-algorithm header is invalid."}
+		}
+	}
+	return sum, nil
+}
+
+// Whether there is anything to verify or compute
+func (sum *checksum) isSet() bool {
+	return sum != nil && (sum.Algorithm != "" || len(sum.ContentMD5) > 0)
+}
+
+// Send the checksums to GCS as well so it rejects an object that doesn't match.  Checksums GCS doesn't know are
+// kept in metadata when their value is known up front
+func (sum *checksum) applyToWriter(writer *storage.Writer) {
+	if len(sum.ContentMD5) > 0 {
+		writer.MD5 = sum.ContentMD5
+	}
+	if sum.Expected == "" {
+		return
+	}
+	if sum.Algorithm == checksumCRC32C {
+		decoded, _ := base64.StdEncoding.DecodeString(sum.Expected)
+		writer.CRC32C = binary.BigEndian.Uint32(decoded)
+		writer.SendCRC32C = true
+		return
+	}
+	if writer.Metadata == nil {
+		writer.Metadata = make(map[string]string)
+	}
+	writer.Metadata[checksumMetadataPrefix+strings.ToLower(sum.Algorithm)] = sum.Expected
+}
+
+// Verifies an upload body as it is read.  The error is returned in place of io.EOF so the write is abandoned
+// before it is committed
+type checksumReader struct {
+	reader   io.Reader
+	checksum *checksum
+	hash     hash.Hash
+	md5      hash.Hash
+	// base64 checksum of everything read, set at the end of the body
+	Sum string
+}
+
+func newChecksumReader(reader io.Reader, sum *checksum) *checksumReader {
+	checksumReader := &checksumReader{reader: reader, checksum: sum, hash: newChecksumHash(sum.Algorithm)}
+	if len(sum.ContentMD5) > 0 {
+		checksumReader.md5 = md5.New()
+	}
+	return checksumReader
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	if c.hash != nil {
+		c.hash.Write(p[:n])
+	}
+	if c.md5 != nil {
+		c.md5.Write(p[:n])
+	}
+	if err == io.EOF {
+		if verifyErr := c.verify(); verifyErr != nil {
+			return n, verifyErr
+		}
+	}
+	return n, err
+}
+
+func (c *checksumReader) verify() error {
+	if c.md5 != nil && !bytes.Equal(c.md5.Sum(nil), c.checksum.ContentMD5) {
+		return &uploadError{response_type.S3BadDigest, response_type.S3BadDigest.Message}
+	}
+	if c.hash == nil {
+		return nil
+	}
+	c.Sum = base64.StdEncoding.EncodeToString(c.hash.Sum(nil))
+	if c.checksum.Expected != "" && c.checksum.Expected != c.Sum {
+		return &uploadError{response_type.S3BadDigest, fmt.Sprintf("The %s you specified did not match the calculated checksum.", c.checksum.Algorithm)}
+	}
+	return nil
+}
+
+// Send back the checksum computed while uploading
+func writeUploadChecksum(writer http.ResponseWriter, body io.Reader) {
+	if checksumReader, ok := body.(*checksumReader); ok && checksumReader.Sum != "" {
+		writer.Header().Set(checksumHeader(checksumReader.checksum.Algorithm), checksumReader.Sum)
+	}
+}
+
+// Write an upload failure, using the upload error code when the body was rejected
+func writeUploadError(writer http.ResponseWriter, request *http.Request, err error) {
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.OrigErr() != nil {
+		// the AWS client wraps errors from reading the body
+		if _, ok := awsErr.OrigErr().(*uploadError); ok {
+			err = awsErr.OrigErr()
+		}
+	}
+	if uploadErr, ok := err.(*uploadError); ok {
+		s3_handler.WriteErrorCode(writer, request, uploadErr.code, uploadErr.message)
+		return
+	}
+	s3_handler.WriteError(writer, request, err)
+}
+
+// Write an error from parsing an upload request, where anything but a rejected upload is a bad argument
+func writeParseError(writer http.ResponseWriter, request *http.Request, err error) {
+	if uploadErr, ok := err.(*uploadError); ok {
+		s3_handler.WriteErrorCode(writer, request, uploadErr.code, uploadErr.message)
+		return
+	}
+	s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+}
+
+// Checksum headers for a GCS object when the client asked for them.  GCS keeps a CRC32C of every object, other
+// algorithms come from metadata.  Nothing is sent for part of an object since the checksum covers all of it
+func writeChecksumHeaders(writer http.ResponseWriter, request *http.Request, attrs *storage.ObjectAttrs, wholeObject bool) {
+	if !wholeObject || !strings.EqualFold(request.Header.Get(checksumModeHeader), "ENABLED") {
+		return
+	}
+	for _, algorithm := range checksumAlgorithms {
+		if value := attrs.Metadata[checksumMetadataPrefix+strings.ToLower(algorithm)]; value != "" {
+			writer.Header().Set(checksumHeader(algorithm), value)
+			return
+		}
+	}
+	writer.Header().Set(checksumHeader(checksumCRC32C), converter.CRC32CToChecksum(attrs.CRC32C))
+}
+
+// Copy checksum headers from an S3 response
+func copyAWSChecksumHeaders(writer http.ResponseWriter, headers http.Header) {
+	for _, algorithm := range checksumAlgorithms {
+		if value := headers.Get(checksumHeader(algorithm)); value != "" {
+			writer.Header().Set(checksumHeader(algorithm), value)
+		}
+	}
+}
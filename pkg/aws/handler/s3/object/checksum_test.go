@@ -0,0 +1,111 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/response_type"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// checksums of "hello world"
+const (
+	helloCRC32C = "yZRlqg=="
+	helloSHA256 = "uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek="
+	helloMD5    = "XrY7u+Ae7tCTyyK7j1rNww=="
+)
+
+func TestParseChecksum(t *testing.T) {
+	header := make(http.Header)
+	header.Set("x-amz-checksum-crc32c", helloCRC32C)
+	header.Set("x-amz-sdk-checksum-algorithm", "crc32c")
+	header.Set("Content-MD5", helloMD5)
+	sum, err := parseChecksum(header)
+	assert.Nil(t, err)
+	assert.Equal(t, checksumCRC32C, sum.Algorithm)
+	assert.Equal(t, helloCRC32C, sum.Expected)
+	assert.Equal(t, 16, len(sum.ContentMD5))
+
+	header.Set("x-amz-checksum-sha256", helloSHA256)
+	_, err = parseChecksum(header)
+	assert.Equal(t, response_type.S3InvalidRequest, err.(*uploadError).code)
+
+	header = make(http.Header)
+	header.Set("x-amz-checksum-sha256", helloCRC32C)
+	_, err = parseChecksum(header)
+	assert.Equal(t, response_type.S3InvalidRequest, err.(*uploadError).code)
+
+	header = make(http.Header)
+	header.Set("Content-MD5", "nope")
+	_, err = parseChecksum(header)
+	assert.Equal(t, response_type.S3InvalidDigest, err.(*uploadError).code)
+
+	header = make(http.Header)
+	header.Set("x-amz-trailer", "x-amz-checksum-sha256")
+	sum, err = parseChecksum(header)
+	assert.Nil(t, err)
+	assert.Equal(t, checksumSHA256, sum.Algorithm)
+	assert.Equal(t, "", sum.Expected)
+
+	sum, err = parseChecksum(make(http.Header))
+	assert.Nil(t, err)
+	assert.False(t, sum.isSet())
+}
+
+func TestChecksumReader(t *testing.T) {
+	reader := newChecksumReader(strings.NewReader("hello world"), &checksum{Algorithm: checksumSHA256, Expected: helloSHA256})
+	body, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, helloSHA256, reader.Sum)
+
+	// computed only, nothing to compare against
+	reader = newChecksumReader(strings.NewReader("hello world"), &checksum{Algorithm: checksumCRC32C})
+	_, err = ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, helloCRC32C, reader.Sum)
+
+	reader = newChecksumReader(strings.NewReader("hello world!"), &checksum{Algorithm: checksumCRC32C, Expected: helloCRC32C})
+	_, err = ioutil.ReadAll(reader)
+	assert.Equal(t, response_type.S3BadDigest, err.(*uploadError).code)
+	assert.Equal(t, "The CRC32C you specified did not match the calculated checksum.", err.Error())
+
+	sum, _ := parseChecksum(http.Header{"Content-Md5": []string{helloMD5}})
+	_, err = ioutil.ReadAll(newChecksumReader(strings.NewReader("goodbye world"), sum))
+	assert.Equal(t, response_type.S3BadDigest, err.(*uploadError).code)
+}
+
+func TestChecksumApplyToWriter(t *testing.T) {
+	writer := &storage.Writer{}
+	(&checksum{Algorithm: checksumCRC32C, Expected: helloCRC32C}).applyToWriter(writer)
+	assert.True(t, writer.SendCRC32C)
+	assert.Equal(t, uint32(0xc99465aa), writer.CRC32C)
+
+	writer = &storage.Writer{}
+	(&checksum{Algorithm: checksumSHA256, Expected: helloSHA256}).applyToWriter(writer)
+	assert.False(t, writer.SendCRC32C)
+	assert.Equal(t, helloSHA256, writer.Metadata["cloudsidecar-checksum-sha256"])
+}
+
+func TestWriteChecksumHeaders(t *testing.T) {
+	attrs := &storage.ObjectAttrs{CRC32C: 0xc99465aa}
+	request := httptest.NewRequest("GET", "/boops/mykey", nil)
+	recorder := httptest.NewRecorder()
+	writeChecksumHeaders(recorder, request, attrs, true)
+	assert.Equal(t, "", recorder.Header().Get("x-amz-checksum-crc32c"))
+
+	request.Header.Set(checksumModeHeader, "ENABLED")
+	writeChecksumHeaders(recorder, request, attrs, false)
+	assert.Equal(t, "", recorder.Header().Get("x-amz-checksum-crc32c"))
+	writeChecksumHeaders(recorder, request, attrs, true)
+	assert.Equal(t, helloCRC32C, recorder.Header().Get("x-amz-checksum-crc32c"))
+
+	attrs.Metadata = map[string]string{"cloudsidecar-checksum-sha256": helloSHA256}
+	recorder = httptest.NewRecorder()
+	writeChecksumHeaders(recorder, request, attrs, true)
+	assert.Equal(t, helloSHA256, recorder.Header().Get("x-amz-checksum-sha256"))
+	assert.Equal(t, "", recorder.Header().Get("x-amz-checksum-crc32c"))
+}
@@ -0,0 +1,305 @@
+package object
+
+import (
+	"bufio"
+	"bytes"
+	"cloudsidecar/pkg/response_type"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type ReaderWrapper struct {
+	Reader io.ReadCloser
+}
+
+func (wrapper ReaderWrapper) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New("invalid operation")
+}
+
+func (wrapper ReaderWrapper) Read(p []byte) (n int, err error) {
+	return (wrapper.Reader).Read(p)
+}
+
+const (
+	streamingSigned          = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingSignedTrailer   = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	// Longest chunk header or trailer line accepted
+	maxChunkLineLength = 4096
+)
+
+var inboundCredentialsKey = "inbound_credentials"
+
+var emptySHA256 = sha256.Sum256(nil)
+
+// Whether an x-amz-content-sha256 value means the body is aws-chunked
+func isChunkedPayload(contentSHA256 string) bool {
+	return strings.HasPrefix(contentSHA256, "STREAMING-")
+}
+
+// Decodes an aws-chunked body.  Each chunk is a hex size, a signature for signed payloads, and the data.  A zero
+// size chunk ends the body and is followed by trailing headers when the payload has a trailer
+type chunkedReader struct {
+	reader *bufio.Reader
+	// nil when chunk signatures aren't checked
+	signer     *chunkSigner
+	hasTrailer bool
+	// data left in the current chunk, -1 between chunks
+	remaining int
+	signature string
+	chunkHash hash.Hash
+	err       error
+	// Called with the trailing headers before the end of the body is returned
+	onTrailers func(trailers http.Header) error
+}
+
+func newChunkedReader(reader io.Reader, contentSHA256 string, signer *chunkSigner) *chunkedReader {
+	return &chunkedReader{
+		reader:     bufio.NewReader(reader),
+		signer:     signer,
+		hasTrailer: strings.HasSuffix(contentSHA256, "-TRAILER"),
+		remaining:  -1,
+		chunkHash:  sha256.New(),
+	}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.remaining < 0 {
+		if c.err = c.readChunkHeader(); c.err != nil {
+			return 0, c.err
+		}
+		if c.remaining == 0 {
+			c.err = c.finish()
+			return 0, c.err
+		}
+	}
+	if len(p) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	c.chunkHash.Write(p[:n])
+	c.remaining -= n
+	if c.remaining == 0 {
+		c.remaining = -1
+		if err = c.endChunk(); err != nil {
+			c.err = err
+			return 0, err
+		}
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// Read a line without its line ending, io.EOF when there is nothing left
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > maxChunkLineLength {
+		return "", errors.New("chunk header too long")
+	}
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func (c *chunkedReader) readChunkHeader() error {
+	line, err := c.readLine()
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	parts := strings.SplitN(line, ";", 2)
+	size, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 16, 32)
+	if err != nil || size < 0 {
+		return &uploadError{response_type.S3IncompleteBody, "Invalid chunk size " + parts[0]}
+	}
+	c.signature = ""
+	if len(parts) == 2 {
+		c.signature = strings.TrimPrefix(strings.TrimSpace(parts[1]), "chunk-signature=")
+	}
+	if c.signer != nil && c.signature == "" {
+		return &uploadError{response_type.S3SignatureDoesNotMatch, "Chunk is missing its signature"}
+	}
+	c.remaining = int(size)
+	c.chunkHash.Reset()
+	return nil
+}
+
+// Skip the line ending after chunk data and check the chunk signature
+func (c *chunkedReader) endChunk() error {
+	if _, err := c.readLine(); err != nil && err != io.EOF {
+		return err
+	}
+	return c.verifyChunk()
+}
+
+func (c *chunkedReader) verifyChunk() error {
+	if c.signer == nil {
+		return nil
+	}
+	if !c.signer.verifyChunk(c.signature, c.chunkHash.Sum(nil)) {
+		return &uploadError{response_type.S3SignatureDoesNotMatch, response_type.S3SignatureDoesNotMatch.Message}
+	}
+	return nil
+}
+
+// Check the final chunk and read trailing headers, io.EOF when all is well
+func (c *chunkedReader) finish() error {
+	if err := c.verifyChunk(); err != nil {
+		return err
+	}
+	trailers := make(http.Header)
+	// trailer lines exactly as sent, which is what the trailer signature covers
+	var signed bytes.Buffer
+	for {
+		line, err := c.readLine()
+		if err == io.EOF || (err == nil && line == "") {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !c.hasTrailer {
+			return &uploadError{response_type.S3IncompleteBody, "Unexpected data after the final chunk"}
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return &uploadError{response_type.S3InvalidRequest, "Malformed trailing header " + line}
+		}
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		if name == "x-amz-trailer-signature" {
+			if c.signer != nil && !c.signer.verifyTrailer(value, signed.Bytes()) {
+				return &uploadError{response_type.S3SignatureDoesNotMatch, response_type.S3SignatureDoesNotMatch.Message}
+			}
+			continue
+		}
+		signed.WriteString(name + ":" + value + "\n")
+		trailers.Set(name, value)
+	}
+	if c.onTrailers != nil {
+		if err := c.onTrailers(trailers); err != nil {
+			return err
+		}
+	}
+	return io.EOF
+}
+
+// Signature chain for a signed aws-chunked body.  Every chunk signs its data along with the signature before it,
+// starting from the signature of the request itself
+type chunkSigner struct {
+	signingKey []byte
+	timestamp  string
+	// date/region/service/aws4_request
+	scope    string
+	previous string
+}
+
+// Signer for a request signed with one of the configured inbound credentials
+func newChunkSigner(header http.Header, credentials map[string]string) (*chunkSigner, error) {
+	authorization := header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 ") {
+		return nil, &uploadError{response_type.S3AccessDenied, "Streaming uploads must be signed with AWS4-HMAC-SHA256"}
+	}
+	var credential, signature string
+	for _, field := range strings.Split(strings.TrimPrefix(authorization, "AWS4-HMAC-SHA256 "), ",") {
+		field = strings.TrimSpace(field)
+		if strings.HasPrefix(field, "Credential=") {
+			credential = strings.TrimPrefix(field, "Credential=")
+		} else if strings.HasPrefix(field, "Signature=") {
+			signature = strings.TrimPrefix(field, "Signature=")
+		}
+	}
+	// access key/date/region/service/aws4_request
+	scopeParts := strings.SplitN(credential, "/", 2)
+	if len(scopeParts) != 2 || signature == "" {
+		return nil, &uploadError{response_type.S3AccessDenied, "Authorization header is malformed"}
+	}
+	secret, ok := credentials[strings.ToLower(scopeParts[0])]
+	if !ok {
+		return nil, &uploadError{response_type.S3InvalidAccessKeyId, response_type.S3InvalidAccessKeyId.Message}
+	}
+	scope := strings.Split(scopeParts[1], "/")
+	if len(scope) != 4 {
+		return nil, &uploadError{response_type.S3AccessDenied, "Authorization header is malformed"}
+	}
+	key := []byte("AWS4" + secret)
+	for _, part := range scope {
+		key = hmacSHA256(key, part)
+	}
+	return &chunkSigner{
+		signingKey: key,
+		timestamp:  header.Get("x-amz-date"),
+		scope:      scopeParts[1],
+		previous:   signature,
+	}, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func (s *chunkSigner) sign(stringToSign string) string {
+	signature := hex.EncodeToString(hmacSHA256(s.signingKey, stringToSign))
+	s.previous = signature
+	return signature
+}
+
+func (s *chunkSigner) verifyChunk(signature string, chunkHash []byte) bool {
+	expected := s.sign(strings.Join([]string{
+		"AWS4-HMAC-SHA256-PAYLOAD",
+		s.timestamp,
+		s.scope,
+		s.previous,
+		hex.EncodeToString(emptySHA256[:]),
+		hex.EncodeToString(chunkHash),
+	}, "\n"))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func (s *chunkSigner) verifyTrailer(signature string, trailers []byte) bool {
+	trailerHash := sha256.Sum256(trailers)
+	expected := s.sign(strings.Join([]string{
+		"AWS4-HMAC-SHA256-TRAILER",
+		s.timestamp,
+		s.scope,
+		s.previous,
+		hex.EncodeToString(trailerHash[:]),
+	}, "\n"))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// Decoder for an aws-chunked upload body.  Chunk signatures are checked when inbound credentials are configured
+func (handler *Handler) chunkedBody(r *http.Request) (*chunkedReader, error) {
+	contentSHA256 := r.Header.Get("x-amz-content-sha256")
+	var signer *chunkSigner
+	if handler.Handler != nil && handler.Config != nil && handler.Config.IsSet(inboundCredentialsKey) &&
+		contentSHA256 != streamingUnsignedTrailer {
+		if contentSHA256 != streamingSigned && contentSHA256 != streamingSignedTrailer {
+			return nil, &uploadError{response_type.S3NotImplemented, "Payload signing " + contentSHA256 + " is not supported"}
+		}
+		var err error
+		if signer, err = newChunkSigner(r.Header, handler.Config.GetStringMapString(inboundCredentialsKey)); err != nil {
+			return nil, err
+		}
+	}
+	return newChunkedReader(r.Body, contentSHA256, signer), nil
+}
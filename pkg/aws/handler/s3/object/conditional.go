@@ -0,0 +1,105 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/response_type"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Conditional headers from a GET, HEAD, or the copy source of a COPY
+type conditions struct {
+	IfMatch           *string
+	IfNoneMatch       *string
+	IfModifiedSince   *time.Time
+	IfUnmodifiedSince *time.Time
+}
+
+// Whether any condition was sent
+func (c conditions) isSet() bool {
+	return c.IfMatch != nil || c.IfNoneMatch != nil || c.IfModifiedSince != nil || c.IfUnmodifiedSince != nil
+}
+
+// Read conditional headers.  Prefix is empty for regular requests and x-amz-copy-source- for copies
+func parseConditions(header http.Header, prefix string) conditions {
+	var c conditions
+	if value := header.Get(prefix + "If-Match"); value != "" {
+		c.IfMatch = &value
+	}
+	if value := header.Get(prefix + "If-None-Match"); value != "" {
+		c.IfNoneMatch = &value
+	}
+	if value := header.Get(prefix + "If-Modified-Since"); value != "" {
+		if parsed, err := http.ParseTime(value); err == nil {
+			c.IfModifiedSince = &parsed
+		}
+	}
+	if value := header.Get(prefix + "If-Unmodified-Since"); value != "" {
+		if parsed, err := http.ParseTime(value); err == nil {
+			c.IfUnmodifiedSince = &parsed
+		}
+	}
+	return c
+}
+
+// Check if an etag header value matches the object etag.  Handles *, lists and quoted or weak etags
+func etagMatches(headerValue string, etag string) bool {
+	for _, candidate := range strings.Split(headerValue, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if strings.Trim(candidate, "\"") == strings.Trim(etag, "\"") && etag != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate conditions in RFC 7232 order.  Returns false and the error to send when a condition fails.
+// Reads fail If-None-Match and If-Modified-Since with 304, everything else fails with 412
+func (c conditions) evaluate(etag string, lastModified time.Time, isRead bool) (response_type.S3ErrorCode, bool) {
+	// http dates only have second precision
+	lastModified = lastModified.Truncate(time.Second)
+	if c.IfMatch != nil {
+		if !etagMatches(*c.IfMatch, etag) {
+			return response_type.S3PreconditionFailed, false
+		}
+	} else if c.IfUnmodifiedSince != nil && lastModified.After(*c.IfUnmodifiedSince) {
+		return response_type.S3PreconditionFailed, false
+	}
+	notModified := response_type.S3PreconditionFailed
+	if isRead {
+		notModified = response_type.S3NotModified
+	}
+	if c.IfNoneMatch != nil {
+		if etagMatches(*c.IfNoneMatch, etag) {
+			return notModified, false
+		}
+	} else if c.IfModifiedSince != nil && !lastModified.After(*c.IfModifiedSince) {
+		return notModified, false
+	}
+	return response_type.S3ErrorCode{}, true
+}
+
+// Evaluate conditions against GCS object attributes
+func (c conditions) evaluateGCS(attrs *storage.ObjectAttrs, isRead bool) (response_type.S3ErrorCode, bool) {
+	etag := ""
+	if len(attrs.MD5) > 0 {
+		etag = converter.MD5toEtag(attrs.MD5)
+	}
+	return c.evaluate(etag, attrs.Updated, isRead)
+}
+
+// Send a failed condition.  Not modified responses carry the validators so caches can refresh
+func writeConditionFailure(writer http.ResponseWriter, request *http.Request, attrs *storage.ObjectAttrs, code response_type.S3ErrorCode) {
+	if code == response_type.S3NotModified {
+		converter.GCSMD5ToEtag(attrs, writer)
+		converter.GCSUpdatedToLastModified(attrs, writer)
+	}
+	s3_handler.WriteErrorCode(writer, request, code, "")
+}
@@ -0,0 +1,186 @@
+package object
+
+import (
+	"bytes"
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/mock"
+	"cloudsidecar/pkg/response_type"
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestConditions_parse(t *testing.T) {
+	header := make(http.Header)
+	header.Set("x-amz-copy-source-if-match", "\"abc\"")
+	header.Set("x-amz-copy-source-if-modified-since", "Mon, 18 Feb 2019 04:23:14 GMT")
+	conds := parseConditions(header, "x-amz-copy-source-")
+	assert.Equal(t, "\"abc\"", *conds.IfMatch)
+	assert.Equal(t, time.Unix(1550463794, 0).Unix(), conds.IfModifiedSince.Unix())
+	assert.Nil(t, conds.IfNoneMatch)
+	assert.False(t, parseConditions(header, "").isSet())
+}
+
+func TestConditions_etagMatches(t *testing.T) {
+	assert.True(t, etagMatches("*", "abc"))
+	assert.True(t, etagMatches("\"abc\"", "abc"))
+	assert.True(t, etagMatches("\"nope\", W/\"abc\"", "abc"))
+	assert.False(t, etagMatches("\"nope\"", "abc"))
+	assert.False(t, etagMatches("\"\"", ""))
+}
+
+func TestConditions_evaluate(t *testing.T) {
+	updated := time.Unix(1550463794, 500)
+	before := updated.Add(-time.Hour)
+	after := updated.Add(time.Hour)
+	match := "\"abc\""
+	noMatch := "\"def\""
+
+	_, ok := conditions{IfMatch: &match}.evaluate("abc", updated, true)
+	assert.True(t, ok)
+	code, ok := conditions{IfMatch: &noMatch}.evaluate("abc", updated, true)
+	assert.False(t, ok)
+	assert.Equal(t, response_type.S3PreconditionFailed, code)
+	code, ok = conditions{IfNoneMatch: &match}.evaluate("abc", updated, true)
+	assert.False(t, ok)
+	assert.Equal(t, response_type.S3NotModified, code)
+	code, ok = conditions{IfNoneMatch: &match}.evaluate("abc", updated, false)
+	assert.False(t, ok)
+	assert.Equal(t, response_type.S3PreconditionFailed, code)
+	code, ok = conditions{IfModifiedSince: &after}.evaluate("abc", updated, true)
+	assert.False(t, ok)
+	assert.Equal(t, response_type.S3NotModified, code)
+	// last modified equal to the header is not modified, sub second precision is ignored
+	truncated := updated.Truncate(time.Second)
+	_, ok = conditions{IfModifiedSince: &truncated}.evaluate("abc", updated, true)
+	assert.False(t, ok)
+	_, ok = conditions{IfModifiedSince: &before}.evaluate("abc", updated, true)
+	assert.True(t, ok)
+	code, ok = conditions{IfUnmodifiedSince: &before}.evaluate("abc", updated, true)
+	assert.False(t, ok)
+	assert.Equal(t, response_type.S3PreconditionFailed, code)
+	// a matching If-Match wins over a failing If-Unmodified-Since
+	_, ok = conditions{IfMatch: &match, IfUnmodifiedSince: &before}.evaluate("abc", updated, true)
+	assert.True(t, ok)
+	// a failing If-None-Match wins over a passing If-Modified-Since
+	_, ok = conditions{IfNoneMatch: &noMatch, IfModifiedSince: &after}.evaluate("abc", updated, true)
+	assert.True(t, ok)
+}
+
+func TestHandler_HeadHandle_NotModified(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	bucketMock := s3_handler.NewMockGCPBucket(ctrl)
+	clientMock := s3_handler.NewMockGCPClient(ctrl)
+	objectMock := s3_handler.NewMockGCPObject(ctrl)
+	writerMock := mock.NewMockResponseWriter(ctrl)
+	ctx := context.Background()
+	s3Handler := &s3_handler.Handler{
+		GCPClient: func() (s3_handler.GCPClient, error) {
+			return clientMock, nil
+		},
+		GCPClientPool: make(map[string][]s3_handler.GCPClient),
+		GCPClientToBucket: func(bucket string, client s3_handler.GCPClient) s3_handler.GCPBucket {
+			return bucketMock
+		},
+		GCPBucketToObject: func(name string, bucket s3_handler.GCPBucket) s3_handler.GCPObject {
+			return objectMock
+		},
+		Context: &ctx,
+		Config:  getConfig(),
+	}
+	testUrl, _ := url.ParseRequestURI("http://localhost:3450/boops/mykey")
+	req := &http.Request{
+		Method: http.MethodHead,
+		URL:    testUrl,
+		Header: make(http.Header),
+	}
+	req.Header.Set("If-None-Match", "\"0102\"")
+	req = mux.SetURLVars(req, map[string]string{"bucket": "boops", "key": "mykey"})
+	attrs := &storage.ObjectAttrs{
+		Bucket:  "boops",
+		Name:    "mykey",
+		Size:    123,
+		MD5:     []byte{1, 2},
+		Updated: time.Unix(1550463794, 0),
+	}
+	outputMap := make(http.Header)
+	objectMock.EXPECT().Attrs(ctx).Return(attrs, nil)
+	writerMock.EXPECT().Header().Return(outputMap).AnyTimes()
+	writerMock.EXPECT().WriteHeader(304)
+	handler := New(s3Handler)
+	handler.HeadHandle(writerMock, req)
+	assert.Equal(t, "0102", outputMap.Get("ETag"))
+	assert.Equal(t, "Mon, 18 Feb 2019 04:23:14 GMT", outputMap.Get("Last-Modified"))
+	assert.Empty(t, outputMap.Get("Content-Length"))
+}
+
+func TestHandler_PutHandleAWSIfNoneMatch(t *testing.T) {
+	conditional := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ioutil.ReadAll(request.Body)
+		query := request.URL.Query()
+		call := request.Method + " " + request.URL.Path
+		_, create := query["uploads"]
+		switch {
+		case request.Method == "POST" && create:
+			call += " create"
+			writer.Write([]byte(`<InitiateMultipartUploadResult><Bucket>boops</Bucket><Key>big</Key><UploadId>upload</UploadId></InitiateMultipartUploadResult>`))
+		case request.Method == "PUT" && query.Get("partNumber") != "":
+			call += " part " + query.Get("partNumber")
+			writer.Header().Set("ETag", `"part"`)
+		case request.Method == "POST":
+			call += " complete"
+			writer.Write([]byte(`<CompleteMultipartUploadResult><Bucket>boops</Bucket><Key>big</Key><ETag>"whole"</ETag></CompleteMultipartUploadResult>`))
+		default:
+			writer.Header().Set("ETag", `"whole"`)
+		}
+		conditional[call] = request.Header.Get("If-None-Match")
+	}))
+	defer server.Close()
+	awsConfig := defaults.Config()
+	awsConfig.Region = "us-east-1"
+	awsConfig.Credentials = aws.NewStaticCredentialsProvider("MY_KEY", "SUPER_SECRET", "")
+	awsConfig.EndpointResolver = aws.ResolveWithEndpointURL(server.URL)
+	awsConfig.HTTPClient = server.Client()
+	client := s3.New(awsConfig)
+	client.ForcePathStyle = true
+	handler := New(&s3_handler.Handler{Config: viper.New(), S3Client: client})
+	router := mux.NewRouter()
+	handler.Register(router)
+	put := func(key string, size int64) int {
+		request := httptest.NewRequest("PUT", "http://localhost:3450/boops/"+key, bytes.NewReader(make([]byte, size)))
+		request.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+		request.Header.Set("If-None-Match", "*")
+		writer := httptest.NewRecorder()
+		router.ServeHTTP(writer, request)
+		return writer.Code
+	}
+
+	assert.Equal(t, 200, put("small", 10))
+	assert.Equal(t, map[string]string{"PUT /boops/small": "*"}, conditional)
+
+	// S3 refuses the header on part uploads, so only the request creating the object has it
+	conditional = make(map[string]string)
+	assert.Equal(t, 200, put("big", s3manager.MinUploadPartSize+1))
+	assert.Equal(t, map[string]string{
+		"POST /boops/big create":   "",
+		"PUT /boops/big part 1":    "",
+		"PUT /boops/big part 2":    "",
+		"POST /boops/big complete": "*",
+	}, conditional)
+}
@@ -0,0 +1,240 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/response_type"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"net/http"
+	"strings"
+)
+
+var kmsKeyMapKey = "gcp_destination_config.gcs_config.kms_key_map"
+var defaultKMSKeyKey = "gcp_destination_config.gcs_config.default_kms_key"
+
+const (
+	sseAES256 = "AES256"
+	sseKMS    = "aws:kms"
+	// Multipart upload file lines holding the encryption of the combined object.  Only a hash of a customer key
+	// is kept, the key itself comes with every part and again with the completion
+	kmsKeyLinePrefix          = "kms-key-name="
+	customerKeyHashLinePrefix = "customer-key-sha256="
+)
+
+// Server side encryption requested by S3 headers
+type encryption struct {
+	// AES256 or aws:kms from x-amz-server-side-encryption
+	Algorithm string
+	KMSKeyId  string
+	// SSE-C key, already decoded and checked against its MD5
+	CustomerKey    []byte
+	CustomerKeyMD5 string
+}
+
+// Read server side encryption headers.  Prefix is x-amz- for the object being written or read and
+// x-amz-copy-source- for the source of a copy, which can only be SSE-C
+func parseEncryption(header http.Header, prefix string) (*encryption, error) {
+	sse := &encryption{}
+	if prefix == "x-amz-" {
+		sse.Algorithm = header.Get("x-amz-server-side-encryption")
+		sse.KMSKeyId = header.Get("x-amz-server-side-encryption-aws-kms-key-id")
+		if sse.Algorithm != "" && sse.Algorithm != sseAES256 && sse.Algorithm != sseKMS {
+			return nil, errors.New("The encryption method specified is not supported")
+		}
+		if sse.KMSKeyId != "" && sse.Algorithm != sseKMS {
+			return nil, errors.New("Server Side Encryption with KMS managed key requires HTTP header x-amz-server-side-encryption : aws:kms")
+		}
+	}
+	algorithm := header.Get(prefix + "server-side-encryption-customer-algorithm")
+	key := header.Get(prefix + "server-side-encryption-customer-key")
+	keyMD5 := header.Get(prefix + "server-side-encryption-customer-key-MD5")
+	if algorithm == "" && key == "" && keyMD5 == "" {
+		return sse, nil
+	}
+	if sse.Algorithm != "" {
+		return nil, errors.New("Server Side Encryption with Customer provided key is incompatible with the encryption method specified")
+	}
+	if algorithm != sseAES256 {
+		return nil, errors.New("The encryption algorithm specified is not valid, it must be AES256")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 32 {
+		return nil, errors.New("The secret key was invalid for the specified algorithm")
+	}
+	hash := md5.Sum(decoded)
+	computedMD5 := base64.StdEncoding.EncodeToString(hash[:])
+	if keyMD5 != "" && keyMD5 != computedMD5 {
+		return nil, errors.New("The calculated MD5 hash of the key did not match the hash that was provided")
+	}
+	sse.CustomerKey = decoded
+	sse.CustomerKeyMD5 = computedMD5
+	return sse, nil
+}
+
+// Whether a customer supplied key was sent
+func (sse *encryption) isCustomerKey() bool {
+	return sse != nil && len(sse.CustomerKey) > 0
+}
+
+// Cloud KMS key to write with.  AWS key ids and aliases are looked up in the configured map, Cloud KMS names
+// are used as is, and no key id uses the configured default.  An empty name leaves the bucket default in place
+func (handler *Handler) kmsKeyName(sse *encryption) (string, error) {
+	if sse == nil || sse.Algorithm != sseKMS {
+		return "", nil
+	}
+	if sse.KMSKeyId == "" {
+		return handler.Config.GetString(defaultKMSKeyKey), nil
+	}
+	if strings.HasPrefix(sse.KMSKeyId, "projects/") {
+		return sse.KMSKeyId, nil
+	}
+	// config keys are case insensitive
+	keyMap := handler.Config.GetStringMapString(kmsKeyMapKey)
+	if keyName, ok := keyMap[strings.ToLower(sse.KMSKeyId)]; ok {
+		return keyName, nil
+	}
+	return "", errors.New("Invalid KMS key id " + sse.KMSKeyId)
+}
+
+// AWS key id to report for a Cloud KMS key.  Versions are dropped and the configured alias is used if there is one
+func (handler *Handler) kmsKeyId(keyName string) string {
+	if index := strings.Index(keyName, "/cryptoKeyVersions/"); index >= 0 {
+		keyName = keyName[:index]
+	}
+	for alias, name := range handler.Config.GetStringMapString(kmsKeyMapKey) {
+		if name == keyName {
+			return alias
+		}
+	}
+	return keyName
+}
+
+// Echo encryption headers for a GCS object the way S3 would.  GCS always encrypts, so objects without a
+// customer or KMS key are reported as AES256
+func (handler *Handler) writeEncryptionHeaders(writer http.ResponseWriter, attrs *storage.ObjectAttrs, sse *encryption) {
+	if attrs.CustomerKeySHA256 != "" {
+		writer.Header().Set("x-amz-server-side-encryption-customer-algorithm", sseAES256)
+		if sse.isCustomerKey() {
+			writer.Header().Set("x-amz-server-side-encryption-customer-key-MD5", sse.CustomerKeyMD5)
+		}
+	} else if attrs.KMSKeyName != "" {
+		writer.Header().Set("x-amz-server-side-encryption", sseKMS)
+		writer.Header().Set("x-amz-server-side-encryption-aws-kms-key-id", handler.kmsKeyId(attrs.KMSKeyName))
+	} else {
+		writer.Header().Set("x-amz-server-side-encryption", sseAES256)
+	}
+}
+
+// Echo the encryption a multipart upload will be written with before any of it exists
+func (handler *Handler) writeUploadEncryptionHeaders(writer http.ResponseWriter, kmsKeyName string, sse *encryption) {
+	attrs := &storage.ObjectAttrs{KMSKeyName: kmsKeyName}
+	if sse.isCustomerKey() {
+		hash := sha256.Sum256(sse.CustomerKey)
+		attrs.CustomerKeySHA256 = base64.StdEncoding.EncodeToString(hash[:])
+	}
+	handler.writeEncryptionHeaders(writer, attrs, sse)
+}
+
+// Encryption settings carried in the fields of an AWS input
+func inputEncryption(algorithm s3.ServerSideEncryption, kmsKeyId *string, customerKey *string, customerKeyMD5 *string) *encryption {
+	sse := &encryption{Algorithm: string(algorithm)}
+	if kmsKeyId != nil {
+		sse.KMSKeyId = *kmsKeyId
+	}
+	if customerKey != nil {
+		sse.CustomerKey = []byte(*customerKey)
+	}
+	if customerKeyMD5 != nil {
+		sse.CustomerKeyMD5 = *customerKeyMD5
+	}
+	return sse
+}
+
+// SSE-C fields for an AWS input.  The SDK takes the raw key and encodes it itself
+func (sse *encryption) customerKeyFields() (algorithm *string, key *string, keyMD5 *string) {
+	if !sse.isCustomerKey() {
+		return nil, nil, nil
+	}
+	customerAlgorithm := sseAES256
+	customerKey := string(sse.CustomerKey)
+	customerKeyMD5 := sse.CustomerKeyMD5
+	return &customerAlgorithm, &customerKey, &customerKeyMD5
+}
+
+// KMS key id field for an AWS input
+func (sse *encryption) kmsKeyIdField() *string {
+	if sse.KMSKeyId == "" {
+		return nil
+	}
+	kmsKeyId := sse.KMSKeyId
+	return &kmsKeyId
+}
+
+// Make sure a request can read an object stored with a customer supplied key.  S3 asks for the key when it is
+// missing and denies access when it is the wrong one
+func checkCustomerKey(attrs *storage.ObjectAttrs, sse *encryption) (response_type.S3ErrorCode, string, bool) {
+	if attrs.CustomerKeySHA256 == "" {
+		return response_type.S3ErrorCode{}, "", true
+	}
+	if !sse.isCustomerKey() {
+		return response_type.S3InvalidRequest, "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object.", false
+	}
+	hash := sha256.Sum256(sse.CustomerKey)
+	if base64.StdEncoding.EncodeToString(hash[:]) != attrs.CustomerKeySHA256 {
+		return response_type.S3AccessDenied, "", false
+	}
+	return response_type.S3ErrorCode{}, "", true
+}
+
+// Multipart upload file lines for the encryption an upload was started with
+func uploadEncryptionLines(kmsKeyName string, sse *encryption) string {
+	lines := ""
+	if kmsKeyName != "" {
+		lines += kmsKeyLinePrefix + kmsKeyName + "\n"
+	}
+	if sse.isCustomerKey() {
+		hash := sha256.Sum256(sse.CustomerKey)
+		lines += customerKeyHashLinePrefix + base64.StdEncoding.EncodeToString(hash[:]) + "\n"
+	}
+	return lines
+}
+
+// KMS key name and customer key hash recorded in a multipart upload file, empty when the upload has none
+func uploadEncryption(partsFile string) (kmsKeyName string, customerKeySHA256 string) {
+	for _, line := range strings.Split(partsFile, "\n") {
+		if strings.HasPrefix(line, kmsKeyLinePrefix) {
+			kmsKeyName = strings.TrimPrefix(line, kmsKeyLinePrefix)
+		} else if strings.HasPrefix(line, customerKeyHashLinePrefix) {
+			customerKeySHA256 = strings.TrimPrefix(line, customerKeyHashLinePrefix)
+		}
+	}
+	return kmsKeyName, customerKeySHA256
+}
+
+// Make sure a part or completion of a multipart upload carries the customer key the upload was started with,
+// and none when it was started without one
+func checkUploadCustomerKey(customerKeySHA256 string, sse *encryption) (response_type.S3ErrorCode, string, bool) {
+	if customerKeySHA256 == "" && sse.isCustomerKey() {
+		return response_type.S3InvalidRequest, "The multipart upload was not initiated with a customer provided key", false
+	}
+	return checkCustomerKey(&storage.ObjectAttrs{CustomerKeySHA256: customerKeySHA256}, sse)
+}
+
+// Echo the encryption headers S3 sent back, or the ones that were requested when the SDK doesn't return them
+func writeAWSEncryptionHeaders(writer http.ResponseWriter, algorithm s3.ServerSideEncryption, kmsKeyId *string, customerAlgorithm *string, customerKeyMD5 *string) {
+	if algorithm != "" {
+		writer.Header().Set("x-amz-server-side-encryption", string(algorithm))
+	}
+	if kmsKeyId != nil {
+		writer.Header().Set("x-amz-server-side-encryption-aws-kms-key-id", *kmsKeyId)
+	}
+	if customerAlgorithm != nil {
+		writer.Header().Set("x-amz-server-side-encryption-customer-algorithm", *customerAlgorithm)
+	}
+	if customerKeyMD5 != nil {
+		writer.Header().Set("x-amz-server-side-encryption-customer-key-MD5", *customerKeyMD5)
+	}
+}
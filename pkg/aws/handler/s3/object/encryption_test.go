@@ -0,0 +1,197 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/response_type"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryption_parse(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	keyMD5 := md5.Sum(key)
+	header := make(http.Header)
+	header.Set("x-amz-server-side-encryption-customer-algorithm", "AES256")
+	header.Set("x-amz-server-side-encryption-customer-key", base64.StdEncoding.EncodeToString(key))
+	header.Set("x-amz-server-side-encryption-customer-key-MD5", base64.StdEncoding.EncodeToString(keyMD5[:]))
+	sse, err := parseEncryption(header, "x-amz-")
+	assert.Nil(t, err)
+	assert.True(t, sse.isCustomerKey())
+	assert.Equal(t, key, sse.CustomerKey)
+
+	header.Set("x-amz-server-side-encryption-customer-key-MD5", "bad")
+	_, err = parseEncryption(header, "x-amz-")
+	assert.NotNil(t, err)
+
+	header.Set("x-amz-server-side-encryption-customer-key", "c2hvcnQ=")
+	header.Del("x-amz-server-side-encryption-customer-key-MD5")
+	_, err = parseEncryption(header, "x-amz-")
+	assert.NotNil(t, err)
+
+	kmsHeader := make(http.Header)
+	kmsHeader.Set("x-amz-server-side-encryption", "aws:kms")
+	kmsHeader.Set("x-amz-server-side-encryption-aws-kms-key-id", "alias/My-Key")
+	sse, err = parseEncryption(kmsHeader, "x-amz-")
+	assert.Nil(t, err)
+	assert.Equal(t, "aws:kms", sse.Algorithm)
+	assert.False(t, sse.isCustomerKey())
+	// source headers only ever carry a customer key
+	sse, err = parseEncryption(kmsHeader, "x-amz-copy-source-")
+	assert.Nil(t, err)
+	assert.Equal(t, "", sse.Algorithm)
+
+	kmsHeader.Set("x-amz-server-side-encryption", "AES256")
+	_, err = parseEncryption(kmsHeader, "x-amz-")
+	assert.NotNil(t, err)
+}
+
+func TestHandler_kmsKeyName(t *testing.T) {
+	config := getConfig()
+	config.Set(kmsKeyMapKey, map[string]string{"alias/my-key": "projects/p/locations/us/keyRings/r/cryptoKeys/k"})
+	config.Set(defaultKMSKeyKey, "projects/p/locations/us/keyRings/r/cryptoKeys/default")
+	handler := New(&s3_handler.Handler{Config: config})
+
+	name, err := handler.kmsKeyName(&encryption{Algorithm: sseKMS, KMSKeyId: "alias/My-Key"})
+	assert.Nil(t, err)
+	assert.Equal(t, "projects/p/locations/us/keyRings/r/cryptoKeys/k", name)
+	name, err = handler.kmsKeyName(&encryption{Algorithm: sseKMS})
+	assert.Nil(t, err)
+	assert.Equal(t, "projects/p/locations/us/keyRings/r/cryptoKeys/default", name)
+	name, err = handler.kmsKeyName(&encryption{Algorithm: sseKMS, KMSKeyId: "projects/p/locations/us/keyRings/r/cryptoKeys/other"})
+	assert.Nil(t, err)
+	assert.Equal(t, "projects/p/locations/us/keyRings/r/cryptoKeys/other", name)
+	_, err = handler.kmsKeyName(&encryption{Algorithm: sseKMS, KMSKeyId: "alias/unknown"})
+	assert.NotNil(t, err)
+	name, err = handler.kmsKeyName(&encryption{Algorithm: sseAES256})
+	assert.Nil(t, err)
+	assert.Equal(t, "", name)
+
+	assert.Equal(t, "alias/my-key", handler.kmsKeyId("projects/p/locations/us/keyRings/r/cryptoKeys/k/cryptoKeyVersions/3"))
+}
+
+func TestEncryption_checkCustomerKey(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	hash := sha256.Sum256(key)
+	attrs := &storage.ObjectAttrs{CustomerKeySHA256: base64.StdEncoding.EncodeToString(hash[:])}
+	_, _, ok := checkCustomerKey(attrs, &encryption{CustomerKey: key})
+	assert.True(t, ok)
+	code, _, ok := checkCustomerKey(attrs, &encryption{})
+	assert.False(t, ok)
+	assert.Equal(t, response_type.S3InvalidRequest, code)
+	code, _, ok = checkCustomerKey(attrs, &encryption{CustomerKey: []byte("fedcba9876543210fedcba9876543210")})
+	assert.False(t, ok)
+	assert.Equal(t, response_type.S3AccessDenied, code)
+	_, _, ok = checkCustomerKey(&storage.ObjectAttrs{}, nil)
+	assert.True(t, ok)
+}
+
+func customerKeyHeader(key []byte) http.Header {
+	header := make(http.Header)
+	header.Set("x-amz-server-side-encryption-customer-algorithm", "AES256")
+	header.Set("x-amz-server-side-encryption-customer-key", base64.StdEncoding.EncodeToString(key))
+	return header
+}
+
+func TestHandler_MultiPartParseInputEncryption(t *testing.T) {
+	handler := New(&s3_handler.Handler{Config: getConfig()})
+	request := httptest.NewRequest("POST", "http://localhost/boops/key?uploads", nil)
+	request = mux.SetURLVars(request, map[string]string{"bucket": "boops", "key": "key"})
+	request.Header.Set("x-amz-server-side-encryption", "aws:kms")
+	request.Header.Set("x-amz-server-side-encryption-aws-kms-key-id", "alias/my-key")
+	s3Req, err := handler.MultiPartParseInput(request)
+	assert.Nil(t, err)
+	assert.Equal(t, s3.ServerSideEncryption("aws:kms"), s3Req.ServerSideEncryption)
+	assert.Equal(t, "alias/my-key", *s3Req.SSEKMSKeyId)
+	assert.Nil(t, s3Req.SSECustomerKey)
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	request.Header = customerKeyHeader(key)
+	s3Req, err = handler.MultiPartParseInput(request)
+	assert.Nil(t, err)
+	assert.Equal(t, "AES256", *s3Req.SSECustomerAlgorithm)
+	assert.Equal(t, string(key), *s3Req.SSECustomerKey)
+
+	request.Header.Set("x-amz-server-side-encryption-customer-key", "c2hvcnQ=")
+	_, err = handler.MultiPartParseInput(request)
+	assert.NotNil(t, err)
+}
+
+func TestHandler_UploadPartParseInputEncryption(t *testing.T) {
+	handler := New(&s3_handler.Handler{Config: getConfig()})
+	key := []byte("0123456789abcdef0123456789abcdef")
+	request := httptest.NewRequest("PUT", "http://localhost/boops/key?uploadId=123&partNumber=1", nil)
+	request = mux.SetURLVars(request, map[string]string{"bucket": "boops", "key": "key", "uploadId": "123", "partNumber": "1"})
+	request.Header = customerKeyHeader(key)
+	s3Req, err := handler.UploadPartParseInput(request)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), *s3Req.PartNumber)
+	assert.Equal(t, "AES256", *s3Req.SSECustomerAlgorithm)
+	assert.Equal(t, string(key), *s3Req.SSECustomerKey)
+	keyMD5 := md5.Sum(key)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(keyMD5[:]), *s3Req.SSECustomerKeyMD5)
+}
+
+func TestEncryption_uploadEncryption(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	hash := sha256.Sum256(key)
+	partsFile := "storage-class=NEARLINE\n" + uploadEncryptionLines("projects/p/locations/us/keyRings/r/cryptoKeys/k", &encryption{CustomerKey: key}) + "etag,key-part-1,5\n"
+	kmsKeyName, customerKeySHA256 := uploadEncryption(partsFile)
+	assert.Equal(t, "projects/p/locations/us/keyRings/r/cryptoKeys/k", kmsKeyName)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(hash[:]), customerKeySHA256)
+	kmsKeyName, customerKeySHA256 = uploadEncryption("etag,key-part-1,5\n")
+	assert.Equal(t, "", kmsKeyName)
+	assert.Equal(t, "", customerKeySHA256)
+
+	_, _, ok := checkUploadCustomerKey(customerKeySHA256, &encryption{})
+	assert.True(t, ok)
+	code, _, ok := checkUploadCustomerKey(customerKeySHA256, &encryption{CustomerKey: key})
+	assert.False(t, ok)
+	assert.Equal(t, response_type.S3InvalidRequest, code)
+	code, _, ok = checkUploadCustomerKey(base64.StdEncoding.EncodeToString(hash[:]), &encryption{CustomerKey: []byte("fedcba9876543210fedcba9876543210")})
+	assert.False(t, ok)
+	assert.Equal(t, response_type.S3AccessDenied, code)
+}
+
+func TestHandler_UploadPartHandleWrongCustomerKey(t *testing.T) {
+	directory, err := ioutil.TempDir("", "multipart")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+	config := getConfig()
+	config.Set("gcp_destination_config.gcs_config.multipart_db_directory", directory)
+	key := []byte("0123456789abcdef0123456789abcdef")
+	err = ioutil.WriteFile(filepath.Join(directory, "123"), []byte(uploadEncryptionLines("", &encryption{CustomerKey: key})), 0644)
+	assert.Nil(t, err)
+	clientMock := s3_handler.NewMockGCPClient(gomock.NewController(t))
+	handler := New(&s3_handler.Handler{
+		Config: config,
+		GCPClient: func() (s3_handler.GCPClient, error) {
+			return clientMock, nil
+		},
+		GCPClientPool: make(map[string][]s3_handler.GCPClient),
+	})
+	request := httptest.NewRequest("PUT", "http://localhost/boops/key?uploadId=123&partNumber=1", strings.NewReader("bleh"))
+	request = mux.SetURLVars(request, map[string]string{"bucket": "boops", "key": "key", "uploadId": "123", "partNumber": "1"})
+	request.Header = customerKeyHeader([]byte("fedcba9876543210fedcba9876543210"))
+	writer := httptest.NewRecorder()
+	handler.UploadPartHandle(writer, request)
+	assert.Equal(t, http.StatusForbidden, writer.Code)
+
+	request = httptest.NewRequest("PUT", "http://localhost/boops/key?uploadId=123&partNumber=1", strings.NewReader("bleh"))
+	request = mux.SetURLVars(request, map[string]string{"bucket": "boops", "key": "key", "uploadId": "123", "partNumber": "1"})
+	writer = httptest.NewRecorder()
+	handler.UploadPartHandle(writer, request)
+	assert.Equal(t, http.StatusBadRequest, writer.Code)
+}
@@ -0,0 +1,114 @@
+package object
+
+import (
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"fmt"
+	uuid2 "github.com/google/uuid"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Lines of a multipart upload file naming the bucket and when the upload was created, so it can be aborted once
+	// the bucket's lifecycle says it's been left long enough
+	bucketLinePrefix    = "bucket="
+	initiatedLinePrefix = "initiated="
+	// How often upload files are checked for uploads to abort.  Lifecycle rules count in days
+	uploadSweepInterval = time.Hour
+)
+
+// Upload file lines recording the bucket and creation time of an upload
+func uploadInitiationLines(bucket string, initiated time.Time) string {
+	return fmt.Sprintf("%s%s\n%s%d\n", bucketLinePrefix, bucket, initiatedLinePrefix, initiated.Unix())
+}
+
+// Bucket and creation time of an upload.  Files from before these were recorded have no bucket and are left alone
+func uploadInitiation(partsFile string, modTime time.Time) (string, time.Time) {
+	bucket := ""
+	initiated := modTime
+	for _, line := range strings.Split(partsFile, "\n") {
+		if strings.HasPrefix(line, bucketLinePrefix) {
+			bucket = strings.TrimPrefix(line, bucketLinePrefix)
+		} else if strings.HasPrefix(line, initiatedLinePrefix) {
+			if seconds, err := strconv.ParseInt(strings.TrimPrefix(line, initiatedLinePrefix), 10, 64); err == nil {
+				initiated = time.Unix(seconds, 0)
+			}
+		}
+	}
+	return bucket, initiated
+}
+
+// Abort incomplete multipart uploads left longer than the abort days their bucket's lifecycle gives, removing the
+// upload file and the parts it lists.  Runs at most once per sweep interval
+func (handler *Handler) expireUploads(client s3_handler.GCPClient) {
+	handler.uploadSweepLock.Lock()
+	if time.Since(handler.lastUploadSweep) < uploadSweepInterval {
+		handler.uploadSweepLock.Unlock()
+		return
+	}
+	handler.lastUploadSweep = time.Now()
+	handler.uploadSweepLock.Unlock()
+	directory := handler.Config.GetString("gcp_destination_config.gcs_config.multipart_db_directory")
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		logging.Log.Errorf("Could not list uploads in %s %s", directory, err)
+		return
+	}
+	abortDays := make(map[string]int64)
+	for _, file := range files {
+		// upload ids are uuids, anything else in the directory isn't an upload
+		if _, err := uuid2.Parse(file.Name()); err != nil || file.IsDir() {
+			continue
+		}
+		path := filepath.Join(directory, file.Name())
+		partsFile, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		bucketName, initiated := uploadInitiation(string(partsFile), file.ModTime())
+		if bucketName == "" {
+			continue
+		}
+		days, ok := abortDays[bucketName]
+		if !ok {
+			attrs, err := handler.GCPClientToBucket(bucketName, client).Attrs(*handler.Context)
+			if err != nil {
+				logging.Log.Errorf("Could not get lifecycle of %s %s", bucketName, err)
+				continue
+			}
+			days = converter.GCSLabelsAbortDays(attrs.Labels)
+			abortDays[bucketName] = days
+		}
+		if days == 0 || time.Since(initiated) < time.Duration(days)*24*time.Hour {
+			continue
+		}
+		logging.Log.Infof("Aborting multipart upload %s to %s started %s", file.Name(), bucketName, initiated)
+		handler.abortUpload(client, bucketName, path)
+	}
+}
+
+// Remove an upload file and then the parts it listed, so parts uploading while it's removed can't be combined
+func (handler *Handler) abortUpload(client s3_handler.GCPClient, bucketName string, path string) {
+	handler.fileMutex.Lock()
+	partsFile, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = os.Remove(path)
+	}
+	handler.fileMutex.Unlock()
+	if err != nil {
+		logging.Log.Errorf("Could not remove upload %s %s", path, err)
+		return
+	}
+	bucket := handler.GCPClientToBucket(bucketName, client)
+	for key := range partSizes(string(partsFile)) {
+		if err := handler.deleteGCPObjectWithRetry(handler.GCPBucketToObject(key, bucket)); err != nil {
+			logging.Log.Errorf("Could not delete part %s %s", key, err)
+		}
+	}
+}
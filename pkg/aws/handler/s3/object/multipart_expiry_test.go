@@ -0,0 +1,76 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
+	"context"
+	"github.com/golang/mock/gomock"
+	uuid2 "github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUploadInitiation(t *testing.T) {
+	modTime := time.Unix(100, 0)
+	bucket, initiated := uploadInitiation(uploadInitiationLines("boops", time.Unix(9000, 0))+"etag,key-part-1,4\n", modTime)
+	assert.Equal(t, "boops", bucket)
+	assert.Equal(t, time.Unix(9000, 0), initiated)
+	bucket, initiated = uploadInitiation("etag,key-part-1,4\n", modTime)
+	assert.Equal(t, "", bucket)
+	assert.Equal(t, modTime, initiated)
+}
+
+func TestHandler_expireUploads(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	directory, err := ioutil.TempDir("", "multipart")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+	clientMock := s3_handler.NewMockGCPClient(ctrl)
+	bucketMock := s3_handler.NewMockGCPBucket(ctrl)
+	objectMock := s3_handler.NewMockGCPObject(ctrl)
+	ctx := context.Background()
+	config := getConfig()
+	config.Set("gcp_destination_config.gcs_config.multipart_db_directory", directory)
+	handler := New(&s3_handler.Handler{
+		GCPClientToBucket: func(bucket string, client s3_handler.GCPClient) s3_handler.GCPBucket {
+			assert.Equal(t, "boops", bucket)
+			return bucketMock
+		},
+		GCPBucketToObject: func(name string, bucket s3_handler.GCPBucket) s3_handler.GCPObject {
+			assert.Equal(t, "_tmp/old-part-1", name)
+			return objectMock
+		},
+		Context: &ctx,
+		Config:  config,
+	})
+	old := filepath.Join(directory, uuid2.New().String())
+	recent := filepath.Join(directory, uuid2.New().String())
+	other := filepath.Join(directory, "not-an-upload")
+	assert.Nil(t, ioutil.WriteFile(old, []byte(uploadInitiationLines("boops", time.Now().Add(-8*24*time.Hour))+"\"etag\",_tmp/old-part-1,4\n"), 0666))
+	assert.Nil(t, ioutil.WriteFile(recent, []byte(uploadInitiationLines("boops", time.Now())), 0666))
+	assert.Nil(t, ioutil.WriteFile(other, []byte(uploadInitiationLines("boops", time.Unix(0, 0))), 0666))
+	bucketMock.EXPECT().Attrs(gomock.Any()).Return(&storage.BucketAttrs{
+		Labels: map[string]string{converter.AbortMultipartDaysLabel: "7"},
+	}, nil)
+	objectMock.EXPECT().Delete(gomock.Any()).Return(nil)
+
+	handler.expireUploads(clientMock)
+	_, err = os.Stat(old)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(recent)
+	assert.Nil(t, err)
+	_, err = os.Stat(other)
+	assert.Nil(t, err)
+
+	// sweeps wait for the interval
+	assert.Nil(t, ioutil.WriteFile(old, []byte(uploadInitiationLines("boops", time.Unix(0, 0))), 0666))
+	handler.expireUploads(clientMock)
+	_, err = os.Stat(old)
+	assert.Nil(t, err)
+}
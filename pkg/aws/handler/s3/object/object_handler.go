@@ -19,6 +19,7 @@ import (
 	"github.com/gorilla/mux"
 	"google.golang.org/api/iterator"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"os"
@@ -31,7 +32,9 @@ import (
 
 type Handler struct {
 	*s3_handler.Handler
-	fileMutex sync.Mutex
+	fileMutex       sync.Mutex
+	uploadSweepLock sync.Mutex
+	lastUploadSweep time.Time
 }
 
 // Interface for object functions
@@ -40,6 +43,10 @@ type Object interface {
 	HeadParseInput(r *http.Request) (*s3.HeadObjectInput, error)
 	GetHandle(writer http.ResponseWriter, request *http.Request)
 	GetParseInput(r *http.Request) (*s3.GetObjectInput, error)
+	ACLHandle(writer http.ResponseWriter, request *http.Request)
+	ACLParseInput(r *http.Request) (*s3.GetObjectAclInput, error)
+	PutACLHandle(writer http.ResponseWriter, request *http.Request)
+	PutACLParseInput(r *http.Request) (*s3.PutObjectAclInput, error)
 	PutHandle(writer http.ResponseWriter, request *http.Request)
 	PutParseInput(r *http.Request) (*s3manager.UploadInput, error)
 	MultiPartHandle(writer http.ResponseWriter, request *http.Request)
@@ -54,50 +61,58 @@ type Object interface {
 	DeleteParseInput(r *http.Request) (*s3.DeleteObjectInput, error)
 	MultiDeleteHandle(writer http.ResponseWriter, request *http.Request)
 	MultiDeleteParseInput(r *http.Request) (*s3.DeleteObjectsInput, error)
+	SelectHandle(writer http.ResponseWriter, request *http.Request)
+	SelectParseInput(r *http.Request) (*response_type.SelectObjectContentRequest, []byte, error)
+	RestoreHandle(writer http.ResponseWriter, request *http.Request)
+	RestoreParseInput(r *http.Request) (*s3.RestoreObjectInput, error)
+	RetentionHandle(writer http.ResponseWriter, request *http.Request)
+	RetentionParseInput(r *http.Request) (*s3.GetObjectRetentionInput, error)
+	PutRetentionHandle(writer http.ResponseWriter, request *http.Request)
+	PutRetentionParseInput(r *http.Request) (*s3.PutObjectRetentionInput, error)
+	LegalHoldHandle(writer http.ResponseWriter, request *http.Request)
+	LegalHoldParseInput(r *http.Request) (*s3.GetObjectLegalHoldInput, error)
+	PutLegalHoldHandle(writer http.ResponseWriter, request *http.Request)
+	PutLegalHoldParseInput(r *http.Request) (*s3.PutObjectLegalHoldInput, error)
 	New(s3Handler *s3_handler.Handler) Handler
 	Register(mux *mux.Router)
 }
 
 var multipartUploadPathPrefix = "gcp_destination_config.gcs_config.multipart_temp_path_prefix"
+var composeConcurrencyKey = "gcp_destination_config.gcs_config.compose_concurrency"
+
+const (
+	// Most source objects GCS accepts in a single compose request
+	maxComposeSources = 32
+	// Compose or delete calls in flight when combining parts, if not configured
+	defaultComposeConcurrency = 8
+)
 
 // Register all HTTP handlers
 func (handler *Handler) Register(mux *mux.Router) {
-	keyFromUrl := handler.Config.Get("gcp_destination_config.key_from_url")
-	if keyFromUrl != nil && keyFromUrl == true {
-		// Credits will be pased in URL instead of config
-		mux.HandleFunc("/{creds}/{bucket}/{key:[^#?\\s]+}", handler.HeadHandle).Methods("HEAD")
-		mux.HandleFunc("/{creds}/{bucket}/{key:[^#?\\s]+}", handler.GetHandle).Methods("GET")
-		mux.HandleFunc("/{creds}/{bucket}", handler.MultiDeleteHandle).Queries("delete", "").Methods("POST")
-		mux.HandleFunc("/{creds}/{bucket}/", handler.MultiDeleteHandle).Queries("delete", "").Methods("POST")
-		mux.HandleFunc("/{creds}/{bucket}/{key:[^#?\\s]+}", handler.MultiPartHandle).Queries("uploads", "").Methods("POST")
-		mux.HandleFunc("/{creds}/{bucket}/{key:[^#?\\s]+}", handler.UploadPartHandle).Queries("partNumber", "{partNumber}", "uploadId", "{uploadId}").Methods("PUT")
-		mux.HandleFunc("/{creds}/{bucket}/{key:[^#?\\s]+}", handler.CompleteMultiPartHandle).Queries("uploadId", "{uploadId}").Methods("POST")
-		mux.HandleFunc("/{creds}/{bucket}/{key:[^#?\\s]+}", handler.CopyHandle).Headers("x-amz-copy-source", "").Methods("PUT")
-		mux.HandleFunc("/{creds}/{bucket}/{key:[^#?\\s]+}", handler.PutHandle).Methods("PUT")
-		mux.HandleFunc("/{creds}/{bucket}/{key:[^#?\\s]+}", handler.DeleteHandle).Methods("DELETE")
-	} else {
-		mux.HandleFunc("/{bucket}/{key:[^#?\\s]+}", handler.HeadHandle).Methods("HEAD")
-		mux.HandleFunc("/{bucket}/{key:[^#?\\s]+}", handler.GetHandle).Methods("GET")
-		mux.HandleFunc("/{bucket}", handler.MultiDeleteHandle).Queries("delete", "").Methods("POST")
-		mux.HandleFunc("/{bucket}/", handler.MultiDeleteHandle).Queries("delete", "").Methods("POST")
-		mux.HandleFunc("/{bucket}/{key:[^#?\\s]+}", handler.MultiPartHandle).Queries("uploads", "").Methods("POST")
-		mux.HandleFunc("/{bucket}/{key:[^#?\\s]+}", handler.UploadPartHandle).Queries("partNumber", "{partNumber}", "uploadId", "{uploadId}").Methods("PUT")
-		mux.HandleFunc("/{bucket}/{key:[^#?\\s]+}", handler.CompleteMultiPartHandle).Queries("uploadId", "{uploadId}").Methods("POST")
-		mux.HandleFunc("/{bucket}/{key:[^#?\\s]+}", handler.CopyHandle).Headers("x-amz-copy-source", "").Methods("PUT")
-		mux.HandleFunc("/{bucket}/{key:[^#?\\s]+}", handler.PutHandle).Methods("PUT")
-		mux.HandleFunc("/{bucket}/{key:[^#?\\s]+}", handler.DeleteHandle).Methods("DELETE")
-	}
-}
-
-func writeInternalError(writer http.ResponseWriter, message string) {
-	code := "InternalError"
-	xmlResponse := response_type.AWSACLResponseError{
-		Code:    &code,
-		Message: &message,
-	}
-	output, _ := xml.Marshal(xmlResponse)
-	writer.Write([]byte(s3_handler.XmlHeader))
-	writer.Write(output)
+	// Credits will be pased in URL instead of config when key_from_url is set
+	for _, addressing := range handler.Addressing(mux) {
+		router := addressing.Router
+		keyPath := addressing.KeyPath()
+		router.HandleFunc(keyPath, handler.HeadHandle).Methods("HEAD")
+		router.HandleFunc(keyPath, handler.ACLHandle).Queries("acl", "").Methods("GET")
+		router.HandleFunc(keyPath, handler.PutACLHandle).Queries("acl", "").Methods("PUT")
+		router.HandleFunc(keyPath, handler.RetentionHandle).Queries("retention", "").Methods("GET")
+		router.HandleFunc(keyPath, handler.PutRetentionHandle).Queries("retention", "").Methods("PUT")
+		router.HandleFunc(keyPath, handler.LegalHoldHandle).Queries("legal-hold", "").Methods("GET")
+		router.HandleFunc(keyPath, handler.PutLegalHoldHandle).Queries("legal-hold", "").Methods("PUT")
+		router.HandleFunc(keyPath, handler.GetHandle).Methods("GET")
+		for _, bucketPath := range addressing.BucketPaths() {
+			router.HandleFunc(bucketPath, handler.MultiDeleteHandle).Queries("delete", "").Methods("POST")
+		}
+		router.HandleFunc(keyPath, handler.RestoreHandle).Queries("restore", "").Methods("POST")
+		router.HandleFunc(keyPath, handler.SelectHandle).Queries("select", "", "select-type", "2").Methods("POST")
+		router.HandleFunc(keyPath, handler.MultiPartHandle).Queries("uploads", "").Methods("POST")
+		router.HandleFunc(keyPath, handler.UploadPartHandle).Queries("partNumber", "{partNumber}", "uploadId", "{uploadId}").Methods("PUT")
+		router.HandleFunc(keyPath, handler.CompleteMultiPartHandle).Queries("uploadId", "{uploadId}").Methods("POST")
+		router.HandleFunc(keyPath, handler.CopyHandle).Headers("x-amz-copy-source", "").Methods("PUT")
+		router.HandleFunc(keyPath, handler.PutHandle).Methods("PUT")
+		router.HandleFunc(keyPath, handler.DeleteHandle).Methods("DELETE")
+	}
 }
 
 func filesAsString(objects []*storage.ObjectHandle) string {
@@ -108,40 +123,151 @@ func filesAsString(objects []*storage.ObjectHandle) string {
 	return strings.Join(names, ", ")
 }
 
-func (handler *Handler) doCombine(bucket s3_handler.GCPBucket, target string, objects []*storage.ObjectHandle) (*storage.ObjectAttrs, error) {
-	objectCount := len(objects)
-	var toCombine []*storage.ObjectHandle
-	maxSize := 32
-	if objectCount > maxSize {
-		toCombine = make([]*storage.ObjectHandle, 2)
-		pos := objectCount / 2
-		firstTarget := fmt.Sprintf("%s_1", target)
-		firstHandle, err := handler.doCombine(bucket, firstTarget, objects[:pos])
+// Name of a temporary object holding one group of a compose tree level
+func composeFileName(key string, level int, group int, pathPrefix string) string {
+	if pathPrefix != "" {
+		if !strings.HasSuffix(pathPrefix, "/") {
+			pathPrefix = pathPrefix + "/"
+		}
+		key = pathPrefix + key
+	}
+	return fmt.Sprintf("%s-compose-%d-%d", key, level, group)
+}
+
+// Split objects into groups no bigger than what GCS can compose in one request
+func composeGroups(objects []*storage.ObjectHandle, groupSize int) [][]*storage.ObjectHandle {
+	groups := make([][]*storage.ObjectHandle, 0, (len(objects)+groupSize-1)/groupSize)
+	for start := 0; start < len(objects); start += groupSize {
+		end := start + groupSize
+		if end > len(objects) {
+			end = len(objects)
+		}
+		groups = append(groups, objects[start:end])
+	}
+	return groups
+}
+
+// Number of compose or delete calls to run against GCS at once
+func (handler *Handler) composeConcurrency() int {
+	if handler.Config != nil {
+		if concurrency := handler.Config.GetInt(composeConcurrencyKey); concurrency > 0 {
+			return concurrency
+		}
+	}
+	return defaultComposeConcurrency
+}
+
+// Run work for every index with at most concurrency calls in flight.  Returns the first error seen
+func runBounded(concurrency int, count int, work func(i int) error) error {
+	var wg sync.WaitGroup
+	var errMutex sync.Mutex
+	var firstErr error
+	semaphore := make(chan struct{}, concurrency)
+	for i := 0; i < count; i++ {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			if err := work(i); err != nil {
+				errMutex.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMutex.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// Delete objects in parallel.  Failures are only logged since the data they held is already combined
+func (handler *Handler) deleteGCPObjects(objects []*storage.ObjectHandle) {
+	runBounded(handler.composeConcurrency(), len(objects), func(i int) error {
+		logging.Log.Debugf("Deleting %s", objects[i].ObjectName())
+		if err := handler.deleteGCPObjectWithRetry(objects[i]); err != nil {
+			logging.Log.Errorf("Could not delete %s %s", objects[i].ObjectName(), err)
+		}
+		return nil
+	})
+}
+
+// Combine objects into target.  GCS only composes 32 sources per request, so bigger uploads are combined as a
+// tree: every level composes its groups into intermediate objects concurrently, and the intermediates become
+// the sources of the next level until one group is left to compose into the target.  Intermediates are
+// removed once the next level has consumed them or when anything fails.  Source objects are only removed after
+// the target exists, so a failed combine can be retried.  Every compose is written with the customer key the
+// parts were, and GCS can't be asked for a KMS key on a compose, so with one the last level is composed into an
+// intermediate that is rewritten to the target under that key.
+func (handler *Handler) doCombine(bucket s3_handler.GCPBucket, target string, objects []*storage.ObjectHandle, metadata map[string]string, storageClass string, sse *encryption, kmsKeyName string) (*storage.ObjectAttrs, error) {
+	pathPrefix := handler.Config.GetString(multipartUploadPathPrefix)
+	concurrency := handler.composeConcurrency()
+	composeTarget := func(name string) s3_handler.GCPObject {
+		object := handler.GCPBucketToObject(name, bucket)
+		if sse.isCustomerKey() {
+			return object.Key(sse.CustomerKey)
+		}
+		return object
+	}
+	// intermediates that currently exist and need to be removed eventually
+	intermediates := make([]*storage.ObjectHandle, 0)
+	toCombine := objects
+	level := 0
+	for ; len(toCombine) > maxComposeSources; level++ {
+		groups := composeGroups(toCombine, maxComposeSources)
+		composed := make([]*storage.ObjectHandle, len(groups))
+		err := runBounded(concurrency, len(groups), func(i int) error {
+			name := composeFileName(target, level, i, pathPrefix)
+			logging.Log.Debugf("Combining to %s %v", name, filesAsString(groups[i]))
+			if _, err := handler.composeGCPObjectWithRetry(composeTarget(name), groups[i], nil, ""); err != nil {
+				return err
+			}
+			composed[i] = bucket.Object(name)
+			return nil
+		})
+		for _, object := range composed {
+			if object != nil {
+				intermediates = append(intermediates, object)
+			}
+		}
 		if err != nil {
+			handler.deleteGCPObjects(intermediates)
 			return nil, err
 		}
-		toCombine[0] = bucket.Object(firstHandle.Name)
-
-		secondTarget := fmt.Sprintf("%s_2", target)
-		secondHandle, err := handler.doCombine(bucket, secondTarget, objects[pos:])
+		if level > 0 {
+			// the prior level of intermediates is now part of this level
+			handler.deleteGCPObjects(toCombine)
+			intermediates = composed
+		}
+		toCombine = composed
+	}
+	if kmsKeyName != "" {
+		name := composeFileName(target, level, 0, pathPrefix)
+		logging.Log.Debugf("Combining to %s %v", name, filesAsString(toCombine))
+		if _, err := handler.composeGCPObjectWithRetry(composeTarget(name), toCombine, nil, ""); err != nil {
+			handler.deleteGCPObjects(intermediates)
+			return nil, err
+		}
+		intermediates = append(intermediates, bucket.Object(name))
+		logging.Log.Debugf("Rewriting %s to %s with %s", name, target, kmsKeyName)
+		gResp, err := handler.kmsRewriteGCPObjectWithRetry(handler.GCPBucketToObject(target, bucket), bucket.Object(name), metadata, storageClass, kmsKeyName)
+		handler.deleteGCPObjects(intermediates)
 		if err != nil {
 			return nil, err
 		}
-		toCombine[1] = bucket.Object(secondHandle.Name)
-	} else {
-		toCombine = objects
+		handler.deleteGCPObjects(objects)
+		return gResp, nil
 	}
 	logging.Log.Debugf("Combining to %s %v", target, filesAsString(toCombine))
-	gResp, err := handler.GCPBucketToObject(target, bucket).ComposerFrom(toCombine...).Run(*handler.Context)
+	gResp, err := handler.composeGCPObjectWithRetry(composeTarget(target), toCombine, metadata, storageClass)
 	if err != nil {
+		handler.deleteGCPObjects(intermediates)
 		return nil, err
 	}
-	for _, object := range toCombine {
-		logging.Log.Debugf("Deleting %s", object.ObjectName())
-		if deleteErr := handler.deleteGCPObjectWithRetry(object); deleteErr != nil {
-			return nil, deleteErr
-		}
-	}
+	handler.deleteGCPObjects(append(intermediates, objects...))
 	return gResp, nil
 }
 
@@ -159,43 +285,61 @@ func (handler *Handler) CompleteMultiPartHandle(writer http.ResponseWriter, requ
 			defer handler.ReturnConnection(client, request)
 		}
 		if err != nil {
-			writer.WriteHeader(400)
 			logging.Log.Error("Error a %s %s", request.RequestURI, err)
-			writeInternalError(writer, err.Error())
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
+		handler.expireUploads(client)
 		path := fmt.Sprintf("%s/%s", handler.Config.GetString("gcp_destination_config.gcs_config.multipart_db_directory"), *s3Req.UploadId)
 		// Read file that stored locations of parts
-		f, fileErr := os.Open(path)
+		partsFile, fileErr := ioutil.ReadFile(path)
 		if fileErr != nil {
-			f.Close()
-			writer.WriteHeader(404)
 			logging.Log.Error("Error a %s %s", request.RequestURI, fileErr)
-			writeInternalError(writer, fileErr.Error())
+			s3_handler.WriteErrorCode(writer, request, response_type.S3NoSuchUpload, "")
+			return
+		}
+		kmsKeyName, customerKeySHA256 := uploadEncryption(string(partsFile))
+		sse, err := parseEncryption(request.Header, "x-amz-")
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			writeParseError(writer, request, err)
+			return
+		}
+		if code, message, ok := checkUploadCustomerKey(customerKeySHA256, sse); !ok {
+			s3_handler.WriteErrorCode(writer, request, code, message)
 			return
 		}
-		f.Close()
+		uploadedSizes := partSizes(string(partsFile))
 		objects := make([]*storage.ObjectHandle, 0)
 		sort.Slice(s3Req.MultipartUpload.Parts, func(i, j int) bool {
 			return *s3Req.MultipartUpload.Parts[i].PartNumber < *s3Req.MultipartUpload.Parts[j].PartNumber
 		})
 		bucket := handler.GCPClientToBucket(*s3Req.Bucket, client)
+		sizes := make([]string, 0, len(s3Req.MultipartUpload.Parts))
 		for _, part := range s3Req.MultipartUpload.Parts {
 			partNumber := *part.PartNumber
 			key := partFileName(*s3Req.Key, partNumber, handler.Config.GetString(multipartUploadPathPrefix))
 			logging.Log.Info("Part number ", partNumber, " ", key)
 			objects = append(objects, bucket.Object(key))
+			if size, ok := uploadedSizes[key]; ok {
+				sizes = append(sizes, size)
+			}
+		}
+		// Keep part sizes so parts can be read back by number
+		var metadata map[string]string
+		if len(sizes) == len(objects) {
+			metadata = map[string]string{partSizesMetadataKey: strings.Join(sizes, ",")}
 		}
 
 		// Join pieces
-		gResp, err := handler.doCombine(bucket, *s3Req.Key, objects)
+		gResp, err := handler.doCombine(bucket, *s3Req.Key, objects, metadata, uploadStorageClass(string(partsFile)), sse, kmsKeyName)
 		if err != nil {
-			writer.WriteHeader(400)
 			logging.Log.Error("Error %s %s", request.RequestURI, err)
-			writeInternalError(writer, err.Error())
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
 		resp = converter.GCSAttrToCombine(gResp)
+		handler.writeEncryptionHeaders(writer, gResp, sse)
 		defer os.Remove(path)
 		logging.Log.Infof("Finished multipart upload {}", *s3Req.UploadId)
 	} else {
@@ -204,9 +348,8 @@ func (handler *Handler) CompleteMultiPartHandle(writer http.ResponseWriter, requ
 		var s3Resp *s3.CompleteMultipartUploadOutput
 		s3Resp, err = req.Send()
 		if err != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
 		resp = &response_type.CompleteMultipartUploadResult{
@@ -217,9 +360,8 @@ func (handler *Handler) CompleteMultiPartHandle(writer http.ResponseWriter, requ
 		}
 	}
 	if err != nil {
-		writer.WriteHeader(404)
 		logging.Log.Error("Error %s %s", request.RequestURI, err)
-		writer.Write([]byte(string(fmt.Sprint(err))))
+		s3_handler.WriteError(writer, request, err)
 		return
 	}
 	output, _ := xml.MarshalIndent(resp, "  ", "    ")
@@ -256,6 +398,21 @@ func (handler *Handler) CompleteMultiPartParseInput(r *http.Request) (*s3.Comple
 	return s3Req, nil
 }
 
+// Sizes of uploaded parts by part file name from the multipart temporary file.  Lines are etag,key,size and
+// the last upload of a part wins
+func partSizes(partsFile string) map[string]string {
+	sizes := make(map[string]string)
+	for _, line := range strings.Split(partsFile, "\n") {
+		// keys can have commas, etags and sizes can't
+		first := strings.Index(line, ",")
+		last := strings.LastIndex(line, ",")
+		if first >= 0 && last > first {
+			sizes[line[first+1:last]] = line[last+1:]
+		}
+	}
+	return sizes
+}
+
 func partFileName(key string, part int64, pathPrefix string) string {
 	if pathPrefix != "" {
 		if !strings.HasSuffix(pathPrefix, "/") {
@@ -281,6 +438,45 @@ func (handler *Handler) deleteGCPObjectWithRetry(object s3_handler.GCPObject) er
 	return errors
 }
 
+// Compose gcp objects into target and retry on failure
+func (handler *Handler) composeGCPObjectWithRetry(target s3_handler.GCPObject, sources []*storage.ObjectHandle, metadata map[string]string, storageClass string) (attrs *storage.ObjectAttrs, err error) {
+	var attributes *storage.ObjectAttrs
+	errors := retry.Do(
+		func() error {
+			composer := target.ComposerFrom(sources...)
+			composer.Metadata = metadata
+			composer.StorageClass = storageClass
+			attributes, err = composer.Run(*handler.Context)
+			return err
+		},
+		retry.OnRetry(func(n uint, err error) {
+			logging.Log.Warning("GCP Compose Retry #%d: %s\n", n, err)
+		}),
+		retry.Delay(1*time.Second),
+	)
+	return attributes, errors
+}
+
+// Rewrite a gcp object to target under a KMS key and retry on failure
+func (handler *Handler) kmsRewriteGCPObjectWithRetry(target s3_handler.GCPObject, source *storage.ObjectHandle, metadata map[string]string, storageClass string, kmsKeyName string) (attrs *storage.ObjectAttrs, err error) {
+	var attributes *storage.ObjectAttrs
+	errors := retry.Do(
+		func() error {
+			copier := target.CopierFrom(source)
+			copier.Metadata = metadata
+			copier.StorageClass = storageClass
+			copier.DestinationKMSKeyName = kmsKeyName
+			attributes, err = copier.Run(*handler.Context)
+			return err
+		},
+		retry.OnRetry(func(n uint, err error) {
+			logging.Log.Warning("GCP Rewrite Retry #%d: %s\n", n, err)
+		}),
+		retry.Delay(1*time.Second),
+	)
+	return attributes, errors
+}
+
 // Get gcp attributes of object and retry on failure
 func (handler *Handler) getGCPAttrsWithRetry(object s3_handler.GCPObject) (attrs *storage.ObjectAttrs, err error) {
 	var attributes *storage.ObjectAttrs
@@ -299,9 +495,13 @@ func (handler *Handler) getGCPAttrsWithRetry(object s3_handler.GCPObject) (attrs
 
 // Handle uploading part of a multipart file
 func (handler *Handler) UploadPartHandle(writer http.ResponseWriter, request *http.Request) {
-	s3Req, _ := handler.UploadPartParseInput(request)
+	s3Req, err := handler.UploadPartParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		writeParseError(writer, request, err)
+		return
+	}
 	var resp *s3.UploadPartOutput
-	var err error
 	if handler.Config.IsSet("gcp_destination_config") {
 		// Use GCS
 		// Log that we are using GCP, get a client based on configurations.  This is from a pool
@@ -311,70 +511,116 @@ func (handler *Handler) UploadPartHandle(writer http.ResponseWriter, request *ht
 			defer handler.ReturnConnection(client, request)
 		}
 		if err != nil {
-			writer.WriteHeader(400)
 			logging.Log.Error("Error a %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		handler.expireUploads(client)
+		path := fmt.Sprintf("%s/%s", handler.Config.GetString("gcp_destination_config.gcs_config.multipart_db_directory"), *s3Req.UploadId)
+		// parts are written with the encryption the upload was started with
+		partsFile, _ := ioutil.ReadFile(path)
+		kmsKeyName, customerKeySHA256 := uploadEncryption(string(partsFile))
+		sse := inputEncryption("", nil, s3Req.SSECustomerKey, s3Req.SSECustomerKeyMD5)
+		if code, message, ok := checkUploadCustomerKey(customerKeySHA256, sse); !ok {
+			s3_handler.WriteErrorCode(writer, request, code, message)
 			return
 		}
 		key := partFileName(*s3Req.Key, *s3Req.PartNumber, handler.Config.GetString(multipartUploadPathPrefix))
 		bucket := handler.GCPClientToBucket(*s3Req.Bucket, client)
 		obj := handler.GCPBucketToObject(key, bucket)
+		if sse.isCustomerKey() {
+			obj = obj.Key(sse.CustomerKey)
+		}
+		gReq, err := handler.PutParseInput(request)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			writeParseError(writer, request, err)
+			return
+		}
 		uploader := handler.GCPObjectToWriter(obj, *handler.Context)
-		gReq, _ := handler.PutParseInput(request)
+		if gcsWriter, ok := uploader.(*storage.Writer); ok {
+			gcsWriter.KMSKeyName = kmsKeyName
+			if sum, ok := gReq.Body.(*checksumReader); ok {
+				sum.checksum.applyToWriter(gcsWriter)
+			}
+		}
 		_, err = converter.GCPUpload(gReq, uploader)
-		closeErr := uploader.Close()
 		if err != nil {
-			writer.WriteHeader(404)
+			// abandon the part so a partial or mismatched body is never committed
+			uploader.CloseWithError(err)
 			logging.Log.Error("Error %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
+			writeUploadError(writer, request, err)
 			return
 		}
-		if closeErr != nil {
-			writer.WriteHeader(400)
-			logging.Log.Error("Error %s %s %s %s", request.RequestURI, bucket, key, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
+		if closeErr := uploader.Close(); closeErr != nil {
+			logging.Log.Error("Error %s %s %s %s", request.RequestURI, bucket, key, closeErr)
+			s3_handler.WriteError(writer, request, closeErr)
 			return
 		}
 		attrs := uploader.Attrs()
 		converter.GCSMD5ToEtag(attrs, writer)
-		path := fmt.Sprintf("%s/%s", handler.Config.GetString("gcp_destination_config.gcs_config.multipart_db_directory"), *s3Req.UploadId)
+		writeUploadChecksum(writer, gReq.Body)
 		logging.Log.Info("Temp upload parts file path " + path)
 		// Add part file to multipart temporary file
 		handler.fileMutex.Lock()
 		f, fileErr := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
 		if fileErr != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error %s %s", request.RequestURI, fileErr)
-			writer.Write([]byte(string(fmt.Sprint(fileErr))))
+			s3_handler.WriteError(writer, request, fileErr)
 			handler.fileMutex.Unlock()
 			return
 		}
 		defer f.Close()
-		_, fileErr = f.WriteString(fmt.Sprintf("%s,%s\n", writer.Header().Get("ETag"), key))
+		_, fileErr = f.WriteString(fmt.Sprintf("%s,%s,%d\n", writer.Header().Get("ETag"), key, attrs.Size))
 		handler.fileMutex.Unlock()
 		if fileErr != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error %s %s", request.RequestURI, fileErr)
-			writer.Write([]byte(string(fmt.Sprint(fileErr))))
+			s3_handler.WriteError(writer, request, fileErr)
 			return
 		}
 	} else {
 		logging.LogUsingAWS()
+		body := io.Reader(request.Body)
+		contentLength := request.Header.Get("Content-Length")
+		contentSHA256 := request.Header.Get("X-Amz-Content-Sha256")
+		if isChunkedPayload(contentSHA256) {
+			// chunks and trailing checksums are checked here, S3 is sent the plain part
+			gReq, err := handler.PutParseInput(request)
+			if err != nil {
+				logging.Log.Error("Error %s %s", request.RequestURI, err)
+				writeParseError(writer, request, err)
+				return
+			}
+			body = gReq.Body
+			contentLength = request.Header.Get("x-amz-decoded-content-length")
+			contentSHA256 = "UNSIGNED-PAYLOAD"
+		}
 		req := handler.S3Client.UploadPartRequest(s3Req)
-		req.HTTPRequest.Header.Set("Content-Length", request.Header.Get("Content-Length"))
-		req.HTTPRequest.Header.Set("X-Amz-Content-Sha256", request.Header.Get("X-Amz-Content-Sha256"))
-		req.Body = aws.ReadSeekCloser(request.Body)
+		req.HTTPRequest.Header.Set("Content-Length", contentLength)
+		req.HTTPRequest.Header.Set("X-Amz-Content-Sha256", contentSHA256)
+		if header := request.Header.Get("Content-MD5"); header != "" {
+			req.HTTPRequest.Header.Set("Content-MD5", header)
+		}
+		for _, algorithm := range checksumAlgorithms {
+			if header := request.Header.Get(checksumHeader(algorithm)); header != "" {
+				req.HTTPRequest.Header.Set(checksumHeader(algorithm), header)
+			}
+		}
+		var responseHeaders http.Header
+		req.ApplyOptions(aws.WithGetResponseHeaders(&responseHeaders))
+		req.Body = aws.ReadSeekCloser(body)
 		resp, err = req.Send()
 		if err != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error in uploading %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
+			writeUploadError(writer, request, err)
 			return
 		}
 		logging.Log.Info(fmt.Sprint(resp))
 		if header := resp.ETag; header != nil {
 			writer.Header().Set("ETag", *header)
 		}
+		copyAWSChecksumHeaders(writer, responseHeaders)
+		writeAWSEncryptionHeaders(writer, resp.ServerSideEncryption, resp.SSEKMSKeyId, resp.SSECustomerAlgorithm, resp.SSECustomerKeyMD5)
 	}
 	writer.WriteHeader(200)
 	writer.Write([]byte(""))
@@ -387,34 +633,83 @@ func (handler *Handler) UploadPartParseInput(r *http.Request) (*s3.UploadPartInp
 	key := vars["key"]
 	partNumber, err := strconv.ParseInt(vars["partNumber"], 10, 64)
 	uploadId := vars["uploadId"]
-	return &s3.UploadPartInput{
+	s3Req := &s3.UploadPartInput{
 		Bucket:     &bucket,
 		Key:        &key,
 		PartNumber: &partNumber,
 		UploadId:   &uploadId,
-	}, err
+	}
+	if err != nil {
+		return s3Req, err
+	}
+	// parts only take a customer key, the rest of the encryption was set when the upload started
+	sse, err := parseEncryption(r.Header, "x-amz-")
+	if err != nil {
+		return s3Req, err
+	}
+	s3Req.SSECustomerAlgorithm, s3Req.SSECustomerKey, s3Req.SSECustomerKeyMD5 = sse.customerKeyFields()
+	return s3Req, nil
 }
 
 // Handle request to create multipart upload
 func (handler *Handler) MultiPartHandle(writer http.ResponseWriter, request *http.Request) {
-	s3Req, _ := handler.MultiPartParseInput(request)
+	s3Req, err := handler.MultiPartParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		writeParseError(writer, request, err)
+		return
+	}
 	var resp *response_type.InitiateMultipartUploadResult
 	var createResp *s3.CreateMultipartUploadOutput
-	var err error
+	sse := inputEncryption(s3Req.ServerSideEncryption, s3Req.SSEKMSKeyId, s3Req.SSECustomerKey, s3Req.SSECustomerKeyMD5)
 
 	if handler.Config.IsSet("gcp_destination_config") {
 		// GCS, so create a temporary local file to store parts.  This file is used to join parts later
+		storageClass, ok := handler.gcsStorageClass(writer, request, string(s3Req.StorageClass))
+		if !ok {
+			return
+		}
+		kmsKeyName, err := handler.kmsKeyName(sse)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+			return
+		}
 		uuid := uuid2.New().String()
 		path := fmt.Sprintf("%s/%s", handler.Config.GetString("gcp_destination_config.gcs_config.multipart_db_directory"), uuid)
 		f, fileErr := os.Create(path)
 		if fileErr != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error %s %s", request.RequestURI, fileErr)
-			writer.Write([]byte(string(fmt.Sprint(fileErr))))
+			s3_handler.WriteError(writer, request, fileErr)
 			return
 		}
 		defer f.Close()
 		logging.Log.Info(uuid)
+		// uploads left too long are aborted by the bucket's lifecycle
+		if _, fileErr = f.WriteString(uploadInitiationLines(*s3Req.Bucket, time.Now())); fileErr != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, fileErr)
+			s3_handler.WriteError(writer, request, fileErr)
+			return
+		}
+		if storageClass != "" {
+			// the combined object is written with the class asked for here
+			if _, fileErr = f.WriteString(storageClassLinePrefix + storageClass + "\n"); fileErr != nil {
+				logging.Log.Error("Error %s %s", request.RequestURI, fileErr)
+				s3_handler.WriteError(writer, request, fileErr)
+				return
+			}
+		}
+		// parts and the combined object are written with the encryption asked for here
+		if _, fileErr = f.WriteString(uploadEncryptionLines(kmsKeyName, sse)); fileErr != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, fileErr)
+			s3_handler.WriteError(writer, request, fileErr)
+			return
+		}
+		handler.writeUploadEncryptionHeaders(writer, kmsKeyName, sse)
+		// parts are checked as they arrive and the combined object carries the GCS CRC32C
+		if algorithm := request.Header.Get("x-amz-checksum-algorithm"); algorithm != "" {
+			writer.Header().Set("x-amz-checksum-algorithm", strings.ToUpper(algorithm))
+		}
 		resp = &response_type.InitiateMultipartUploadResult{
 			Key:      s3Req.Key,
 			Bucket:   s3Req.Bucket,
@@ -424,7 +719,17 @@ func (handler *Handler) MultiPartHandle(writer http.ResponseWriter, request *htt
 	} else {
 		logging.LogUsingAWS()
 		req := handler.S3Client.CreateMultipartUploadRequest(s3Req)
+		if algorithm := request.Header.Get("x-amz-checksum-algorithm"); algorithm != "" {
+			req.HTTPRequest.Header.Set("x-amz-checksum-algorithm", algorithm)
+			writer.Header().Set("x-amz-checksum-algorithm", strings.ToUpper(algorithm))
+		}
 		createResp, err = req.Send()
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		writeAWSEncryptionHeaders(writer, createResp.ServerSideEncryption, createResp.SSEKMSKeyId, createResp.SSECustomerAlgorithm, createResp.SSECustomerKeyMD5)
 		resp = &response_type.InitiateMultipartUploadResult{
 			Key:      s3Req.Key,
 			Bucket:   s3Req.Bucket,
@@ -433,9 +738,8 @@ func (handler *Handler) MultiPartHandle(writer http.ResponseWriter, request *htt
 		}
 	}
 	if err != nil {
-		writer.WriteHeader(404)
 		logging.Log.Error("Error %s %s", request.RequestURI, err)
-		writer.Write([]byte(string(fmt.Sprint(err))))
+		s3_handler.WriteError(writer, request, err)
 		return
 	}
 	output, _ := xml.MarshalIndent(resp, "  ", "    ")
@@ -449,9 +753,17 @@ func (handler *Handler) MultiPartParseInput(r *http.Request) (*s3.CreateMultipar
 	bucket := vars["bucket"]
 	key := vars["key"]
 	s3Req := &s3.CreateMultipartUploadInput{
-		Bucket: &bucket,
-		Key:    &key,
+		Bucket:       &bucket,
+		Key:          &key,
+		StorageClass: s3.StorageClass(r.Header.Get(storageClassHeader)),
+	}
+	sse, err := parseEncryption(r.Header, "x-amz-")
+	if err != nil {
+		return s3Req, err
 	}
+	s3Req.ServerSideEncryption = s3.ServerSideEncryption(sse.Algorithm)
+	s3Req.SSEKMSKeyId = sse.kmsKeyIdField()
+	s3Req.SSECustomerAlgorithm, s3Req.SSECustomerKey, s3Req.SSECustomerKeyMD5 = sse.customerKeyFields()
 	return s3Req, nil
 }
 
@@ -470,34 +782,68 @@ func (handler *Handler) PutParseInput(r *http.Request) (*s3manager.UploadInput,
 	if header := r.Header.Get("Content-Type"); header != "" {
 		s3Req.ContentType = &header
 	}
-	isChunked := false
-	if header := r.Header.Get("x-amz-content-sha256"); header == "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
-		isChunked = true
+	s3Req.Body = r.Body
+	var chunked *chunkedReader
+	if isChunkedPayload(r.Header.Get("x-amz-content-sha256")) {
+		logging.Log.Debug("CHUNKED %s", r.Header.Get("x-amz-decoded-content-length"))
+		var err error
+		if chunked, err = handler.chunkedBody(r); err != nil {
+			return s3Req, err
+		}
+		s3Req.Body = chunked
 	}
-	var contentLength int64
-	if header := r.Header.Get("x-amz-decoded-content-length"); header != "" {
-		contentLength, _ = strconv.ParseInt(header, 10, 64)
-	} else if header := r.Header.Get("x-amz-decoded-content-length"); header != "" {
-		contentLength, _ = strconv.ParseInt(header, 10, 64)
+	sum, err := parseChecksum(r.Header)
+	if err != nil {
+		return s3Req, err
 	}
-	if !isChunked {
-		s3Req.Body = r.Body
-	} else {
-		logging.Log.Debug("CHUNKED %d", contentLength)
-		readerWrapper := ChunkedReaderWrapper{
-			Reader:            &r.Body,
-			ChunkNextPosition: -1,
+	if chunked != nil && chunked.hasTrailer && sum.Algorithm != "" {
+		// the checksum arrives after the data, so it is filled in before the body ends
+		chunked.onTrailers = func(trailers http.Header) error {
+			if sum.Expected = trailers.Get(checksumHeader(sum.Algorithm)); sum.Expected == "" {
+				return &uploadError{response_type.S3InvalidRequest, "The trailing header " + checksumHeader(sum.Algorithm) + " was not sent"}
+			}
+			return nil
 		}
-		s3Req.Body = &readerWrapper
 	}
+	if sum.isSet() {
+		s3Req.Body = newChecksumReader(s3Req.Body, sum)
+	}
+	sse, err := parseEncryption(r.Header, "x-amz-")
+	if err != nil {
+		return s3Req, err
+	}
+	s3Req.ServerSideEncryption = s3.ServerSideEncryption(sse.Algorithm)
+	s3Req.SSEKMSKeyId = sse.kmsKeyIdField()
+	s3Req.SSECustomerAlgorithm, s3Req.SSECustomerKey, s3Req.SSECustomerKeyMD5 = sse.customerKeyFields()
+	s3Req.StorageClass = s3.StorageClass(r.Header.Get(storageClassHeader))
+	if s3Req.ObjectLockMode, s3Req.ObjectLockRetainUntilDate, s3Req.ObjectLockLegalHoldStatus, err = parseObjectLockHeaders(r.Header); err != nil {
+		return s3Req, err
+	}
+	s3Req.ACL = s3.ObjectCannedACL(r.Header.Get("x-amz-acl"))
+	grants := s3_handler.GrantHeaders(r.Header)
+	s3Req.GrantFullControl = grants[s3.PermissionFullControl]
+	s3Req.GrantRead = grants[s3.PermissionRead]
+	s3Req.GrantReadACP = grants[s3.PermissionReadAcp]
+	s3Req.GrantWriteACP = grants[s3.PermissionWriteAcp]
 	return s3Req, nil
 }
 
 // Handle an upload
 func (handler *Handler) PutHandle(writer http.ResponseWriter, request *http.Request) {
-	s3Req, _ := handler.PutParseInput(request)
-	var err error
+	s3Req, err := handler.PutParseInput(request)
 	defer request.Body.Close()
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		writeParseError(writer, request, err)
+		return
+	}
+	sse := inputEncryption(s3Req.ServerSideEncryption, s3Req.SSEKMSKeyId, s3Req.SSECustomerKey, s3Req.SSECustomerKeyMD5)
+	// S3 only supports create if absent, so * is the only If-None-Match value allowed on PUT
+	ifNoneMatch := request.Header.Get("If-None-Match")
+	if ifNoneMatch != "" && ifNoneMatch != "*" {
+		s3_handler.WriteErrorCode(writer, request, response_type.S3NotImplemented, "A header you provided implies functionality that is not implemented")
+		return
+	}
 	if handler.Config.IsSet("gcp_destination_config") {
 		// Use GCS
 		logging.Log.Info("Begin PUT request", request.RequestURI)
@@ -508,38 +854,91 @@ func (handler *Handler) PutHandle(writer http.ResponseWriter, request *http.Requ
 			defer handler.ReturnConnection(client, request)
 		}
 		if err != nil {
-			writer.WriteHeader(400)
 			logging.Log.Error("Error a %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
 		bucket := handler.BucketRename(*s3Req.Bucket)
 		bucketHandle := handler.GCPClientToBucket(bucket, client)
-		uploader := handler.GCPBucketToObject(*s3Req.Key, bucketHandle).NewWriter(*handler.Context)
+		kmsKeyName, err := handler.kmsKeyName(sse)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+			return
+		}
+		headers := s3_handler.GrantFields(s3Req.GrantFullControl, s3Req.GrantRead, s3Req.GrantReadACP, nil, s3Req.GrantWriteACP)
+		predefinedACL, aclRules, ok := handler.objectACL(writer, request, string(s3Req.ACL), nil, headers)
+		if !ok {
+			return
+		}
+		storageClass, ok := handler.gcsStorageClass(writer, request, string(s3Req.StorageClass))
+		if !ok {
+			return
+		}
+		if !handler.checkNewObjectRetention(writer, request, bucketHandle, s3Req.ObjectLockMode, s3Req.ObjectLockRetainUntilDate) {
+			return
+		}
+		objHandle := handler.GCPBucketToObject(*s3Req.Key, bucketHandle)
+		if ifNoneMatch == "*" {
+			objHandle = objHandle.If(storage.Conditions{DoesNotExist: true})
+		}
+		if sse.isCustomerKey() {
+			objHandle = objHandle.Key(sse.CustomerKey)
+		}
+		uploader := objHandle.NewWriter(*handler.Context)
+		uploader.KMSKeyName = kmsKeyName
+		uploader.PredefinedACL = predefinedACL
+		uploader.ACL = aclRules
+		uploader.StorageClass = storageClass
+		uploader.TemporaryHold = s3Req.ObjectLockLegalHoldStatus == s3.ObjectLockLegalHoldStatusOn
+		if sum, ok := s3Req.Body.(*checksumReader); ok {
+			sum.checksum.applyToWriter(uploader)
+		}
 		_, err = converter.GCPUpload(s3Req, uploader)
-		uploaderErr := uploader.Close()
 		if err != nil {
-			writer.WriteHeader(404)
+			// abandon the upload so a partial or mismatched body is never committed
+			uploader.CloseWithError(err)
 			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			writeUploadError(writer, request, err)
 			return
 		}
-		if uploaderErr != nil {
-			writer.WriteHeader(404)
+		if uploaderErr := uploader.Close(); uploaderErr != nil {
 			logging.Log.Error("Error %s %s", request.RequestURI, uploaderErr)
+			s3_handler.WriteError(writer, request, uploaderErr)
 			return
 		}
 		attrs := uploader.Attrs()
 		converter.GCSMD5ToEtag(attrs, writer)
+		writeUploadChecksum(writer, s3Req.Body)
+		handler.writeEncryptionHeaders(writer, attrs, sse)
+		writeVersionHeader(writer, attrs)
 		logging.Log.Info("Finish PUT request", request.RequestURI)
 	} else {
 		logging.LogUsingAWS()
 		uploader := s3manager.NewUploaderWithClient(handler.S3Client)
-		_, err = uploader.Upload(s3Req)
+		var output *s3manager.UploadOutput
+		if ifNoneMatch == "*" {
+			// the upload input has no field for this so set the header directly, only on the requests that create the
+			// object since S3 rejects it on the rest of a multipart upload
+			output, err = uploader.Upload(s3Req, s3manager.WithUploaderRequestOptions(func(r *aws.Request) {
+				if r.Operation.Name == "PutObject" || r.Operation.Name == "CompleteMultipartUpload" {
+					r.HTTPRequest.Header.Set("If-None-Match", "*")
+				}
+			}))
+		} else {
+			output, err = uploader.Upload(s3Req)
+		}
 		if err != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			writeUploadError(writer, request, err)
 			return
 		}
+		writeUploadChecksum(writer, s3Req.Body)
+		if output.VersionID != nil {
+			writer.Header().Set(versionIdHeader, *output.VersionID)
+		}
+		// the upload output doesn't include encryption, so echo what was asked for
+		writeAWSEncryptionHeaders(writer, s3Req.ServerSideEncryption, s3Req.SSEKMSKeyId, s3Req.SSECustomerAlgorithm, s3Req.SSECustomerKeyMD5)
 	}
 	writer.WriteHeader(200)
 	writer.Write([]byte(""))
@@ -550,16 +949,38 @@ func (handler *Handler) GetParseInput(r *http.Request) (*s3.GetObjectInput, erro
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 	key := vars["key"]
-	return &s3.GetObjectInput{Bucket: &bucket, Key: &key}, nil
+	conds := parseConditions(r.Header, "")
+	input := &s3.GetObjectInput{
+		Bucket:            &bucket,
+		Key:               &key,
+		IfMatch:           conds.IfMatch,
+		IfNoneMatch:       conds.IfNoneMatch,
+		IfModifiedSince:   conds.IfModifiedSince,
+		IfUnmodifiedSince: conds.IfUnmodifiedSince,
+	}
+	if header := r.Header.Get("Range"); header != "" {
+		input.Range = &header
+	}
+	sse, err := parseEncryption(r.Header, "x-amz-")
+	if err != nil {
+		return input, err
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sse.customerKeyFields()
+	input.VersionId = parseVersionId(r)
+	partNumber, err := parsePartNumber(r)
+	input.PartNumber = partNumber
+	return input, err
 }
 
 // Handle get request
 func (handler *Handler) GetHandle(writer http.ResponseWriter, request *http.Request) {
-	input, _ := handler.GetParseInput(request)
-	identifier := rand.Int()
-	if header := request.Header.Get("Range"); header != "" {
-		input.Range = &header
+	input, err := handler.GetParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+		return
 	}
+	identifier := rand.Int()
 	if handler.Config.IsSet("gcp_destination_config") {
 		// Use GCS
 		logging.Log.Info("Begin GET request", identifier, request.RequestURI, request.Header.Get("Range"))
@@ -570,47 +991,81 @@ func (handler *Handler) GetHandle(writer http.ResponseWriter, request *http.Requ
 			defer handler.ReturnConnection(client, request)
 		}
 		if err != nil {
-			writer.WriteHeader(400)
 			logging.Log.Error("Error a %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		generation, deleteMarker, err := gcsVersion(input.VersionId)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+			return
+		}
+		if deleteMarker {
+			writeDeleteMarkerError(writer, request, *input.VersionId)
 			return
 		}
 		bucket := handler.BucketRename(*input.Bucket)
 		bucketHandle := handler.GCPClientToBucket(bucket, client)
-		objHandle := handler.GCPBucketToObject(*input.Key, bucketHandle)
+		objHandle := versionHandle(handler.GCPBucketToObject(*input.Key, bucketHandle), generation)
+		sse := inputEncryption("", nil, input.SSECustomerKey, input.SSECustomerKeyMD5)
+		if sse.isCustomerKey() {
+			objHandle = objHandle.Key(sse.CustomerKey)
+		}
 		// Need to get attributes first to get size
 		attrs, err := objHandle.Attrs(*handler.Context)
 		if err != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			writeVersionError(writer, request, err, generation)
+			return
+		}
+		if code, message, ok := checkCustomerKey(attrs, sse); !ok {
+			logging.Log.Error("Error %s %s", request.RequestURI, code.Code)
+			s3_handler.WriteErrorCode(writer, request, code, message)
+			return
+		}
+		conds := conditions{
+			IfMatch:           input.IfMatch,
+			IfNoneMatch:       input.IfNoneMatch,
+			IfModifiedSince:   input.IfModifiedSince,
+			IfUnmodifiedSince: input.IfUnmodifiedSince,
+		}
+		if conds.isSet() {
+			if code, ok := conds.evaluateGCS(attrs, true); !ok {
+				logging.Log.Debug("Condition failed %s %s", request.RequestURI, code.Code)
+				writeConditionFailure(writer, request, attrs, code)
+				return
+			}
+			// make sure we read the same generation the conditions were checked against
+			objHandle = objHandle.If(storage.Conditions{GenerationMatch: attrs.Generation})
+		}
+		readRange, partsCount, ok := resolveRange(writer, request, attrs, input.Range, input.PartNumber)
+		if !ok {
+			logging.Log.Error("Error %s range not satisfiable %v", request.RequestURI, request.Header.Get("Range"))
 			return
 		}
 		var reader *storage.Reader
 		var readerError error
-		if input.Range != nil {
-			// Range requests are by length not by start and end
-			equalSplit := strings.SplitN(*input.Range, "=", 2)
-			byteSplit := strings.SplitN(equalSplit[1], "-", 2)
-			startByte, _ := strconv.ParseInt(byteSplit[0], 10, 64)
-			length := int64(-1)
-			if len(byteSplit) > 1 {
-				endByte, _ := strconv.ParseInt(byteSplit[1], 10, 64)
-				length = endByte + 1 - startByte
-				attrs.Size = length
-			} else {
-				length = attrs.Size - startByte
-			}
-			reader, readerError = objHandle.NewRangeReader(*handler.Context, startByte, length)
+		if readRange != nil {
+			reader, readerError = objHandle.NewRangeReader(*handler.Context, readRange.start, readRange.length)
 		} else {
 			reader, readerError = objHandle.NewReader(*handler.Context)
 		}
 		if readerError != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error %s %s", request.RequestURI, readerError)
+			s3_handler.WriteError(writer, request, readerError)
 			return
 		}
 		// Send headers
 		converter.GCSAttrToHeaders(attrs, writer)
+		writeChecksumHeaders(writer, request, attrs, readRange == nil)
+		handler.writeStorageClassHeaders(writer, attrs)
+		handler.writeObjectLockHeaders(writer, bucketHandle, attrs)
+		handler.writeEncryptionHeaders(writer, attrs, sse)
+		writeVersionHeader(writer, attrs)
+		if status := setRangeHeaders(writer, attrs, readRange, partsCount); status != http.StatusOK {
+			writer.WriteHeader(status)
+		}
 		defer reader.Close()
 		if n, writeErr := io.Copy(writer, reader); writeErr != nil {
 			logging.Log.Error("Some error writing", n, identifier, request.RequestURI, writeErr)
@@ -620,29 +1075,50 @@ func (handler *Handler) GetHandle(writer http.ResponseWriter, request *http.Requ
 	} else {
 		logging.LogUsingAWS()
 		req := handler.S3Client.GetObjectRequest(input)
+		var responseHeaders http.Header
+		req.ApplyOptions(aws.WithGetResponseHeaders(&responseHeaders))
+		if header := request.Header.Get(checksumModeHeader); header != "" {
+			req.HTTPRequest.Header.Set(checksumModeHeader, header)
+		}
 		resp, respError := req.Send()
 		if respError != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error %s %s", identifier, request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
 			return
 		}
-		if header := resp.ServerSideEncryption; header != "" {
-			writer.Header().Set("ServerSideEncryption", string(header))
+		copyAWSChecksumHeaders(writer, responseHeaders)
+		writeAWSEncryptionHeaders(writer, resp.ServerSideEncryption, resp.SSEKMSKeyId, resp.SSECustomerAlgorithm, resp.SSECustomerKeyMD5)
+		if header := resp.VersionId; header != nil {
+			writer.Header().Set(versionIdHeader, *header)
 		}
+		if header := resp.StorageClass; header != "" {
+			writer.Header().Set(storageClassHeader, string(header))
+		}
+		if header := resp.Restore; header != nil {
+			writer.Header().Set("x-amz-restore", *header)
+		}
+		writeAWSObjectLockHeaders(writer, resp.ObjectLockMode, resp.ObjectLockRetainUntilDate, resp.ObjectLockLegalHoldStatus)
 		if header := resp.LastModified; header != nil {
 			lastMod := header.Format(time.RFC1123)
 			lastMod = strings.Replace(lastMod, "UTC", "GMT", 1)
 			writer.Header().Set("Last-Modified", lastMod)
 		}
-		if header := resp.ContentRange; header != nil {
-			writer.Header().Set("ContentRange", *header)
-		}
 		if header := resp.ETag; header != nil {
 			writer.Header().Set("ETag", *header)
 		}
 		if header := resp.ContentLength; header != nil {
 			writer.Header().Set("Content-Length", strconv.FormatInt(*header, 10))
 		}
+		if header := resp.AcceptRanges; header != nil {
+			writer.Header().Set("Accept-Ranges", *header)
+		}
+		if header := resp.PartsCount; header != nil {
+			writer.Header().Set("x-amz-mp-parts-count", strconv.FormatInt(*header, 10))
+		}
+		if header := resp.ContentRange; header != nil {
+			writer.Header().Set("Content-Range", *header)
+			writer.WriteHeader(http.StatusPartialContent)
+		}
 		defer resp.Body.Close()
 		if n, writeErr := io.Copy(writer, resp.Body); writeErr != nil {
 			logging.Log.Error("Some error writing", n, identifier, request.RequestURI, writeErr)
@@ -655,12 +1131,38 @@ func (handler *Handler) HeadParseInput(r *http.Request) (*s3.HeadObjectInput, er
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 	key := vars["key"]
-	return &s3.HeadObjectInput{Bucket: &bucket, Key: &key}, nil
+	conds := parseConditions(r.Header, "")
+	input := &s3.HeadObjectInput{
+		Bucket:            &bucket,
+		Key:               &key,
+		IfMatch:           conds.IfMatch,
+		IfNoneMatch:       conds.IfNoneMatch,
+		IfModifiedSince:   conds.IfModifiedSince,
+		IfUnmodifiedSince: conds.IfUnmodifiedSince,
+	}
+	if header := r.Header.Get("Range"); header != "" {
+		input.Range = &header
+	}
+	sse, err := parseEncryption(r.Header, "x-amz-")
+	if err != nil {
+		return input, err
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sse.customerKeyFields()
+	input.VersionId = parseVersionId(r)
+	partNumber, err := parsePartNumber(r)
+	input.PartNumber = partNumber
+	return input, err
 }
 
 // Handle HEAD request
 func (handler *Handler) HeadHandle(writer http.ResponseWriter, request *http.Request) {
-	input, _ := handler.HeadParseInput(request)
+	input, err := handler.HeadParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+		return
+	}
+	status := http.StatusOK
 	if handler.Config.IsSet("gcp_destination_config") {
 		// Use GCS
 		var resp *storage.ObjectAttrs
@@ -671,14 +1173,24 @@ func (handler *Handler) HeadHandle(writer http.ResponseWriter, request *http.Req
 			defer handler.ReturnConnection(client, request)
 		}
 		if err != nil {
-			writer.WriteHeader(400)
 			logging.Log.Error("Error a %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		generation, deleteMarker, err := gcsVersion(input.VersionId)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+			return
+		}
+		if deleteMarker {
+			writeDeleteMarkerError(writer, request, *input.VersionId)
 			return
 		}
 		bucket := handler.BucketRename(*input.Bucket)
 		bucketHandle := handler.GCPClientToBucket(bucket, client)
-		if strings.HasSuffix(*input.Key, "/") {
+		sse := inputEncryption("", nil, input.SSECustomerKey, input.SSECustomerKeyMD5)
+		if strings.HasSuffix(*input.Key, "/") && generation == 0 {
 			//directories dont have header info so fake it
 			it := bucketHandle.Objects(*handler.Context, &storage.Query{
 				Delimiter: "/",
@@ -688,13 +1200,13 @@ func (handler *Handler) HeadHandle(writer http.ResponseWriter, request *http.Req
 			var pageResponse []*storage.ObjectAttrs
 			_, err := iterator.NewPager(it, 1, "").NextPage(&pageResponse)
 			if err != nil {
-				writer.WriteHeader(404)
 				logging.Log.Error("Error %s %s", request.RequestURI, err)
+				s3_handler.WriteError(writer, request, err)
 				return
 			}
 			if len(pageResponse) == 0 {
-				writer.WriteHeader(404)
 				logging.Log.Error("Error %s key doesn't exist", request.RequestURI)
+				s3_handler.WriteErrorCode(writer, request, response_type.S3NoSuchKey, "")
 				return
 			}
 			hash := md5.Sum([]byte(time.Now().String()))
@@ -706,21 +1218,59 @@ func (handler *Handler) HeadHandle(writer http.ResponseWriter, request *http.Req
 			}
 		} else {
 			var err error
-			resp, err = handler.GCPBucketToObject(*input.Key, bucketHandle).Attrs(*handler.Context)
+			objHandle := versionHandle(handler.GCPBucketToObject(*input.Key, bucketHandle), generation)
+			if sse.isCustomerKey() {
+				objHandle = objHandle.Key(sse.CustomerKey)
+			}
+			resp, err = objHandle.Attrs(*handler.Context)
 			if err != nil {
-				writer.WriteHeader(404)
 				logging.Log.Error("Error %s %s", request.RequestURI, err)
+				writeVersionError(writer, request, err, generation)
 				return
 			}
+			if code, message, ok := checkCustomerKey(resp, sse); !ok {
+				logging.Log.Error("Error %s %s", request.RequestURI, code.Code)
+				s3_handler.WriteErrorCode(writer, request, code, message)
+				return
+			}
+		}
+		conds := conditions{
+			IfMatch:           input.IfMatch,
+			IfNoneMatch:       input.IfNoneMatch,
+			IfModifiedSince:   input.IfModifiedSince,
+			IfUnmodifiedSince: input.IfUnmodifiedSince,
+		}
+		if code, ok := conds.evaluateGCS(resp, true); !ok {
+			logging.Log.Debug("Condition failed %s %s", request.RequestURI, code.Code)
+			writeConditionFailure(writer, request, resp, code)
+			return
+		}
+		readRange, partsCount, ok := resolveRange(writer, request, resp, input.Range, input.PartNumber)
+		if !ok {
+			logging.Log.Error("Error %s range not satisfiable %v", request.RequestURI, request.Header.Get("Range"))
+			return
 		}
 		converter.GCSAttrToHeaders(resp, writer)
+		writeChecksumHeaders(writer, request, resp, readRange == nil)
+		handler.writeStorageClassHeaders(writer, resp)
+		handler.writeObjectLockHeaders(writer, bucketHandle, resp)
+		handler.writeEncryptionHeaders(writer, resp, sse)
+		writeVersionHeader(writer, resp)
+		status = setRangeHeaders(writer, resp, readRange, partsCount)
 	} else {
 		logging.LogUsingAWS()
 		req := handler.S3Client.HeadObjectRequest(input)
+		// the head output doesn't include the range that was sent back
+		var contentRange string
+		var responseHeaders http.Header
+		req.ApplyOptions(aws.WithGetResponseHeader("Content-Range", &contentRange), aws.WithGetResponseHeaders(&responseHeaders))
+		if header := request.Header.Get(checksumModeHeader); header != "" {
+			req.HTTPRequest.Header.Set(checksumModeHeader, header)
+		}
 		resp, respError := req.Send()
 		if respError != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error %s %s", request.RequestURI, respError)
+			s3_handler.WriteError(writer, request, respError)
 			return
 		}
 		if resp.AcceptRanges != nil {
@@ -729,9 +1279,7 @@ func (handler *Handler) HeadHandle(writer http.ResponseWriter, request *http.Req
 		if resp.ContentLength != nil {
 			writer.Header().Set("Content-Length", strconv.FormatInt(*resp.ContentLength, 10))
 		}
-		if resp.ServerSideEncryption != "" {
-			writer.Header().Set("x-amz-server-side-encryption", string(resp.ServerSideEncryption))
-		}
+		writeAWSEncryptionHeaders(writer, resp.ServerSideEncryption, resp.SSEKMSKeyId, resp.SSECustomerAlgorithm, resp.SSECustomerKeyMD5)
 		if resp.CacheControl != nil {
 			writer.Header().Set("Cache-Control", *resp.CacheControl)
 		}
@@ -746,8 +1294,26 @@ func (handler *Handler) HeadHandle(writer http.ResponseWriter, request *http.Req
 			lastMod = strings.Replace(lastMod, "UTC", "GMT", 1)
 			writer.Header().Set("Last-Modified", lastMod)
 		}
+		if resp.PartsCount != nil {
+			writer.Header().Set("x-amz-mp-parts-count", strconv.FormatInt(*resp.PartsCount, 10))
+		}
+		if resp.VersionId != nil {
+			writer.Header().Set(versionIdHeader, *resp.VersionId)
+		}
+		if resp.StorageClass != "" {
+			writer.Header().Set(storageClassHeader, string(resp.StorageClass))
+		}
+		if resp.Restore != nil {
+			writer.Header().Set("x-amz-restore", *resp.Restore)
+		}
+		writeAWSObjectLockHeaders(writer, resp.ObjectLockMode, resp.ObjectLockRetainUntilDate, resp.ObjectLockLegalHoldStatus)
+		copyAWSChecksumHeaders(writer, responseHeaders)
+		if contentRange != "" {
+			writer.Header().Set("Content-Range", contentRange)
+			status = http.StatusPartialContent
+		}
 	}
-	writer.WriteHeader(200)
+	writer.WriteHeader(status)
 	return
 }
 
@@ -765,12 +1331,44 @@ func (handler *Handler) CopyParseInput(r *http.Request) (*s3.CopyObjectInput, er
 	if header := r.Header.Get("Content-Type"); header != "" {
 		s3Req.ContentType = &header
 	}
+	conds := parseConditions(r.Header, "x-amz-copy-source-")
+	s3Req.CopySourceIfMatch = conds.IfMatch
+	s3Req.CopySourceIfNoneMatch = conds.IfNoneMatch
+	s3Req.CopySourceIfModifiedSince = conds.IfModifiedSince
+	s3Req.CopySourceIfUnmodifiedSince = conds.IfUnmodifiedSince
+	sse, err := parseEncryption(r.Header, "x-amz-")
+	if err != nil {
+		return s3Req, err
+	}
+	s3Req.ServerSideEncryption = s3.ServerSideEncryption(sse.Algorithm)
+	s3Req.SSEKMSKeyId = sse.kmsKeyIdField()
+	s3Req.SSECustomerAlgorithm, s3Req.SSECustomerKey, s3Req.SSECustomerKeyMD5 = sse.customerKeyFields()
+	sourceSSE, err := parseEncryption(r.Header, "x-amz-copy-source-")
+	if err != nil {
+		return s3Req, err
+	}
+	s3Req.CopySourceSSECustomerAlgorithm, s3Req.CopySourceSSECustomerKey, s3Req.CopySourceSSECustomerKeyMD5 = sourceSSE.customerKeyFields()
+	s3Req.StorageClass = s3.StorageClass(r.Header.Get(storageClassHeader))
+	if s3Req.ObjectLockMode, s3Req.ObjectLockRetainUntilDate, s3Req.ObjectLockLegalHoldStatus, err = parseObjectLockHeaders(r.Header); err != nil {
+		return s3Req, err
+	}
+	s3Req.ACL = s3.ObjectCannedACL(r.Header.Get("x-amz-acl"))
+	grants := s3_handler.GrantHeaders(r.Header)
+	s3Req.GrantFullControl = grants[s3.PermissionFullControl]
+	s3Req.GrantRead = grants[s3.PermissionRead]
+	s3Req.GrantReadACP = grants[s3.PermissionReadAcp]
+	s3Req.GrantWriteACP = grants[s3.PermissionWriteAcp]
 	return s3Req, nil
 }
 
 // Handle copy command
 func (handler *Handler) CopyHandle(writer http.ResponseWriter, request *http.Request) {
-	s3Req, _ := handler.CopyParseInput(request)
+	s3Req, err := handler.CopyParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+		return
+	}
 	var copyResult response_type.CopyResult
 	if handler.Config.IsSet("gcp_destination_config") {
 		// Use GCS
@@ -781,39 +1379,112 @@ func (handler *Handler) CopyHandle(writer http.ResponseWriter, request *http.Req
 			defer handler.ReturnConnection(client, request)
 		}
 		if err != nil {
-			writer.WriteHeader(400)
 			logging.Log.Error("Error a %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
-		source := *s3Req.CopySource
-		if strings.Index(source, "/") == 0 {
-			source = source[1:]
+		sourceBucket, sourceKey, sourceVersionId := parseCopySource(*s3Req.CopySource)
+		sourceGeneration, deleteMarker, err := gcsVersion(sourceVersionId)
+		if err != nil || deleteMarker {
+			logging.Log.Error("Error %s invalid copy source version %v", request.RequestURI, sourceVersionId)
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, "Invalid version id specified")
+			return
 		}
-		sourcePieces := strings.SplitN(source, "/", 2)
-		sourceBucket := sourcePieces[0]
-		sourceKey := sourcePieces[1]
 		bucket := handler.BucketRename(*s3Req.Bucket)
 		bucketHandle := handler.GCPClientToBucket(bucket, client)
 		sourceBucket = handler.BucketRename(sourceBucket)
 		sourceHandle := handler.GCPClientToBucket(sourceBucket, client).Object(sourceKey)
-		uploader := handler.GCPBucketToObject(*s3Req.Key, bucketHandle).CopierFrom(sourceHandle)
+		if sourceGeneration != 0 {
+			sourceHandle = sourceHandle.Generation(sourceGeneration)
+		}
+		sourceSSE := inputEncryption("", nil, s3Req.CopySourceSSECustomerKey, s3Req.CopySourceSSECustomerKeyMD5)
+		if sourceSSE.isCustomerKey() {
+			sourceHandle = sourceHandle.Key(sourceSSE.CustomerKey)
+		}
+		sse := inputEncryption(s3Req.ServerSideEncryption, s3Req.SSEKMSKeyId, s3Req.SSECustomerKey, s3Req.SSECustomerKeyMD5)
+		kmsKeyName, err := handler.kmsKeyName(sse)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+			return
+		}
+		conds := conditions{
+			IfMatch:           s3Req.CopySourceIfMatch,
+			IfNoneMatch:       s3Req.CopySourceIfNoneMatch,
+			IfModifiedSince:   s3Req.CopySourceIfModifiedSince,
+			IfUnmodifiedSince: s3Req.CopySourceIfUnmodifiedSince,
+		}
+		storageClass, ok := handler.gcsStorageClass(writer, request, string(s3Req.StorageClass))
+		if !ok {
+			return
+		}
+		if !handler.checkNewObjectRetention(writer, request, bucketHandle, s3Req.ObjectLockMode, s3Req.ObjectLockRetainUntilDate) {
+			return
+		}
+		legalHold := s3Req.ObjectLockLegalHoldStatus == s3.ObjectLockLegalHoldStatusOn
+		var sourceAttrs *storage.ObjectAttrs
+		if conds.isSet() || storageClass != "" || legalHold {
+			sourceAttrs, err = sourceHandle.Attrs(*handler.Context)
+			if err != nil {
+				logging.Log.Error("Error %s %s", request.RequestURI, err)
+				writeVersionError(writer, request, err, sourceGeneration)
+				return
+			}
+			// copies fail every condition with 412
+			if code, ok := conds.evaluateGCS(sourceAttrs, false); !ok {
+				logging.Log.Debug("Copy source condition failed %s %s", request.RequestURI, code.Code)
+				s3_handler.WriteErrorCode(writer, request, code, "")
+				return
+			}
+			// make sure we copy the same generation the conditions were checked against
+			sourceHandle = sourceHandle.If(storage.Conditions{GenerationMatch: sourceAttrs.Generation})
+		}
+		headers := s3_handler.GrantFields(s3Req.GrantFullControl, s3Req.GrantRead, s3Req.GrantReadACP, nil, s3Req.GrantWriteACP)
+		predefinedACL, aclRules, ok := handler.objectACL(writer, request, string(s3Req.ACL), nil, headers)
+		if !ok {
+			return
+		}
+		destHandle := handler.GCPBucketToObject(*s3Req.Key, bucketHandle)
+		if sse.isCustomerKey() {
+			destHandle = destHandle.Key(sse.CustomerKey)
+		}
+		uploader := destHandle.CopierFrom(sourceHandle)
+		uploader.DestinationKMSKeyName = kmsKeyName
+		uploader.PredefinedACL = predefinedACL
+		uploader.ACL = aclRules
+		if storageClass != "" || legalHold {
+			copyObjectAttrs(uploader, sourceAttrs)
+			uploader.StorageClass = storageClass
+			uploader.TemporaryHold = legalHold
+		}
 		attrs, err := uploader.Run(*handler.Context)
 		if err != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			writeVersionError(writer, request, err, sourceGeneration)
 			return
 		}
+		handler.writeEncryptionHeaders(writer, attrs, sse)
+		writeVersionHeader(writer, attrs)
+		if sourceVersionId != nil {
+			writer.Header().Set("x-amz-copy-source-version-id", *sourceVersionId)
+		}
 		copyResult = converter.GCSCopyResponseToAWS(attrs)
 	} else {
 		logging.LogUsingAWS()
 		req := handler.S3Client.CopyObjectRequest(s3Req)
 		result, err := req.Send()
 		if err != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
+		writeAWSEncryptionHeaders(writer, result.ServerSideEncryption, result.SSEKMSKeyId, result.SSECustomerAlgorithm, result.SSECustomerKeyMD5)
+		if result.VersionId != nil {
+			writer.Header().Set(versionIdHeader, *result.VersionId)
+		}
+		if result.CopySourceVersionId != nil {
+			writer.Header().Set("x-amz-copy-source-version-id", *result.CopySourceVersionId)
+		}
 		copyResult = response_type.CopyResult{
 			LastModified: converter.FormatTimeZulu(result.CopyObjectResult.LastModified),
 			ETag:         *result.CopyObjectResult.ETag,
@@ -830,8 +1501,12 @@ func (handler *Handler) DeleteParseInput(r *http.Request) (*s3.DeleteObjectInput
 	bucket := vars["bucket"]
 	key := vars["key"]
 	s3Req := &s3.DeleteObjectInput{
-		Bucket: &bucket,
-		Key:    &key,
+		Bucket:    &bucket,
+		Key:       &key,
+		VersionId: parseVersionId(r),
+	}
+	if mfa := r.Header.Get("x-amz-mfa"); mfa != "" {
+		s3Req.MFA = &mfa
 	}
 	return s3Req, nil
 }
@@ -848,29 +1523,44 @@ func (handler *Handler) DeleteHandle(writer http.ResponseWriter, request *http.R
 			defer handler.ReturnConnection(client, request)
 		}
 		if err != nil {
-			writer.WriteHeader(400)
 			logging.Log.Error("Error a %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
 		bucket := handler.BucketRename(*s3Req.Bucket)
 		bucketHandle := handler.GCPClientToBucket(bucket, client)
-		objectHandle := handler.GCPBucketToObject(*s3Req.Key, bucketHandle)
-		err = objectHandle.Delete(*handler.Context)
+		if _, _, err := gcsVersion(s3Req.VersionId); err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+			return
+		}
+		deleted, err := handler.deleteGCSVersion(bucketHandle, *s3Req.Key, s3Req.VersionId)
 		if err != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
+		if deleted.DeleteMarker != nil && *deleted.DeleteMarker {
+			writer.Header().Set(deleteMarkerHeader, "true")
+			writer.Header().Set(versionIdHeader, *deleted.DeleteMarkerVersionId)
+		} else if deleted.VersionId != nil {
+			writer.Header().Set(versionIdHeader, *deleted.VersionId)
+		}
 	} else {
 		logging.LogUsingAWS()
 		req := handler.S3Client.DeleteObjectRequest(s3Req)
-		_, err := req.Send()
+		resp, err := req.Send()
 		if err != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
+		if resp.DeleteMarker != nil && *resp.DeleteMarker {
+			writer.Header().Set(deleteMarkerHeader, "true")
+		}
+		if resp.VersionId != nil {
+			writer.Header().Set(versionIdHeader, *resp.VersionId)
+		}
 	}
 	writer.WriteHeader(200)
 }
@@ -897,7 +1587,8 @@ func (handler *Handler) MultiDeleteParseInput(r *http.Request) (*s3.DeleteObject
 	keys := make([]s3.ObjectIdentifier, len(input.Objects))
 	for i, key := range input.Objects {
 		keys[i] = s3.ObjectIdentifier{
-			Key: key.Key,
+			Key:       key.Key,
+			VersionId: key.VersionId,
 		}
 	}
 	s3Req := &s3.DeleteObjectsInput{
@@ -911,7 +1602,12 @@ func (handler *Handler) MultiDeleteParseInput(r *http.Request) (*s3.DeleteObject
 
 // Handle request for deleting multiple files
 func (handler *Handler) MultiDeleteHandle(writer http.ResponseWriter, request *http.Request) {
-	s3Req, _ := handler.MultiDeleteParseInput(request)
+	s3Req, err := handler.MultiDeleteParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3MalformedXML, "")
+		return
+	}
 	response := response_type.MultiDeleteResult{}
 	if handler.Config.IsSet("gcp_destination_config") {
 		// Use GCS
@@ -922,55 +1618,72 @@ func (handler *Handler) MultiDeleteHandle(writer http.ResponseWriter, request *h
 			defer handler.ReturnConnection(client, request)
 		}
 		if err != nil {
-			writer.WriteHeader(400)
 			logging.Log.Error("Error a %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
 		bucket := handler.BucketRename(*s3Req.Bucket)
 		bucketHandle := handler.GCPClientToBucket(bucket, client)
-		deletedKeys := make([]string, 0)
-		failedKeys := make([]string, 0)
+		deletedObjects := make([]*response_type.DeleteObject, 0)
+		failedObjects := make([]*response_type.ErrorResult, 0)
 		// iterate through objects and delete each
 		for _, obj := range s3Req.Delete.Objects {
-			err := bucketHandle.Object(*obj.Key).Delete(*handler.Context)
+			key := *obj.Key
+			versionId := obj.VersionId
+			if _, _, err := gcsVersion(versionId); err != nil {
+				code := response_type.S3NoSuchVersion
+				message := code.Message
+				failedObjects = append(failedObjects, &response_type.ErrorResult{
+					Key:       &key,
+					VersionId: versionId,
+					Code:      &code.Code,
+					Message:   &message,
+				})
+				continue
+			}
+			deleted, err := handler.deleteGCSVersion(bucketHandle, key, versionId)
 			if err != nil {
-				failedKeys = append(failedKeys, *obj.Key)
+				// aws treats deleting a missing key as a success, anything else is reported per key
+				code, message, _ := converter.ErrorToS3(err)
+				failedObjects = append(failedObjects, &response_type.ErrorResult{
+					Key:       &key,
+					VersionId: versionId,
+					Code:      &code.Code,
+					Message:   &message,
+				})
 			} else {
-				deletedKeys = append(deletedKeys, *obj.Key)
-			}
-		}
-		// aws never returns failed deletes
-		logging.Log.Debugf("failed keys %s succeeded keys %s", failedKeys, deletedKeys)
-		deletedObjects := make([]*response_type.DeleteObject, len(deletedKeys))
-		for i, obj := range deletedKeys {
-			deletedObjects[i] = &response_type.DeleteObject{
-				Key: &obj,
+				deletedObjects = append(deletedObjects, deleted)
 			}
 		}
+		logging.Log.Debugf("failed keys %d succeeded keys %d", len(failedObjects), len(deletedObjects))
+		response.Errors = failedObjects
 		response.Objects = deletedObjects
 	} else {
 		logging.LogUsingAWS()
 		req := handler.S3Client.DeleteObjectsRequest(s3Req)
 		resp, err := req.Send()
 		if err != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
 			return
 		}
 		deletedObjects := make([]*response_type.DeleteObject, len(resp.Deleted))
 		for i, obj := range resp.Deleted {
 			deletedObjects[i] = &response_type.DeleteObject{
-				Key: obj.Key,
+				Key:                   obj.Key,
+				VersionId:             obj.VersionId,
+				DeleteMarker:          obj.DeleteMarker,
+				DeleteMarkerVersionId: obj.DeleteMarkerVersionId,
 			}
 		}
 		response.Objects = deletedObjects
 		failedObjects := make([]*response_type.ErrorResult, len(resp.Errors))
 		for i, obj := range resp.Errors {
 			failedObjects[i] = &response_type.ErrorResult{
-				Key:     obj.Key,
-				Code:    obj.Code,
-				Message: obj.Message,
+				Key:       obj.Key,
+				VersionId: obj.VersionId,
+				Code:      obj.Code,
+				Message:   obj.Message,
 			}
 		}
 		response.Errors = failedObjects
@@ -981,5 +1694,5 @@ func (handler *Handler) MultiDeleteHandle(writer http.ResponseWriter, request *h
 }
 
 func New(s3Handler *s3_handler.Handler) *Handler {
-	return &Handler{s3Handler, sync.Mutex{}}
+	return &Handler{Handler: s3Handler}
 }
@@ -0,0 +1,411 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"cloudsidecar/pkg/response_type"
+	"encoding/xml"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	objectLockModeHeader      = "x-amz-object-lock-mode"
+	objectLockRetainHeader    = "x-amz-object-lock-retain-until-date"
+	objectLockLegalHoldHeader = "x-amz-object-lock-legal-hold"
+)
+
+// Object lock settings for a new object.  Mode and retain until date only come together
+func parseObjectLockHeaders(header http.Header) (s3.ObjectLockMode, *time.Time, s3.ObjectLockLegalHoldStatus, error) {
+	mode := s3.ObjectLockMode(header.Get(objectLockModeHeader))
+	legalHold := s3.ObjectLockLegalHoldStatus(header.Get(objectLockLegalHoldHeader))
+	if legalHold != "" && legalHold != s3.ObjectLockLegalHoldStatusOn && legalHold != s3.ObjectLockLegalHoldStatusOff {
+		return "", nil, "", errors.New("invalid legal hold status " + string(legalHold))
+	}
+	retainUntil := header.Get(objectLockRetainHeader)
+	if mode == "" && retainUntil == "" {
+		return "", nil, legalHold, nil
+	}
+	if mode != s3.ObjectLockModeGovernance && mode != s3.ObjectLockModeCompliance {
+		return "", nil, "", errors.New("x-amz-object-lock-mode and x-amz-object-lock-retain-until-date must both be supplied")
+	}
+	until, err := time.Parse(time.RFC3339, retainUntil)
+	if err != nil {
+		return "", nil, "", errors.New("invalid x-amz-object-lock-retain-until-date " + retainUntil)
+	}
+	return mode, &until, legalHold, nil
+}
+
+// Whether S3 retention is already given by a GCS retention policy, which is the only retention GCS objects have.
+// An object is covered when the policy keeps it at least until the date asked for and, for compliance mode,
+// the policy is locked.  Returns the error to send otherwise
+func retentionCovered(policy *storage.RetentionPolicy, expiration time.Time, mode string, until time.Time) (response_type.S3ErrorCode, string, bool) {
+	if policy == nil || policy.RetentionPeriod <= 0 {
+		return response_type.S3InvalidRequest, "Bucket is missing Object Lock Configuration", false
+	}
+	if mode == string(s3.ObjectLockRetentionModeCompliance) && !policy.IsLocked {
+		return response_type.S3NotImplemented, "Compliance mode needs the bucket's object lock configuration in compliance mode", false
+	}
+	if until.After(expiration) {
+		return response_type.S3NotImplemented, "Objects can't be retained longer than the bucket's default retention", false
+	}
+	return response_type.S3ErrorCode{}, "", true
+}
+
+// Check retention asked for on a new object against the bucket retention policy, writing the error if it isn't
+// covered
+func (handler *Handler) checkNewObjectRetention(writer http.ResponseWriter, request *http.Request, bucketHandle s3_handler.GCPBucket, mode s3.ObjectLockMode, until *time.Time) bool {
+	if until == nil {
+		return true
+	}
+	attrs, err := bucketHandle.Attrs(*handler.Context)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteError(writer, request, err)
+		return false
+	}
+	var expiration time.Time
+	if attrs.RetentionPolicy != nil {
+		expiration = time.Now().Add(attrs.RetentionPolicy.RetentionPeriod)
+	}
+	if code, message, ok := retentionCovered(attrs.RetentionPolicy, expiration, string(mode), *until); !ok {
+		logging.Log.Error("Error %s %s", request.RequestURI, message)
+		s3_handler.WriteErrorCode(writer, request, code, message)
+		return false
+	}
+	return true
+}
+
+// Object lock headers for a GCS object.  A temporary hold is a legal hold, and objects under a retention policy
+// are retained in the mode of the policy
+func (handler *Handler) writeObjectLockHeaders(writer http.ResponseWriter, bucketHandle s3_handler.GCPBucket, attrs *storage.ObjectAttrs) {
+	if attrs.TemporaryHold {
+		writer.Header().Set(objectLockLegalHoldHeader, string(s3.ObjectLockLegalHoldStatusOn))
+	}
+	if attrs.RetentionExpirationTime.IsZero() {
+		return
+	}
+	bucketAttrs, err := bucketHandle.Attrs(*handler.Context)
+	if err != nil {
+		logging.Log.Error("Error getting retention policy %s", err)
+		return
+	}
+	writer.Header().Set(objectLockModeHeader, string(converter.GCSRetentionMode(bucketAttrs.RetentionPolicy)))
+	writer.Header().Set(objectLockRetainHeader, converter.FormatTimeZulu(&attrs.RetentionExpirationTime))
+}
+
+// Object lock headers from an S3 response
+func writeAWSObjectLockHeaders(writer http.ResponseWriter, mode s3.ObjectLockMode, until *time.Time, legalHold s3.ObjectLockLegalHoldStatus) {
+	if mode != "" {
+		writer.Header().Set(objectLockModeHeader, string(mode))
+	}
+	if until != nil {
+		writer.Header().Set(objectLockRetainHeader, converter.FormatTimeZulu(until))
+	}
+	if legalHold != "" {
+		writer.Header().Set(objectLockLegalHoldHeader, string(legalHold))
+	}
+}
+
+// Parse input for get object retention requests
+func (handler *Handler) RetentionParseInput(r *http.Request) (*s3.GetObjectRetentionInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+	return &s3.GetObjectRetentionInput{Bucket: &bucket, Key: &key, VersionId: parseVersionId(r)}, nil
+}
+
+// Handle get object retention requests.  GCS objects are retained until the expiration set by the bucket
+// retention policy
+func (handler *Handler) RetentionHandle(writer http.ResponseWriter, request *http.Request) {
+	input, _ := handler.RetentionParseInput(request)
+	response := &response_type.ObjectLockRetention{XmlNS: "http://s3.amazonaws.com/doc/2006-03-01/"}
+	if handler.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		generation, deleteMarker, err := gcsVersion(input.VersionId)
+		if err != nil {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+			return
+		}
+		if deleteMarker {
+			writeDeleteMarkerError(writer, request, *input.VersionId)
+			return
+		}
+		client, err := handler.GCPRequestSetup(request)
+		if client != nil {
+			defer handler.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucketHandle := handler.GCPClientToBucket(handler.BucketRename(*input.Bucket), client)
+		attrs, err := versionHandle(handler.GCPBucketToObject(*input.Key, bucketHandle), generation).Attrs(*handler.Context)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			writeVersionError(writer, request, err, generation)
+			return
+		}
+		if attrs.RetentionExpirationTime.IsZero() {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3NoSuchObjectRetention, "")
+			return
+		}
+		bucketAttrs, err := bucketHandle.Attrs(*handler.Context)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		response.Mode = string(converter.GCSRetentionMode(bucketAttrs.RetentionPolicy))
+		response.RetainUntilDate = converter.FormatTimeZulu(&attrs.RetentionExpirationTime)
+	} else {
+		logging.LogUsingAWS()
+		req := handler.S3Client.GetObjectRetentionRequest(input)
+		resp, err := req.Send()
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		if resp.Retention != nil {
+			response.Mode = string(resp.Retention.Mode)
+			if resp.Retention.RetainUntilDate != nil {
+				response.RetainUntilDate = converter.FormatTimeZulu(resp.Retention.RetainUntilDate)
+			}
+		}
+	}
+	output, _ := xml.Marshal(response)
+	writer.Write([]byte(s3_handler.XmlHeader))
+	writer.Write(output)
+}
+
+// Parse input for put object retention requests
+func (handler *Handler) PutRetentionParseInput(r *http.Request) (*s3.PutObjectRetentionInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+	input := &s3.PutObjectRetentionInput{Bucket: &bucket, Key: &key, VersionId: parseVersionId(r)}
+	if r.Header.Get("x-amz-bypass-governance-retention") == "true" {
+		bypass := true
+		input.BypassGovernanceRetention = &bypass
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return input, err
+	}
+	var retention response_type.ObjectLockRetention
+	if err := xml.Unmarshal(body, &retention); err != nil {
+		return input, err
+	}
+	mode := s3.ObjectLockRetentionMode(retention.Mode)
+	if mode != s3.ObjectLockRetentionModeGovernance && mode != s3.ObjectLockRetentionModeCompliance {
+		return input, errors.New("invalid retention mode " + retention.Mode)
+	}
+	until, err := time.Parse(time.RFC3339, retention.RetainUntilDate)
+	if err != nil {
+		return input, err
+	}
+	input.Retention = &s3.ObjectLockRetention{Mode: mode, RetainUntilDate: &until}
+	return input, nil
+}
+
+// Handle put object retention requests.  GCS can't retain single objects, so this only succeeds when the bucket
+// retention policy already retains the object as long as asked
+func (handler *Handler) PutRetentionHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := handler.PutRetentionParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3MalformedXML, "")
+		return
+	}
+	if handler.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		generation, deleteMarker, err := gcsVersion(input.VersionId)
+		if err != nil {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+			return
+		}
+		if deleteMarker {
+			writeDeleteMarkerError(writer, request, *input.VersionId)
+			return
+		}
+		client, err := handler.GCPRequestSetup(request)
+		if client != nil {
+			defer handler.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucketHandle := handler.GCPClientToBucket(handler.BucketRename(*input.Bucket), client)
+		attrs, err := versionHandle(handler.GCPBucketToObject(*input.Key, bucketHandle), generation).Attrs(*handler.Context)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			writeVersionError(writer, request, err, generation)
+			return
+		}
+		bucketAttrs, err := bucketHandle.Attrs(*handler.Context)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		code, message, ok := retentionCovered(bucketAttrs.RetentionPolicy, attrs.RetentionExpirationTime,
+			string(input.Retention.Mode), *input.Retention.RetainUntilDate)
+		if !ok {
+			logging.Log.Error("Error %s %s", request.RequestURI, message)
+			s3_handler.WriteErrorCode(writer, request, code, message)
+			return
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := handler.S3Client.PutObjectRetentionRequest(input)
+		if _, err := req.Send(); err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+// Parse input for get object legal hold requests
+func (handler *Handler) LegalHoldParseInput(r *http.Request) (*s3.GetObjectLegalHoldInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+	return &s3.GetObjectLegalHoldInput{Bucket: &bucket, Key: &key, VersionId: parseVersionId(r)}, nil
+}
+
+// Handle get object legal hold requests.  A legal hold is a GCS temporary hold
+func (handler *Handler) LegalHoldHandle(writer http.ResponseWriter, request *http.Request) {
+	input, _ := handler.LegalHoldParseInput(request)
+	response := &response_type.ObjectLockLegalHold{XmlNS: "http://s3.amazonaws.com/doc/2006-03-01/"}
+	if handler.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		generation, deleteMarker, err := gcsVersion(input.VersionId)
+		if err != nil {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+			return
+		}
+		if deleteMarker {
+			writeDeleteMarkerError(writer, request, *input.VersionId)
+			return
+		}
+		client, err := handler.GCPRequestSetup(request)
+		if client != nil {
+			defer handler.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucketHandle := handler.GCPClientToBucket(handler.BucketRename(*input.Bucket), client)
+		attrs, err := versionHandle(handler.GCPBucketToObject(*input.Key, bucketHandle), generation).Attrs(*handler.Context)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			writeVersionError(writer, request, err, generation)
+			return
+		}
+		response.Status = string(s3.ObjectLockLegalHoldStatusOff)
+		if attrs.TemporaryHold {
+			response.Status = string(s3.ObjectLockLegalHoldStatusOn)
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := handler.S3Client.GetObjectLegalHoldRequest(input)
+		resp, err := req.Send()
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		if resp.LegalHold != nil {
+			response.Status = string(resp.LegalHold.Status)
+		}
+	}
+	output, _ := xml.Marshal(response)
+	writer.Write([]byte(s3_handler.XmlHeader))
+	writer.Write(output)
+}
+
+// Parse input for put object legal hold requests
+func (handler *Handler) PutLegalHoldParseInput(r *http.Request) (*s3.PutObjectLegalHoldInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+	input := &s3.PutObjectLegalHoldInput{Bucket: &bucket, Key: &key, VersionId: parseVersionId(r)}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return input, err
+	}
+	var legalHold response_type.ObjectLockLegalHold
+	if err := xml.Unmarshal(body, &legalHold); err != nil {
+		return input, err
+	}
+	status := s3.ObjectLockLegalHoldStatus(legalHold.Status)
+	if status != s3.ObjectLockLegalHoldStatusOn && status != s3.ObjectLockLegalHoldStatusOff {
+		return input, errors.New("invalid legal hold status " + legalHold.Status)
+	}
+	input.LegalHold = &s3.ObjectLockLegalHold{Status: status}
+	return input, nil
+}
+
+// Handle put object legal hold requests by setting or releasing the GCS temporary hold
+func (handler *Handler) PutLegalHoldHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := handler.PutLegalHoldParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3MalformedXML, "")
+		return
+	}
+	if handler.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		generation, deleteMarker, err := gcsVersion(input.VersionId)
+		if err != nil {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+			return
+		}
+		if deleteMarker {
+			writeDeleteMarkerError(writer, request, *input.VersionId)
+			return
+		}
+		client, err := handler.GCPRequestSetup(request)
+		if client != nil {
+			defer handler.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucketHandle := handler.GCPClientToBucket(handler.BucketRename(*input.Bucket), client)
+		objHandle := versionHandle(handler.GCPBucketToObject(*input.Key, bucketHandle), generation)
+		_, err = objHandle.Update(*handler.Context, storage.ObjectAttrsToUpdate{
+			TemporaryHold: input.LegalHold.Status == s3.ObjectLockLegalHoldStatusOn,
+		})
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			writeVersionError(writer, request, err, generation)
+			return
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := handler.S3Client.PutObjectLegalHoldRequest(input)
+		if _, err := req.Send(); err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,91 @@
+package object
+
+import (
+	"bytes"
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/response_type"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseObjectLockHeaders(t *testing.T) {
+	header := make(http.Header)
+	header.Set(objectLockModeHeader, "GOVERNANCE")
+	header.Set(objectLockRetainHeader, "2030-01-02T03:04:05.000Z")
+	header.Set(objectLockLegalHoldHeader, "ON")
+	mode, until, legalHold, err := parseObjectLockHeaders(header)
+	assert.Nil(t, err)
+	assert.Equal(t, s3.ObjectLockModeGovernance, mode)
+	assert.Equal(t, time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC), *until)
+	assert.Equal(t, s3.ObjectLockLegalHoldStatusOn, legalHold)
+
+	header.Del(objectLockRetainHeader)
+	_, _, _, err = parseObjectLockHeaders(header)
+	assert.NotNil(t, err)
+
+	header = make(http.Header)
+	header.Set(objectLockLegalHoldHeader, "MAYBE")
+	_, _, _, err = parseObjectLockHeaders(header)
+	assert.NotNil(t, err)
+}
+
+func TestRetentionCovered(t *testing.T) {
+	expiration := time.Now().Add(48 * time.Hour)
+	policy := &storage.RetentionPolicy{RetentionPeriod: 48 * time.Hour}
+	_, _, ok := retentionCovered(policy, expiration, "GOVERNANCE", time.Now().Add(24*time.Hour))
+	assert.True(t, ok)
+
+	code, _, ok := retentionCovered(policy, expiration, "GOVERNANCE", time.Now().Add(72*time.Hour))
+	assert.False(t, ok)
+	assert.Equal(t, response_type.S3NotImplemented, code)
+
+	// compliance needs a locked policy
+	_, _, ok = retentionCovered(policy, expiration, "COMPLIANCE", time.Now().Add(24*time.Hour))
+	assert.False(t, ok)
+	policy.IsLocked = true
+	_, _, ok = retentionCovered(policy, expiration, "COMPLIANCE", time.Now().Add(24*time.Hour))
+	assert.True(t, ok)
+
+	code, _, ok = retentionCovered(nil, time.Time{}, "GOVERNANCE", time.Now())
+	assert.False(t, ok)
+	assert.Equal(t, response_type.S3InvalidRequest, code)
+}
+
+func TestHandler_PutRetentionParseInput(t *testing.T) {
+	body := "<Retention><Mode>COMPLIANCE</Mode><RetainUntilDate>2030-01-02T03:04:05Z</RetainUntilDate></Retention>"
+	req, _ := http.NewRequest("PUT", "http://localhost:3450/boops/mykey?retention", ioutil.NopCloser(bytes.NewReader([]byte(body))))
+	req = mux.SetURLVars(req, map[string]string{"bucket": "boops", "key": "mykey"})
+	handler := New(nil)
+	input, err := handler.PutRetentionParseInput(req)
+	assert.Nil(t, err)
+	assert.Equal(t, s3.ObjectLockRetentionModeCompliance, input.Retention.Mode)
+	assert.Equal(t, time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC), *input.Retention.RetainUntilDate)
+
+	body = "<Retention><Mode>FOREVER</Mode><RetainUntilDate>2030-01-02T03:04:05Z</RetainUntilDate></Retention>"
+	req, _ = http.NewRequest("PUT", "http://localhost:3450/boops/mykey?retention", ioutil.NopCloser(bytes.NewReader([]byte(body))))
+	req = mux.SetURLVars(req, map[string]string{"bucket": "boops", "key": "mykey"})
+	_, err = handler.PutRetentionParseInput(req)
+	assert.NotNil(t, err)
+}
+
+func TestHandler_PutLegalHoldParseInput(t *testing.T) {
+	req, _ := http.NewRequest("PUT", "http://localhost:3450/boops/mykey?legal-hold&versionId=123",
+		ioutil.NopCloser(bytes.NewReader([]byte("<LegalHold><Status>ON</Status></LegalHold>"))))
+	req = mux.SetURLVars(req, map[string]string{"bucket": "boops", "key": "mykey"})
+	handler := New(nil)
+	input, err := handler.PutLegalHoldParseInput(req)
+	assert.Nil(t, err)
+	assert.Equal(t, s3.ObjectLockLegalHoldStatusOn, input.LegalHold.Status)
+	assert.Equal(t, "123", *input.VersionId)
+
+	req, _ = http.NewRequest("PUT", "http://localhost:3450/boops/mykey?legal-hold",
+		ioutil.NopCloser(bytes.NewReader([]byte("<LegalHold><Status>on</Status></LegalHold>"))))
+	req = mux.SetURLVars(req, map[string]string{"bucket": "boops", "key": "mykey"})
+	_, err = handler.PutLegalHoldParseInput(req)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,164 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/response_type"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Metadata on combined multipart objects listing the size of every part in order
+const partSizesMetadataKey = "cloudsidecar-part-sizes"
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// Bytes of an object to send
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+// Value for the Content-Range header
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// Parse a Range header as described in RFC 7233.  A nil range without an error means the header should be
+// ignored and the whole object sent, which is what S3 does for malformed headers and multiple ranges
+func parseRange(header string, size int64) (*byteRange, error) {
+	equalSplit := strings.SplitN(strings.TrimSpace(header), "=", 2)
+	if len(equalSplit) != 2 || strings.ToLower(strings.TrimSpace(equalSplit[0])) != "bytes" {
+		return nil, nil
+	}
+	specs := strings.Split(equalSplit[1], ",")
+	if len(specs) != 1 {
+		return nil, nil
+	}
+	byteSplit := strings.SplitN(strings.TrimSpace(specs[0]), "-", 2)
+	if len(byteSplit) != 2 {
+		return nil, nil
+	}
+	startString := strings.TrimSpace(byteSplit[0])
+	endString := strings.TrimSpace(byteSplit[1])
+	if startString == "" {
+		// suffix range, the last n bytes
+		suffix, err := strconv.ParseInt(endString, 10, 64)
+		if err != nil || suffix < 0 {
+			return nil, nil
+		}
+		if suffix == 0 || size == 0 {
+			return nil, errRangeNotSatisfiable
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return &byteRange{start: size - suffix, length: suffix}, nil
+	}
+	start, err := strconv.ParseInt(startString, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+	end := size - 1
+	if endString != "" {
+		end, err = strconv.ParseInt(endString, 10, 64)
+		if err != nil || end < start {
+			return nil, nil
+		}
+	}
+	if start >= size {
+		return nil, errRangeNotSatisfiable
+	}
+	if end >= size {
+		end = size - 1
+	}
+	return &byteRange{start: start, length: end - start + 1}, nil
+}
+
+// Find the bytes of one part of a combined multipart object and the number of parts it has.  Objects that
+// were not uploaded in parts only have part 1, which is the whole object
+func partRange(attrs *storage.ObjectAttrs, partNumber int64) (*byteRange, int64, error) {
+	sizes := attrs.Metadata[partSizesMetadataKey]
+	if sizes == "" {
+		if partNumber == 1 {
+			return nil, 0, nil
+		}
+		return nil, 0, errRangeNotSatisfiable
+	}
+	parts := strings.Split(sizes, ",")
+	if partNumber < 1 || partNumber > int64(len(parts)) {
+		return nil, int64(len(parts)), errRangeNotSatisfiable
+	}
+	start := int64(0)
+	for i, part := range parts {
+		partSize, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, 0, err
+		}
+		if int64(i)+1 == partNumber {
+			return &byteRange{start: start, length: partSize}, int64(len(parts)), nil
+		}
+		start += partSize
+	}
+	return nil, int64(len(parts)), errRangeNotSatisfiable
+}
+
+// Work out what part of a GCS object a request wants from its Range header or partNumber.  Writes the error
+// response and returns false if the request can't be satisfied
+func resolveRange(writer http.ResponseWriter, request *http.Request, attrs *storage.ObjectAttrs, rangeHeader *string, partNumber *int64) (*byteRange, int64, bool) {
+	if partNumber != nil {
+		if rangeHeader != nil {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidRequest, "Cannot specify both Range header and partNumber query parameter")
+			return nil, 0, false
+		}
+		readRange, partsCount, err := partRange(attrs, *partNumber)
+		if err == errRangeNotSatisfiable {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidPartNumber, "")
+			return nil, 0, false
+		} else if err != nil {
+			s3_handler.WriteError(writer, request, err)
+			return nil, 0, false
+		}
+		return readRange, partsCount, true
+	}
+	if rangeHeader == nil {
+		return nil, 0, true
+	}
+	readRange, err := parseRange(*rangeHeader, attrs.Size)
+	if err != nil {
+		writer.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", attrs.Size))
+		s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidRange, "")
+		return nil, 0, false
+	}
+	return readRange, 0, true
+}
+
+// Set headers describing what part of the object is being sent.  Returns the status to respond with
+func setRangeHeaders(writer http.ResponseWriter, attrs *storage.ObjectAttrs, readRange *byteRange, partsCount int64) int {
+	writer.Header().Set("Accept-Ranges", "bytes")
+	if partsCount > 0 {
+		writer.Header().Set("x-amz-mp-parts-count", strconv.FormatInt(partsCount, 10))
+	}
+	if readRange == nil {
+		return http.StatusOK
+	}
+	writer.Header().Set("Content-Length", strconv.FormatInt(readRange.length, 10))
+	writer.Header().Set("Content-Range", readRange.contentRange(attrs.Size))
+	return http.StatusPartialContent
+}
+
+// Read the partNumber query parameter of a GET or HEAD
+func parsePartNumber(r *http.Request) (*int64, error) {
+	value := r.URL.Query().Get("partNumber")
+	if value == "" {
+		return nil, nil
+	}
+	partNumber, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		return nil, errors.New("Part number must be an integer between 1 and 10000, inclusive")
+	}
+	return &partNumber, nil
+}
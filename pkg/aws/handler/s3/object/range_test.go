@@ -0,0 +1,123 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/mock"
+	"context"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	r, err := parseRange("bytes=0-99", 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, &byteRange{start: 0, length: 100}, r)
+	assert.Equal(t, "bytes 0-99/1000", r.contentRange(1000))
+
+	r, err = parseRange("bytes=900-", 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, &byteRange{start: 900, length: 100}, r)
+
+	r, err = parseRange("bytes=-500", 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, &byteRange{start: 500, length: 500}, r)
+
+	r, err = parseRange("bytes=-5000", 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, &byteRange{start: 0, length: 1000}, r)
+
+	r, err = parseRange("bytes=990-2000", 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, &byteRange{start: 990, length: 10}, r)
+
+	_, err = parseRange("bytes=1000-", 1000)
+	assert.Equal(t, errRangeNotSatisfiable, err)
+	_, err = parseRange("bytes=-0", 1000)
+	assert.Equal(t, errRangeNotSatisfiable, err)
+	_, err = parseRange("bytes=0-0", 0)
+	assert.Equal(t, errRangeNotSatisfiable, err)
+
+	// ignored headers serve the whole object
+	for _, header := range []string{"bytes=0-9,20-29", "items=0-9", "bytes=9-0", "bytes=a-b", "bytes=5"} {
+		r, err = parseRange(header, 1000)
+		assert.Nil(t, err, header)
+		assert.Nil(t, r, header)
+	}
+}
+
+func TestPartRange(t *testing.T) {
+	attrs := &storage.ObjectAttrs{
+		Size:     25,
+		Metadata: map[string]string{partSizesMetadataKey: "10,10,5"},
+	}
+	r, count, err := partRange(attrs, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), count)
+	assert.Equal(t, &byteRange{start: 10, length: 10}, r)
+	r, _, err = partRange(attrs, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, &byteRange{start: 20, length: 5}, r)
+	_, _, err = partRange(attrs, 4)
+	assert.Equal(t, errRangeNotSatisfiable, err)
+
+	single := &storage.ObjectAttrs{Size: 25}
+	r, count, err = partRange(single, 1)
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+	assert.Equal(t, int64(0), count)
+	_, _, err = partRange(single, 2)
+	assert.Equal(t, errRangeNotSatisfiable, err)
+}
+
+func TestPartSizes(t *testing.T) {
+	sizes := partSizes("abc,key-part-1,10\nold,key-part-2\ndef,key,with,commas-part-3,5\nfed,key-part-1,12\n")
+	assert.Equal(t, map[string]string{
+		"key-part-1":             "12",
+		"key,with,commas-part-3": "5",
+	}, sizes)
+}
+
+func TestHandler_GetHandle_RangeNotSatisfiable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	bucketMock := s3_handler.NewMockGCPBucket(ctrl)
+	clientMock := s3_handler.NewMockGCPClient(ctrl)
+	objectMock := s3_handler.NewMockGCPObject(ctrl)
+	writerMock := mock.NewMockResponseWriter(ctrl)
+	ctx := context.Background()
+	s3Handler := &s3_handler.Handler{
+		GCPClient: func() (s3_handler.GCPClient, error) {
+			return clientMock, nil
+		},
+		GCPClientPool: make(map[string][]s3_handler.GCPClient),
+		GCPClientToBucket: func(bucket string, client s3_handler.GCPClient) s3_handler.GCPBucket {
+			return bucketMock
+		},
+		GCPBucketToObject: func(name string, bucket s3_handler.GCPBucket) s3_handler.GCPObject {
+			return objectMock
+		},
+		Context: &ctx,
+		Config:  getConfig(),
+	}
+	testUrl, _ := url.ParseRequestURI("http://localhost:3450/boops/mykey")
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    testUrl,
+		Header: make(http.Header),
+	}
+	req.Header.Set("Range", "bytes=500-")
+	req = mux.SetURLVars(req, map[string]string{"bucket": "boops", "key": "mykey"})
+	outputMap := make(http.Header)
+	objectMock.EXPECT().Attrs(ctx).Return(&storage.ObjectAttrs{Size: 123}, nil)
+	writerMock.EXPECT().Header().Return(outputMap).AnyTimes()
+	writerMock.EXPECT().WriteHeader(416)
+	writerMock.EXPECT().Write(gomock.Any()).Times(2)
+	handler := New(s3Handler)
+	handler.GetHandle(writerMock, req)
+	assert.Equal(t, "bytes */123", outputMap.Get("Content-Range"))
+}
@@ -0,0 +1,192 @@
+package object
+
+import (
+	"bytes"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/logging"
+	"cloudsidecar/pkg/response_type"
+	"cloudsidecar/pkg/s3select"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/private/protocol/rest"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Parse input for select object content request.  The raw request body is returned too so it can be sent on to S3
+func (handler *Handler) SelectParseInput(r *http.Request) (*response_type.SelectObjectContentRequest, []byte, error) {
+	vars := mux.Vars(r)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	input := &response_type.SelectObjectContentRequest{}
+	if err := xml.Unmarshal(body, input); err != nil {
+		return nil, nil, err
+	}
+	input.Bucket = vars["bucket"]
+	input.Key = vars["key"]
+	return input, body, nil
+}
+
+// Handle select object content request.  For GCS the object is streamed through the query here.  The AWS client
+// has no select call, so for S3 the request is signed with the client's credentials and sent on, and the event
+// stream S3 answers with is relayed as it arrives
+func (handler *Handler) SelectHandle(writer http.ResponseWriter, request *http.Request) {
+	input, rawBody, err := handler.SelectParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3MalformedXML, "")
+		return
+	}
+	sse, err := parseEncryption(request.Header, "x-amz-")
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+		return
+	}
+	if !handler.Config.IsSet("gcp_destination_config") {
+		logging.LogUsingAWS()
+		handler.awsSelect(writer, request, input, rawBody, sse)
+		return
+	}
+	// Use GCS
+	selection, err := s3select.New(input)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		if selectErr, ok := err.(*s3select.Error); ok {
+			s3_handler.WriteErrorCode(writer, request, selectErr.Code, selectErr.Message)
+		} else {
+			s3_handler.WriteError(writer, request, err)
+		}
+		return
+	}
+	client, err := handler.GCPRequestSetup(request)
+	if client != nil {
+		defer handler.ReturnConnection(client, request)
+	}
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteError(writer, request, err)
+		return
+	}
+	bucketHandle := handler.GCPClientToBucket(handler.BucketRename(input.Bucket), client)
+	objHandle := handler.GCPBucketToObject(input.Key, bucketHandle)
+	if sse.isCustomerKey() {
+		objHandle = objHandle.Key(sse.CustomerKey)
+	}
+	attrs, err := objHandle.Attrs(*handler.Context)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteError(writer, request, err)
+		return
+	}
+	if code, message, ok := checkCustomerKey(attrs, sse); !ok {
+		logging.Log.Error("Error %s %s", request.RequestURI, code.Code)
+		s3_handler.WriteErrorCode(writer, request, code, message)
+		return
+	}
+	body, err := objHandle.NewReader(*handler.Context)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteError(writer, request, err)
+		return
+	}
+	defer body.Close()
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.WriteHeader(http.StatusOK)
+	if err := selection.Run(body, writer); err != nil {
+		logging.Log.Error("Error writing select results %s %s", request.RequestURI, err)
+	}
+}
+
+// Send a select request to S3 signed with the AWS client's credentials and relay the response.  Errors come back
+// from S3 in its own format, so they are relayed the same way
+func (handler *Handler) awsSelect(writer http.ResponseWriter, request *http.Request, input *response_type.SelectObjectContentRequest, rawBody []byte, sse *encryption) {
+	client, ok := handler.S3Client.(*s3.S3)
+	if !ok {
+		s3_handler.WriteErrorCode(writer, request, response_type.S3NotImplemented, "Select needs an S3 client")
+		return
+	}
+	endpoint, err := client.EndpointResolver.ResolveEndpoint(s3.EndpointsID, client.Region)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteError(writer, request, err)
+		return
+	}
+	path := rest.EscapePath(fmt.Sprintf("/%s/%s", input.Bucket, input.Key), false)
+	selectURL := strings.TrimSuffix(endpoint.URL, "/") + path + "?select&select-type=2"
+	selectRequest, err := http.NewRequest("POST", selectURL, bytes.NewReader(rawBody))
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteError(writer, request, err)
+		return
+	}
+	selectRequest = selectRequest.WithContext(request.Context())
+	if algorithm, key, keyMD5 := sse.customerKeyFields(); key != nil {
+		selectRequest.Header.Set("x-amz-server-side-encryption-customer-algorithm", *algorithm)
+		selectRequest.Header.Set("x-amz-server-side-encryption-customer-key", base64.StdEncoding.EncodeToString([]byte(*key)))
+		selectRequest.Header.Set("x-amz-server-side-encryption-customer-key-MD5", *keyMD5)
+	}
+	region := endpoint.SigningRegion
+	if region == "" {
+		region = client.Region
+	}
+	signer := v4.NewSigner(client.Credentials, func(signer *v4.Signer) {
+		// the path is escaped above the way S3 expects
+		signer.DisableURIPathEscaping = true
+	})
+	if _, err := signer.Sign(selectRequest, bytes.NewReader(rawBody), s3.ServiceName, region, time.Now()); err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteError(writer, request, err)
+		return
+	}
+	httpClient := client.Config.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(selectRequest)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteError(writer, request, err)
+		return
+	}
+	defer resp.Body.Close()
+	for name, values := range resp.Header {
+		if name == "Content-Length" {
+			continue
+		}
+		for _, value := range values {
+			writer.Header().Add(name, value)
+		}
+	}
+	writer.WriteHeader(resp.StatusCode)
+	flusher, _ := writer.(http.Flusher)
+	buffer := make([]byte, 32*1024)
+	for {
+		n, readErr := resp.Body.Read(buffer)
+		if n > 0 {
+			if _, err := writer.Write(buffer[:n]); err != nil {
+				logging.Log.Error("Error writing select results %s %s", request.RequestURI, err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if readErr == io.EOF {
+			return
+		}
+		if readErr != nil {
+			logging.Log.Error("Error reading select results %s %s", request.RequestURI, readErr)
+			return
+		}
+	}
+}
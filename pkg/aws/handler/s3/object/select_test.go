@@ -0,0 +1,93 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"context"
+	"encoding/base64"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const selectBody = `<SelectObjectContentRequest><Expression>SELECT * FROM S3Object</Expression><ExpressionType>SQL</ExpressionType>` +
+	`<InputSerialization><Parquet/></InputSerialization><OutputSerialization><JSON/></OutputSerialization></SelectObjectContentRequest>`
+
+func TestHandler_SelectHandleAWS(t *testing.T) {
+	events := []byte("\x00\x00\x00\x10event stream")
+	key := []byte("0123456789abcdef0123456789abcdef")
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "POST", request.Method)
+		assert.Equal(t, "/boops/dir/data%2B1.parquet", request.URL.EscapedPath())
+		assert.Equal(t, "2", request.URL.Query().Get("select-type"))
+		assert.True(t, strings.HasPrefix(request.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=MY_KEY/"))
+		assert.NotEmpty(t, request.Header.Get("X-Amz-Content-Sha256"))
+		assert.Equal(t, base64.StdEncoding.EncodeToString(key), request.Header.Get("x-amz-server-side-encryption-customer-key"))
+		body, _ := ioutil.ReadAll(request.Body)
+		// parquet isn't run here, S3 gets the request as sent
+		assert.Equal(t, selectBody, string(body))
+		writer.Header().Set("Content-Type", "application/octet-stream")
+		writer.Header().Set("x-amz-request-id", "meow")
+		writer.Write(events)
+	}))
+	defer server.Close()
+	handler := New(&s3_handler.Handler{
+		Config: viper.New(),
+		S3Client: s3.New(aws.Config{
+			Region:           "us-east-1",
+			Credentials:      aws.NewStaticCredentialsProvider("MY_KEY", "SUPER_SECRET", ""),
+			EndpointResolver: aws.ResolveWithEndpointURL(server.URL),
+			HTTPClient:       server.Client(),
+		}),
+	})
+	router := mux.NewRouter()
+	handler.Register(router)
+	request := httptest.NewRequest("POST", "http://localhost:3450/boops/dir/data+1.parquet?select&select-type=2", strings.NewReader(selectBody))
+	request.Header = customerKeyHeader(key)
+	writer := httptest.NewRecorder()
+	router.ServeHTTP(writer, request)
+	assert.Equal(t, http.StatusOK, writer.Code)
+	assert.Equal(t, "meow", writer.Header().Get("x-amz-request-id"))
+	assert.Equal(t, events, writer.Body.Bytes())
+}
+
+func TestHandler_SelectHandleGCSNoSuchKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	clientMock := s3_handler.NewMockGCPClient(ctrl)
+	bucketMock := s3_handler.NewMockGCPBucket(ctrl)
+	objectMock := s3_handler.NewMockGCPObject(ctrl)
+	ctx := context.Background()
+	handler := New(&s3_handler.Handler{
+		GCPClient: func() (s3_handler.GCPClient, error) {
+			return clientMock, nil
+		},
+		GCPClientPool: make(map[string][]s3_handler.GCPClient),
+		GCPClientToBucket: func(bucket string, client s3_handler.GCPClient) s3_handler.GCPBucket {
+			return bucketMock
+		},
+		GCPBucketToObject: func(name string, bucket s3_handler.GCPBucket) s3_handler.GCPObject {
+			assert.Equal(t, "data.csv", name)
+			return objectMock
+		},
+		Context: &ctx,
+		Config:  getConfig(),
+	})
+	objectMock.EXPECT().Attrs(gomock.Any()).Return(nil, storage.ErrObjectNotExist)
+	router := mux.NewRouter()
+	handler.Register(router)
+	body := strings.Replace(selectBody, "<Parquet/>", "<CSV/>", 1)
+	request := httptest.NewRequest("POST", "http://localhost:3450/boops/data.csv?select&select-type=2", strings.NewReader(body))
+	writer := httptest.NewRecorder()
+	router.ServeHTTP(writer, request)
+	assert.Equal(t, http.StatusNotFound, writer.Code)
+	assert.Contains(t, writer.Body.String(), "NoSuchKey")
+}
@@ -0,0 +1,190 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"cloudsidecar/pkg/response_type"
+	"encoding/xml"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+var restoreModeKey = "gcp_destination_config.gcs_config.restore_mode"
+
+const (
+	storageClassHeader = "x-amz-storage-class"
+	// Archive objects are readable as is, so restores do nothing
+	restoreModeNoop = "noop"
+	// Restores rewrite the object as standard storage
+	restoreModeCopy = "copy"
+	// Multipart upload file line holding the storage class for the combined object
+	storageClassLinePrefix = "storage-class="
+)
+
+// GCS storage class for the storage class of a write, empty to use the bucket default.  Writes the error and
+// returns false for classes GCS has nothing like
+func (handler *Handler) gcsStorageClass(writer http.ResponseWriter, request *http.Request, storageClass string) (string, bool) {
+	if storageClass == "" {
+		return "", true
+	}
+	gcsClass, ok := handler.StorageClasses().ToGCS(storageClass)
+	if !ok {
+		logging.Log.Error("Error %s unsupported storage class %s", request.RequestURI, storageClass)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidStorageClass, "")
+	}
+	return gcsClass, ok
+}
+
+// Storage class header for a GCS object.  Like S3 nothing is sent for standard storage, and archive objects
+// show as already restored when restores do nothing
+func (handler *Handler) writeStorageClassHeaders(writer http.ResponseWriter, attrs *storage.ObjectAttrs) {
+	if attrs.StorageClass == "" {
+		return
+	}
+	storageClass := handler.StorageClasses().ToS3(attrs.StorageClass)
+	if storageClass == "STANDARD" {
+		return
+	}
+	writer.Header().Set(storageClassHeader, storageClass)
+	if converter.IsArchiveStorageClass(storageClass) && handler.restoreMode() == restoreModeNoop {
+		writer.Header().Set("x-amz-restore", "ongoing-request=\"false\"")
+	}
+}
+
+func (handler *Handler) restoreMode() string {
+	if mode := handler.Config.GetString(restoreModeKey); mode != "" {
+		return strings.ToLower(mode)
+	}
+	return restoreModeNoop
+}
+
+// Storage class recorded in a multipart upload file, empty for the bucket default
+func uploadStorageClass(partsFile string) string {
+	for _, line := range strings.Split(partsFile, "\n") {
+		if strings.HasPrefix(line, storageClassLinePrefix) {
+			return strings.TrimPrefix(line, storageClassLinePrefix)
+		}
+	}
+	return ""
+}
+
+// A rewrite with any attributes set takes all of them from the request, so carry the source's over
+func copyObjectAttrs(copier *storage.Copier, attrs *storage.ObjectAttrs) {
+	copier.ContentType = attrs.ContentType
+	copier.ContentLanguage = attrs.ContentLanguage
+	copier.ContentEncoding = attrs.ContentEncoding
+	copier.ContentDisposition = attrs.ContentDisposition
+	copier.CacheControl = attrs.CacheControl
+	copier.Metadata = attrs.Metadata
+}
+
+// Parse input for restore object request
+func (handler *Handler) RestoreParseInput(r *http.Request) (*s3.RestoreObjectInput, error) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+	input := &s3.RestoreObjectInput{Bucket: &bucket, Key: &key, VersionId: parseVersionId(r)}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return input, err
+	}
+	if len(body) > 0 {
+		var restoreRequest response_type.RestoreRequest
+		if err := xml.Unmarshal(body, &restoreRequest); err != nil {
+			return input, err
+		}
+		input.RestoreRequest = &s3.RestoreRequest{Days: restoreRequest.Days}
+		if restoreRequest.GlacierJobParameters != nil {
+			input.RestoreRequest.GlacierJobParameters = &s3.GlacierJobParameters{
+				Tier: s3.Tier(restoreRequest.GlacierJobParameters.Tier),
+			}
+		}
+	}
+	return input, nil
+}
+
+// Handle restore object request.  GCS archive classes can be read without a restore, so by default this only
+// checks the object is in an archive class.  With restore_mode copy the object is rewritten as standard storage,
+// which finishes before responding
+func (handler *Handler) RestoreHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := handler.RestoreParseInput(request)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		s3_handler.WriteErrorCode(writer, request, response_type.S3MalformedXML, "")
+		return
+	}
+	if handler.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		generation, deleteMarker, err := gcsVersion(input.VersionId)
+		if err != nil {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidArgument, err.Error())
+			return
+		}
+		if deleteMarker {
+			writeDeleteMarkerError(writer, request, *input.VersionId)
+			return
+		}
+		client, err := handler.GCPRequestSetup(request)
+		if client != nil {
+			defer handler.ReturnConnection(client, request)
+		}
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		bucketHandle := handler.GCPClientToBucket(handler.BucketRename(*input.Bucket), client)
+		objHandle := versionHandle(handler.GCPBucketToObject(*input.Key, bucketHandle), generation)
+		attrs, err := objHandle.Attrs(*handler.Context)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			writeVersionError(writer, request, err, generation)
+			return
+		}
+		if !converter.IsArchiveStorageClass(handler.StorageClasses().ToS3(attrs.StorageClass)) {
+			s3_handler.WriteErrorCode(writer, request, response_type.S3InvalidObjectState, "Restore is not allowed for the object's current storage class")
+			return
+		}
+		if handler.restoreMode() == restoreModeCopy {
+			standard, _ := handler.StorageClasses().ToGCS("STANDARD")
+			copier := handler.GCPBucketToObject(*input.Key, bucketHandle).CopierFrom(objHandle.If(storage.Conditions{GenerationMatch: attrs.Generation}))
+			copyObjectAttrs(copier, attrs)
+			copier.StorageClass = standard
+			restored, err := copier.Run(*handler.Context)
+			if err != nil {
+				logging.Log.Error("Error %s %s", request.RequestURI, err)
+				s3_handler.WriteError(writer, request, err)
+				return
+			}
+			writeVersionHeader(writer, restored)
+		}
+	} else {
+		logging.LogUsingAWS()
+		req := handler.S3Client.RestoreObjectRequest(input)
+		var status int
+		req.ApplyOptions(func(r *aws.Request) {
+			r.Handlers.Complete.PushBack(func(r *aws.Request) {
+				if r.HTTPResponse != nil {
+					status = r.HTTPResponse.StatusCode
+				}
+			})
+		})
+		if _, err := req.Send(); err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			s3_handler.WriteError(writer, request, err)
+			return
+		}
+		if status == http.StatusAccepted {
+			// the restore was started and hasn't finished
+			writer.WriteHeader(http.StatusAccepted)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusOK)
+}
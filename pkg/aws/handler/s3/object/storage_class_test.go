@@ -0,0 +1,86 @@
+package object
+
+import (
+	"bytes"
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/response_type"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler_gcsStorageClass(t *testing.T) {
+	config := getConfig()
+	config.Set("gcp_destination_config.gcs_config.storage_class_map", map[string]string{"glacier": "archive"})
+	handler := New(&s3_handler.Handler{Config: config})
+	req, _ := http.NewRequest("PUT", "http://localhost:3450/boops/mykey", nil)
+
+	recorder := httptest.NewRecorder()
+	storageClass, ok := handler.gcsStorageClass(recorder, req, "STANDARD_IA")
+	assert.True(t, ok)
+	assert.Equal(t, "NEARLINE", storageClass)
+	storageClass, ok = handler.gcsStorageClass(recorder, req, "GLACIER")
+	assert.True(t, ok)
+	assert.Equal(t, "ARCHIVE", storageClass)
+	storageClass, ok = handler.gcsStorageClass(recorder, req, "")
+	assert.True(t, ok)
+	assert.Equal(t, "", storageClass)
+
+	_, ok = handler.gcsStorageClass(recorder, req, "OUTPOSTS")
+	assert.False(t, ok)
+	assert.Equal(t, response_type.S3InvalidStorageClass.StatusCode, recorder.Code)
+}
+
+func TestHandler_writeStorageClassHeaders(t *testing.T) {
+	config := getConfig()
+	handler := New(&s3_handler.Handler{Config: config})
+
+	recorder := httptest.NewRecorder()
+	handler.writeStorageClassHeaders(recorder, &storage.ObjectAttrs{StorageClass: "STANDARD"})
+	assert.Equal(t, "", recorder.Header().Get(storageClassHeader))
+
+	recorder = httptest.NewRecorder()
+	handler.writeStorageClassHeaders(recorder, &storage.ObjectAttrs{StorageClass: "NEARLINE"})
+	assert.Equal(t, "STANDARD_IA", recorder.Header().Get(storageClassHeader))
+	assert.Equal(t, "", recorder.Header().Get("x-amz-restore"))
+
+	recorder = httptest.NewRecorder()
+	handler.writeStorageClassHeaders(recorder, &storage.ObjectAttrs{StorageClass: "ARCHIVE"})
+	assert.Equal(t, "DEEP_ARCHIVE", recorder.Header().Get(storageClassHeader))
+	assert.Equal(t, "ongoing-request=\"false\"", recorder.Header().Get("x-amz-restore"))
+
+	// copied objects are only readable once restored
+	config.Set(restoreModeKey, "copy")
+	recorder = httptest.NewRecorder()
+	handler.writeStorageClassHeaders(recorder, &storage.ObjectAttrs{StorageClass: "ARCHIVE"})
+	assert.Equal(t, "", recorder.Header().Get("x-amz-restore"))
+}
+
+func TestUploadStorageClass(t *testing.T) {
+	partsFile := "storage-class=NEARLINE\n\"abc\",key/1,5\n\"def\",key/2,6\n"
+	assert.Equal(t, "NEARLINE", uploadStorageClass(partsFile))
+	assert.Equal(t, map[string]string{"key/1": "5", "key/2": "6"}, partSizes(partsFile))
+	assert.Equal(t, "", uploadStorageClass("\"abc\",key/1,5\n"))
+}
+
+func TestHandler_RestoreParseInput(t *testing.T) {
+	body := "<RestoreRequest><Days>2</Days><GlacierJobParameters><Tier>Expedited</Tier></GlacierJobParameters></RestoreRequest>"
+	req, _ := http.NewRequest("POST", "http://localhost:3450/boops/mykey?restore", ioutil.NopCloser(bytes.NewReader([]byte(body))))
+	req = mux.SetURLVars(req, map[string]string{"bucket": "boops", "key": "mykey"})
+	handler := New(nil)
+	input, err := handler.RestoreParseInput(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "boops", *input.Bucket)
+	assert.Equal(t, int64(2), *input.RestoreRequest.Days)
+	assert.Equal(t, s3.TierExpedited, input.RestoreRequest.GlacierJobParameters.Tier)
+
+	req, _ = http.NewRequest("POST", "http://localhost:3450/boops/mykey?restore", ioutil.NopCloser(bytes.NewReader([]byte("<Restore"))))
+	req = mux.SetURLVars(req, map[string]string{"bucket": "boops", "key": "mykey"})
+	_, err = handler.RestoreParseInput(req)
+	assert.NotNil(t, err)
+}
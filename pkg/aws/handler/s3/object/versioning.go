@@ -0,0 +1,137 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/response_type"
+	"google.golang.org/api/googleapi"
+	"net/http"
+	"strings"
+)
+
+const (
+	versionIdHeader    = "x-amz-version-id"
+	deleteMarkerHeader = "x-amz-delete-marker"
+)
+
+// Version id from the versionId query parameter, nil when it is missing
+func parseVersionId(r *http.Request) *string {
+	if versionId := r.URL.Query().Get("versionId"); versionId != "" {
+		return &versionId
+	}
+	return nil
+}
+
+// Split a copy source into bucket, key and an optional version id
+func parseCopySource(source string) (bucket string, key string, versionId *string) {
+	source = strings.TrimPrefix(source, "/")
+	if index := strings.Index(source, "?versionId="); index >= 0 {
+		id := source[index+len("?versionId="):]
+		versionId = &id
+		source = source[:index]
+	}
+	pieces := strings.SplitN(source, "/", 2)
+	if len(pieces) < 2 {
+		return pieces[0], "", versionId
+	}
+	return pieces[0], pieces[1], versionId
+}
+
+// Generation a version id points at.  Version ids are GCS generations, and delete markers carry the generation
+// they hide.  No version id or null means the live object
+func gcsVersion(versionId *string) (generation int64, deleteMarker bool, err error) {
+	if versionId == nil {
+		return 0, false, nil
+	}
+	return converter.VersionIdToGeneration(*versionId)
+}
+
+// Handle for one generation of an object, or the live object for generation zero
+func versionHandle(object s3_handler.GCPObject, generation int64) s3_handler.GCPObject {
+	if generation == 0 {
+		return object
+	}
+	return object.Generation(generation)
+}
+
+// S3 refuses to read a delete marker
+func writeDeleteMarkerError(writer http.ResponseWriter, request *http.Request, versionId string) {
+	writer.Header().Set(deleteMarkerHeader, "true")
+	writer.Header().Set(versionIdHeader, versionId)
+	s3_handler.WriteErrorCode(writer, request, response_type.S3MethodNotAllowed, "")
+}
+
+// Write a GCS error, reporting a missing generation as a missing version
+func writeVersionError(writer http.ResponseWriter, request *http.Request, err error, generation int64) {
+	if generation != 0 && err == storage.ErrObjectNotExist {
+		s3_handler.WriteErrorCode(writer, request, response_type.S3NoSuchVersion, "")
+		return
+	}
+	s3_handler.WriteError(writer, request, err)
+}
+
+// Report the generation of a GCS object as its version id
+func writeVersionHeader(writer http.ResponseWriter, attrs *storage.ObjectAttrs) {
+	if attrs != nil && attrs.Generation != 0 {
+		writer.Header().Set(versionIdHeader, converter.GenerationToVersionId(attrs.Generation))
+	}
+}
+
+// Delete an object or one of its versions from GCS and describe what happened the way S3 does.
+//   - No version id deletes the live object.  When bucket versioning keeps the generation around it shows up
+//     as a delete marker
+//   - A version id permanently deletes that generation
+//   - A delete marker id removes the marker by copying the generation it hides back to live
+func (handler *Handler) deleteGCSVersion(bucketHandle s3_handler.GCPBucket, key string, versionId *string) (*response_type.DeleteObject, error) {
+	generation, deleteMarker, err := gcsVersion(versionId)
+	if err != nil {
+		return nil, err
+	}
+	result := &response_type.DeleteObject{Key: &key, VersionId: versionId}
+	objectHandle := handler.GCPBucketToObject(key, bucketHandle)
+	if deleteMarker {
+		restore := objectHandle.If(storage.Conditions{DoesNotExist: true})
+		_, err := restore.CopierFrom(objectHandle.Generation(generation)).Run(*handler.Context)
+		// a live object or a missing generation both mean there is no such marker, which S3 treats as deleted
+		if err != nil && err != storage.ErrObjectNotExist && !isPreconditionFailed(err) {
+			return nil, err
+		}
+		isMarker := true
+		result.DeleteMarker = &isMarker
+		result.DeleteMarkerVersionId = versionId
+		return result, nil
+	}
+	if generation != 0 {
+		err := objectHandle.Generation(generation).Delete(*handler.Context)
+		if err != nil && err != storage.ErrObjectNotExist {
+			return nil, err
+		}
+		return result, nil
+	}
+	attrs, err := objectHandle.Attrs(*handler.Context)
+	if err == storage.ErrObjectNotExist {
+		// aws treats deleting a missing key as a success
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = objectHandle.If(storage.Conditions{GenerationMatch: attrs.Generation}).Delete(*handler.Context)
+	if err != nil && err != storage.ErrObjectNotExist {
+		return nil, err
+	}
+	// with versioning on the generation is kept as a noncurrent version
+	if _, err := objectHandle.Generation(attrs.Generation).Attrs(*handler.Context); err == nil {
+		isMarker := true
+		markerId := converter.GenerationToDeleteMarkerId(attrs.Generation)
+		result.DeleteMarker = &isMarker
+		result.DeleteMarkerVersionId = &markerId
+	}
+	return result, nil
+}
+
+// Whether GCS rejected a request because of its preconditions
+func isPreconditionFailed(err error) bool {
+	gcsErr, ok := err.(*googleapi.Error)
+	return ok && gcsErr.Code == http.StatusPreconditionFailed
+}
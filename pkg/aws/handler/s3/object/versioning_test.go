@@ -0,0 +1,32 @@
+package object
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVersioning_parseCopySource(t *testing.T) {
+	bucket, key, versionId := parseCopySource("/bucket/a/b.txt?versionId=123")
+	assert.Equal(t, "bucket", bucket)
+	assert.Equal(t, "a/b.txt", key)
+	assert.Equal(t, "123", *versionId)
+	bucket, key, versionId = parseCopySource("bucket/a")
+	assert.Equal(t, "bucket", bucket)
+	assert.Equal(t, "a", key)
+	assert.Nil(t, versionId)
+}
+
+func TestVersioning_gcsVersion(t *testing.T) {
+	generation, deleteMarker, err := gcsVersion(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), generation)
+	assert.False(t, deleteMarker)
+	id := "dm-42"
+	generation, deleteMarker, err = gcsVersion(&id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), generation)
+	assert.True(t, deleteMarker)
+	id = "bogus"
+	_, _, err = gcsVersion(&id)
+	assert.NotNil(t, err)
+}
@@ -27,16 +27,20 @@ type AWSConfig struct {
 	ServiceType          string                `mapstructure:"service_type"`
 	Port                 int                   `mapstructure:"port"`
 	UrlPrefix            string                `mapstructure:"url_prefix"`
+	VirtualHostDomains   []string              `mapstructure:"virtual_host_domains"`
+	InboundCredentials   map[string]string     `mapstructure:"inbound_credentials"`
 	Middleware           []string              `mapstructure:"middleware"`
 	DestinationAWSConfig *AWSDestinationConfig `mapstructure:"aws_destination_config"`
 	DestinationGCPConfig *GCPDestinationConfig `mapstructure:"gcp_destination_config"`
 }
 
 type AWSDestinationConfig struct {
-	Name            string     `mapstructure:"name"`
-	AccessKeyId     string     `mapstructure:"access_key_id"`
-	SecretAccessKey string     `mapstructure:"secret_access_key"`
-	S3Config        *GCSConfig `mapstructure:"s3_config"`
+	Name            string          `mapstructure:"name"`
+	AccessKeyId     string          `mapstructure:"access_key_id"`
+	SecretAccessKey string          `mapstructure:"secret_access_key"`
+	S3Config        *GCSConfig      `mapstructure:"s3_config"`
+	PubSubConfig    *PubSubConfig   `mapstructure:"pubsub_config"`
+	BigtableConfig  *BigtableConfig `mapstructure:"bigtable_config"`
 }
 
 type GCPDestinationConfig struct {
@@ -54,6 +58,40 @@ type GCSConfig struct {
 	BucketRename         map[string]string `mapstructure:"bucket_rename"`
 	MultipartDBDirectory string            `mapstructure:"multipart_db_directory"`
 	MultipartPathPrefix  string            `mapstructure:"multipart_temp_path_prefix"`
+	ComposeConcurrency   int               `mapstructure:"compose_concurrency"`
+	KMSKeyMap            map[string]string `mapstructure:"kms_key_map"`
+	DefaultKMSKey        string            `mapstructure:"default_kms_key"`
+	IAMPrincipalMap      map[string]string `mapstructure:"iam_principal_map"`
+	StorageClassMap      map[string]string `mapstructure:"storage_class_map"`
+	GCSStorageClassMap   map[string]string `mapstructure:"gcs_storage_class_map"`
+	RestoreMode          string            `mapstructure:"restore_mode"`
+	// how long an unused GCS JSON API resumable upload session lives, a duration like 24h
+	ResumableSessionExpiry string `mapstructure:"resumable_session_expiry"`
+	// overrides for gcs locations to aws regions, and back
+	LocationRegionMap map[string]string `mapstructure:"location_region_map"`
+	RegionLocationMap map[string]string `mapstructure:"region_location_map"`
+	// pub/sub topics as projects/p/topics/t to the sns topic or sqs queue arns standing in for them
+	NotificationTopicMap map[string]string `mapstructure:"notification_topic_map"`
+	// s3 (the default) to have s3 send the events it can, emit to have the sidecar send them all
+	NotificationMode string `mapstructure:"notification_mode"`
+	// bucket/prefix where the notification configs the sidecar sends events for are kept
+	NotificationStore string `mapstructure:"notification_store"`
+}
+
+type PubSubConfig struct {
+	// pub/sub topics as projects/p/topics/t to the sns topic or kinesis stream arns standing in for them
+	TopicMap map[string]string `mapstructure:"topic_map"`
+	// pub/sub subscriptions as projects/p/subscriptions/s to the sqs queue names or arns standing in for them
+	SubscriptionMap map[string]string `mapstructure:"subscription_map"`
+}
+
+type BigtableConfig struct {
+	// bigtable tables as projects/p/instances/i/tables/t to the dynamodb tables standing in for them
+	TableMap map[string]string `mapstructure:"table_map"`
+	// binary hash key attribute holding the row key, row_key by default
+	KeyAttribute string `mapstructure:"key_attribute"`
+	// cells kept per column, 1 by default and 0 for all
+	MaxVersions int `mapstructure:"max_versions"`
 }
 
 type GCPDatastoreConfig struct {
@@ -0,0 +1,332 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/response_type"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"strings"
+)
+
+// GCS predefined ACLs for S3 canned bucket ACLs
+var cannedBucketACLs = map[string]string{
+	"private":            "private",
+	"public-read":        "publicRead",
+	"public-read-write":  "publicReadWrite",
+	"authenticated-read": "authenticatedRead",
+}
+
+// GCS predefined ACLs for S3 canned object ACLs.  GCS objects can't be written by others, so public-read-write
+// is public read like S3 effectively treats it
+var cannedObjectACLs = map[string]string{
+	"private":                   "private",
+	"public-read":               "publicRead",
+	"public-read-write":         "publicRead",
+	"authenticated-read":        "authenticatedRead",
+	"bucket-owner-read":         "bucketOwnerRead",
+	"bucket-owner-full-control": "bucketOwnerFullControl",
+}
+
+// Order of GCS roles, a grantee named more than once gets the strongest
+var roleStrength = map[storage.ACLRole]int{storage.RoleReader: 1, storage.RoleWriter: 2, storage.RoleOwner: 3}
+
+// GCS predefined ACL for an S3 canned ACL, false if GCS has nothing like it
+func CannedACLToGCS(canned string, isObject bool) (string, bool) {
+	if isObject {
+		predefined, ok := cannedObjectACLs[canned]
+		return predefined, ok
+	}
+	predefined, ok := cannedBucketACLs[canned]
+	return predefined, ok
+}
+
+// S3 grantee for a GCS ACL entity.  Everyone and authenticated users are S3 groups, anything else is a
+// canonical user whose id is the GCS entity so it can be sent back unchanged
+func gcsEntityToGrantee(entry storage.ACLRule) *response_type.Grantee {
+	grantee := &response_type.Grantee{XmlNS: response_type.ACLXmlNs}
+	switch entry.Entity {
+	case storage.AllUsers:
+		grantee.Xsi = response_type.ACLXmlGroup
+		grantee.URI = response_type.ACLAllUsers
+	case storage.AllAuthenticatedUsers:
+		grantee.Xsi = response_type.ACLXmlGroup
+		grantee.URI = response_type.ACLAuthenticatedUsers
+	default:
+		grantee.Xsi = response_type.ACLXmlXsi
+		grantee.Id = string(entry.Entity)
+		if entry.Email != "" {
+			grantee.DisplayName = entry.Email
+		} else {
+			grantee.DisplayName = string(entry.Entity)
+		}
+	}
+	return grantee
+}
+
+// Access control policy from S3 XML
+func ACLXMLToAWS(input *response_type.AccessControlPolicyInput) *s3.AccessControlPolicy {
+	policy := &s3.AccessControlPolicy{Grants: make([]s3.Grant, len(input.Grants))}
+	if input.OwnerId != "" {
+		ownerId := input.OwnerId
+		ownerDisplayName := input.OwnerDisplayName
+		policy.Owner = &s3.Owner{ID: &ownerId, DisplayName: &ownerDisplayName}
+	}
+	for i, grant := range input.Grants {
+		grantee := &s3.Grantee{Type: s3.Type(grant.Grantee.Type)}
+		if grant.Grantee.Id != "" {
+			grantee.ID = &grant.Grantee.Id
+		}
+		if grant.Grantee.DisplayName != "" {
+			grantee.DisplayName = &grant.Grantee.DisplayName
+		}
+		if grant.Grantee.EmailAddress != "" {
+			grantee.EmailAddress = &grant.Grantee.EmailAddress
+		}
+		if grant.Grantee.URI != "" {
+			grantee.URI = &grant.Grantee.URI
+		}
+		policy.Grants[i] = s3.Grant{Grantee: grantee, Permission: s3.Permission(grant.Permission)}
+	}
+	return policy
+}
+
+// S3 XML for an access control policy
+func AWSACLToXML(owner *s3.Owner, grants []s3.Grant) response_type.AWSACLResponse {
+	response := response_type.AWSACLResponse{AccessControlList: &response_type.AccessControlList{
+		Grants: make([]*response_type.Grant, len(grants)),
+	}}
+	if owner != nil {
+		response.OwnerId = stringValue(owner.ID)
+		response.OwnerDisplayName = stringValue(owner.DisplayName)
+	}
+	for i, grant := range grants {
+		grantee := &response_type.Grantee{XmlNS: response_type.ACLXmlNs, Xsi: response_type.ACLXmlXsi}
+		if grant.Grantee != nil {
+			if grant.Grantee.Type != "" {
+				grantee.Xsi = string(grant.Grantee.Type)
+			}
+			grantee.Id = stringValue(grant.Grantee.ID)
+			grantee.DisplayName = stringValue(grant.Grantee.DisplayName)
+			grantee.EmailAddress = stringValue(grant.Grantee.EmailAddress)
+			grantee.URI = stringValue(grant.Grantee.URI)
+		}
+		response.AccessControlList.Grants[i] = &response_type.Grant{Grantee: grantee, Permission: string(grant.Permission)}
+	}
+	return response
+}
+
+// Grantees from an x-amz-grant- header, a comma separated list of type="value"
+func ParseGrantHeader(header string) ([]s3.Grantee, error) {
+	grantees := make([]s3.Grantee, 0)
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		pieces := strings.SplitN(part, "=", 2)
+		if len(pieces) != 2 {
+			return nil, errors.New("invalid grant " + part)
+		}
+		value := strings.Trim(strings.TrimSpace(pieces[1]), "\"")
+		switch strings.ToLower(strings.TrimSpace(pieces[0])) {
+		case "id":
+			grantees = append(grantees, s3.Grantee{Type: s3.TypeCanonicalUser, ID: &value})
+		case "emailaddress":
+			grantees = append(grantees, s3.Grantee{Type: s3.TypeAmazonCustomerByEmail, EmailAddress: &value})
+		case "uri":
+			grantees = append(grantees, s3.Grantee{Type: s3.TypeGroup, URI: &value})
+		default:
+			return nil, errors.New("invalid grant " + part)
+		}
+	}
+	return grantees, nil
+}
+
+// GCS ACL entity for an S3 grantee.  Canonical ids that are GCS entities are used as is, other ids are looked up
+// in the principal map like bucket policy principals, emails are users and the all and authenticated users
+// groups are the GCS ones
+func granteeToEntity(grantee *s3.Grantee, principals map[string]string) (storage.ACLEntity, string) {
+	if grantee == nil {
+		return "", "a grantee is required"
+	}
+	if grantee.URI != nil {
+		switch *grantee.URI {
+		case response_type.ACLAllUsers:
+			return storage.AllUsers, ""
+		case response_type.ACLAuthenticatedUsers:
+			return storage.AllAuthenticatedUsers, ""
+		}
+		return "", "group " + *grantee.URI + " is not supported"
+	}
+	if grantee.EmailAddress != nil {
+		return storage.ACLEntity("user-" + *grantee.EmailAddress), ""
+	}
+	if grantee.ID != nil {
+		id := *grantee.ID
+		if id == string(storage.AllUsers) || id == string(storage.AllAuthenticatedUsers) {
+			return storage.ACLEntity(id), ""
+		}
+		for _, prefix := range []string{"user-", "group-", "domain-", "project-"} {
+			if strings.HasPrefix(id, prefix) {
+				return storage.ACLEntity(id), ""
+			}
+		}
+		if member, ok := principals[strings.ToLower(id)]; ok {
+			if entity, ok := memberToEntity(member); ok {
+				return entity, ""
+			}
+		}
+		return "", "canonical user " + id + " has no GCS entity mapped"
+	}
+	return "", "grantee is not valid"
+}
+
+// GCS ACL entity for an IAM member
+func memberToEntity(member string) (storage.ACLEntity, bool) {
+	if member == string(storage.AllUsers) || member == string(storage.AllAuthenticatedUsers) {
+		return storage.ACLEntity(member), true
+	}
+	pieces := strings.SplitN(member, ":", 2)
+	if len(pieces) != 2 {
+		return "", false
+	}
+	switch pieces[0] {
+	case "user", "serviceAccount":
+		return storage.ACLEntity("user-" + pieces[1]), true
+	case "group", "domain":
+		return storage.ACLEntity(pieces[0] + "-" + pieces[1]), true
+	}
+	return "", false
+}
+
+// GCS role for an S3 permission.  GCS has no separate permission for reading or writing the ACL itself and
+// objects can't be granted write
+func permissionToRole(permission s3.Permission, isObject bool) (storage.ACLRole, string) {
+	switch permission {
+	case s3.PermissionFullControl:
+		return storage.RoleOwner, ""
+	case s3.PermissionRead:
+		return storage.RoleReader, ""
+	case s3.PermissionWrite:
+		if !isObject {
+			return storage.RoleWriter, ""
+		}
+	}
+	return "", "permission " + string(permission) + " is not supported"
+}
+
+// GCS ACL rules for S3 grants, from an access control policy and grant headers by permission.  Anything that
+// can't be translated is returned so nothing is applied half way
+func AWSGrantsToGCS(grants []s3.Grant, principals map[string]string, isObject bool) ([]storage.ACLRule, []string) {
+	roles := make(map[storage.ACLEntity]storage.ACLRole)
+	order := make([]storage.ACLEntity, 0)
+	unsupported := make([]string, 0)
+	for _, grant := range grants {
+		entity, reason := granteeToEntity(grant.Grantee, principals)
+		if reason != "" {
+			unsupported = append(unsupported, reason)
+			continue
+		}
+		role, reason := permissionToRole(grant.Permission, isObject)
+		if reason != "" {
+			unsupported = append(unsupported, reason)
+			continue
+		}
+		existing, ok := roles[entity]
+		if !ok {
+			order = append(order, entity)
+		}
+		if roleStrength[role] > roleStrength[existing] {
+			roles[entity] = role
+		}
+	}
+	rules := make([]storage.ACLRule, len(order))
+	for i, entity := range order {
+		rules[i] = storage.ACLRule{Entity: entity, Role: roles[entity]}
+	}
+	return rules, unsupported
+}
+
+// Grants for x-amz-grant- headers, keyed by the permission they grant
+func GrantHeadersToAWS(headers map[s3.Permission]*string) ([]s3.Grant, error) {
+	grants := make([]s3.Grant, 0)
+	for _, permission := range []s3.Permission{s3.PermissionFullControl, s3.PermissionRead, s3.PermissionReadAcp,
+		s3.PermissionWrite, s3.PermissionWriteAcp} {
+		header := headers[permission]
+		if header == nil {
+			continue
+		}
+		grantees, err := ParseGrantHeader(*header)
+		if err != nil {
+			return nil, err
+		}
+		for i := range grantees {
+			grants = append(grants, s3.Grant{Grantee: &grantees[i], Permission: permission})
+		}
+	}
+	return grants, nil
+}
+
+// x-amz-grant- headers for grants, keyed by the permission they grant.  The inverse of GrantHeadersToAWS, for
+// requests like copies that take an ACL as headers only
+func GrantsToHeaders(grants []s3.Grant) map[s3.Permission]*string {
+	values := make(map[s3.Permission][]string)
+	for _, grant := range grants {
+		if grant.Grantee == nil {
+			continue
+		}
+		var value string
+		switch grant.Grantee.Type {
+		case s3.TypeCanonicalUser:
+			value = fmt.Sprintf("id=\"%s\"", stringValue(grant.Grantee.ID))
+		case s3.TypeAmazonCustomerByEmail:
+			value = fmt.Sprintf("emailAddress=\"%s\"", stringValue(grant.Grantee.EmailAddress))
+		case s3.TypeGroup:
+			value = fmt.Sprintf("uri=\"%s\"", stringValue(grant.Grantee.URI))
+		default:
+			continue
+		}
+		values[grant.Permission] = append(values[grant.Permission], value)
+	}
+	headers := make(map[s3.Permission]*string)
+	for permission, granted := range values {
+		header := strings.Join(granted, ", ")
+		headers[permission] = &header
+	}
+	return headers
+}
+
+// GCS ACL for an S3 ACL request, which is a canned ACL, grant headers or an access control policy.  A canned ACL
+// gives a GCS predefined ACL, anything else the rules to replace the ACL with.  Both are empty when the request
+// has no ACL, and everything that can't be translated is returned
+func AWSACLToGCS(canned string, policy *s3.AccessControlPolicy, headers map[s3.Permission]*string, principals map[string]string, isObject bool) (string, []storage.ACLRule, []string, error) {
+	grants, err := GrantHeadersToAWS(headers)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if canned != "" {
+		if len(grants) > 0 || policy != nil {
+			return "", nil, nil, errors.New("specify either a canned ACL or grants, not both")
+		}
+		predefined, ok := CannedACLToGCS(canned, isObject)
+		if !ok {
+			return "", nil, []string{"canned ACL " + canned + " is not supported"}, nil
+		}
+		return predefined, nil, nil, nil
+	}
+	if policy != nil {
+		grants = append(grants, policy.Grants...)
+	} else if len(grants) == 0 {
+		return "", nil, nil, nil
+	}
+	rules, unsupported := AWSGrantsToGCS(grants, principals, isObject)
+	return "", rules, unsupported, nil
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,118 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/response_type"
+	"encoding/xml"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const accessControlPolicy = `<AccessControlPolicy xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Owner><ID>project-owners-1</ID><DisplayName>owners</DisplayName></Owner>
+  <AccessControlList>
+    <Grant>
+      <Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="CanonicalUser"><ID>abc123</ID></Grantee>
+      <Permission>FULL_CONTROL</Permission>
+    </Grant>
+    <Grant>
+      <Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="Group"><URI>http://acs.amazonaws.com/groups/global/AllUsers</URI></Grantee>
+      <Permission>READ</Permission>
+    </Grant>
+    <Grant>
+      <Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="AmazonCustomerByEmail"><EmailAddress>larry@barry.com</EmailAddress></Grantee>
+      <Permission>READ</Permission>
+    </Grant>
+  </AccessControlList>
+</AccessControlPolicy>`
+
+func TestAWSACLToGCS_Policy(t *testing.T) {
+	var input response_type.AccessControlPolicyInput
+	assert.Nil(t, xml.Unmarshal([]byte(accessControlPolicy), &input))
+	policy := ACLXMLToAWS(&input)
+	assert.Equal(t, "project-owners-1", *policy.Owner.ID)
+	assert.Equal(t, s3.TypeGroup, policy.Grants[1].Grantee.Type)
+
+	principals := map[string]string{"abc123": "serviceAccount:writer@p.iam.gserviceaccount.com"}
+	predefined, rules, unsupported, err := AWSACLToGCS("", policy, nil, principals, false)
+	assert.Nil(t, err)
+	assert.Empty(t, unsupported)
+	assert.Equal(t, "", predefined)
+	assert.Equal(t, []storage.ACLRule{
+		{Entity: "user-writer@p.iam.gserviceaccount.com", Role: storage.RoleOwner},
+		{Entity: storage.AllUsers, Role: storage.RoleReader},
+		{Entity: "user-larry@barry.com", Role: storage.RoleReader},
+	}, rules)
+}
+
+func TestAWSACLToGCS_Headers(t *testing.T) {
+	read := `uri="http://acs.amazonaws.com/groups/global/AuthenticatedUsers", id="group-devs@example.com"`
+	full := `id="group-devs@example.com"`
+	headers := map[s3.Permission]*string{s3.PermissionRead: &read, s3.PermissionFullControl: &full}
+	_, rules, unsupported, err := AWSACLToGCS("", nil, headers, nil, true)
+	assert.Nil(t, err)
+	assert.Empty(t, unsupported)
+	assert.Equal(t, []storage.ACLRule{
+		{Entity: "group-devs@example.com", Role: storage.RoleOwner},
+		{Entity: storage.AllAuthenticatedUsers, Role: storage.RoleReader},
+	}, rules)
+
+	writeACP := `id="unmapped"`
+	headers = map[s3.Permission]*string{s3.PermissionWriteAcp: &writeACP, s3.PermissionFullControl: &writeACP}
+	_, _, unsupported, err = AWSACLToGCS("", nil, headers, nil, true)
+	assert.Nil(t, err)
+	assert.Len(t, unsupported, 2)
+
+	bad := `nope`
+	_, _, _, err = AWSACLToGCS("", nil, map[s3.Permission]*string{s3.PermissionRead: &bad}, nil, true)
+	assert.NotNil(t, err)
+}
+
+func TestGrantsToHeaders(t *testing.T) {
+	owner, group := "owner-id", "http://acs.amazonaws.com/groups/global/AllUsers"
+	grants := []s3.Grant{
+		{Grantee: &s3.Grantee{Type: s3.TypeCanonicalUser, ID: &owner}, Permission: s3.PermissionFullControl},
+		{Grantee: &s3.Grantee{Type: s3.TypeGroup, URI: &group}, Permission: s3.PermissionRead},
+	}
+	headers := GrantsToHeaders(grants)
+	assert.Equal(t, `id="owner-id"`, *headers[s3.PermissionFullControl])
+	assert.Nil(t, headers[s3.PermissionReadAcp])
+	roundTrip, err := GrantHeadersToAWS(headers)
+	assert.Nil(t, err)
+	assert.Equal(t, grants, roundTrip)
+}
+
+func TestAWSACLToGCS_Canned(t *testing.T) {
+	predefined, rules, unsupported, err := AWSACLToGCS("bucket-owner-full-control", nil, nil, nil, true)
+	assert.Nil(t, err)
+	assert.Empty(t, unsupported)
+	assert.Nil(t, rules)
+	assert.Equal(t, "bucketOwnerFullControl", predefined)
+
+	_, _, unsupported, _ = AWSACLToGCS("bucket-owner-full-control", nil, nil, nil, false)
+	assert.Len(t, unsupported, 1)
+	predefined, _, _, _ = AWSACLToGCS("public-read-write", nil, nil, nil, false)
+	assert.Equal(t, "publicReadWrite", predefined)
+
+	full := `id="user-a@b.com"`
+	_, _, _, err = AWSACLToGCS("private", nil, map[s3.Permission]*string{s3.PermissionFullControl: &full}, nil, true)
+	assert.NotNil(t, err)
+
+	predefined, rules, unsupported, err = AWSACLToGCS("", nil, nil, nil, true)
+	assert.Equal(t, "", predefined)
+	assert.Nil(t, rules)
+	assert.Nil(t, err)
+}
+
+func TestGCSACLResponseToAWS_Groups(t *testing.T) {
+	response := GCSACLResponseToAWS([]storage.ACLRule{
+		{Entity: "project-owners-1", Role: storage.RoleOwner},
+		{Entity: storage.AllUsers, Role: storage.RoleReader},
+	})
+	assert.Equal(t, "project-owners-1", response.OwnerId)
+	assert.Len(t, response.AccessControlList.Grants, 2)
+	assert.Equal(t, response_type.ACLAllUsers, response.AccessControlList.Grants[1].Grantee.URI)
+	assert.Equal(t, response_type.ACLXmlGroup, response.AccessControlList.Grants[1].Grantee.Xsi)
+	assert.Equal(t, "READ", response.AccessControlList.Grants[1].Permission)
+}
@@ -0,0 +1,580 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	bigtable "google.golang.org/genproto/googleapis/bigtable/v2"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"regexp"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Names of the attributes holding a cell's timestamp and value in a DynamoDB item
+const bigtableTimestampAttribute = "timestamp"
+const bigtableValueAttribute = "value"
+
+// Column family names Bigtable allows
+var bigtableFamilyName = regexp.MustCompile(`^[_a-zA-Z0-9][-_.a-zA-Z0-9]*$`)
+
+// Error for row filters there's nothing to apply them with, like sinks and row sampling
+var ErrUnsupportedBigtableFilter = errors.New("unsupported row filter")
+
+// A Bigtable cell
+type BigtableCell struct {
+	Family    string
+	Qualifier []byte
+	Timestamp int64
+	Value     []byte
+	Labels    []string
+}
+
+// Sort cells in the order Bigtable returns them: by family, then qualifier, newest first
+func SortBigtableCells(cells []BigtableCell) {
+	sort.SliceStable(cells, func(i, j int) bool {
+		if cells[i].Family != cells[j].Family {
+			return cells[i].Family < cells[j].Family
+		}
+		if c := bytes.Compare(cells[i].Qualifier, cells[j].Qualifier); c != 0 {
+			return c < 0
+		}
+		return cells[i].Timestamp > cells[j].Timestamp
+	})
+}
+
+// Cells of a DynamoDB item.  Each column family is a map attribute from qualifier to the column's cells, newest first,
+// and the attributes in skip, like the key, aren't families
+func DynamoItemToBigtableCells(item map[string]dynamodb.AttributeValue, skip ...string) ([]BigtableCell, error) {
+	skipped := make(map[string]bool)
+	for _, name := range skip {
+		skipped[name] = true
+	}
+	cells := make([]BigtableCell, 0)
+	for family, columns := range item {
+		if skipped[family] {
+			continue
+		}
+		if columns.M == nil {
+			return nil, errors.New("attribute " + family + " is not a column family map")
+		}
+		for qualifier, column := range columns.M {
+			for _, cell := range column.L {
+				if cell.M == nil || cell.M[bigtableTimestampAttribute].N == nil {
+					return nil, fmt.Errorf("invalid cell in column %s:%s", family, qualifier)
+				}
+				timestamp, err := strconv.ParseInt(*cell.M[bigtableTimestampAttribute].N, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				cells = append(cells, BigtableCell{
+					Family:    family,
+					Qualifier: []byte(qualifier),
+					Timestamp: timestamp,
+					Value:     cell.M[bigtableValueAttribute].B,
+				})
+			}
+		}
+	}
+	SortBigtableCells(cells)
+	return cells, nil
+}
+
+// DynamoDB item attributes holding cells, one map attribute per column family
+func BigtableCellsToDynamoItem(cells []BigtableCell) map[string]dynamodb.AttributeValue {
+	item := make(map[string]dynamodb.AttributeValue)
+	for _, cell := range cells {
+		family, ok := item[cell.Family]
+		if !ok {
+			family = dynamodb.AttributeValue{M: make(map[string]dynamodb.AttributeValue)}
+			item[cell.Family] = family
+		}
+		timestamp := strconv.FormatInt(cell.Timestamp, 10)
+		value := cell.Value
+		if value == nil {
+			// DynamoDB has no null binary, an empty one stands in for an empty value
+			value = []byte{}
+		}
+		column := family.M[string(cell.Qualifier)]
+		column.L = append(column.L, dynamodb.AttributeValue{M: map[string]dynamodb.AttributeValue{
+			bigtableTimestampAttribute: {N: &timestamp},
+			bigtableValueAttribute:     {B: value},
+		}})
+		family.M[string(cell.Qualifier)] = column
+	}
+	return item
+}
+
+// Bigtable timestamp for now, at the millisecond granularity tables use
+func BigtableNow(nowMicros int64) int64 {
+	return nowMicros - nowMicros%1000
+}
+
+func checkBigtableColumn(family string, qualifier []byte) error {
+	if !bigtableFamilyName.MatchString(family) {
+		return errors.New("invalid column family " + family)
+	}
+	if !utf8.Valid(qualifier) {
+		// qualifiers are DynamoDB map keys, which are strings
+		return errors.New("column qualifiers must be valid UTF-8")
+	}
+	return nil
+}
+
+// Apply mutations to a row's cells in order, keeping at most maxVersions cells per column when it's positive.  Cells
+// set with a timestamp of -1 get now
+func ApplyBigtableMutations(cells []BigtableCell, mutations []*bigtable.Mutation, now int64, maxVersions int) ([]BigtableCell, error) {
+	cells = append([]BigtableCell{}, cells...)
+	for _, mutation := range mutations {
+		switch m := mutation.Mutation.(type) {
+		case *bigtable.Mutation_SetCell_:
+			if err := checkBigtableColumn(m.SetCell.FamilyName, m.SetCell.ColumnQualifier); err != nil {
+				return nil, err
+			}
+			timestamp := m.SetCell.TimestampMicros
+			if timestamp == -1 {
+				timestamp = BigtableNow(now)
+			} else if timestamp < 0 || timestamp%1000 != 0 {
+				return nil, fmt.Errorf("invalid timestamp %d, timestamps are in microseconds at millisecond granularity", timestamp)
+			}
+			kept := cells[:0]
+			for _, cell := range cells {
+				if !(cell.Family == m.SetCell.FamilyName && bytes.Equal(cell.Qualifier, m.SetCell.ColumnQualifier) && cell.Timestamp == timestamp) {
+					kept = append(kept, cell)
+				}
+			}
+			cells = append(kept, BigtableCell{
+				Family:    m.SetCell.FamilyName,
+				Qualifier: m.SetCell.ColumnQualifier,
+				Timestamp: timestamp,
+				Value:     m.SetCell.Value,
+			})
+		case *bigtable.Mutation_DeleteFromColumn_:
+			kept := cells[:0]
+			for _, cell := range cells {
+				if !(cell.Family == m.DeleteFromColumn.FamilyName && bytes.Equal(cell.Qualifier, m.DeleteFromColumn.ColumnQualifier) && inTimestampRange(cell.Timestamp, m.DeleteFromColumn.TimeRange)) {
+					kept = append(kept, cell)
+				}
+			}
+			cells = kept
+		case *bigtable.Mutation_DeleteFromFamily_:
+			kept := cells[:0]
+			for _, cell := range cells {
+				if cell.Family != m.DeleteFromFamily.FamilyName {
+					kept = append(kept, cell)
+				}
+			}
+			cells = kept
+		case *bigtable.Mutation_DeleteFromRow_:
+			cells = cells[:0]
+		default:
+			return nil, errors.New("empty mutation")
+		}
+	}
+	SortBigtableCells(cells)
+	if maxVersions > 0 {
+		cells = limitPerColumn(cells, maxVersions)
+	}
+	return cells, nil
+}
+
+// Apply read-modify-write rules to a row's cells in order.  Returns the row's cells and the new cell of each column the
+// rules changed
+func ReadModifyWriteBigtableCells(cells []BigtableCell, rules []*bigtable.ReadModifyWriteRule, now int64, maxVersions int) ([]BigtableCell, []BigtableCell, error) {
+	changed := make([]BigtableCell, 0, len(rules))
+	for _, rule := range rules {
+		if err := checkBigtableColumn(rule.FamilyName, rule.ColumnQualifier); err != nil {
+			return nil, nil, err
+		}
+		// cells are kept sorted, so the first one in the column is the latest
+		var latest *BigtableCell
+		for i := range cells {
+			if cells[i].Family == rule.FamilyName && bytes.Equal(cells[i].Qualifier, rule.ColumnQualifier) {
+				latest = &cells[i]
+				break
+			}
+		}
+		var value []byte
+		switch r := rule.Rule.(type) {
+		case *bigtable.ReadModifyWriteRule_AppendValue:
+			if latest != nil {
+				value = append(value, latest.Value...)
+			}
+			value = append(value, r.AppendValue...)
+		case *bigtable.ReadModifyWriteRule_IncrementAmount:
+			var current int64
+			if latest != nil {
+				if len(latest.Value) != 8 {
+					return nil, nil, errors.New("value to increment is not a 64-bit big-endian integer")
+				}
+				current = int64(binary.BigEndian.Uint64(latest.Value))
+			}
+			value = make([]byte, 8)
+			binary.BigEndian.PutUint64(value, uint64(current+r.IncrementAmount))
+		default:
+			return nil, nil, errors.New("empty read-modify-write rule")
+		}
+		timestamp := BigtableNow(now)
+		if latest != nil && latest.Timestamp > timestamp {
+			timestamp = latest.Timestamp
+		}
+		var err error
+		cells, err = ApplyBigtableMutations(cells, []*bigtable.Mutation{{Mutation: &bigtable.Mutation_SetCell_{SetCell: &bigtable.Mutation_SetCell{
+			FamilyName:      rule.FamilyName,
+			ColumnQualifier: rule.ColumnQualifier,
+			TimestampMicros: timestamp,
+			Value:           value,
+		}}}}, now, maxVersions)
+		if err != nil {
+			return nil, nil, err
+		}
+		cell := BigtableCell{Family: rule.FamilyName, Qualifier: rule.ColumnQualifier, Timestamp: timestamp, Value: value}
+		replaced := false
+		for i := range changed {
+			if changed[i].Family == cell.Family && bytes.Equal(changed[i].Qualifier, cell.Qualifier) {
+				changed[i] = cell
+				replaced = true
+			}
+		}
+		if !replaced {
+			changed = append(changed, cell)
+		}
+	}
+	SortBigtableCells(changed)
+	return cells, changed, nil
+}
+
+// Whether a row key is in a row set.  An empty set has every row
+func BigtableRowInSet(key []byte, rows *bigtable.RowSet) bool {
+	if rows == nil || (len(rows.RowKeys) == 0 && len(rows.RowRanges) == 0) {
+		return true
+	}
+	for _, rowKey := range rows.RowKeys {
+		if bytes.Equal(key, rowKey) {
+			return true
+		}
+	}
+	for _, rowRange := range rows.RowRanges {
+		if inRowRange(key, rowRange) {
+			return true
+		}
+	}
+	return false
+}
+
+func inRowRange(key []byte, rowRange *bigtable.RowRange) bool {
+	switch start := rowRange.StartKey.(type) {
+	case *bigtable.RowRange_StartKeyClosed:
+		if bytes.Compare(key, start.StartKeyClosed) < 0 {
+			return false
+		}
+	case *bigtable.RowRange_StartKeyOpen:
+		if bytes.Compare(key, start.StartKeyOpen) <= 0 {
+			return false
+		}
+	}
+	switch end := rowRange.EndKey.(type) {
+	case *bigtable.RowRange_EndKeyClosed:
+		if len(end.EndKeyClosed) > 0 && bytes.Compare(key, end.EndKeyClosed) > 0 {
+			return false
+		}
+	case *bigtable.RowRange_EndKeyOpen:
+		if len(end.EndKeyOpen) > 0 && bytes.Compare(key, end.EndKeyOpen) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether a value is in a range with closed or open ends, either of which may be missing
+// Bounds of a range of row keys.  Empty bounds are unbounded
+type BigtableKeyRange struct {
+	Start     []byte
+	StartOpen bool
+	End       []byte
+	EndOpen   bool
+}
+
+// Whether a row key is in the range
+func (keyRange BigtableKeyRange) Contains(key []byte) bool {
+	if len(keyRange.Start) > 0 {
+		if c := bytes.Compare(key, keyRange.Start); c < 0 || (c == 0 && keyRange.StartOpen) {
+			return false
+		}
+	}
+	if len(keyRange.End) > 0 {
+		if c := bytes.Compare(key, keyRange.End); c > 0 || (c == 0 && keyRange.EndOpen) {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether no row key is in the range
+func (keyRange BigtableKeyRange) Empty() bool {
+	if len(keyRange.Start) == 0 || len(keyRange.End) == 0 {
+		return false
+	}
+	c := bytes.Compare(keyRange.Start, keyRange.End)
+	return c > 0 || (c == 0 && (keyRange.StartOpen || keyRange.EndOpen))
+}
+
+// Key ranges of a row set ordered by start key, with row keys as ranges of one key and empty ranges dropped.  A row
+// set with no keys or ranges is the whole table
+func BigtableKeyRanges(rows *bigtable.RowSet) []BigtableKeyRange {
+	if rows == nil || (len(rows.RowKeys) == 0 && len(rows.RowRanges) == 0) {
+		return []BigtableKeyRange{{}}
+	}
+	ranges := make([]BigtableKeyRange, 0, len(rows.RowKeys)+len(rows.RowRanges))
+	for _, rowKey := range rows.RowKeys {
+		ranges = append(ranges, BigtableKeyRange{Start: rowKey, End: rowKey})
+	}
+	for _, rowRange := range rows.RowRanges {
+		var keyRange BigtableKeyRange
+		switch start := rowRange.StartKey.(type) {
+		case *bigtable.RowRange_StartKeyClosed:
+			keyRange.Start = start.StartKeyClosed
+		case *bigtable.RowRange_StartKeyOpen:
+			keyRange.Start, keyRange.StartOpen = start.StartKeyOpen, true
+		}
+		switch end := rowRange.EndKey.(type) {
+		case *bigtable.RowRange_EndKeyClosed:
+			keyRange.End = end.EndKeyClosed
+		case *bigtable.RowRange_EndKeyOpen:
+			keyRange.End, keyRange.EndOpen = end.EndKeyOpen, true
+		}
+		if !keyRange.Empty() {
+			ranges = append(ranges, keyRange)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].Start, ranges[j].Start) < 0
+	})
+	return ranges
+}
+
+func inBytesRange(value []byte, startClosed []byte, startOpen []byte, endClosed []byte, endOpen []byte) bool {
+	if startClosed != nil && bytes.Compare(value, startClosed) < 0 {
+		return false
+	}
+	if startOpen != nil && bytes.Compare(value, startOpen) <= 0 {
+		return false
+	}
+	if endClosed != nil && bytes.Compare(value, endClosed) > 0 {
+		return false
+	}
+	if endOpen != nil && bytes.Compare(value, endOpen) >= 0 {
+		return false
+	}
+	return true
+}
+
+// Whether a timestamp is in [start, end), where an end of 0 has no end
+func inTimestampRange(timestamp int64, timestampRange *bigtable.TimestampRange) bool {
+	if timestampRange == nil {
+		return true
+	}
+	return timestamp >= timestampRange.StartTimestampMicros &&
+		(timestampRange.EndTimestampMicros == 0 || timestamp < timestampRange.EndTimestampMicros)
+}
+
+// Regex that has to match all of a key, qualifier or value, the way Bigtable applies RE2 filters
+func fullMatch(pattern []byte) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?s:" + string(pattern) + ")$")
+}
+
+func limitPerColumn(cells []BigtableCell, limit int) []BigtableCell {
+	kept := make([]BigtableCell, 0, len(cells))
+	count := 0
+	for i, cell := range cells {
+		if i == 0 || cell.Family != cells[i-1].Family || !bytes.Equal(cell.Qualifier, cells[i-1].Qualifier) {
+			count = 0
+		}
+		count++
+		if count <= limit {
+			kept = append(kept, cell)
+		}
+	}
+	return kept
+}
+
+// Cells of a row a filter lets through.  Sink filters and row sampling aren't supported
+func FilterBigtableCells(key []byte, cells []BigtableCell, filter *bigtable.RowFilter) ([]BigtableCell, error) {
+	if filter == nil {
+		return cells, nil
+	}
+	keep := func(match func(cell BigtableCell) bool) []BigtableCell {
+		kept := make([]BigtableCell, 0, len(cells))
+		for _, cell := range cells {
+			if match(cell) {
+				kept = append(kept, cell)
+			}
+		}
+		return kept
+	}
+	switch f := filter.Filter.(type) {
+	case *bigtable.RowFilter_Chain_:
+		var err error
+		for _, link := range f.Chain.Filters {
+			if cells, err = FilterBigtableCells(key, cells, link); err != nil {
+				return nil, err
+			}
+		}
+		return cells, nil
+	case *bigtable.RowFilter_Interleave_:
+		interleaved := make([]BigtableCell, 0)
+		for _, branch := range f.Interleave.Filters {
+			output, err := FilterBigtableCells(key, cells, branch)
+			if err != nil {
+				return nil, err
+			}
+			interleaved = append(interleaved, output...)
+		}
+		SortBigtableCells(interleaved)
+		return interleaved, nil
+	case *bigtable.RowFilter_Condition_:
+		matched, err := FilterBigtableCells(key, cells, f.Condition.PredicateFilter)
+		if err != nil {
+			return nil, err
+		}
+		next := f.Condition.FalseFilter
+		if len(matched) > 0 {
+			next = f.Condition.TrueFilter
+		}
+		if next == nil {
+			return []BigtableCell{}, nil
+		}
+		return FilterBigtableCells(key, cells, next)
+	case *bigtable.RowFilter_PassAllFilter:
+		return cells, nil
+	case *bigtable.RowFilter_BlockAllFilter:
+		return []BigtableCell{}, nil
+	case *bigtable.RowFilter_RowKeyRegexFilter:
+		expression, err := fullMatch(f.RowKeyRegexFilter)
+		if err != nil {
+			return nil, err
+		}
+		if !expression.Match(key) {
+			return []BigtableCell{}, nil
+		}
+		return cells, nil
+	case *bigtable.RowFilter_FamilyNameRegexFilter:
+		expression, err := fullMatch([]byte(f.FamilyNameRegexFilter))
+		if err != nil {
+			return nil, err
+		}
+		return keep(func(cell BigtableCell) bool { return expression.MatchString(cell.Family) }), nil
+	case *bigtable.RowFilter_ColumnQualifierRegexFilter:
+		expression, err := fullMatch(f.ColumnQualifierRegexFilter)
+		if err != nil {
+			return nil, err
+		}
+		return keep(func(cell BigtableCell) bool { return expression.Match(cell.Qualifier) }), nil
+	case *bigtable.RowFilter_ColumnRangeFilter:
+		columns := f.ColumnRangeFilter
+		return keep(func(cell BigtableCell) bool {
+			return cell.Family == columns.FamilyName && inBytesRange(
+				cell.Qualifier,
+				columns.GetStartQualifierClosed(), columns.GetStartQualifierOpen(),
+				columns.GetEndQualifierClosed(), columns.GetEndQualifierOpen(),
+			)
+		}), nil
+	case *bigtable.RowFilter_TimestampRangeFilter:
+		return keep(func(cell BigtableCell) bool { return inTimestampRange(cell.Timestamp, f.TimestampRangeFilter) }), nil
+	case *bigtable.RowFilter_ValueRegexFilter:
+		expression, err := fullMatch(f.ValueRegexFilter)
+		if err != nil {
+			return nil, err
+		}
+		return keep(func(cell BigtableCell) bool { return expression.Match(cell.Value) }), nil
+	case *bigtable.RowFilter_ValueRangeFilter:
+		values := f.ValueRangeFilter
+		return keep(func(cell BigtableCell) bool {
+			return inBytesRange(
+				cell.Value,
+				values.GetStartValueClosed(), values.GetStartValueOpen(),
+				values.GetEndValueClosed(), values.GetEndValueOpen(),
+			)
+		}), nil
+	case *bigtable.RowFilter_CellsPerRowOffsetFilter:
+		if int(f.CellsPerRowOffsetFilter) >= len(cells) {
+			return []BigtableCell{}, nil
+		}
+		return cells[f.CellsPerRowOffsetFilter:], nil
+	case *bigtable.RowFilter_CellsPerRowLimitFilter:
+		if int(f.CellsPerRowLimitFilter) < len(cells) {
+			return cells[:f.CellsPerRowLimitFilter], nil
+		}
+		return cells, nil
+	case *bigtable.RowFilter_CellsPerColumnLimitFilter:
+		return limitPerColumn(cells, int(f.CellsPerColumnLimitFilter)), nil
+	case *bigtable.RowFilter_StripValueTransformer:
+		stripped := make([]BigtableCell, len(cells))
+		for i, cell := range cells {
+			cell.Value = nil
+			stripped[i] = cell
+		}
+		return stripped, nil
+	case *bigtable.RowFilter_ApplyLabelTransformer:
+		labeled := make([]BigtableCell, len(cells))
+		for i, cell := range cells {
+			cell.Labels = append(append([]string{}, cell.Labels...), f.ApplyLabelTransformer)
+			labeled[i] = cell
+		}
+		return labeled, nil
+	}
+	return nil, ErrUnsupportedBigtableFilter
+}
+
+// ReadRows chunks for a row's cells, with the family and qualifier only given when they change
+func BigtableCellChunks(key []byte, cells []BigtableCell) []*bigtable.ReadRowsResponse_CellChunk {
+	chunks := make([]*bigtable.ReadRowsResponse_CellChunk, 0, len(cells))
+	for i, cell := range cells {
+		chunk := &bigtable.ReadRowsResponse_CellChunk{
+			TimestampMicros: cell.Timestamp,
+			Labels:          cell.Labels,
+			Value:           cell.Value,
+		}
+		if i == 0 {
+			chunk.RowKey = key
+		}
+		if i == 0 || cell.Family != cells[i-1].Family {
+			chunk.FamilyName = wrapperspb.String(cell.Family)
+			chunk.Qualifier = wrapperspb.Bytes(cell.Qualifier)
+		} else if !bytes.Equal(cell.Qualifier, cells[i-1].Qualifier) {
+			chunk.Qualifier = wrapperspb.Bytes(cell.Qualifier)
+		}
+		if i == len(cells)-1 {
+			chunk.RowStatus = &bigtable.ReadRowsResponse_CellChunk_CommitRow{CommitRow: true}
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// Bigtable row of sorted cells
+func BigtableCellsToRow(key []byte, cells []BigtableCell) *bigtable.Row {
+	row := &bigtable.Row{Key: key}
+	var family *bigtable.Family
+	var column *bigtable.Column
+	for _, cell := range cells {
+		if family == nil || family.Name != cell.Family {
+			family = &bigtable.Family{Name: cell.Family}
+			row.Families = append(row.Families, family)
+			column = nil
+		}
+		if column == nil || !bytes.Equal(column.Qualifier, cell.Qualifier) {
+			column = &bigtable.Column{Qualifier: cell.Qualifier}
+			family.Columns = append(family.Columns, column)
+		}
+		column.Cells = append(column.Cells, &bigtable.Cell{
+			TimestampMicros: cell.Timestamp,
+			Value:           cell.Value,
+			Labels:          cell.Labels,
+		})
+	}
+	return row
+}
@@ -0,0 +1,233 @@
+package converter
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	bigtable "google.golang.org/genproto/googleapis/bigtable/v2"
+	"testing"
+)
+
+func setCell(family string, qualifier string, timestamp int64, value string) *bigtable.Mutation {
+	return &bigtable.Mutation{Mutation: &bigtable.Mutation_SetCell_{SetCell: &bigtable.Mutation_SetCell{
+		FamilyName:      family,
+		ColumnQualifier: []byte(qualifier),
+		TimestampMicros: timestamp,
+		Value:           []byte(value),
+	}}}
+}
+
+func testCells(t *testing.T) []BigtableCell {
+	cells, err := ApplyBigtableMutations(nil, []*bigtable.Mutation{
+		setCell("cf", "b", 1000, "b1"),
+		setCell("cf", "a", 1000, "a1"),
+		setCell("cf", "a", 2000, "a2"),
+		setCell("other", "x", 1000, "x1"),
+	}, 5000, 0)
+	assert.Nil(t, err)
+	return cells
+}
+
+func TestBigtableDynamoItem(t *testing.T) {
+	cells := testCells(t)
+	item := BigtableCellsToDynamoItem(cells)
+	assert.Len(t, item, 2)
+	assert.Len(t, item["cf"].M["a"].L, 2)
+	back, err := DynamoItemToBigtableCells(item)
+	assert.Nil(t, err)
+	assert.Equal(t, cells, back)
+
+	item["row_key"] = item["cf"].M["a"].L[0].M["value"]
+	_, err = DynamoItemToBigtableCells(item)
+	assert.NotNil(t, err)
+	back, err = DynamoItemToBigtableCells(item, "row_key")
+	assert.Nil(t, err)
+	assert.Equal(t, cells, back)
+}
+
+func TestApplyBigtableMutations(t *testing.T) {
+	cells := testCells(t)
+	assert.Equal(t, "a", string(cells[0].Qualifier))
+	assert.Equal(t, int64(2000), cells[0].Timestamp)
+	assert.Equal(t, "other", cells[3].Family)
+
+	// server timestamps are at millisecond granularity
+	updated, err := ApplyBigtableMutations(cells, []*bigtable.Mutation{setCell("cf", "a", -1, "now")}, 7123456, 2)
+	assert.Nil(t, err)
+	assert.Len(t, updated, 4)
+	assert.Equal(t, int64(7123000), updated[0].Timestamp)
+	assert.Equal(t, "a1", string(cells[1].Value), "input cells are left alone")
+
+	updated, err = ApplyBigtableMutations(cells, []*bigtable.Mutation{
+		{Mutation: &bigtable.Mutation_DeleteFromColumn_{DeleteFromColumn: &bigtable.Mutation_DeleteFromColumn{
+			FamilyName:      "cf",
+			ColumnQualifier: []byte("a"),
+			TimeRange:       &bigtable.TimestampRange{StartTimestampMicros: 2000},
+		}}},
+		{Mutation: &bigtable.Mutation_DeleteFromFamily_{DeleteFromFamily: &bigtable.Mutation_DeleteFromFamily{FamilyName: "other"}}},
+	}, 5000, 0)
+	assert.Nil(t, err)
+	assert.Len(t, updated, 2)
+	assert.Equal(t, "a1", string(updated[0].Value))
+
+	updated, err = ApplyBigtableMutations(cells, []*bigtable.Mutation{
+		{Mutation: &bigtable.Mutation_DeleteFromRow_{DeleteFromRow: &bigtable.Mutation_DeleteFromRow{}}},
+	}, 5000, 0)
+	assert.Nil(t, err)
+	assert.Len(t, updated, 0)
+
+	_, err = ApplyBigtableMutations(cells, []*bigtable.Mutation{setCell("cf", "a", 1500, "v")}, 5000, 0)
+	assert.NotNil(t, err)
+	_, err = ApplyBigtableMutations(cells, []*bigtable.Mutation{setCell("bad family", "a", 1000, "v")}, 5000, 0)
+	assert.NotNil(t, err)
+	_, err = ApplyBigtableMutations(cells, []*bigtable.Mutation{setCell("cf", "\xff", 1000, "v")}, 5000, 0)
+	assert.NotNil(t, err)
+}
+
+func TestReadModifyWriteBigtableCells(t *testing.T) {
+	cells := testCells(t)
+	one := make([]byte, 8)
+	binary.BigEndian.PutUint64(one, 1)
+	cells, _ = ApplyBigtableMutations(cells, []*bigtable.Mutation{setCell("cf", "count", 1000, string(one))}, 5000, 0)
+	updated, changed, err := ReadModifyWriteBigtableCells(cells, []*bigtable.ReadModifyWriteRule{
+		{FamilyName: "cf", ColumnQualifier: []byte("a"), Rule: &bigtable.ReadModifyWriteRule_AppendValue{AppendValue: []byte("+")}},
+		{FamilyName: "cf", ColumnQualifier: []byte("count"), Rule: &bigtable.ReadModifyWriteRule_IncrementAmount{IncrementAmount: 2}},
+		{FamilyName: "cf", ColumnQualifier: []byte("count"), Rule: &bigtable.ReadModifyWriteRule_IncrementAmount{IncrementAmount: 3}},
+	}, 9000, 1)
+	assert.Nil(t, err)
+	assert.Len(t, changed, 2)
+	assert.Equal(t, "a2+", string(changed[0].Value))
+	assert.Equal(t, uint64(6), binary.BigEndian.Uint64(changed[1].Value))
+	assert.Equal(t, int64(9000), changed[1].Timestamp)
+	// one version per column is kept
+	assert.Len(t, updated, 4)
+
+	_, _, err = ReadModifyWriteBigtableCells(cells, []*bigtable.ReadModifyWriteRule{
+		{FamilyName: "cf", ColumnQualifier: []byte("a"), Rule: &bigtable.ReadModifyWriteRule_IncrementAmount{IncrementAmount: 1}},
+	}, 9000, 1)
+	assert.NotNil(t, err)
+}
+
+func TestBigtableRowInSet(t *testing.T) {
+	assert.True(t, BigtableRowInSet([]byte("a"), nil))
+	rows := &bigtable.RowSet{
+		RowKeys: [][]byte{[]byte("z")},
+		RowRanges: []*bigtable.RowRange{{
+			StartKey: &bigtable.RowRange_StartKeyOpen{StartKeyOpen: []byte("b")},
+			EndKey:   &bigtable.RowRange_EndKeyClosed{EndKeyClosed: []byte("d")},
+		}},
+	}
+	assert.False(t, BigtableRowInSet([]byte("b"), rows))
+	assert.True(t, BigtableRowInSet([]byte("c"), rows))
+	assert.True(t, BigtableRowInSet([]byte("d"), rows))
+	assert.False(t, BigtableRowInSet([]byte("e"), rows))
+	assert.True(t, BigtableRowInSet([]byte("z"), rows))
+	unbounded := &bigtable.RowSet{RowRanges: []*bigtable.RowRange{{
+		StartKey: &bigtable.RowRange_StartKeyClosed{StartKeyClosed: []byte("m")},
+	}}}
+	assert.True(t, BigtableRowInSet([]byte("zzz"), unbounded))
+	assert.False(t, BigtableRowInSet([]byte("a"), unbounded))
+}
+
+func TestBigtableKeyRanges(t *testing.T) {
+	assert.Equal(t, []BigtableKeyRange{{}}, BigtableKeyRanges(nil))
+	rows := &bigtable.RowSet{
+		RowKeys: [][]byte{[]byte("z")},
+		RowRanges: []*bigtable.RowRange{{
+			StartKey: &bigtable.RowRange_StartKeyOpen{StartKeyOpen: []byte("b")},
+			EndKey:   &bigtable.RowRange_EndKeyOpen{EndKeyOpen: []byte("d")},
+		}, {
+			StartKey: &bigtable.RowRange_StartKeyOpen{StartKeyOpen: []byte("f")},
+			EndKey:   &bigtable.RowRange_EndKeyClosed{EndKeyClosed: []byte("f")},
+		}, {
+			EndKey: &bigtable.RowRange_EndKeyClosed{EndKeyClosed: []byte("a")},
+		}},
+	}
+	ranges := BigtableKeyRanges(rows)
+	assert.Equal(t, []BigtableKeyRange{
+		{End: []byte("a")},
+		{Start: []byte("b"), StartOpen: true, End: []byte("d"), EndOpen: true},
+		{Start: []byte("z"), End: []byte("z")},
+	}, ranges)
+	assert.True(t, ranges[0].Contains([]byte("")))
+	assert.True(t, ranges[0].Contains([]byte("a")))
+	assert.False(t, ranges[0].Contains([]byte("aa")))
+	assert.False(t, ranges[1].Contains([]byte("b")))
+	assert.True(t, ranges[1].Contains([]byte("c")))
+	assert.False(t, ranges[1].Contains([]byte("d")))
+	assert.True(t, ranges[2].Contains([]byte("z")))
+}
+
+func TestFilterBigtableCells(t *testing.T) {
+	cells := testCells(t)
+	filter := func(filter *bigtable.RowFilter) []BigtableCell {
+		filtered, err := FilterBigtableCells([]byte("row"), cells, filter)
+		assert.Nil(t, err)
+		return filtered
+	}
+	assert.Len(t, filter(nil), 4)
+	assert.Len(t, filter(&bigtable.RowFilter{Filter: &bigtable.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "c."}}), 3)
+	assert.Len(t, filter(&bigtable.RowFilter{Filter: &bigtable.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "c"}}), 0)
+	assert.Len(t, filter(&bigtable.RowFilter{Filter: &bigtable.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("r.*")}}), 4)
+	assert.Len(t, filter(&bigtable.RowFilter{Filter: &bigtable.RowFilter_RowKeyRegexFilter{RowKeyRegexFilter: []byte("x")}}), 0)
+	assert.Len(t, filter(&bigtable.RowFilter{Filter: &bigtable.RowFilter_CellsPerColumnLimitFilter{CellsPerColumnLimitFilter: 1}}), 3)
+	assert.Len(t, filter(&bigtable.RowFilter{Filter: &bigtable.RowFilter_CellsPerRowLimitFilter{CellsPerRowLimitFilter: 2}}), 2)
+	assert.Len(t, filter(&bigtable.RowFilter{Filter: &bigtable.RowFilter_CellsPerRowOffsetFilter{CellsPerRowOffsetFilter: 3}}), 1)
+	assert.Len(t, filter(&bigtable.RowFilter{Filter: &bigtable.RowFilter_TimestampRangeFilter{TimestampRangeFilter: &bigtable.TimestampRange{EndTimestampMicros: 2000}}}), 3)
+	assert.Len(t, filter(&bigtable.RowFilter{Filter: &bigtable.RowFilter_ValueRangeFilter{ValueRangeFilter: &bigtable.ValueRange{
+		StartValue: &bigtable.ValueRange_StartValueClosed{StartValueClosed: []byte("a2")},
+		EndValue:   &bigtable.ValueRange_EndValueOpen{EndValueOpen: []byte("x1")},
+	}}}), 2)
+	assert.Len(t, filter(&bigtable.RowFilter{Filter: &bigtable.RowFilter_ColumnRangeFilter{ColumnRangeFilter: &bigtable.ColumnRange{
+		FamilyName:     "cf",
+		StartQualifier: &bigtable.ColumnRange_StartQualifierOpen{StartQualifierOpen: []byte("a")},
+	}}}), 1)
+
+	chained := filter(&bigtable.RowFilter{Filter: &bigtable.RowFilter_Chain_{Chain: &bigtable.RowFilter_Chain{Filters: []*bigtable.RowFilter{
+		{Filter: &bigtable.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("a.")}},
+		{Filter: &bigtable.RowFilter_StripValueTransformer{StripValueTransformer: true}},
+	}}}})
+	assert.Len(t, chained, 2)
+	assert.Nil(t, chained[0].Value)
+	assert.Equal(t, "a2", string(cells[0].Value))
+
+	interleaved := filter(&bigtable.RowFilter{Filter: &bigtable.RowFilter_Interleave_{Interleave: &bigtable.RowFilter_Interleave{Filters: []*bigtable.RowFilter{
+		{Filter: &bigtable.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "other"}},
+		{Filter: &bigtable.RowFilter_ColumnQualifierRegexFilter{ColumnQualifierRegexFilter: []byte("b")}},
+	}}}})
+	assert.Len(t, interleaved, 2)
+	assert.Equal(t, "cf", interleaved[0].Family)
+
+	condition := &bigtable.RowFilter_Condition{
+		PredicateFilter: &bigtable.RowFilter{Filter: &bigtable.RowFilter_ValueRegexFilter{ValueRegexFilter: []byte("x1")}},
+		TrueFilter:      &bigtable.RowFilter{Filter: &bigtable.RowFilter_ApplyLabelTransformer{ApplyLabelTransformer: "hit"}},
+	}
+	labeled := filter(&bigtable.RowFilter{Filter: &bigtable.RowFilter_Condition_{Condition: condition}})
+	assert.Len(t, labeled, 4)
+	assert.Equal(t, []string{"hit"}, labeled[0].Labels)
+	condition.PredicateFilter = &bigtable.RowFilter{Filter: &bigtable.RowFilter_BlockAllFilter{BlockAllFilter: true}}
+	assert.Len(t, filter(&bigtable.RowFilter{Filter: &bigtable.RowFilter_Condition_{Condition: condition}}), 0)
+
+	_, err := FilterBigtableCells([]byte("row"), cells, &bigtable.RowFilter{Filter: &bigtable.RowFilter_Sink{Sink: true}})
+	assert.Equal(t, ErrUnsupportedBigtableFilter, err)
+}
+
+func TestBigtableCellChunks(t *testing.T) {
+	cells := testCells(t)
+	chunks := BigtableCellChunks([]byte("row"), cells)
+	assert.Len(t, chunks, 4)
+	assert.Equal(t, "row", string(chunks[0].RowKey))
+	assert.Equal(t, "cf", chunks[0].FamilyName.Value)
+	assert.Equal(t, "a", string(chunks[0].Qualifier.Value))
+	assert.Nil(t, chunks[1].RowKey)
+	assert.Nil(t, chunks[1].Qualifier)
+	assert.Nil(t, chunks[2].FamilyName)
+	assert.Equal(t, "b", string(chunks[2].Qualifier.Value))
+	assert.Equal(t, "other", chunks[3].FamilyName.Value)
+	assert.True(t, chunks[3].GetCommitRow())
+	assert.False(t, chunks[2].GetCommitRow())
+
+	row := BigtableCellsToRow([]byte("row"), cells)
+	assert.Len(t, row.Families, 2)
+	assert.Len(t, row.Families[0].Columns, 2)
+	assert.Len(t, row.Families[0].Columns[0].Cells, 2)
+}
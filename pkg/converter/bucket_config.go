@@ -0,0 +1,388 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/response_type"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"strconv"
+	"time"
+)
+
+// Lifecycle rules from S3 XML
+func LifecycleXMLToAWS(input *response_type.LifecycleConfiguration) ([]s3.LifecycleRule, error) {
+	rules := make([]s3.LifecycleRule, len(input.Rules))
+	for i, rule := range input.Rules {
+		rules[i] = s3.LifecycleRule{
+			ID:     rule.ID,
+			Prefix: rule.Prefix,
+			Status: s3.ExpirationStatus(rule.Status),
+		}
+		if rule.Filter != nil {
+			rules[i].Filter = &s3.LifecycleRuleFilter{Prefix: rule.Filter.Prefix}
+			if rule.Filter.Tag != nil {
+				rules[i].Filter.Tag = tagXMLToAWS(rule.Filter.Tag)
+			}
+			if rule.Filter.And != nil {
+				rules[i].Filter.And = &s3.LifecycleRuleAndOperator{Prefix: rule.Filter.And.Prefix}
+				for _, tag := range rule.Filter.And.Tags {
+					rules[i].Filter.And.Tags = append(rules[i].Filter.And.Tags, *tagXMLToAWS(tag))
+				}
+			}
+		}
+		if rule.Expiration != nil {
+			date, err := parseLifecycleDate(rule.Expiration.Date)
+			if err != nil {
+				return nil, err
+			}
+			rules[i].Expiration = &s3.LifecycleExpiration{
+				Date:                      date,
+				Days:                      rule.Expiration.Days,
+				ExpiredObjectDeleteMarker: rule.Expiration.ExpiredObjectDeleteMarker,
+			}
+		}
+		for _, transition := range rule.Transitions {
+			date, err := parseLifecycleDate(transition.Date)
+			if err != nil {
+				return nil, err
+			}
+			rules[i].Transitions = append(rules[i].Transitions, s3.Transition{
+				Date:         date,
+				Days:         transition.Days,
+				StorageClass: s3.TransitionStorageClass(transition.StorageClass),
+			})
+		}
+		if rule.NoncurrentVersionExpiration != nil {
+			rules[i].NoncurrentVersionExpiration = &s3.NoncurrentVersionExpiration{
+				NoncurrentDays: rule.NoncurrentVersionExpiration.NoncurrentDays,
+			}
+		}
+		for _, transition := range rule.NoncurrentVersionTransitions {
+			rules[i].NoncurrentVersionTransitions = append(rules[i].NoncurrentVersionTransitions, s3.NoncurrentVersionTransition{
+				NoncurrentDays: transition.NoncurrentDays,
+				StorageClass:   s3.TransitionStorageClass(transition.StorageClass),
+			})
+		}
+		if rule.AbortIncompleteMultipartUpload != nil {
+			rules[i].AbortIncompleteMultipartUpload = &s3.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: rule.AbortIncompleteMultipartUpload.DaysAfterInitiation,
+			}
+		}
+	}
+	return rules, nil
+}
+
+// S3 XML for lifecycle rules
+func AWSLifecycleToXML(rules []s3.LifecycleRule) *response_type.LifecycleConfiguration {
+	output := &response_type.LifecycleConfiguration{
+		XmlNS: "http://s3.amazonaws.com/doc/2006-03-01/",
+		Rules: make([]*response_type.LifecycleRule, len(rules)),
+	}
+	for i, rule := range rules {
+		xmlRule := &response_type.LifecycleRule{
+			ID:     rule.ID,
+			Prefix: rule.Prefix,
+			Status: string(rule.Status),
+		}
+		if rule.Filter != nil {
+			xmlRule.Filter = &response_type.LifecycleFilter{Prefix: rule.Filter.Prefix}
+			if rule.Filter.Tag != nil {
+				xmlRule.Filter.Tag = tagAWSToXML(rule.Filter.Tag)
+			}
+			if rule.Filter.And != nil {
+				xmlRule.Filter.And = &response_type.LifecycleAnd{Prefix: rule.Filter.And.Prefix}
+				for _, tag := range rule.Filter.And.Tags {
+					xmlRule.Filter.And.Tags = append(xmlRule.Filter.And.Tags, tagAWSToXML(&tag))
+				}
+			}
+		}
+		if rule.Expiration != nil {
+			xmlRule.Expiration = &response_type.LifecycleExpiration{
+				Date:                      formatLifecycleDate(rule.Expiration.Date),
+				Days:                      rule.Expiration.Days,
+				ExpiredObjectDeleteMarker: rule.Expiration.ExpiredObjectDeleteMarker,
+			}
+		}
+		for _, transition := range rule.Transitions {
+			xmlRule.Transitions = append(xmlRule.Transitions, &response_type.LifecycleTransition{
+				Date:         formatLifecycleDate(transition.Date),
+				Days:         transition.Days,
+				StorageClass: string(transition.StorageClass),
+			})
+		}
+		if rule.NoncurrentVersionExpiration != nil {
+			xmlRule.NoncurrentVersionExpiration = &response_type.NoncurrentVersionExpiration{
+				NoncurrentDays: rule.NoncurrentVersionExpiration.NoncurrentDays,
+			}
+		}
+		for _, transition := range rule.NoncurrentVersionTransitions {
+			xmlRule.NoncurrentVersionTransitions = append(xmlRule.NoncurrentVersionTransitions, &response_type.NoncurrentVersionTransition{
+				NoncurrentDays: transition.NoncurrentDays,
+				StorageClass:   string(transition.StorageClass),
+			})
+		}
+		if rule.AbortIncompleteMultipartUpload != nil {
+			xmlRule.AbortIncompleteMultipartUpload = &response_type.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: rule.AbortIncompleteMultipartUpload.DaysAfterInitiation,
+			}
+		}
+		output.Rules[i] = xmlRule
+	}
+	return output
+}
+
+// Bucket label holding the days after which the sidecar aborts incomplete multipart uploads.  GCS has no rule for
+// them since their parts are ordinary objects
+const AbortMultipartDaysLabel = "abort-incomplete-multipart-days"
+
+// ID of the S3 rule standing for the abort multipart days label
+const abortMultipartRuleID = "abort-incomplete-multipart"
+
+// GCS lifecycle for S3 rules.  GCS rules can only match on age and storage class, so prefix and tag filters,
+// absolute dates, noncurrent version days and delete markers can't be expressed.  Those are returned as a list of
+// what wasn't supported so nothing is applied half way.  Incomplete multipart uploads are left to
+// AWSLifecycleAbortDays.  Disabled rules do nothing and are dropped
+func AWSLifecycleToGCS(rules []s3.LifecycleRule) (*storage.Lifecycle, []string) {
+	lifecycle := &storage.Lifecycle{Rules: make([]storage.LifecycleRule, 0)}
+	unsupported := make([]string, 0)
+	for i, rule := range rules {
+		name := fmt.Sprintf("rule %d", i+1)
+		if rule.ID != nil && *rule.ID != "" {
+			name = "rule " + *rule.ID
+		}
+		report := func(reason string) {
+			unsupported = append(unsupported, name+": "+reason)
+		}
+		if rule.Status != s3.ExpirationStatusEnabled {
+			continue
+		}
+		if rule.Prefix != nil && *rule.Prefix != "" {
+			report("prefix filters are not supported")
+		}
+		if filter := rule.Filter; filter != nil {
+			if (filter.Prefix != nil && *filter.Prefix != "") || filter.And != nil {
+				report("prefix filters are not supported")
+			}
+			if filter.Tag != nil {
+				report("tag filters are not supported")
+			}
+		}
+		if expiration := rule.Expiration; expiration != nil {
+			if expiration.Days != nil {
+				lifecycle.Rules = append(lifecycle.Rules, storage.LifecycleRule{
+					Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+					Condition: storage.LifecycleCondition{AgeInDays: *expiration.Days, Liveness: storage.Live},
+				})
+			}
+			if expiration.Date != nil {
+				report("expiration dates are not supported, use days")
+			}
+			if expiration.ExpiredObjectDeleteMarker != nil && *expiration.ExpiredObjectDeleteMarker {
+				report("expired object delete markers are not supported")
+			}
+		}
+		for _, transition := range rule.Transitions {
+			storageClass, ok := S3StorageClassToGCS(string(transition.StorageClass))
+			if !ok {
+				report("storage class " + string(transition.StorageClass) + " is not supported")
+				continue
+			}
+			if transition.Days == nil {
+				report("transition dates are not supported, use days")
+				continue
+			}
+			lifecycle.Rules = append(lifecycle.Rules, storage.LifecycleRule{
+				Action:    storage.LifecycleAction{Type: storage.SetStorageClassAction, StorageClass: storageClass},
+				Condition: storage.LifecycleCondition{AgeInDays: *transition.Days, Liveness: storage.Live},
+			})
+		}
+		if rule.NoncurrentVersionExpiration != nil || len(rule.NoncurrentVersionTransitions) > 0 {
+			report("noncurrent version actions are not supported")
+		}
+		if abort := rule.AbortIncompleteMultipartUpload; abort != nil && (abort.DaysAfterInitiation == nil || *abort.DaysAfterInitiation <= 0) {
+			report("aborting incomplete multipart uploads needs a positive number of days")
+		}
+	}
+	return lifecycle, unsupported
+}
+
+// Fewest days after initiation any enabled S3 rule aborts incomplete multipart uploads after, 0 if none do
+func AWSLifecycleAbortDays(rules []s3.LifecycleRule) int64 {
+	var days int64
+	for _, rule := range rules {
+		abort := rule.AbortIncompleteMultipartUpload
+		if rule.Status != s3.ExpirationStatusEnabled || abort == nil || abort.DaysAfterInitiation == nil || *abort.DaysAfterInitiation <= 0 {
+			continue
+		}
+		if days == 0 || *abort.DaysAfterInitiation < days {
+			days = *abort.DaysAfterInitiation
+		}
+	}
+	return days
+}
+
+// Days after initiation incomplete multipart uploads to a bucket are aborted after going by its labels, 0 if never
+func GCSLabelsAbortDays(labels map[string]string) int64 {
+	days, err := strconv.ParseInt(labels[AbortMultipartDaysLabel], 10, 64)
+	if err != nil || days <= 0 {
+		return 0
+	}
+	return days
+}
+
+// S3 rule aborting incomplete multipart uploads after days
+func AbortMultipartRuleToAWS(days int64) s3.LifecycleRule {
+	id := abortMultipartRuleID
+	prefix := ""
+	return s3.LifecycleRule{
+		ID:                             &id,
+		Filter:                         &s3.LifecycleRuleFilter{Prefix: &prefix},
+		Status:                         s3.ExpirationStatusEnabled,
+		AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{DaysAfterInitiation: &days},
+	}
+}
+
+// S3 rules for a GCS lifecycle.  Only live object rules conditioned on age have an S3 equivalent, the rest
+// are left out
+func GCSLifecycleToAWS(lifecycle storage.Lifecycle) []s3.LifecycleRule {
+	rules := make([]s3.LifecycleRule, 0, len(lifecycle.Rules))
+	for i, rule := range lifecycle.Rules {
+		condition := rule.Condition
+		if condition.AgeInDays <= 0 || condition.Liveness == storage.Archived || !condition.CreatedBefore.IsZero() ||
+			condition.NumNewerVersions != 0 || len(condition.MatchesStorageClasses) > 0 {
+			continue
+		}
+		id := fmt.Sprintf("gcs-rule-%d", i+1)
+		prefix := ""
+		days := condition.AgeInDays
+		s3Rule := s3.LifecycleRule{
+			ID:     &id,
+			Filter: &s3.LifecycleRuleFilter{Prefix: &prefix},
+			Status: s3.ExpirationStatusEnabled,
+		}
+		switch rule.Action.Type {
+		case storage.DeleteAction:
+			s3Rule.Expiration = &s3.LifecycleExpiration{Days: &days}
+		case storage.SetStorageClassAction:
+			s3Rule.Transitions = []s3.Transition{{
+				Days:         &days,
+				StorageClass: s3.TransitionStorageClass(GCSStorageClassToS3(rule.Action.StorageClass)),
+			}}
+		default:
+			continue
+		}
+		rules = append(rules, s3Rule)
+	}
+	return rules
+}
+
+// CORS rules from S3 XML
+func CORSXMLToAWS(input *response_type.CORSConfiguration) []s3.CORSRule {
+	rules := make([]s3.CORSRule, len(input.Rules))
+	for i, rule := range input.Rules {
+		rules[i] = s3.CORSRule{
+			AllowedHeaders: rule.AllowedHeaders,
+			AllowedMethods: rule.AllowedMethods,
+			AllowedOrigins: rule.AllowedOrigins,
+			ExposeHeaders:  rule.ExposeHeaders,
+			MaxAgeSeconds:  rule.MaxAgeSeconds,
+		}
+	}
+	return rules
+}
+
+// S3 XML for CORS rules
+func AWSCORSToXML(rules []s3.CORSRule) *response_type.CORSConfiguration {
+	output := &response_type.CORSConfiguration{
+		XmlNS: "http://s3.amazonaws.com/doc/2006-03-01/",
+		Rules: make([]*response_type.CORSRule, len(rules)),
+	}
+	for i, rule := range rules {
+		output.Rules[i] = &response_type.CORSRule{
+			AllowedHeaders: rule.AllowedHeaders,
+			AllowedMethods: rule.AllowedMethods,
+			AllowedOrigins: rule.AllowedOrigins,
+			ExposeHeaders:  rule.ExposeHeaders,
+			MaxAgeSeconds:  rule.MaxAgeSeconds,
+		}
+	}
+	return output
+}
+
+// GCS CORS for S3 rules.  GCS has one list of response headers that it both allows and exposes
+func AWSCORSToGCS(rules []s3.CORSRule) []storage.CORS {
+	cors := make([]storage.CORS, len(rules))
+	for i, rule := range rules {
+		headers := make([]string, 0, len(rule.ExposeHeaders)+len(rule.AllowedHeaders))
+		seen := make(map[string]bool)
+		for _, header := range append(append([]string{}, rule.ExposeHeaders...), rule.AllowedHeaders...) {
+			if !seen[header] {
+				seen[header] = true
+				headers = append(headers, header)
+			}
+		}
+		cors[i] = storage.CORS{
+			Methods:         rule.AllowedMethods,
+			Origins:         rule.AllowedOrigins,
+			ResponseHeaders: headers,
+		}
+		if rule.MaxAgeSeconds != nil {
+			cors[i].MaxAge = time.Duration(*rule.MaxAgeSeconds) * time.Second
+		}
+	}
+	return cors
+}
+
+// S3 rules for GCS CORS
+func GCSCORSToAWS(cors []storage.CORS) []s3.CORSRule {
+	rules := make([]s3.CORSRule, len(cors))
+	for i, entry := range cors {
+		rules[i] = s3.CORSRule{
+			AllowedHeaders: entry.ResponseHeaders,
+			AllowedMethods: entry.Methods,
+			AllowedOrigins: entry.Origins,
+			ExposeHeaders:  entry.ResponseHeaders,
+		}
+		if entry.MaxAge > 0 {
+			maxAge := int64(entry.MaxAge / time.Second)
+			rules[i].MaxAgeSeconds = &maxAge
+		}
+	}
+	return rules
+}
+
+func tagXMLToAWS(tag *response_type.Tag) *s3.Tag {
+	key := tag.Key
+	value := tag.Value
+	return &s3.Tag{Key: &key, Value: &value}
+}
+
+func tagAWSToXML(tag *s3.Tag) *response_type.Tag {
+	output := &response_type.Tag{}
+	if tag.Key != nil {
+		output.Key = *tag.Key
+	}
+	if tag.Value != nil {
+		output.Value = *tag.Value
+	}
+	return output
+}
+
+func parseLifecycleDate(date *string) (*time.Time, error) {
+	if date == nil {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, *date)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+func formatLifecycleDate(date *time.Time) *string {
+	if date == nil {
+		return nil
+	}
+	formatted := FormatTimeZulu(date)
+	return &formatted
+}
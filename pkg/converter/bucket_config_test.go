@@ -0,0 +1,101 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/response_type"
+	"encoding/xml"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const lifecycleXML = `<LifecycleConfiguration>
+  <Rule>
+    <ID>archive</ID>
+    <Filter><Prefix></Prefix></Filter>
+    <Status>Enabled</Status>
+    <Transition><Days>30</Days><StorageClass>STANDARD_IA</StorageClass></Transition>
+    <Transition><Days>90</Days><StorageClass>GLACIER</StorageClass></Transition>
+    <Expiration><Days>365</Days></Expiration>
+  </Rule>
+  <Rule>
+    <ID>off</ID>
+    <Status>Disabled</Status>
+    <Expiration><Days>1</Days></Expiration>
+  </Rule>
+</LifecycleConfiguration>`
+
+func TestAWSLifecycleToGCS(t *testing.T) {
+	var configuration response_type.LifecycleConfiguration
+	assert.Nil(t, xml.Unmarshal([]byte(lifecycleXML), &configuration))
+	rules, err := LifecycleXMLToAWS(&configuration)
+	assert.Nil(t, err)
+	lifecycle, unsupported := AWSLifecycleToGCS(rules)
+	assert.Empty(t, unsupported)
+	assert.Len(t, lifecycle.Rules, 3)
+	assert.Equal(t, storage.DeleteAction, lifecycle.Rules[0].Action.Type)
+	assert.Equal(t, int64(365), lifecycle.Rules[0].Condition.AgeInDays)
+	assert.Equal(t, "NEARLINE", lifecycle.Rules[1].Action.StorageClass)
+	assert.Equal(t, "COLDLINE", lifecycle.Rules[2].Action.StorageClass)
+	assert.Equal(t, int64(90), lifecycle.Rules[2].Condition.AgeInDays)
+
+	back := GCSLifecycleToAWS(*lifecycle)
+	assert.Len(t, back, 3)
+	assert.Equal(t, int64(365), *back[0].Expiration.Days)
+	assert.Equal(t, s3.TransitionStorageClassStandardIa, back[1].Transitions[0].StorageClass)
+}
+
+func TestAWSLifecycleToGCS_Unsupported(t *testing.T) {
+	prefix := "logs/"
+	days := int64(7)
+	id := "cleanup"
+	_, unsupported := AWSLifecycleToGCS([]s3.LifecycleRule{{
+		ID:                             &id,
+		Status:                         s3.ExpirationStatusEnabled,
+		Filter:                         &s3.LifecycleRuleFilter{Prefix: &prefix},
+		AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{DaysAfterInitiation: &days},
+	}})
+	assert.Equal(t, []string{
+		"rule cleanup: prefix filters are not supported",
+	}, unsupported)
+}
+
+func TestAWSLifecycleAbortDays(t *testing.T) {
+	week := int64(7)
+	day := int64(1)
+	rules := []s3.LifecycleRule{{
+		Status:                         s3.ExpirationStatusEnabled,
+		AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{DaysAfterInitiation: &week},
+	}, {
+		Status:                         s3.ExpirationStatusDisabled,
+		AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{DaysAfterInitiation: &day},
+	}}
+	lifecycle, unsupported := AWSLifecycleToGCS(rules)
+	assert.Empty(t, unsupported)
+	assert.Empty(t, lifecycle.Rules)
+	assert.Equal(t, int64(7), AWSLifecycleAbortDays(rules))
+	assert.Equal(t, int64(0), AWSLifecycleAbortDays(rules[1:]))
+	assert.Equal(t, int64(7), GCSLabelsAbortDays(map[string]string{AbortMultipartDaysLabel: "7"}))
+	assert.Equal(t, int64(0), GCSLabelsAbortDays(map[string]string{}))
+	rule := AbortMultipartRuleToAWS(7)
+	assert.Equal(t, int64(7), *rule.AbortIncompleteMultipartUpload.DaysAfterInitiation)
+	assert.Equal(t, int64(7), AWSLifecycleAbortDays([]s3.LifecycleRule{rule}))
+}
+
+func TestAWSCORSToGCS(t *testing.T) {
+	maxAge := int64(3000)
+	cors := AWSCORSToGCS([]s3.CORSRule{{
+		AllowedHeaders: []string{"Authorization", "x-amz-meta-a"},
+		AllowedMethods: []string{"GET", "PUT"},
+		AllowedOrigins: []string{"https://example.com"},
+		ExposeHeaders:  []string{"x-amz-meta-a", "ETag"},
+		MaxAgeSeconds:  &maxAge,
+	}})
+	assert.Len(t, cors, 1)
+	assert.Equal(t, []string{"x-amz-meta-a", "ETag", "Authorization"}, cors[0].ResponseHeaders)
+	assert.Equal(t, 3000*time.Second, cors[0].MaxAge)
+	rules := GCSCORSToAWS(cors)
+	assert.Equal(t, maxAge, *rules[0].MaxAgeSeconds)
+	assert.Equal(t, []string{"GET", "PUT"}, rules[0].AllowedMethods)
+}
@@ -0,0 +1,117 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/response_type"
+	"github.com/avast/retry-go"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"google.golang.org/api/googleapi"
+	"net/http"
+	"strings"
+)
+
+// Figure out the S3 error for an error that came from GCS or the AWS SDK.  AWS errors keep their own code,
+// message and request id so clients see exactly what S3 said
+func ErrorToS3(err error) (code response_type.S3ErrorCode, message string, requestId string) {
+	if retryErr, ok := err.(retry.Error); ok && len(retryErr) > 0 {
+		// retries wrap every attempt, the last one is what matters
+		err = retryErr[len(retryErr)-1]
+	}
+	if err == nil {
+		return response_type.S3InternalError, response_type.S3InternalError.Message, ""
+	}
+	if err == storage.ErrObjectNotExist {
+		return response_type.S3NoSuchKey, response_type.S3NoSuchKey.Message, ""
+	}
+	if err == storage.ErrBucketNotExist {
+		return response_type.S3NoSuchBucket, response_type.S3NoSuchBucket.Message, ""
+	}
+	if gcsErr, ok := err.(*googleapi.Error); ok {
+		code = GCSStatusToS3(gcsErr.Code, gcsErr.Message)
+		return code, code.Message, ""
+	}
+	if awsErr, ok := err.(awserr.RequestFailure); ok {
+		code = awsCodeToS3(awsErr.Code(), awsErr.StatusCode())
+		message = awsErr.Message()
+		if message == "" {
+			message = code.Message
+		}
+		return code, message, awsErr.RequestID()
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		code = awsCodeToS3(awsErr.Code(), 0)
+		message = awsErr.Message()
+		if message == "" {
+			message = code.Message
+		}
+		return code, message, ""
+	}
+	return response_type.S3InternalError, err.Error(), ""
+}
+
+// Map a GCS http status to the closest S3 error
+func GCSStatusToS3(status int, message string) response_type.S3ErrorCode {
+	lowerMessage := strings.ToLower(message)
+	switch status {
+	case http.StatusNotModified:
+		return response_type.S3NotModified
+	case http.StatusBadRequest:
+		if strings.Contains(lowerMessage, "storage class") {
+			return response_type.S3InvalidStorageClass
+		}
+		if strings.Contains(lowerMessage, "match calculated") {
+			// the md5 or crc32c sent with the upload was wrong
+			return response_type.S3BadDigest
+		}
+		return response_type.S3InvalidArgument
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return response_type.S3AccessDenied
+	case http.StatusNotFound:
+		if strings.Contains(lowerMessage, "bucket") {
+			return response_type.S3NoSuchBucket
+		}
+		return response_type.S3NoSuchKey
+	case http.StatusConflict:
+		if strings.Contains(lowerMessage, "not empty") {
+			return response_type.S3BucketNotEmpty
+		}
+		if strings.Contains(lowerMessage, "already") || strings.Contains(lowerMessage, "not available") {
+			return response_type.S3BucketAlreadyExists
+		}
+		return response_type.S3OperationAborted
+	case http.StatusPreconditionFailed:
+		return response_type.S3PreconditionFailed
+	case http.StatusRequestedRangeNotSatisfiable:
+		return response_type.S3InvalidRange
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return response_type.S3SlowDown
+	case http.StatusNotImplemented:
+		return response_type.S3NotImplemented
+	}
+	return response_type.S3InternalError
+}
+
+// Map an AWS error code to a known S3 error.  HEAD requests come back with generic codes that need translating
+func awsCodeToS3(code string, status int) response_type.S3ErrorCode {
+	switch code {
+	case "NotFound":
+		return response_type.S3NoSuchKey
+	case "NotModified":
+		return response_type.S3NotModified
+	case "Forbidden":
+		return response_type.S3AccessDenied
+	}
+	if known, ok := response_type.LookupS3ErrorCode(code); ok {
+		if status != 0 {
+			known.StatusCode = status
+		}
+		return known
+	}
+	if code == "" {
+		code = response_type.S3InternalError.Code
+	}
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	return response_type.S3ErrorCode{Code: code, StatusCode: status}
+}
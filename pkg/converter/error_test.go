@@ -0,0 +1,44 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/response_type"
+	"errors"
+	"github.com/avast/retry-go"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+	"testing"
+)
+
+func TestErrorToS3(t *testing.T) {
+	code, _, _ := ErrorToS3(storage.ErrObjectNotExist)
+	assert.Equal(t, response_type.S3NoSuchKey, code)
+	code, _, _ = ErrorToS3(storage.ErrBucketNotExist)
+	assert.Equal(t, response_type.S3NoSuchBucket, code)
+	code, _, _ = ErrorToS3(&googleapi.Error{Code: 412, Message: "Precondition Failed"})
+	assert.Equal(t, response_type.S3PreconditionFailed, code)
+	code, _, _ = ErrorToS3(&googleapi.Error{Code: 429, Message: "rate limited"})
+	assert.Equal(t, response_type.S3SlowDown, code)
+	code, _, _ = ErrorToS3(retry.Error{errors.New("first"), &googleapi.Error{Code: 403}})
+	assert.Equal(t, response_type.S3AccessDenied, code)
+
+	awsErr := awserr.NewRequestFailure(awserr.New("NoSuchKey", "The key is gone", nil), 404, "ABC123")
+	code, message, requestId := ErrorToS3(awsErr)
+	assert.Equal(t, response_type.S3NoSuchKey, code)
+	assert.Equal(t, "The key is gone", message)
+	assert.Equal(t, "ABC123", requestId)
+
+	code, message, _ = ErrorToS3(errors.New("boom"))
+	assert.Equal(t, response_type.S3InternalError, code)
+	assert.Equal(t, "boom", message)
+}
+
+func TestGCSStatusToS3(t *testing.T) {
+	assert.Equal(t, response_type.S3NoSuchBucket, GCSStatusToS3(404, "The specified bucket does not exist."))
+	assert.Equal(t, response_type.S3NoSuchKey, GCSStatusToS3(404, "No such object: meow/bleh"))
+	assert.Equal(t, response_type.S3BucketNotEmpty, GCSStatusToS3(409, "The bucket you tried to delete is not empty."))
+	assert.Equal(t, response_type.S3InvalidRange, GCSStatusToS3(416, ""))
+	assert.Equal(t, response_type.S3BadDigest, GCSStatusToS3(400, "Provided CRC32C \"AAAAAA==\" doesn't match calculated CRC32C \"yZRlqg==\"."))
+	assert.Equal(t, response_type.S3InternalError, GCSStatusToS3(500, ""))
+}
@@ -0,0 +1,227 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	storage2 "google.golang.org/api/storage/v1"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Closest AWS region to each GCS location
+var gcsLocationToRegion = map[string]string{
+	"US":                      "us-east-1",
+	"EU":                      "eu-west-1",
+	"ASIA":                    "ap-northeast-1",
+	"NAM4":                    "us-east-1",
+	"EUR4":                    "eu-west-1",
+	"US-EAST1":                "us-east-1",
+	"US-EAST4":                "us-east-1",
+	"US-EAST5":                "us-east-2",
+	"US-CENTRAL1":             "us-east-2",
+	"US-WEST1":                "us-west-2",
+	"US-WEST2":                "us-west-1",
+	"NORTHAMERICA-NORTHEAST1": "ca-central-1",
+	"SOUTHAMERICA-EAST1":      "sa-east-1",
+	"EUROPE-WEST1":            "eu-west-1",
+	"EUROPE-WEST2":            "eu-west-2",
+	"EUROPE-WEST3":            "eu-central-1",
+	"EUROPE-WEST9":            "eu-west-3",
+	"EUROPE-NORTH1":           "eu-north-1",
+	"ASIA-NORTHEAST1":         "ap-northeast-1",
+	"ASIA-NORTHEAST3":         "ap-northeast-2",
+	"ASIA-SOUTHEAST1":         "ap-southeast-1",
+	"ASIA-SOUTH1":             "ap-south-1",
+	"AUSTRALIA-SOUTHEAST1":    "ap-southeast-2",
+}
+
+// Closest GCS location to each AWS region
+var regionToGCSLocation = map[string]string{
+	"us-east-1":      "US-EAST4",
+	"us-east-2":      "US-EAST5",
+	"us-west-1":      "US-WEST2",
+	"us-west-2":      "US-WEST1",
+	"ca-central-1":   "NORTHAMERICA-NORTHEAST1",
+	"sa-east-1":      "SOUTHAMERICA-EAST1",
+	"eu-west-1":      "EUROPE-WEST1",
+	"eu-west-2":      "EUROPE-WEST2",
+	"eu-west-3":      "EUROPE-WEST9",
+	"eu-central-1":   "EUROPE-WEST3",
+	"eu-north-1":     "EUROPE-NORTH1",
+	"ap-northeast-1": "ASIA-NORTHEAST1",
+	"ap-northeast-2": "ASIA-NORTHEAST3",
+	"ap-southeast-1": "ASIA-SOUTHEAST1",
+	"ap-southeast-2": "AUSTRALIA-SOUTHEAST1",
+	"ap-south-1":     "ASIA-SOUTH1",
+}
+
+// GCS locations spanning more than one region, anything else is a single region
+var gcsLocationTypes = map[string]string{
+	"US":    "multi-region",
+	"EU":    "multi-region",
+	"ASIA":  "multi-region",
+	"NAM4":  "dual-region",
+	"EUR4":  "dual-region",
+	"ASIA1": "dual-region",
+}
+
+// GCS location type for a location
+func GCSLocationType(location string) string {
+	if location == "" {
+		return ""
+	}
+	if locationType, ok := gcsLocationTypes[strings.ToUpper(location)]; ok {
+		return locationType
+	}
+	return "region"
+}
+
+// Region S3 uses for buckets created without a location constraint
+const defaultRegion = "us-east-1"
+
+// Location translation in both directions, the defaults with any configured overrides
+type LocationMap struct {
+	toRegion   map[string]string
+	toLocation map[string]string
+}
+
+// Mapping with overrides from GCS locations to AWS regions and from AWS regions to GCS locations
+func NewLocationMap(toRegion map[string]string, toLocation map[string]string) *LocationMap {
+	locations := &LocationMap{toRegion: make(map[string]string), toLocation: make(map[string]string)}
+	for location, region := range gcsLocationToRegion {
+		locations.toRegion[location] = region
+	}
+	for region, location := range regionToGCSLocation {
+		locations.toLocation[region] = location
+	}
+	for location, region := range toRegion {
+		locations.toRegion[strings.ToUpper(location)] = strings.ToLower(region)
+	}
+	for region, location := range toLocation {
+		locations.toLocation[strings.ToLower(region)] = strings.ToUpper(location)
+	}
+	return locations
+}
+
+// The mapping with no overrides
+var DefaultLocations = NewLocationMap(nil, nil)
+
+// AWS region for a GCS location.  Unknown locations are passed through in case they already name a region
+func (locations *LocationMap) ToRegion(location string) string {
+	if location == "" {
+		return defaultRegion
+	}
+	if region, ok := locations.toRegion[strings.ToUpper(location)]; ok {
+		return region
+	}
+	return strings.ToLower(location)
+}
+
+// GCS location for an AWS region, where an empty region is the one S3 defaults to
+func (locations *LocationMap) ToLocation(region string) string {
+	if region == "" {
+		region = defaultRegion
+	}
+	if location, ok := locations.toLocation[strings.ToLower(region)]; ok {
+		return location
+	}
+	return strings.ToUpper(region)
+}
+
+// S3 tags for GCS labels, sorted by key
+func LabelsToTags(labels map[string]string) []s3.Tag {
+	tags := make([]s3.Tag, 0, len(labels))
+	for key, value := range labels {
+		key, value := key, value
+		tags = append(tags, s3.Tag{Key: &key, Value: &value})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return *tags[i].Key < *tags[j].Key
+	})
+	return tags
+}
+
+// GCS labels for S3 tags.  Labels are stricter than tags, so characters they can't hold become underscores
+func TagsToLabels(tags []s3.Tag) map[string]string {
+	labels := make(map[string]string)
+	for _, tag := range tags {
+		if tag.Key == nil {
+			continue
+		}
+		var value string
+		if tag.Value != nil {
+			value = *tag.Value
+		}
+		labels[labelString(*tag.Key)] = labelString(value)
+	}
+	return labels
+}
+
+// Lower case letters, digits, dashes and underscores, at most 63 of them
+func labelString(input string) string {
+	var builder strings.Builder
+	for _, r := range strings.ToLower(input) {
+		if builder.Len() >= 63 {
+			break
+		}
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			builder.WriteRune(r)
+		} else {
+			builder.WriteRune('_')
+		}
+	}
+	return builder.String()
+}
+
+// GCS JSON API bucket resource for GCS attributes
+func GCSBucketAttrsToBucket(attrs *storage.BucketAttrs) *storage2.Bucket {
+	bucket := &storage2.Bucket{
+		Kind:           "storage#bucket",
+		Id:             attrs.Name,
+		Name:           attrs.Name,
+		Location:       attrs.Location,
+		LocationType:   GCSLocationType(attrs.Location),
+		StorageClass:   attrs.StorageClass,
+		Labels:         attrs.Labels,
+		Metageneration: attrs.MetaGeneration,
+		Etag:           GenerationEtag(attrs.MetaGeneration),
+		SelfLink:       bucketSelfLink(attrs.Name),
+		Versioning:     &storage2.BucketVersioning{Enabled: attrs.VersioningEnabled},
+	}
+	if !attrs.Created.IsZero() {
+		bucket.TimeCreated = attrs.Created.UTC().Format(time.RFC3339Nano)
+		bucket.Updated = bucket.TimeCreated
+	}
+	return bucket
+}
+
+// GCS JSON API bucket resource for what S3 tells about a bucket.  S3 buckets have no default storage class
+func AWSBucketToGCSBucket(name string, location string, tags []s3.Tag, versioning s3.BucketVersioningStatus, created *time.Time) *storage2.Bucket {
+	bucket := &storage2.Bucket{
+		Kind:           "storage#bucket",
+		Id:             name,
+		Name:           name,
+		Location:       location,
+		StorageClass:   "STANDARD",
+		Metageneration: 1,
+		SelfLink:       bucketSelfLink(name),
+		Versioning:     &storage2.BucketVersioning{Enabled: versioning == s3.BucketVersioningStatusEnabled},
+	}
+	if location != "" {
+		// S3 locations are all single regions
+		bucket.LocationType = "region"
+	}
+	if len(tags) > 0 {
+		bucket.Labels = TagsToLabels(tags)
+	}
+	if created != nil {
+		bucket.TimeCreated = created.UTC().Format(time.RFC3339Nano)
+		bucket.Updated = bucket.TimeCreated
+	}
+	return bucket
+}
+
+func bucketSelfLink(bucket string) string {
+	return "https://www.googleapis.com/storage/v1/b/" + bucket
+}
@@ -0,0 +1,81 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLocationMap(t *testing.T) {
+	assert.Equal(t, "us-east-1", DefaultLocations.ToRegion(""))
+	assert.Equal(t, "eu-west-1", DefaultLocations.ToRegion("eu"))
+	assert.Equal(t, "ap-south-1", DefaultLocations.ToRegion("ASIA-SOUTH1"))
+	// unknown locations may already be regions
+	assert.Equal(t, "me-south-1", DefaultLocations.ToRegion("ME-SOUTH-1"))
+	assert.Equal(t, "US-EAST4", DefaultLocations.ToLocation(""))
+	assert.Equal(t, "EUROPE-WEST3", DefaultLocations.ToLocation("eu-central-1"))
+
+	locations := NewLocationMap(map[string]string{"us": "US-EAST-2"}, map[string]string{"US-EAST-2": "us-central1"})
+	assert.Equal(t, "us-east-2", locations.ToRegion("US"))
+	assert.Equal(t, "US-CENTRAL1", locations.ToLocation("us-east-2"))
+	assert.Equal(t, "eu-west-1", locations.ToRegion("EU"))
+	// overrides don't leak into the defaults
+	assert.Equal(t, "us-east-1", DefaultLocations.ToRegion("US"))
+}
+
+func TestGCSLocationType(t *testing.T) {
+	assert.Equal(t, "multi-region", GCSLocationType("US"))
+	assert.Equal(t, "dual-region", GCSLocationType("nam4"))
+	assert.Equal(t, "region", GCSLocationType("US-EAST1"))
+	assert.Equal(t, "", GCSLocationType(""))
+}
+
+func TestLabelsAndTags(t *testing.T) {
+	tags := LabelsToTags(map[string]string{"team": "storage", "env": "prod"})
+	assert.Len(t, tags, 2)
+	assert.Equal(t, "env", *tags[0].Key)
+	assert.Equal(t, "prod", *tags[0].Value)
+	assert.Equal(t, "team", *tags[1].Key)
+
+	key, value, other := "Cost Center", "R&D", "aws:createdBy"
+	labels := TagsToLabels([]s3.Tag{{Key: &key, Value: &value}, {Key: &other}})
+	assert.Equal(t, map[string]string{"cost_center": "r_d", "aws_createdby": ""}, labels)
+}
+
+func TestAWSBucketToGCSBucket(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	key, value := "env", "prod"
+	bucket := AWSBucketToGCSBucket("bucket", "EUROPE-WEST1", []s3.Tag{{Key: &key, Value: &value}}, s3.BucketVersioningStatusEnabled, &created)
+	assert.Equal(t, "storage#bucket", bucket.Kind)
+	assert.Equal(t, "EUROPE-WEST1", bucket.Location)
+	assert.Equal(t, "region", bucket.LocationType)
+	assert.Equal(t, map[string]string{"env": "prod"}, bucket.Labels)
+	assert.True(t, bucket.Versioning.Enabled)
+	assert.Equal(t, "2020-01-02T03:04:05Z", bucket.TimeCreated)
+	assert.Equal(t, "https://www.googleapis.com/storage/v1/b/bucket", bucket.SelfLink)
+
+	bucket = AWSBucketToGCSBucket("bucket", "", nil, s3.BucketVersioningStatusSuspended, nil)
+	assert.Equal(t, "", bucket.LocationType)
+	assert.Nil(t, bucket.Labels)
+	assert.False(t, bucket.Versioning.Enabled)
+}
+
+func TestGCSBucketAttrsToBucket(t *testing.T) {
+	bucket := GCSBucketAttrsToBucket(&storage.BucketAttrs{
+		Name:              "bucket",
+		Location:          "US",
+		StorageClass:      "NEARLINE",
+		MetaGeneration:    1,
+		Labels:            map[string]string{"a": "b"},
+		VersioningEnabled: true,
+		Created:           time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	assert.Equal(t, "bucket", bucket.Id)
+	assert.Equal(t, "multi-region", bucket.LocationType)
+	assert.Equal(t, "CAE=", bucket.Etag)
+	assert.Equal(t, "NEARLINE", bucket.StorageClass)
+	assert.True(t, bucket.Versioning.Enabled)
+	assert.Equal(t, "2020-01-02T03:04:05Z", bucket.TimeCreated)
+}
@@ -0,0 +1,203 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	storage2 "google.golang.org/api/storage/v1"
+	"strings"
+	"time"
+)
+
+// Event types a notification config gets when it names none
+var allGCSEventTypes = []string{
+	storage.ObjectFinalizeEvent,
+	storage.ObjectMetadataUpdateEvent,
+	storage.ObjectDeleteEvent,
+	storage.ObjectArchiveEvent,
+}
+
+// S3 events for the GCS event types S3 has a match for
+var gcsEventToS3 = map[string]s3.Event{
+	storage.ObjectFinalizeEvent: s3.EventS3ObjectCreated,
+	storage.ObjectDeleteEvent:   s3.EventS3ObjectRemoved,
+}
+
+// Project and topic id from a notification topic, either //pubsub.googleapis.com/projects/p/topics/t or
+// projects/p/topics/t
+func ParsePubSubTopic(topic string) (string, string, error) {
+	pieces := strings.Split(strings.TrimPrefix(topic, "//pubsub.googleapis.com/"), "/")
+	if len(pieces) != 4 || pieces[0] != "projects" || pieces[2] != "topics" || pieces[1] == "" || pieces[3] == "" {
+		return "", "", errors.New("invalid topic " + topic)
+	}
+	return pieces[1], pieces[3], nil
+}
+
+// Topic name in the form notification resources use
+func PubSubTopicName(project string, topic string) string {
+	return fmt.Sprintf("//pubsub.googleapis.com/projects/%s/topics/%s", project, topic)
+}
+
+// Notification config resource, with the defaults GCS fills in
+func NewNotification(bucket string, input *storage2.Notification) *storage2.Notification {
+	notification := *input
+	notification.Kind = "storage#notification"
+	if notification.PayloadFormat == "" {
+		notification.PayloadFormat = storage.JSONPayload
+	}
+	if notification.Id != "" {
+		notification.SelfLink = notificationSelfLink(bucket, notification.Id)
+	}
+	return &notification
+}
+
+// GCS JSON API notification resource for a GCS notification
+func GCSNotificationToResource(bucket string, input *storage.Notification) *storage2.Notification {
+	return NewNotification(bucket, &storage2.Notification{
+		Id:               input.ID,
+		Topic:            PubSubTopicName(input.TopicProjectID, input.TopicID),
+		EventTypes:       input.EventTypes,
+		ObjectNamePrefix: input.ObjectNamePrefix,
+		CustomAttributes: input.CustomAttributes,
+		PayloadFormat:    input.PayloadFormat,
+	})
+}
+
+// GCS notification for a GCS JSON API notification resource
+func ResourceToGCSNotification(input *storage2.Notification) (*storage.Notification, error) {
+	project, topic, err := ParsePubSubTopic(input.Topic)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.Notification{
+		TopicProjectID:   project,
+		TopicID:          topic,
+		EventTypes:       input.EventTypes,
+		ObjectNamePrefix: input.ObjectNamePrefix,
+		CustomAttributes: input.CustomAttributes,
+		PayloadFormat:    input.PayloadFormat,
+	}, nil
+}
+
+// S3 events for a notification's event types.  Not ok when S3 has nothing to send for some of them
+func GCSEventTypesToS3(eventTypes []string) ([]s3.Event, bool) {
+	if len(eventTypes) == 0 {
+		eventTypes = allGCSEventTypes
+	}
+	events := make([]s3.Event, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		event, ok := gcsEventToS3[eventType]
+		if !ok {
+			return nil, false
+		}
+		events = append(events, event)
+	}
+	return events, true
+}
+
+// GCS event types for S3 events, leaving out ones with no match
+func S3EventsToGCS(events []s3.Event) []string {
+	eventTypes := make([]string, 0, len(events))
+	seen := make(map[string]bool)
+	for _, event := range events {
+		var eventType string
+		if strings.HasPrefix(string(event), "s3:ObjectCreated:") {
+			eventType = storage.ObjectFinalizeEvent
+		} else if strings.HasPrefix(string(event), "s3:ObjectRemoved:") {
+			eventType = storage.ObjectDeleteEvent
+		}
+		if eventType != "" && !seen[eventType] {
+			seen[eventType] = true
+			eventTypes = append(eventTypes, eventType)
+		}
+	}
+	return eventTypes
+}
+
+// S3 key filter for an object name prefix
+func PrefixToS3Filter(prefix string) *s3.NotificationConfigurationFilter {
+	if prefix == "" {
+		return nil
+	}
+	return &s3.NotificationConfigurationFilter{
+		Key: &s3.KeyFilter{FilterRules: []s3.FilterRule{{Name: s3.FilterRuleNamePrefix, Value: &prefix}}},
+	}
+}
+
+// Object name prefix of an S3 key filter
+func S3FilterToPrefix(filter *s3.NotificationConfigurationFilter) string {
+	if filter == nil || filter.Key == nil {
+		return ""
+	}
+	for _, rule := range filter.Key.FilterRules {
+		if strings.EqualFold(string(rule.Name), string(s3.FilterRuleNamePrefix)) && rule.Value != nil {
+			return *rule.Value
+		}
+	}
+	return ""
+}
+
+// Whether a notification config wants an event for an object
+func NotificationMatches(notification *storage2.Notification, eventType string, name string) bool {
+	if !strings.HasPrefix(name, notification.ObjectNamePrefix) {
+		return false
+	}
+	if len(notification.EventTypes) == 0 {
+		return true
+	}
+	for _, wanted := range notification.EventTypes {
+		if wanted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Data and attributes of the Pub/Sub message GCS sends a notification config for an object event
+func NotificationMessage(notification *storage2.Notification, eventType string, object *storage2.Object, now time.Time) ([]byte, map[string]string, error) {
+	attributes := make(map[string]string)
+	for k, v := range notification.CustomAttributes {
+		attributes[k] = v
+	}
+	attributes["notificationConfig"] = fmt.Sprintf("projects/_/buckets/%s/notificationConfigs/%s", object.Bucket, notification.Id)
+	attributes["eventType"] = eventType
+	attributes["payloadFormat"] = notification.PayloadFormat
+	attributes["bucketId"] = object.Bucket
+	attributes["objectId"] = object.Name
+	attributes["objectGeneration"] = fmt.Sprint(object.Generation)
+	attributes["eventTime"] = now.UTC().Format(time.RFC3339Nano)
+	if notification.PayloadFormat != storage.JSONPayload {
+		return nil, attributes, nil
+	}
+	data, err := json.Marshal(object)
+	return data, attributes, err
+}
+
+// A Pub/Sub message as the Pub/Sub REST API writes it, used as the body of messages carried by SNS and SQS
+type PubSubMessage struct {
+	// base64 encoded
+	Data        string            `json:"data,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	MessageId   string            `json:"messageId,omitempty"`
+	PublishTime string            `json:"publishTime,omitempty"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+}
+
+// SNS or SQS message body holding a Pub/Sub message
+func EncodePubSubMessage(data []byte, attributes map[string]string, id string, published time.Time) string {
+	message := PubSubMessage{
+		Data:        base64.StdEncoding.EncodeToString(data),
+		Attributes:  attributes,
+		MessageId:   id,
+		PublishTime: published.UTC().Format(time.RFC3339Nano),
+	}
+	output, _ := json.Marshal(message)
+	return string(output)
+}
+
+func notificationSelfLink(bucket string, id string) string {
+	return fmt.Sprintf("https://www.googleapis.com/storage/v1/b/%s/notificationConfigs/%s", bucket, id)
+}
@@ -0,0 +1,79 @@
+package converter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	storage2 "google.golang.org/api/storage/v1"
+	"testing"
+	"time"
+)
+
+func TestParsePubSubTopic(t *testing.T) {
+	project, topic, err := ParsePubSubTopic("//pubsub.googleapis.com/projects/p/topics/uploads")
+	assert.Nil(t, err)
+	assert.Equal(t, "p", project)
+	assert.Equal(t, "uploads", topic)
+	project, topic, err = ParsePubSubTopic("projects/p/topics/uploads")
+	assert.Nil(t, err)
+	assert.Equal(t, "//pubsub.googleapis.com/projects/p/topics/uploads", PubSubTopicName(project, topic))
+	_, _, err = ParsePubSubTopic("uploads")
+	assert.NotNil(t, err)
+}
+
+func TestGCSEventTypesToS3(t *testing.T) {
+	events, ok := GCSEventTypesToS3([]string{"OBJECT_FINALIZE", "OBJECT_DELETE"})
+	assert.True(t, ok)
+	assert.Equal(t, []s3.Event{s3.EventS3ObjectCreated, s3.EventS3ObjectRemoved}, events)
+	// S3 has nothing for metadata updates, and no event types means all of them
+	_, ok = GCSEventTypesToS3([]string{"OBJECT_METADATA_UPDATE"})
+	assert.False(t, ok)
+	_, ok = GCSEventTypesToS3(nil)
+	assert.False(t, ok)
+
+	assert.Equal(t, []string{"OBJECT_FINALIZE", "OBJECT_DELETE"}, S3EventsToGCS([]s3.Event{
+		s3.EventS3ObjectCreatedPut, s3.EventS3ObjectCreatedCopy, s3.EventS3ObjectRemoved, s3.EventS3ObjectRestorePost,
+	}))
+}
+
+func TestS3Filter(t *testing.T) {
+	assert.Nil(t, PrefixToS3Filter(""))
+	assert.Equal(t, "", S3FilterToPrefix(nil))
+	assert.Equal(t, "uploads/", S3FilterToPrefix(PrefixToS3Filter("uploads/")))
+}
+
+func TestNotificationMessage(t *testing.T) {
+	notification := NewNotification("bucket", &storage2.Notification{
+		Id:               "1",
+		ObjectNamePrefix: "uploads/",
+		EventTypes:       []string{"OBJECT_FINALIZE"},
+		CustomAttributes: map[string]string{"team": "data"},
+	})
+	assert.Equal(t, "JSON_API_V1", notification.PayloadFormat)
+	assert.Equal(t, "https://www.googleapis.com/storage/v1/b/bucket/notificationConfigs/1", notification.SelfLink)
+	assert.True(t, NotificationMatches(notification, "OBJECT_FINALIZE", "uploads/a"))
+	assert.False(t, NotificationMatches(notification, "OBJECT_DELETE", "uploads/a"))
+	assert.False(t, NotificationMatches(notification, "OBJECT_FINALIZE", "other/a"))
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	object := &storage2.Object{Bucket: "bucket", Name: "uploads/a", Generation: 7}
+	data, attributes, err := NotificationMessage(notification, "OBJECT_FINALIZE", object, now)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"name":"uploads/a"`)
+	assert.Equal(t, "projects/_/buckets/bucket/notificationConfigs/1", attributes["notificationConfig"])
+	assert.Equal(t, "7", attributes["objectGeneration"])
+	assert.Equal(t, "data", attributes["team"])
+	assert.Equal(t, "2020-01-02T03:04:05Z", attributes["eventTime"])
+
+	notification.PayloadFormat = "NONE"
+	data, _, _ = NotificationMessage(notification, "OBJECT_FINALIZE", object, now)
+	assert.Nil(t, data)
+
+	var message PubSubMessage
+	assert.Nil(t, json.Unmarshal([]byte(EncodePubSubMessage([]byte("hi"), attributes, "m1", now)), &message))
+	decoded, _ := base64.StdEncoding.DecodeString(message.Data)
+	assert.Equal(t, "hi", string(decoded))
+	assert.Equal(t, "m1", message.MessageId)
+	assert.Equal(t, "OBJECT_FINALIZE", message.Attributes["eventType"])
+}
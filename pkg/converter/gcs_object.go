@@ -0,0 +1,288 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	storage2 "google.golang.org/api/storage/v1"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// GCS JSON API object resource for GCS attributes
+func GCSAttrsToObject(attrs *storage.ObjectAttrs) *storage2.Object {
+	object := &storage2.Object{
+		Kind:               "storage#object",
+		Id:                 fmt.Sprintf("%s/%s/%d", attrs.Bucket, attrs.Name, attrs.Generation),
+		Name:               attrs.Name,
+		Bucket:             attrs.Bucket,
+		Size:               uint64(attrs.Size),
+		ContentType:        attrs.ContentType,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentLanguage:    attrs.ContentLanguage,
+		CacheControl:       attrs.CacheControl,
+		Metadata:           attrs.Metadata,
+		Etag:               GenerationEtag(attrs.Generation, attrs.Metageneration),
+		Generation:         attrs.Generation,
+		Metageneration:     attrs.Metageneration,
+		StorageClass:       attrs.StorageClass,
+		KmsKeyName:         attrs.KMSKeyName,
+		TemporaryHold:      attrs.TemporaryHold,
+		EventBasedHold:     attrs.EventBasedHold,
+		MediaLink:          attrs.MediaLink,
+		SelfLink:           objectSelfLink(attrs.Bucket, attrs.Name),
+		ForceSendFields:    []string{"Size"},
+	}
+	if len(attrs.MD5) > 0 {
+		object.Md5Hash = base64.StdEncoding.EncodeToString(attrs.MD5)
+	}
+	if attrs.CRC32C != 0 {
+		crc := make([]byte, 4)
+		binary.BigEndian.PutUint32(crc, attrs.CRC32C)
+		object.Crc32c = base64.StdEncoding.EncodeToString(crc)
+	}
+	if !attrs.Created.IsZero() {
+		object.TimeCreated = attrs.Created.UTC().Format(time.RFC3339Nano)
+	}
+	if !attrs.Updated.IsZero() {
+		object.Updated = attrs.Updated.UTC().Format(time.RFC3339Nano)
+	}
+	if !attrs.RetentionExpirationTime.IsZero() {
+		object.RetentionExpirationTime = attrs.RetentionExpirationTime.UTC().Format(time.RFC3339Nano)
+	}
+	return object
+}
+
+// GCS attributes for a GCS JSON API object resource, the fields listings use
+func GCSObjectToAttrs(object *storage2.Object) *storage.ObjectAttrs {
+	attrs := &storage.ObjectAttrs{
+		Bucket:         object.Bucket,
+		Name:           object.Name,
+		ContentType:    object.ContentType,
+		Size:           int64(object.Size),
+		Generation:     object.Generation,
+		Metageneration: object.Metageneration,
+		StorageClass:   object.StorageClass,
+		Metadata:       object.Metadata,
+	}
+	if object.Owner != nil {
+		attrs.Owner = object.Owner.Entity
+	}
+	attrs.MD5, _ = base64.StdEncoding.DecodeString(object.Md5Hash)
+	attrs.Created, _ = time.Parse(time.RFC3339Nano, object.TimeCreated)
+	attrs.Updated, _ = time.Parse(time.RFC3339Nano, object.Updated)
+	attrs.Deleted, _ = time.Parse(time.RFC3339Nano, object.TimeDeleted)
+	return attrs
+}
+
+// GCS style resource etag, the protobuf encoding of a resource's generation numbers.  The pinned storage client
+// does not hand back the etag GCS sends, so it is rebuilt from what that etag is made of
+func GenerationEtag(generations ...int64) string {
+	etag := make([]byte, 0, len(generations)*(binary.MaxVarintLen64+1))
+	varint := make([]byte, binary.MaxVarintLen64)
+	for i, generation := range generations {
+		etag = append(etag, byte(i+1)<<3)
+		etag = append(etag, varint[:binary.PutUvarint(varint, uint64(generation))]...)
+	}
+	return base64.StdEncoding.EncodeToString(etag)
+}
+
+// GCS JSON API object resource for an S3 HEAD.  S3 has no generations, so the last modified time stands in for one
+func AWSHeadToGCSObject(bucket string, key string, head *s3.HeadObjectOutput) *storage2.Object {
+	object := awsObjectToGCS(bucket, key, head.ContentLength, head.ETag, head.LastModified, string(head.StorageClass))
+	object.Metadata = head.Metadata
+	if head.ContentType != nil {
+		object.ContentType = *head.ContentType
+	}
+	if head.ContentEncoding != nil {
+		object.ContentEncoding = *head.ContentEncoding
+	}
+	if head.ContentDisposition != nil {
+		object.ContentDisposition = *head.ContentDisposition
+	}
+	if head.ContentLanguage != nil {
+		object.ContentLanguage = *head.ContentLanguage
+	}
+	if head.CacheControl != nil {
+		object.CacheControl = *head.CacheControl
+	}
+	return object
+}
+
+// GCS JSON API object resource for an S3 listing entry
+func AWSListObjectToGCSObject(bucket string, input s3.Object) *storage2.Object {
+	return awsObjectToGCS(bucket, *input.Key, input.Size, input.ETag, input.LastModified, string(input.StorageClass))
+}
+
+// GCS JSON API object resource for an S3 object version
+func AWSObjectVersionToGCSObject(bucket string, input s3.ObjectVersion) *storage2.Object {
+	return awsObjectToGCS(bucket, *input.Key, input.Size, input.ETag, input.LastModified, string(input.StorageClass))
+}
+
+func awsObjectToGCS(bucket string, key string, size *int64, etag *string, modified *time.Time, storageClass string) *storage2.Object {
+	object := &storage2.Object{
+		Kind:            "storage#object",
+		Name:            key,
+		Bucket:          bucket,
+		Metageneration:  1,
+		SelfLink:        objectSelfLink(bucket, key),
+		ForceSendFields: []string{"Size"},
+	}
+	if size != nil {
+		object.Size = uint64(*size)
+	}
+	if etag != nil {
+		object.Etag = strings.Trim(*etag, "\"")
+		// multipart ETags aren't an MD5 of the object
+		if md5, err := hex.DecodeString(object.Etag); err == nil && len(md5) == 16 {
+			object.Md5Hash = base64.StdEncoding.EncodeToString(md5)
+		}
+	}
+	if modified != nil {
+		object.Generation = modified.UnixNano() / int64(time.Microsecond)
+		object.TimeCreated = modified.UTC().Format(time.RFC3339Nano)
+		object.Updated = object.TimeCreated
+	}
+	object.StorageClass = "STANDARD"
+	if gcsClass, ok := S3StorageClassToGCS(storageClass); ok && storageClass != "" {
+		object.StorageClass = gcsClass
+	}
+	object.Id = fmt.Sprintf("%s/%s/%d", bucket, key, object.Generation)
+	return object
+}
+
+func objectSelfLink(bucket string, key string) string {
+	return fmt.Sprintf("https://www.googleapis.com/storage/v1/b/%s/o/%s", bucket, url.PathEscape(key))
+}
+
+// Fields selected by a fields parameter, nil for a field selected whole
+type fieldSelection map[string]fieldSelection
+
+// Trim a JSON response down to a GCS fields parameter such as name,items(name,size),owner/entity
+func ProjectFields(body []byte, fields string) ([]byte, error) {
+	if strings.TrimSpace(fields) == "" {
+		return body, nil
+	}
+	selection, err := parseFields(fields)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(selection.apply(value))
+}
+
+func parseFields(fields string) (fieldSelection, error) {
+	selection := fieldSelection{}
+	depth := 0
+	start := 0
+	for i := 0; i <= len(fields); i++ {
+		if i < len(fields) {
+			switch fields[i] {
+			case '(':
+				depth++
+				continue
+			case ')':
+				depth--
+				if depth < 0 {
+					return nil, errors.New("invalid fields " + fields)
+				}
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		if depth != 0 {
+			return nil, errors.New("invalid fields " + fields)
+		}
+		if err := selection.add(strings.TrimSpace(fields[start:i])); err != nil {
+			return nil, err
+		}
+		start = i + 1
+	}
+	return selection, nil
+}
+
+// Add one comma separated item, a path that may end in a parenthesised sub selection
+func (selection fieldSelection) add(item string) error {
+	if item == "" {
+		return errors.New("empty field in fields")
+	}
+	path := item
+	var sub fieldSelection
+	if open := strings.Index(item, "("); open >= 0 {
+		if !strings.HasSuffix(item, ")") {
+			return errors.New("invalid field " + item)
+		}
+		var err error
+		if sub, err = parseFields(item[open+1 : len(item)-1]); err != nil {
+			return err
+		}
+		path = item[:open]
+	}
+	names := strings.Split(path, "/")
+	current := selection
+	for i, name := range names {
+		if name == "" {
+			return errors.New("invalid field " + item)
+		}
+		existing, seen := current[name]
+		if seen && existing == nil {
+			// already selected whole
+			return nil
+		}
+		if i == len(names)-1 {
+			if sub == nil || !seen {
+				current[name] = sub
+			} else {
+				for k, v := range sub {
+					existing[k] = v
+				}
+			}
+			return nil
+		}
+		if !seen {
+			existing = fieldSelection{}
+			current[name] = existing
+		}
+		current = existing
+	}
+	return nil
+}
+
+func (selection fieldSelection) apply(value interface{}) interface{} {
+	if selection == nil {
+		return value
+	}
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{})
+		for name, sub := range selection {
+			if v, ok := typed[name]; ok {
+				result[name] = sub.apply(v)
+			}
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(typed))
+		for i, v := range typed {
+			result[i] = selection.apply(v)
+		}
+		return result
+	default:
+		return value
+	}
+}
@@ -0,0 +1,74 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAWSHeadToGCSObject(t *testing.T) {
+	length := int64(0)
+	contentType := "text/plain"
+	etag := "\"900150983cd24fb0d6963f7d28e17f72\""
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	object := AWSHeadToGCSObject("bucket", "dir/key", &s3.HeadObjectOutput{
+		ContentLength: &length,
+		ContentType:   &contentType,
+		ETag:          &etag,
+		LastModified:  &modified,
+		StorageClass:  s3.StorageClassStandardIa,
+		Metadata:      map[string]string{"a": "b"},
+	})
+	assert.Equal(t, "storage#object", object.Kind)
+	assert.Equal(t, "kAFQmDzST7DWlj99KOF/cg==", object.Md5Hash)
+	assert.Equal(t, "NEARLINE", object.StorageClass)
+	assert.Equal(t, "2020-01-02T03:04:05Z", object.Updated)
+	assert.Equal(t, "https://www.googleapis.com/storage/v1/b/bucket/o/dir%2Fkey", object.SelfLink)
+	js, _ := json.Marshal(object)
+	// empty objects still report their size
+	assert.Contains(t, string(js), `"size":"0"`)
+
+	multipart := "\"900150983cd24fb0d6963f7d28e17f72-2\""
+	object = AWSHeadToGCSObject("bucket", "key", &s3.HeadObjectOutput{ETag: &multipart})
+	assert.Equal(t, "", object.Md5Hash)
+	assert.Equal(t, "STANDARD", object.StorageClass)
+}
+
+func TestGCSAttrsToObject(t *testing.T) {
+	object := GCSAttrsToObject(&storage.ObjectAttrs{
+		Bucket:         "bucket",
+		Name:           "key",
+		Size:           3,
+		Generation:     7,
+		Metageneration: 1,
+		CRC32C:         0x364b3fb7,
+	})
+	assert.Equal(t, "bucket/key/7", object.Id)
+	assert.Equal(t, "CAcQAQ==", object.Etag)
+	assert.Equal(t, uint64(3), object.Size)
+	assert.Equal(t, "Nks/tw==", object.Crc32c)
+	assert.Equal(t, "", object.TimeCreated)
+}
+
+func TestProjectFields(t *testing.T) {
+	body := []byte(`{"kind":"storage#objects","nextPageToken":"t","items":[{"name":"a","size":"1","owner":{"entity":"e","entityId":"i"}},{"name":"b","size":"2"}]}`)
+	projected, err := ProjectFields(body, "nextPageToken,items(name,owner/entity)")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"nextPageToken":"t","items":[{"name":"a","owner":{"entity":"e"}},{"name":"b"}]}`, string(projected))
+
+	projected, err = ProjectFields(body, "items/name, items(size)")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"items":[{"name":"a","size":"1"},{"name":"b","size":"2"}]}`, string(projected))
+
+	projected, err = ProjectFields(body, "")
+	assert.Nil(t, err)
+	assert.Equal(t, body, projected)
+
+	_, err = ProjectFields(body, "items(name")
+	assert.NotNil(t, err)
+	_, err = ProjectFields(body, "kind,,name")
+	assert.NotNil(t, err)
+}
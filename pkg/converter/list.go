@@ -0,0 +1,267 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/response_type"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	storage2 "google.golang.org/api/storage/v1"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// S3 never returns more keys than this in one listing
+const MaxListKeys = 1000
+
+// Where a V2 listing continues from.  The token carries the start after key along with the GCS page token, so
+// every page of the listing skips the same keys.  Clients only ever see it encoded
+type ListToken struct {
+	PageToken   string `json:"p"`
+	StartOffset string `json:"s"`
+}
+
+// Opaque continuation token for a listing position
+func (token *ListToken) Encode() string {
+	output, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(output)
+}
+
+// Listing position for a V2 request, from its continuation token or else its start after key
+func ListV2Position(listRequest *s3.ListObjectsV2Input) (*ListToken, error) {
+	if listRequest.ContinuationToken == nil || *listRequest.ContinuationToken == "" {
+		token := &ListToken{}
+		if listRequest.StartAfter != nil {
+			token.StartOffset = *listRequest.StartAfter
+		}
+		return token, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(*listRequest.ContinuationToken)
+	if err != nil {
+		return nil, errors.New("The continuation token provided is incorrect")
+	}
+	var token ListToken
+	if err := json.Unmarshal(decoded, &token); err != nil || token.PageToken == "" {
+		return nil, errors.New("The continuation token provided is incorrect")
+	}
+	return &token, nil
+}
+
+// Where a GCS JSON API listing of an S3 bucket continues from.  Clients only ever see it encoded
+type S3PageToken struct {
+	ContinuationToken string `json:"c,omitempty"`
+	KeyMarker         string `json:"k,omitempty"`
+	VersionIdMarker   string `json:"v,omitempty"`
+}
+
+// Opaque page token for a listing position
+func (token *S3PageToken) Encode() string {
+	output, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(output)
+}
+
+// Listing position from a GCS page token
+func DecodeS3PageToken(pageToken string) (*S3PageToken, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(pageToken)
+	if err != nil {
+		return nil, errors.New("invalid page token")
+	}
+	var token S3PageToken
+	if err := json.Unmarshal(decoded, &token); err != nil {
+		return nil, errors.New("invalid page token")
+	}
+	return &token, nil
+}
+
+// A key sorting just before the given one, so an exclusive S3 start after can stand in for an inclusive GCS
+// start offset.  Only keys ending in U+10FFFF could fall between the two
+func KeyBefore(key string) string {
+	if key == "" {
+		return ""
+	}
+	last, size := utf8.DecodeLastRuneInString(key)
+	key = key[:len(key)-size]
+	if last == 0 {
+		return key
+	}
+	last--
+	if last >= 0xD800 && last <= 0xDFFF {
+		last = 0xD7FF
+	}
+	return key + string(last) + string(utf8.MaxRune)
+}
+
+// Keys a listing asks for, capped like S3 does
+func ListPageSize(maxKeys *int64) int {
+	if maxKeys == nil || *maxKeys > MaxListKeys {
+		return MaxListKeys
+	}
+	if *maxKeys < 0 {
+		return 0
+	}
+	return int(*maxKeys)
+}
+
+// Whether a listed GCS item sorts after the key a listing starts after.  A common prefix holding that key may
+// still have keys after it, so it stays in
+func listedAfter(item *storage.ObjectAttrs, startAfter string) bool {
+	if startAfter == "" {
+		return true
+	}
+	if item.Name != "" {
+		return item.Name > startAfter
+	}
+	return item.Prefix > startAfter || (item.Prefix != startAfter && strings.HasPrefix(startAfter, item.Prefix))
+}
+
+// Key of a listed GCS item, its name or the common prefix it stands for
+func listKey(item *storage.ObjectAttrs) string {
+	if item.Prefix != "" {
+		return item.Prefix
+	}
+	return item.Name
+}
+
+// Entries of a storage/v1 listing page in key order.  GCS returns a page's objects first and its prefixes after
+// them, so the two are merged back together
+func gcsPageEntries(page *storage2.Objects) []*storage.ObjectAttrs {
+	entries := make([]*storage.ObjectAttrs, 0, len(page.Items)+len(page.Prefixes))
+	for _, item := range page.Items {
+		entries = append(entries, GCSObjectToAttrs(item))
+	}
+	for _, prefix := range page.Prefixes {
+		entries = append(entries, &storage.ObjectAttrs{Prefix: prefix})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return listKey(entries[i]) < listKey(entries[j])
+	})
+	return entries
+}
+
+// One GCS page of a listing starting after a key.  GCS is asked to start just before that key, and directory
+// placeholders and the key itself are dropped.  Pages that only hold dropped entries are paged past, so a page can
+// come back short but is only empty at the end
+func gcsListPage(call *storage2.ObjectsListCall, pageSize int, pageToken string, startAfter string) ([]*storage.ObjectAttrs, string, error) {
+	call.MaxResults(int64(pageSize))
+	if startAfter != "" {
+		call.StartOffset(KeyBefore(startAfter))
+	}
+	items := make([]*storage.ObjectAttrs, 0, pageSize)
+	for {
+		if pageToken != "" {
+			call.PageToken(pageToken)
+		}
+		page, err := call.Do()
+		if err != nil {
+			return nil, "", err
+		}
+		for _, item := range gcsPageEntries(page) {
+			if strings.HasSuffix(item.Name, "/") {
+				// weird gcs phantom item
+				continue
+			}
+			if !listedAfter(item, startAfter) {
+				continue
+			}
+			items = append(items, item)
+		}
+		if len(items) > 0 || page.NextPageToken == "" {
+			return items, page.NextPageToken, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// Split a GCS page into S3 contents and common prefixes
+func gcsItemsToContents(items []*storage.ObjectAttrs, fetchOwner bool) ([]*response_type.BucketContent, []*response_type.BucketCommonPrefix) {
+	contents := make([]*response_type.BucketContent, 0, len(items))
+	prefixes := make([]*response_type.BucketCommonPrefix, 0)
+	for _, item := range items {
+		if item.Name != "" {
+			content := GCSItemToContent(item)
+			if fetchOwner && item.Owner != "" {
+				content.Owner = &response_type.BucketOwner{ID: item.Owner, DisplayName: item.Owner}
+			}
+			contents = append(contents, content)
+		} else {
+			prefixes = append(prefixes, GCSItemToPrefix(item))
+		}
+	}
+	return contents, prefixes
+}
+
+// Greatest key or prefix of a page, the key the next page starts after
+func greatestListItem(items []*storage.ObjectAttrs) string {
+	greatest := ""
+	for _, item := range items {
+		if key := listKey(item); key > greatest {
+			greatest = key
+		}
+	}
+	return greatest
+}
+
+// S3 url encoding of a listing value, which leaves slashes alone
+func listEncode(value string) string {
+	return strings.Replace(url.QueryEscape(value), "%2F", "/", -1)
+}
+
+func listEncodePointer(value *string) *string {
+	if value == nil {
+		return nil
+	}
+	encoded := listEncode(*value)
+	return &encoded
+}
+
+// Url encode the keys, prefixes and markers of a listing, for clients that asked with encoding-type=url
+func EncodeListResponse(response *response_type.AWSListBucketResponse) {
+	encodingType := string(s3.EncodingTypeUrl)
+	response.EncodingType = &encodingType
+	response.Prefix = listEncodePointer(response.Prefix)
+	response.Delimiter = listEncodePointer(response.Delimiter)
+	response.Marker = listEncodePointer(response.Marker)
+	response.NextMarker = listEncodePointer(response.NextMarker)
+	response.StartAfter = listEncodePointer(response.StartAfter)
+	for _, content := range response.Contents {
+		content.Key = listEncode(content.Key)
+	}
+	for _, prefix := range response.CommonPrefixes {
+		prefix.Prefix = listEncode(prefix.Prefix)
+	}
+}
+
+// S3 listing contents for AWS objects
+func AWSObjectsToContents(objects []s3.Object) []*response_type.BucketContent {
+	contents := make([]*response_type.BucketContent, len(objects))
+	for i, content := range objects {
+		contents[i] = &response_type.BucketContent{
+			Key:          *content.Key,
+			LastModified: content.LastModified.Format("2006-01-02T15:04:05.000Z"),
+			ETag:         *content.ETag,
+			Size:         *content.Size,
+			StorageClass: string(content.StorageClass),
+		}
+		if content.Owner != nil {
+			contents[i].Owner = &response_type.BucketOwner{
+				ID:          stringValue(content.Owner.ID),
+				DisplayName: stringValue(content.Owner.DisplayName),
+			}
+		}
+	}
+	return contents
+}
+
+// S3 listing common prefixes for AWS prefixes
+func AWSPrefixesToXML(commonPrefixes []s3.CommonPrefix) []*response_type.BucketCommonPrefix {
+	prefixes := make([]*response_type.BucketCommonPrefix, len(commonPrefixes))
+	for i, prefix := range commonPrefixes {
+		prefixes[i] = &response_type.BucketCommonPrefix{
+			Prefix: *prefix.Prefix,
+		}
+	}
+	return prefixes
+}
@@ -0,0 +1,151 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/response_type"
+	"context"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
+	storage2 "google.golang.org/api/storage/v1"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// Server answering storage/v1 listings with a page per page token, recording the queries it was sent
+func listServer(pages map[string]string, queries *[]url.Values) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		*queries = append(*queries, request.URL.Query())
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write([]byte(pages[request.URL.Query().Get("pageToken")]))
+	}))
+}
+
+func listCall(t *testing.T, server *httptest.Server) *storage2.ObjectsListCall {
+	service, err := storage2.NewService(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithHTTPClient(server.Client()))
+	assert.Nil(t, err)
+	return service.Objects.List("bucket").Delimiter("/")
+}
+
+func TestListV2Position(t *testing.T) {
+	startAfter := "cats/b"
+	position, err := ListV2Position(&s3.ListObjectsV2Input{StartAfter: &startAfter})
+	assert.Nil(t, err)
+	assert.Equal(t, "cats/b", position.StartOffset)
+	assert.Equal(t, "", position.PageToken)
+
+	token := (&ListToken{PageToken: "gcs-token", StartOffset: "cats/b"}).Encode()
+	other := "ignored"
+	position, err = ListV2Position(&s3.ListObjectsV2Input{ContinuationToken: &token, StartAfter: &other})
+	assert.Nil(t, err)
+	assert.Equal(t, "gcs-token", position.PageToken)
+	assert.Equal(t, "cats/b", position.StartOffset)
+
+	bad := "gcs-token"
+	_, err = ListV2Position(&s3.ListObjectsV2Input{ContinuationToken: &bad})
+	assert.NotNil(t, err)
+}
+
+func TestListPageSize(t *testing.T) {
+	small, large, negative := int64(5), int64(5000), int64(-1)
+	assert.Equal(t, 1000, ListPageSize(nil))
+	assert.Equal(t, 5, ListPageSize(&small))
+	assert.Equal(t, 1000, ListPageSize(&large))
+	assert.Equal(t, 0, ListPageSize(&negative))
+}
+
+func TestEncodeListResponse(t *testing.T) {
+	prefix := "my dir/"
+	response := &response_type.AWSListBucketResponse{
+		Prefix:         &prefix,
+		Contents:       []*response_type.BucketContent{{Key: "my dir/a+b.txt"}},
+		CommonPrefixes: []*response_type.BucketCommonPrefix{{Prefix: "my dir/ü/"}},
+	}
+	EncodeListResponse(response)
+	assert.Equal(t, "url", *response.EncodingType)
+	assert.Equal(t, "my+dir/", *response.Prefix)
+	assert.Nil(t, response.Marker)
+	assert.Equal(t, "my+dir/a%2Bb.txt", response.Contents[0].Key)
+	assert.Equal(t, "my+dir/%C3%BC/", response.CommonPrefixes[0].Prefix)
+}
+
+func TestS3PageToken(t *testing.T) {
+	token := &S3PageToken{KeyMarker: "cats/b", VersionIdMarker: "v1"}
+	decoded, err := DecodeS3PageToken(token.Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, token, decoded)
+	_, err = DecodeS3PageToken("not a token!")
+	assert.NotNil(t, err)
+}
+
+func TestKeyBefore(t *testing.T) {
+	assert.Equal(t, "", KeyBefore(""))
+	assert.True(t, KeyBefore("cats/b") < "cats/b")
+	assert.True(t, KeyBefore("cats/b") > "cats/a\U0010FFFE")
+	assert.True(t, KeyBefore("cats/b") > "cats/a/z")
+	assert.Equal(t, "cats/", KeyBefore("cats/\x00"))
+	assert.True(t, KeyBefore("caté") < "caté")
+	assert.True(t, KeyBefore("caté") > "catè/z")
+}
+
+func TestListedAfter(t *testing.T) {
+	assert.True(t, listedAfter(&storage.ObjectAttrs{Name: "cats/a"}, ""))
+	assert.False(t, listedAfter(&storage.ObjectAttrs{Name: "cats/a"}, "cats/b"))
+	assert.False(t, listedAfter(&storage.ObjectAttrs{Name: "cats/b"}, "cats/b"))
+	assert.True(t, listedAfter(&storage.ObjectAttrs{Name: "cats/c"}, "cats/b"))
+	assert.True(t, listedAfter(&storage.ObjectAttrs{Prefix: "cats/"}, "cats/b"))
+	assert.False(t, listedAfter(&storage.ObjectAttrs{Prefix: "cats/"}, "cats/"))
+	assert.False(t, listedAfter(&storage.ObjectAttrs{Prefix: "bats/"}, "cats/b"))
+	assert.True(t, listedAfter(&storage.ObjectAttrs{Prefix: "dogs/"}, "cats/b"))
+}
+
+func TestGCSListResponseToAWS(t *testing.T) {
+	var queries []url.Values
+	// GCS sends a page's objects before its prefixes
+	server := listServer(map[string]string{
+		"": `{"items":[{"name":"b"},{"name":"c"},{"name":"e"}],"prefixes":["d/","f/"],"nextPageToken":"t"}`,
+	}, &queries)
+	defer server.Close()
+	marker := "b"
+	maxKeys := int64(5)
+	bucket := "bucket"
+	response, err := GCSListResponseToAWS(listCall(t, server), &s3.ListObjectsInput{Bucket: &bucket, Marker: &marker, MaxKeys: &maxKeys}, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, KeyBefore("b"), queries[0].Get("startOffset"))
+	assert.Equal(t, "5", queries[0].Get("maxResults"))
+	assert.True(t, *response.IsTruncated)
+	assert.Equal(t, "f/", *response.NextMarker)
+	assert.Len(t, response.Contents, 2)
+	assert.Equal(t, "c", response.Contents[0].Key)
+	assert.Equal(t, "e", response.Contents[1].Key)
+	assert.Len(t, response.CommonPrefixes, 2)
+	assert.Equal(t, "d/", response.CommonPrefixes[0].Prefix)
+	assert.Equal(t, "f/", response.CommonPrefixes[1].Prefix)
+}
+
+func TestGCSListResponseToAWSv2(t *testing.T) {
+	var queries []url.Values
+	// the first page only holds the key the listing starts after, so it is paged past
+	server := listServer(map[string]string{
+		"":   `{"prefixes":["cats/"],"nextPageToken":"t1"}`,
+		"t1": `{"items":[{"name":"dogs"}],"prefixes":["eels/"],"nextPageToken":"t2"}`,
+	}, &queries)
+	defer server.Close()
+	startAfter := "cats/"
+	bucket := "bucket"
+	input := &s3.ListObjectsV2Input{Bucket: &bucket, StartAfter: &startAfter}
+	position, _ := ListV2Position(input)
+	response, err := GCSListResponseToAWSv2(listCall(t, server), input, position, 2)
+	assert.Nil(t, err)
+	assert.Len(t, queries, 2)
+	assert.Equal(t, KeyBefore("cats/"), queries[1].Get("startOffset"))
+	assert.Equal(t, "t1", queries[1].Get("pageToken"))
+	assert.Equal(t, int64(2), response.KeyCount)
+	assert.Equal(t, "dogs", response.Contents[0].Key)
+	assert.Equal(t, "eels/", response.CommonPrefixes[0].Prefix)
+	next, err := ListV2Position(&s3.ListObjectsV2Input{ContinuationToken: response.NextContinuationToken})
+	assert.Nil(t, err)
+	assert.Equal(t, &ListToken{PageToken: "t2", StartOffset: "cats/"}, next)
+}
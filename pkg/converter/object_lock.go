@@ -0,0 +1,99 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/response_type"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"time"
+)
+
+// Days in an object lock year
+const objectLockYear = 365 * 24 * time.Hour
+
+// Object lock configuration from S3 XML.  A rule needs a mode and either days or years
+func ObjectLockXMLToAWS(input *response_type.ObjectLockConfiguration) (*s3.ObjectLockConfiguration, error) {
+	if s3.ObjectLockEnabled(input.ObjectLockEnabled) != s3.ObjectLockEnabledEnabled {
+		return nil, errors.New("ObjectLockEnabled must be Enabled")
+	}
+	configuration := &s3.ObjectLockConfiguration{ObjectLockEnabled: s3.ObjectLockEnabledEnabled}
+	if input.Rule == nil {
+		return configuration, nil
+	}
+	retention := input.Rule.DefaultRetention
+	if retention == nil {
+		return nil, errors.New("object lock rule has no default retention")
+	}
+	mode := s3.ObjectLockRetentionMode(retention.Mode)
+	if mode != s3.ObjectLockRetentionModeGovernance && mode != s3.ObjectLockRetentionModeCompliance {
+		return nil, errors.New("invalid object lock mode " + retention.Mode)
+	}
+	if (retention.Days == nil) == (retention.Years == nil) {
+		return nil, errors.New("default retention needs one of days or years")
+	}
+	if (retention.Days != nil && *retention.Days <= 0) || (retention.Years != nil && *retention.Years <= 0) {
+		return nil, errors.New("default retention period must be positive")
+	}
+	configuration.Rule = &s3.ObjectLockRule{DefaultRetention: &s3.DefaultRetention{
+		Mode:  mode,
+		Days:  retention.Days,
+		Years: retention.Years,
+	}}
+	return configuration, nil
+}
+
+// S3 XML for an object lock configuration
+func AWSObjectLockToXML(input *s3.ObjectLockConfiguration) *response_type.ObjectLockConfiguration {
+	output := &response_type.ObjectLockConfiguration{
+		XmlNS:             "http://s3.amazonaws.com/doc/2006-03-01/",
+		ObjectLockEnabled: string(input.ObjectLockEnabled),
+	}
+	if input.Rule != nil && input.Rule.DefaultRetention != nil {
+		output.Rule = &response_type.ObjectLockRule{DefaultRetention: &response_type.DefaultRetention{
+			Mode:  string(input.Rule.DefaultRetention.Mode),
+			Days:  input.Rule.DefaultRetention.Days,
+			Years: input.Rule.DefaultRetention.Years,
+		}}
+	}
+	return output
+}
+
+// GCS retention period for an object lock configuration, zero when there is no default retention.  Compliance
+// mode is a locked retention policy, which like compliance mode can only be lengthened
+func AWSObjectLockToGCS(input *s3.ObjectLockConfiguration) (time.Duration, bool) {
+	if input.Rule == nil || input.Rule.DefaultRetention == nil {
+		return 0, false
+	}
+	retention := input.Rule.DefaultRetention
+	var period time.Duration
+	if retention.Days != nil {
+		period = time.Duration(*retention.Days) * 24 * time.Hour
+	} else if retention.Years != nil {
+		period = time.Duration(*retention.Years) * objectLockYear
+	}
+	return period, retention.Mode == s3.ObjectLockRetentionModeCompliance
+}
+
+// Object lock configuration for a GCS retention policy, nil when the bucket has none.  Periods are rounded up
+// to whole days
+func GCSRetentionPolicyToAWS(policy *storage.RetentionPolicy) *s3.ObjectLockConfiguration {
+	if policy == nil || policy.RetentionPeriod <= 0 {
+		return nil
+	}
+	days := int64((policy.RetentionPeriod + 24*time.Hour - 1) / (24 * time.Hour))
+	return &s3.ObjectLockConfiguration{
+		ObjectLockEnabled: s3.ObjectLockEnabledEnabled,
+		Rule: &s3.ObjectLockRule{DefaultRetention: &s3.DefaultRetention{
+			Mode: GCSRetentionMode(policy),
+			Days: &days,
+		}},
+	}
+}
+
+// Object lock mode of objects retained by a GCS retention policy
+func GCSRetentionMode(policy *storage.RetentionPolicy) s3.ObjectLockRetentionMode {
+	if policy != nil && policy.IsLocked {
+		return s3.ObjectLockRetentionModeCompliance
+	}
+	return s3.ObjectLockRetentionModeGovernance
+}
@@ -0,0 +1,54 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/response_type"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestObjectLockXMLToAWS(t *testing.T) {
+	years := int64(2)
+	configuration, err := ObjectLockXMLToAWS(&response_type.ObjectLockConfiguration{
+		ObjectLockEnabled: "Enabled",
+		Rule:              &response_type.ObjectLockRule{DefaultRetention: &response_type.DefaultRetention{Mode: "COMPLIANCE", Years: &years}},
+	})
+	assert.Nil(t, err)
+	period, compliance := AWSObjectLockToGCS(configuration)
+	assert.Equal(t, 2*365*24*time.Hour, period)
+	assert.True(t, compliance)
+
+	configuration, err = ObjectLockXMLToAWS(&response_type.ObjectLockConfiguration{ObjectLockEnabled: "Enabled"})
+	assert.Nil(t, err)
+	period, compliance = AWSObjectLockToGCS(configuration)
+	assert.Equal(t, time.Duration(0), period)
+	assert.False(t, compliance)
+
+	_, err = ObjectLockXMLToAWS(&response_type.ObjectLockConfiguration{ObjectLockEnabled: "Disabled"})
+	assert.NotNil(t, err)
+	days := int64(1)
+	_, err = ObjectLockXMLToAWS(&response_type.ObjectLockConfiguration{
+		ObjectLockEnabled: "Enabled",
+		Rule:              &response_type.ObjectLockRule{DefaultRetention: &response_type.DefaultRetention{Mode: "GOVERNANCE", Days: &days, Years: &years}},
+	})
+	assert.NotNil(t, err)
+	_, err = ObjectLockXMLToAWS(&response_type.ObjectLockConfiguration{
+		ObjectLockEnabled: "Enabled",
+		Rule:              &response_type.ObjectLockRule{DefaultRetention: &response_type.DefaultRetention{Mode: "FOREVER", Days: &days}},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestGCSRetentionPolicyToAWS(t *testing.T) {
+	assert.Nil(t, GCSRetentionPolicyToAWS(nil))
+	configuration := GCSRetentionPolicyToAWS(&storage.RetentionPolicy{RetentionPeriod: 36 * time.Hour})
+	assert.Equal(t, s3.ObjectLockEnabledEnabled, configuration.ObjectLockEnabled)
+	assert.Equal(t, int64(2), *configuration.Rule.DefaultRetention.Days)
+	assert.Equal(t, s3.ObjectLockRetentionModeGovernance, configuration.Rule.DefaultRetention.Mode)
+
+	configuration = GCSRetentionPolicyToAWS(&storage.RetentionPolicy{RetentionPeriod: 24 * time.Hour, IsLocked: true})
+	assert.Equal(t, int64(1), *configuration.Rule.DefaultRetention.Days)
+	assert.Equal(t, s3.ObjectLockRetentionModeCompliance, configuration.Rule.DefaultRetention.Mode)
+}
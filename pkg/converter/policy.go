@@ -0,0 +1,219 @@
+package converter
+
+import (
+	"cloudsidecar/pkg/response_type"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// GCS roles for the S3 actions that have a bucket level equivalent
+var s3ActionToRole = map[string]string{
+	"s3:getobject":  "roles/storage.legacyObjectReader",
+	"s3:listbucket": "roles/storage.legacyBucketReader",
+	"s3:putobject":  "roles/storage.objectCreator",
+	"s3:*object":    "roles/storage.objectAdmin",
+	"s3:*":          "roles/storage.admin",
+}
+
+// S3 actions reported for the roles a policy manages
+var roleToS3Action = map[string]string{
+	"roles/storage.legacyObjectReader": "s3:GetObject",
+	"roles/storage.legacyBucketReader": "s3:ListBucket",
+	"roles/storage.objectCreator":      "s3:PutObject",
+	"roles/storage.objectAdmin":        "s3:*Object",
+	"roles/storage.admin":              "s3:*",
+}
+
+// IAM member prefixes that are already GCS members
+var gcsMemberPrefixes = []string{"user:", "serviceAccount:", "group:", "domain:"}
+
+// Roles a bucket policy translates to, the only ones a policy put or delete touches
+func PolicyRoles() []string {
+	roles := make([]string, 0, len(roleToS3Action))
+	for role := range roleToS3Action {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// Whether a member is one of the bindings GCS keeps for project owners, editors and viewers
+func IsConvenienceMember(member string) bool {
+	return strings.HasPrefix(member, "projectOwner:") || strings.HasPrefix(member, "projectEditor:") ||
+		strings.HasPrefix(member, "projectViewer:")
+}
+
+// Values of a policy field that can be a string or a list of strings
+func policyStrings(value interface{}) ([]string, bool) {
+	switch typed := value.(type) {
+	case string:
+		return []string{typed}, true
+	case []interface{}:
+		values := make([]string, len(typed))
+		for i, item := range typed {
+			str, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			values[i] = str
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// GCS member for an S3 principal.  Anyone is allUsers, GCS members are used as is and AWS principals are
+// looked up in the configured principal map, whose keys are lower case
+func principalToMember(principal string, principals map[string]string) (string, bool) {
+	if principal == "*" {
+		return "allUsers", true
+	}
+	if principal == "allUsers" || principal == "allAuthenticatedUsers" {
+		return principal, true
+	}
+	for _, prefix := range gcsMemberPrefixes {
+		if strings.HasPrefix(principal, prefix) {
+			return principal, true
+		}
+	}
+	member, ok := principals[strings.ToLower(principal)]
+	return member, ok
+}
+
+// S3 principal for a GCS member, the reverse of the principal map
+func memberToPrincipal(member string, principals map[string]string) string {
+	if member == "allUsers" {
+		return "*"
+	}
+	for principal, mapped := range principals {
+		if mapped == member {
+			return principal
+		}
+	}
+	return member
+}
+
+// Members for a statement principal, which is * or a map of principal type to one or more principals
+func statementMembers(principal interface{}, principals map[string]string) ([]string, string) {
+	if principal == nil {
+		return nil, "a principal is required"
+	}
+	if str, ok := principal.(string); ok {
+		if str != "*" {
+			return nil, "principal " + str + " is not supported"
+		}
+		return []string{"allUsers"}, ""
+	}
+	principalMap, ok := principal.(map[string]interface{})
+	if !ok {
+		return nil, "principal is not valid"
+	}
+	members := make([]string, 0)
+	for principalType, value := range principalMap {
+		if principalType != "AWS" {
+			return nil, principalType + " principals are not supported"
+		}
+		values, ok := policyStrings(value)
+		if !ok {
+			return nil, "principal is not valid"
+		}
+		for _, value := range values {
+			member, ok := principalToMember(value, principals)
+			if !ok {
+				return nil, "principal " + value + " has no GCS member mapped"
+			}
+			members = append(members, member)
+		}
+	}
+	return members, ""
+}
+
+// Translate an S3 bucket policy to IAM bindings, role to members.  IAM on a bucket can only allow, so deny
+// statements, conditions, negated fields and resources narrower than the whole bucket aren't supported.
+// Every statement that can't be translated is reported so the policy is only applied when all of it is
+func S3PolicyToIAM(policy *response_type.BucketPolicy, bucket string, principals map[string]string) (map[string][]string, []string) {
+	bindings := make(map[string][]string)
+	unsupported := make([]string, 0)
+	bucketArn := "arn:aws:s3:::" + bucket
+	for i, statement := range policy.Statement {
+		name := fmt.Sprintf("statement %d", i+1)
+		if statement.Sid != "" {
+			name = "statement " + statement.Sid
+		}
+		reasons := make([]string, 0)
+		if statement.Effect != "Allow" {
+			reasons = append(reasons, "only Allow statements are supported")
+		}
+		if statement.Condition != nil {
+			reasons = append(reasons, "conditions are not supported")
+		}
+		if statement.NotAction != nil {
+			reasons = append(reasons, "NotAction is not supported")
+		}
+		members, reason := statementMembers(statement.Principal, principals)
+		if reason != "" {
+			reasons = append(reasons, reason)
+		}
+		resources, ok := policyStrings(statement.Resource)
+		if !ok {
+			reasons = append(reasons, "resource is not valid")
+		}
+		for _, resource := range resources {
+			if resource != bucketArn && resource != bucketArn+"/*" {
+				reasons = append(reasons, "resource "+resource+" is narrower than the bucket")
+			}
+		}
+		actions, ok := policyStrings(statement.Action)
+		if !ok {
+			reasons = append(reasons, "action is not valid")
+		}
+		roles := make([]string, 0, len(actions))
+		for _, action := range actions {
+			role, ok := s3ActionToRole[strings.ToLower(action)]
+			if !ok {
+				reasons = append(reasons, "action "+action+" is not supported")
+				continue
+			}
+			roles = append(roles, role)
+		}
+		if len(reasons) > 0 {
+			unsupported = append(unsupported, name+": "+strings.Join(reasons, ", "))
+			continue
+		}
+		for _, role := range roles {
+			bindings[role] = append(bindings[role], members...)
+		}
+	}
+	return bindings, unsupported
+}
+
+// S3 bucket policy for IAM bindings, one statement per role.  Returns nil when no binding translates
+func IAMToS3Policy(bindings map[string][]string, bucket string, principals map[string]string) *response_type.BucketPolicy {
+	policy := &response_type.BucketPolicy{Version: "2012-10-17", Statement: make([]*response_type.BucketPolicyStatement, 0)}
+	bucketArn := "arn:aws:s3:::" + bucket
+	for _, role := range PolicyRoles() {
+		action := roleToS3Action[role]
+		principalList := make([]interface{}, 0)
+		for _, member := range bindings[role] {
+			if IsConvenienceMember(member) {
+				continue
+			}
+			principalList = append(principalList, memberToPrincipal(member, principals))
+		}
+		if len(principalList) == 0 {
+			continue
+		}
+		policy.Statement = append(policy.Statement, &response_type.BucketPolicyStatement{
+			Sid:       strings.TrimPrefix(role, "roles/storage."),
+			Effect:    "Allow",
+			Principal: map[string]interface{}{"AWS": principalList},
+			Action:    action,
+			Resource:  []interface{}{bucketArn, bucketArn + "/*"},
+		})
+	}
+	if len(policy.Statement) == 0 {
+		return nil
+	}
+	return policy
+}
@@ -0,0 +1,47 @@
+package converter
+
+import (
+	"cloudsidecar/pkg/response_type"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const bucketPolicy = `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {"Sid": "public", "Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::meow/*"},
+    {"Effect": "Allow", "Principal": {"AWS": ["arn:aws:iam::1:role/Writer"]}, "Action": ["s3:PutObject", "s3:ListBucket"],
+     "Resource": ["arn:aws:s3:::meow", "arn:aws:s3:::meow/*"]}
+  ]
+}`
+
+func TestS3PolicyToIAM(t *testing.T) {
+	var policy response_type.BucketPolicy
+	assert.Nil(t, json.Unmarshal([]byte(bucketPolicy), &policy))
+	principals := map[string]string{"arn:aws:iam::1:role/writer": "serviceAccount:writer@p.iam.gserviceaccount.com"}
+	bindings, unsupported := S3PolicyToIAM(&policy, "meow", principals)
+	assert.Empty(t, unsupported)
+	assert.Equal(t, []string{"allUsers"}, bindings["roles/storage.legacyObjectReader"])
+	assert.Equal(t, []string{"serviceAccount:writer@p.iam.gserviceaccount.com"}, bindings["roles/storage.objectCreator"])
+	assert.Equal(t, []string{"serviceAccount:writer@p.iam.gserviceaccount.com"}, bindings["roles/storage.legacyBucketReader"])
+
+	bindings["roles/storage.legacyBucketReader"] = append(bindings["roles/storage.legacyBucketReader"], "projectViewer:p")
+	back := IAMToS3Policy(bindings, "meow", principals)
+	assert.Len(t, back.Statement, 3)
+	assert.Nil(t, IAMToS3Policy(map[string][]string{"roles/storage.legacyBucketReader": {"projectViewer:p"}}, "meow", principals))
+}
+
+func TestS3PolicyToIAM_Unsupported(t *testing.T) {
+	policy := &response_type.BucketPolicy{Statement: []*response_type.BucketPolicyStatement{
+		{Sid: "deny", Effect: "Deny", Principal: "*", Action: "s3:GetObject", Resource: "arn:aws:s3:::meow/*"},
+		{Effect: "Allow", Principal: map[string]interface{}{"AWS": "arn:aws:iam::2:root"}, Action: "s3:GetObjectTagging",
+			Resource: "arn:aws:s3:::meow/logs/*"},
+	}}
+	bindings, unsupported := S3PolicyToIAM(policy, "meow", nil)
+	assert.Empty(t, bindings)
+	assert.Equal(t, []string{
+		"statement deny: only Allow statements are supported",
+		"statement 2: principal arn:aws:iam::2:root has no GCS member mapped, resource arn:aws:s3:::meow/logs/* is narrower than the bucket, action s3:GetObjectTagging is not supported",
+	}, unsupported)
+}
@@ -0,0 +1,63 @@
+package converter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Longest name SQS allows for a queue
+const maxQueueNameLength = 80
+
+// An SNS notification as SNS writes it to SQS queues subscribed without raw message delivery
+type snsEnvelope struct {
+	Type      string `json:"Type"`
+	MessageId string `json:"MessageId"`
+	Message   string `json:"Message"`
+}
+
+// Project and subscription id from a subscription name, projects/p/subscriptions/s
+func ParsePubSubSubscription(subscription string) (string, string, error) {
+	pieces := strings.Split(subscription, "/")
+	if len(pieces) != 4 || pieces[0] != "projects" || pieces[2] != "subscriptions" || pieces[1] == "" || pieces[3] == "" {
+		return "", "", errors.New("invalid subscription " + subscription)
+	}
+	return pieces[1], pieces[3], nil
+}
+
+// Subscription name in the form the Pub/Sub API uses
+func PubSubSubscriptionName(project string, subscription string) string {
+	return fmt.Sprintf("projects/%s/subscriptions/%s", project, subscription)
+}
+
+// SQS queue name for a subscription id.  Pub/Sub allows . ~ + and % in ids where SQS only allows - and _
+func SubscriptionToQueueName(subscription string) (string, error) {
+	if len(subscription) > maxQueueNameLength {
+		return "", errors.New("subscription " + subscription + " is too long for an SQS queue name")
+	}
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, subscription), nil
+}
+
+// Pub/Sub message and its data from an SNS or SQS message body.  SNS notifications are unwrapped, and bodies that
+// aren't Pub/Sub messages, like ones sent by plain SQS or SNS publishers, become the data of a message with the given id
+func DecodePubSubMessage(body string, id string) ([]byte, *PubSubMessage) {
+	var envelope snsEnvelope
+	if err := json.Unmarshal([]byte(body), &envelope); err == nil && envelope.Type == "Notification" {
+		body = envelope.Message
+		id = envelope.MessageId
+	}
+	var message PubSubMessage
+	if err := json.Unmarshal([]byte(body), &message); err == nil && message.MessageId != "" {
+		if data, err := base64.StdEncoding.DecodeString(message.Data); err == nil {
+			return data, &message
+		}
+	}
+	return []byte(body), &PubSubMessage{MessageId: id}
+}
@@ -0,0 +1,52 @@
+package converter
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePubSubSubscription(t *testing.T) {
+	project, subscription, err := ParsePubSubSubscription("projects/p/subscriptions/workers")
+	assert.Nil(t, err)
+	assert.Equal(t, "p", project)
+	assert.Equal(t, "workers", subscription)
+	assert.Equal(t, "projects/p/subscriptions/workers", PubSubSubscriptionName(project, subscription))
+	_, _, err = ParsePubSubSubscription("projects/p/topics/workers")
+	assert.NotNil(t, err)
+}
+
+func TestSubscriptionToQueueName(t *testing.T) {
+	name, err := SubscriptionToQueueName("my.sub~1+a%2")
+	assert.Nil(t, err)
+	assert.Equal(t, "my_sub_1_a_2", name)
+	_, err = SubscriptionToQueueName(strings.Repeat("a", 81))
+	assert.NotNil(t, err)
+}
+
+func TestDecodePubSubMessage(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	body := EncodePubSubMessage([]byte("hi"), map[string]string{"a": "b"}, "m1", now)
+	data, message := DecodePubSubMessage(body, "sqs-id")
+	assert.Equal(t, "hi", string(data))
+	assert.Equal(t, "m1", message.MessageId)
+	assert.Equal(t, "b", message.Attributes["a"])
+	assert.Equal(t, "2020-01-02T03:04:05Z", message.PublishTime)
+
+	// sns deliveries without raw message delivery wrap the body
+	envelope, _ := json.Marshal(snsEnvelope{Type: "Notification", MessageId: "sns-id", Message: body})
+	data, message = DecodePubSubMessage(string(envelope), "sqs-id")
+	assert.Equal(t, "hi", string(data))
+	assert.Equal(t, "m1", message.MessageId)
+
+	envelope, _ = json.Marshal(snsEnvelope{Type: "Notification", MessageId: "sns-id", Message: "plain"})
+	data, message = DecodePubSubMessage(string(envelope), "sqs-id")
+	assert.Equal(t, "plain", string(data))
+	assert.Equal(t, "sns-id", message.MessageId)
+
+	data, message = DecodePubSubMessage(`{"some":"json"}`, "sqs-id")
+	assert.Equal(t, `{"some":"json"}`, string(data))
+	assert.Equal(t, "sqs-id", message.MessageId)
+}
@@ -0,0 +1,88 @@
+package converter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+const (
+	// S3 limits on the parts of a multipart upload
+	minS3PartSize  = 5 * 1024 * 1024
+	maxS3PartSize  = 5 * 1024 * 1024 * 1024
+	maxS3PartCount = 10000
+	// GCS counts rewrite progress in whole MiB
+	RewriteChunk = 1024 * 1024
+)
+
+// Where a GCS rewrite into S3 continues from.  Everything needed to resume is in the token, so any replica sharing
+// the signing key can pick the copy up.  Clients only ever see it encoded and signed
+type S3RewriteToken struct {
+	UploadId string `json:"u"`
+	// source and destination as bucket/key, so a token can't be replayed onto another copy
+	Source      string `json:"s"`
+	Destination string `json:"d"`
+	// the copy fails rather than mixing two versions if the source changes part way
+	SourceETag string `json:"e"`
+	Size       int64  `json:"z"`
+	PartSize   int64  `json:"p"`
+	Offset     int64  `json:"o"`
+}
+
+// Opaque rewrite token for a copy position, signed with key so clients can't change it
+func (token *S3RewriteToken) Encode(key []byte) string {
+	output, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(output) + "." + base64.RawURLEncoding.EncodeToString(rewriteTokenMAC(output, key))
+}
+
+func rewriteTokenMAC(payload []byte, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Number of the part starting at the token's offset
+func (token *S3RewriteToken) PartNumber() int64 {
+	return token.Offset/token.PartSize + 1
+}
+
+// Copy position from a GCS rewrite token, if it was signed with key
+func DecodeS3RewriteToken(rewriteToken string, key []byte) (*S3RewriteToken, error) {
+	dot := strings.Index(rewriteToken, ".")
+	if dot < 0 {
+		return nil, errors.New("invalid rewrite token")
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(rewriteToken[:dot])
+	if err != nil {
+		return nil, errors.New("invalid rewrite token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(rewriteToken[dot+1:])
+	if err != nil || !hmac.Equal(signature, rewriteTokenMAC(decoded, key)) {
+		return nil, errors.New("invalid rewrite token")
+	}
+	var token S3RewriteToken
+	if err := json.Unmarshal(decoded, &token); err != nil || token.UploadId == "" || token.PartSize <= 0 {
+		return nil, errors.New("invalid rewrite token")
+	}
+	return &token, nil
+}
+
+// Part size for copying an object of the given size a call's worth of bytes at a time.  Parts are whole MiB, no
+// larger than a call copies unless S3's part count limit forces it
+func RewritePartSize(size int64, bytesPerCall int64) int64 {
+	partSize := bytesPerCall
+	if partSize > maxS3PartSize {
+		partSize = maxS3PartSize
+	}
+	if fewestParts := (size + maxS3PartCount - 1) / maxS3PartCount; partSize < fewestParts {
+		partSize = fewestParts
+	}
+	partSize = (partSize + RewriteChunk - 1) / RewriteChunk * RewriteChunk
+	if partSize < minS3PartSize {
+		return minS3PartSize
+	}
+	return partSize
+}
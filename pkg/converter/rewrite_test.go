@@ -0,0 +1,51 @@
+package converter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestS3RewriteToken(t *testing.T) {
+	token := &S3RewriteToken{
+		UploadId:    "upload",
+		Source:      "bucket/a",
+		Destination: "bucket/b",
+		SourceETag:  "\"etag\"",
+		Size:        100 << 20,
+		PartSize:    10 << 20,
+		Offset:      30 << 20,
+	}
+	key := []byte("secret")
+	encoded := token.Encode(key)
+	decoded, err := DecodeS3RewriteToken(encoded, key)
+	assert.Nil(t, err)
+	assert.Equal(t, token, decoded)
+	assert.Equal(t, int64(4), decoded.PartNumber())
+
+	_, err = DecodeS3RewriteToken("not a token!", key)
+	assert.NotNil(t, err)
+	_, err = DecodeS3RewriteToken((&S3RewriteToken{PartSize: 1}).Encode(key), key)
+	assert.NotNil(t, err)
+	// signed with another key
+	_, err = DecodeS3RewriteToken(encoded, []byte("other"))
+	assert.NotNil(t, err)
+	// payload changed under the signature
+	token.Offset = 0
+	forged := strings.Split(token.Encode([]byte("other")), ".")[0] + encoded[strings.Index(encoded, "."):]
+	_, err = DecodeS3RewriteToken(forged, key)
+	assert.NotNil(t, err)
+}
+
+func TestRewritePartSize(t *testing.T) {
+	// never under S3's minimum
+	assert.Equal(t, int64(5<<20), RewritePartSize(100<<20, 1<<20))
+	// rounded up to whole MiB
+	assert.Equal(t, int64(8<<20), RewritePartSize(100<<20, 7<<20+1))
+	// large enough to stay within 10000 parts
+	assert.Equal(t, int64(11<<20), RewritePartSize(100001<<20, 5<<20))
+	// never over S3's maximum
+	assert.Equal(t, int64(5<<30), RewritePartSize(10<<30, 8<<30))
+	assert.Equal(t, int64(5<<30), RewritePartSize(10<<30, math.MaxInt64))
+}
@@ -4,10 +4,12 @@ import (
 	"cloud.google.com/go/storage"
 	"cloudsidecar/pkg/logging"
 	"cloudsidecar/pkg/response_type"
+	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
-	"google.golang.org/api/iterator"
+	storage2 "google.golang.org/api/storage/v1"
 	"io"
 	"net/http"
 	"strconv"
@@ -78,112 +80,103 @@ func GCSItemToPrefix(item *storage.ObjectAttrs) *response_type.BucketCommonPrefi
 	}
 }
 
-// Old version of aws listing does not paginate nicely.  It uses the last item of the prior list to
-// do an offset.  So here we will just paginate through till we find that item.
-func GCSListResponseToAWS(input *storage.ObjectIterator, listRequest *s3.ListObjectsInput, pageSize int) (*response_type.AWSListBucketResponse, error) {
-	contentI := 0
-	prefixI := 0
-	var marker string
-	if listRequest.Marker != nil && *listRequest.Marker != "" {
+// Old version of aws listing uses the last item of the prior list as an offset, which GCS starts the listing from
+func GCSListResponseToAWS(input *storage2.ObjectsListCall, listRequest *s3.ListObjectsInput, pageSize int) (*response_type.AWSListBucketResponse, error) {
+	marker := ""
+	if listRequest.Marker != nil {
 		marker = *listRequest.Marker
 	}
-	var contents = make([]*response_type.BucketContent, pageSize)
-	var prefixes = make([]*response_type.BucketCommonPrefix, pageSize)
+	var items []*storage.ObjectAttrs
 	nextToken := ""
-	var err error
-	lastItem := ""
-	// loop through until we have enough objects and folder, or until nothing is left
-	for contentI+prefixI < pageSize {
-		var pageResponse []*storage.ObjectAttrs
-		nextToken, err = iterator.NewPager(input, pageSize, nextToken).NextPage(&pageResponse)
+	if pageSize > 0 {
+		var err error
+		items, nextToken, err = gcsListPage(input, pageSize, "", marker)
 		if err != nil {
 			logging.Log.Error("Some error paginating", err)
 			return nil, err
 		}
-		// loop through each result, see if it is an object or folder, handle accordingly
-		for _, item := range pageResponse {
-			if contentI+prefixI >= pageSize {
-				break
-			}
-			if marker != "" {
-				// we are looking for some offset.  skip items until we pass this
-				if item.Name == marker || item.Prefix == marker {
-					// this is that offset, now return items
-					marker = ""
-				}
-			} else {
-				if strings.HasSuffix(item.Name, "/") {
-					// weird phantom item in gcs
-				} else if item.Name != "" {
-					// object not folder
-					contents[contentI] = GCSItemToContent(item)
-					lastItem = item.Name
-					contentI++
-				} else {
-					// folder
-					prefixes[prefixI] = GCSItemToPrefix(item)
-					lastItem = item.Prefix
-					prefixI++
-				}
-			}
-		}
-		if len(pageResponse) == 0 || nextToken == "" {
-			break
-		}
 	}
-	if nextToken != "" {
-		nextToken = lastItem
+	isTruncated := nextToken != ""
+	if len(items) > pageSize {
+		items = items[:pageSize]
+		isTruncated = true
+	}
+	nextMarker := ""
+	if isTruncated {
+		nextMarker = greatestListItem(items)
+	}
+	contents, prefixes := gcsItemsToContents(items, false)
+	s3Resp := GCSListResponseObjectsToAWS(contents, listRequest, nextMarker, len(contents), len(prefixes), prefixes)
+	s3Resp.IsTruncated = &isTruncated
+	s3Resp.Marker = &marker
+	if isTruncated {
+		s3Resp.NextMarker = &nextMarker
+	}
+	if listRequest.EncodingType == s3.EncodingTypeUrl {
+		EncodeListResponse(s3Resp)
 	}
-	contents = contents[:contentI]
-	prefixes = prefixes[:prefixI]
-	s3Resp := GCSListResponseObjectsToAWS(contents, listRequest, nextToken, contentI, prefixI, prefixes)
 	return s3Resp, nil
 }
 
-// new version of aws listing does same pagination as gcs
-func GCSListResponseToAWSv2(input *storage.ObjectIterator, listRequest *s3.ListObjectsInput, pageSize int) (*response_type.AWSListBucketResponse, error) {
-	contentI := 0
-	prefixI := 0
-	var pageResponse []*storage.ObjectAttrs
-	var marker string
-	if listRequest.Marker != nil && *listRequest.Marker != "" {
-		marker = *listRequest.Marker
+// new version of aws listing continues from a token, which wraps the gcs page token
+func GCSListResponseToAWSv2(input *storage2.ObjectsListCall, listRequest *s3.ListObjectsV2Input, position *ListToken, pageSize int) (*response_type.AWSListBucketResponse, error) {
+	var items []*storage.ObjectAttrs
+	nextToken := ""
+	if pageSize > 0 {
+		var err error
+		items, nextToken, err = gcsListPage(input, pageSize, position.PageToken, position.StartOffset)
+		if err != nil {
+			return nil, err
+		}
 	}
-	nextToken, err := iterator.NewPager(input, pageSize, marker).NextPage(&pageResponse)
-	if err != nil {
-		return nil, err
+	fetchOwner := listRequest.FetchOwner != nil && *listRequest.FetchOwner
+	contents, prefixes := gcsItemsToContents(items, fetchOwner)
+	isTruncated := nextToken != ""
+	s3Resp := &response_type.AWSListBucketResponse{
+		XmlNS:             "http://s3.amazonaws.com/doc/2006-03-01/",
+		Name:              listRequest.Bucket,
+		Prefix:            listRequest.Prefix,
+		StartAfter:        listRequest.StartAfter,
+		KeyCount:          int64(len(contents) + len(prefixes)),
+		MaxKeys:           listRequest.MaxKeys,
+		IsTruncated:       &isTruncated,
+		Contents:          contents,
+		CommonPrefixes:    prefixes,
+		ContinuationToken: listRequest.ContinuationToken,
 	}
-	var contents = make([]*response_type.BucketContent, len(pageResponse))
-	var prefixes = make([]*response_type.BucketCommonPrefix, len(pageResponse))
-	for _, item := range pageResponse {
-		if strings.HasSuffix(item.Name, "/") {
-			// weird gcs phantom item
-
-		} else if item.Name != "" {
-			// object
-			contents[contentI] = GCSItemToContent(item)
-			contentI++
-		} else {
-			// folder
-			prefixes[prefixI] = GCSItemToPrefix(item)
-			prefixI++
-		}
+	if listRequest.Delimiter != nil && *listRequest.Delimiter != "" {
+		s3Resp.Delimiter = listRequest.Delimiter
+	}
+	if isTruncated {
+		next := (&ListToken{PageToken: nextToken, StartOffset: position.StartOffset}).Encode()
+		s3Resp.NextContinuationToken = &next
+	}
+	if listRequest.EncodingType == s3.EncodingTypeUrl {
+		EncodeListResponse(s3Resp)
 	}
-	s3Resp := GCSListResponseObjectsToAWS(contents, listRequest, nextToken, contentI, prefixI, prefixes)
 	return s3Resp, nil
 }
 
 func GCSAttrToCombine(input *storage.ObjectAttrs) *response_type.CompleteMultipartUploadResult {
 	etag := MD5toEtag(input.MD5)
 	location := fmt.Sprintf("http://%s.s3.amazonaws.com/%s", input.Bucket, input.Name)
+	checksum := CRC32CToChecksum(input.CRC32C)
 	return &response_type.CompleteMultipartUploadResult{
-		Bucket:   &input.Bucket,
-		Key:      &input.Name,
-		ETag:     &etag,
-		Location: &location,
+		Bucket:         &input.Bucket,
+		Key:            &input.Name,
+		ETag:           &etag,
+		Location:       &location,
+		ChecksumCRC32C: &checksum,
 	}
 }
 
+// Base64 of a big endian CRC32C, how S3 formats checksums
+func CRC32CToChecksum(crc uint32) string {
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, crc)
+	return base64.StdEncoding.EncodeToString(checksum)
+}
+
 func GCSMD5ToEtag(input *storage.ObjectAttrs, writer http.ResponseWriter) {
 	if len(input.MD5) > 0 {
 		other := MD5toEtag(input.MD5)
@@ -192,7 +185,6 @@ func GCSMD5ToEtag(input *storage.ObjectAttrs, writer http.ResponseWriter) {
 }
 
 func GCSAttrToHeaders(input *storage.ObjectAttrs, writer http.ResponseWriter) {
-	utc, _ := time.LoadLocation("UTC")
 	writer.Header().Set("Content-Length", strconv.FormatInt(input.Size, 10))
 	if input.CacheControl != "" {
 		writer.Header().Set("Cache-Control", input.CacheControl)
@@ -201,6 +193,11 @@ func GCSAttrToHeaders(input *storage.ObjectAttrs, writer http.ResponseWriter) {
 		writer.Header().Set("Cache-Type", input.ContentType)
 	}
 	GCSMD5ToEtag(input, writer)
+	GCSUpdatedToLastModified(input, writer)
+}
+
+func GCSUpdatedToLastModified(input *storage.ObjectAttrs, writer http.ResponseWriter) {
+	utc, _ := time.LoadLocation("UTC")
 	lastMod := input.Updated.In(utc).Format(time.RFC1123)
 	lastMod = strings.Replace(lastMod, "UTC", "GMT", 1)
 	writer.Header().Set("Last-Modified", lastMod)
@@ -208,36 +205,20 @@ func GCSAttrToHeaders(input *storage.ObjectAttrs, writer http.ResponseWriter) {
 
 func GCSACLResponseToAWS(input []storage.ACLRule) response_type.AWSACLResponse {
 	response := response_type.AWSACLResponse{}
-	// lets only do head
-	var grants = make([]*response_type.Grant, 1)
-	if len(input) > 0 {
-		for i, entry := range input[:1] {
-			var displayName string
-			if entry.Email != "" {
-				displayName = entry.Email
-			} else {
-				displayName = string(entry.Entity)
-			}
-			if entry.Role == storage.RoleOwner && response.OwnerId == "" {
-				response.OwnerId = string(entry.Entity)
-				response.OwnerDisplayName = displayName
-			}
-			grant := &response_type.Grant{
-				Permission: gcpPermissionToAWS(entry.Role),
-				Grantee: &response_type.Grantee{
-					DisplayName: displayName,
-					Id:          string(entry.Entity),
-					XmlNS:       response_type.ACLXmlNs,
-					Xsi:         response_type.ACLXmlXsi,
-				},
-			}
-			grants[i] = grant
+	var grants = make([]*response_type.Grant, len(input))
+	for i, entry := range input {
+		grantee := gcsEntityToGrantee(entry)
+		if entry.Role == storage.RoleOwner && response.OwnerId == "" {
+			response.OwnerId = string(entry.Entity)
+			response.OwnerDisplayName = grantee.DisplayName
 		}
-		response.AccessControlList = &response_type.AccessControlList{
-			Grants: grants,
+		grants[i] = &response_type.Grant{
+			Permission: gcpPermissionToAWS(entry.Role),
+			Grantee:    grantee,
 		}
-	} else {
-		response.AccessControlList = &response_type.AccessControlList{}
+	}
+	response.AccessControlList = &response_type.AccessControlList{
+		Grants: grants,
 	}
 	return response
 }
@@ -0,0 +1,107 @@
+package converter
+
+import (
+	"cloudsidecar/pkg/response_type"
+	"strings"
+)
+
+// GCS storage classes for S3 ones.  The infrequent access classes are nearline, instant retrieval glacier is
+// coldline and the archive classes are archive
+var s3ToGCSStorageClass = map[string]string{
+	"STANDARD":            "STANDARD",
+	"REDUCED_REDUNDANCY":  "STANDARD",
+	"INTELLIGENT_TIERING": "STANDARD",
+	"STANDARD_IA":         "NEARLINE",
+	"ONEZONE_IA":          "NEARLINE",
+	"GLACIER_IR":          "COLDLINE",
+	"GLACIER":             "COLDLINE",
+	"DEEP_ARCHIVE":        "ARCHIVE",
+}
+
+// S3 storage classes for GCS ones, including the legacy location based classes
+var gcsToS3StorageClass = map[string]string{
+	"STANDARD":                     "STANDARD",
+	"MULTI_REGIONAL":               "STANDARD",
+	"REGIONAL":                     "STANDARD",
+	"DURABLE_REDUCED_AVAILABILITY": "REDUCED_REDUNDANCY",
+	"NEARLINE":                     "STANDARD_IA",
+	"COLDLINE":                     "GLACIER_IR",
+	"ARCHIVE":                      "DEEP_ARCHIVE",
+}
+
+// S3 classes that have to be restored before they can be read
+var s3ArchiveStorageClasses = map[string]bool{
+	"GLACIER":      true,
+	"DEEP_ARCHIVE": true,
+}
+
+// Storage class translation in both directions, the defaults with any configured overrides
+type StorageClassMap struct {
+	toGCS map[string]string
+	toS3  map[string]string
+}
+
+// Mapping with overrides from S3 to GCS classes and from GCS to S3 classes.  Names are matched upper case
+// since configuration keys arrive lower cased
+func NewStorageClassMap(toGCS map[string]string, toS3 map[string]string) *StorageClassMap {
+	classes := &StorageClassMap{toGCS: make(map[string]string), toS3: make(map[string]string)}
+	for s3Class, gcsClass := range s3ToGCSStorageClass {
+		classes.toGCS[s3Class] = gcsClass
+	}
+	for gcsClass, s3Class := range gcsToS3StorageClass {
+		classes.toS3[gcsClass] = s3Class
+	}
+	for s3Class, gcsClass := range toGCS {
+		classes.toGCS[strings.ToUpper(s3Class)] = strings.ToUpper(gcsClass)
+	}
+	for gcsClass, s3Class := range toS3 {
+		classes.toS3[strings.ToUpper(gcsClass)] = strings.ToUpper(s3Class)
+	}
+	return classes
+}
+
+// The mapping with no overrides
+var DefaultStorageClasses = NewStorageClassMap(nil, nil)
+
+// GCS storage class for an S3 storage class, false if GCS has nothing like it
+func (classes *StorageClassMap) ToGCS(storageClass string) (string, bool) {
+	gcsClass, ok := classes.toGCS[storageClass]
+	return gcsClass, ok
+}
+
+// S3 storage class for a GCS storage class.  Unknown classes are passed through
+func (classes *StorageClassMap) ToS3(storageClass string) string {
+	if s3Class, ok := classes.toS3[storageClass]; ok {
+		return s3Class
+	}
+	return storageClass
+}
+
+// Rewrite the GCS storage classes of listed objects as S3 ones
+func (classes *StorageClassMap) ContentsToS3(contents []*response_type.BucketContent) {
+	for _, content := range contents {
+		content.StorageClass = classes.ToS3(content.StorageClass)
+	}
+}
+
+// Rewrite the GCS storage classes of listed object versions as S3 ones
+func (classes *StorageClassMap) VersionsToS3(versions []*response_type.ObjectVersion) {
+	for _, version := range versions {
+		version.StorageClass = classes.ToS3(version.StorageClass)
+	}
+}
+
+// GCS storage class for an S3 storage class, false if GCS has nothing like it
+func S3StorageClassToGCS(storageClass string) (string, bool) {
+	return DefaultStorageClasses.ToGCS(storageClass)
+}
+
+// S3 storage class for a GCS storage class.  Unknown classes are passed through
+func GCSStorageClassToS3(storageClass string) string {
+	return DefaultStorageClasses.ToS3(storageClass)
+}
+
+// Whether objects in an S3 storage class need a restore before they are read
+func IsArchiveStorageClass(storageClass string) bool {
+	return s3ArchiveStorageClasses[storageClass]
+}
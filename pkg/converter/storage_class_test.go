@@ -0,0 +1,40 @@
+package converter
+
+import (
+	"cloudsidecar/pkg/response_type"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestStorageClassMap(t *testing.T) {
+	gcsClass, ok := DefaultStorageClasses.ToGCS("STANDARD_IA")
+	assert.True(t, ok)
+	assert.Equal(t, "NEARLINE", gcsClass)
+	gcsClass, ok = DefaultStorageClasses.ToGCS("DEEP_ARCHIVE")
+	assert.True(t, ok)
+	assert.Equal(t, "ARCHIVE", gcsClass)
+	_, ok = DefaultStorageClasses.ToGCS("OUTPOSTS")
+	assert.False(t, ok)
+	assert.Equal(t, "GLACIER_IR", DefaultStorageClasses.ToS3("COLDLINE"))
+	assert.Equal(t, "STANDARD", DefaultStorageClasses.ToS3("MULTI_REGIONAL"))
+	assert.Equal(t, "SOMETHING_NEW", DefaultStorageClasses.ToS3("SOMETHING_NEW"))
+
+	// configuration keys arrive lower cased
+	classes := NewStorageClassMap(map[string]string{"glacier": "archive"}, map[string]string{"coldline": "glacier"})
+	gcsClass, _ = classes.ToGCS("GLACIER")
+	assert.Equal(t, "ARCHIVE", gcsClass)
+	assert.Equal(t, "GLACIER", classes.ToS3("COLDLINE"))
+	gcsClass, _ = DefaultStorageClasses.ToGCS("GLACIER")
+	assert.Equal(t, "COLDLINE", gcsClass)
+
+	contents := []*response_type.BucketContent{{StorageClass: "NEARLINE"}, {StorageClass: "ARCHIVE"}}
+	classes.ContentsToS3(contents)
+	assert.Equal(t, "STANDARD_IA", contents[0].StorageClass)
+	assert.Equal(t, "DEEP_ARCHIVE", contents[1].StorageClass)
+	versions := []*response_type.ObjectVersion{{StorageClass: "COLDLINE"}}
+	classes.VersionsToS3(versions)
+	assert.Equal(t, "GLACIER", versions[0].StorageClass)
+
+	assert.True(t, IsArchiveStorageClass("GLACIER"))
+	assert.False(t, IsArchiveStorageClass("GLACIER_IR"))
+}
@@ -0,0 +1,216 @@
+package converter
+
+import (
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/response_type"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	storage2 "google.golang.org/api/storage/v1"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version ids of emulated delete markers start with this, followed by the generation they hide
+const DeleteMarkerPrefix = "dm-"
+
+// S3 calls versions written while versioning was off null, GCS always has a generation so it is the live one
+const NullVersionId = "null"
+
+// Version id S3 clients see for a GCS generation
+func GenerationToVersionId(generation int64) string {
+	return strconv.FormatInt(generation, 10)
+}
+
+// Version id of the delete marker hiding a generation
+func GenerationToDeleteMarkerId(generation int64) string {
+	return DeleteMarkerPrefix + GenerationToVersionId(generation)
+}
+
+// Parse a version id into a generation.  Zero means the live object
+func VersionIdToGeneration(versionId string) (generation int64, deleteMarker bool, err error) {
+	if versionId == "" || versionId == NullVersionId {
+		return 0, false, nil
+	}
+	if strings.HasPrefix(versionId, DeleteMarkerPrefix) {
+		deleteMarker = true
+		versionId = versionId[len(DeleteMarkerPrefix):]
+	}
+	generation, err = strconv.ParseInt(versionId, 10, 64)
+	if err != nil || generation <= 0 {
+		return 0, false, errors.New("Invalid version id specified")
+	}
+	return generation, deleteMarker, nil
+}
+
+// One entry of a version listing, either a version or a delete marker
+type versionEntry struct {
+	key     string
+	id      string
+	version *response_type.ObjectVersion
+	marker  *response_type.DeleteMarkerEntry
+}
+
+// Turn every GCS generation of one object into S3 versions, newest first.  When no generation is live the
+// object was deleted, so a delete marker is the latest version
+func gcsGenerationsToVersions(generations []*storage.ObjectAttrs) []versionEntry {
+	sort.Slice(generations, func(i, j int) bool {
+		return generations[i].Generation > generations[j].Generation
+	})
+	entries := make([]versionEntry, 0, len(generations)+1)
+	newest := generations[0]
+	live := newest.Deleted.IsZero()
+	if !live {
+		id := GenerationToDeleteMarkerId(newest.Generation)
+		entries = append(entries, versionEntry{
+			key: newest.Name,
+			id:  id,
+			marker: &response_type.DeleteMarkerEntry{
+				Key:          newest.Name,
+				VersionId:    id,
+				IsLatest:     true,
+				LastModified: FormatTimeZulu(&newest.Deleted),
+			},
+		})
+	}
+	for i, generation := range generations {
+		id := GenerationToVersionId(generation.Generation)
+		entries = append(entries, versionEntry{
+			key: generation.Name,
+			id:  id,
+			version: &response_type.ObjectVersion{
+				Key:          generation.Name,
+				VersionId:    id,
+				IsLatest:     live && i == 0,
+				LastModified: FormatTimeZulu(&generation.Updated),
+				ETag:         MD5toEtag(generation.MD5),
+				Size:         generation.Size,
+				StorageClass: generation.StorageClass,
+			},
+		})
+	}
+	return entries
+}
+
+// List object versions from a GCS listing with versions turned on, started just before the key marker.  GCS returns
+// every generation of an object together, so they are collected per key and paginated with S3 key and version id
+// markers
+func GCSVersionsToAWS(input *storage2.ObjectsListCall, listRequest *s3.ListObjectVersionsInput) (*response_type.ListVersionsResult, error) {
+	maxKeys := int64(1000)
+	if listRequest.MaxKeys != nil && *listRequest.MaxKeys >= 0 {
+		maxKeys = *listRequest.MaxKeys
+	}
+	keyMarker := ""
+	if listRequest.KeyMarker != nil {
+		keyMarker = *listRequest.KeyMarker
+	}
+	versionIdMarker := ""
+	if listRequest.VersionIdMarker != nil {
+		versionIdMarker = *listRequest.VersionIdMarker
+	}
+	result := &response_type.ListVersionsResult{
+		XmlNS:           "http://s3.amazonaws.com/doc/2006-03-01/",
+		Name:            listRequest.Bucket,
+		Prefix:          listRequest.Prefix,
+		KeyMarker:       &keyMarker,
+		VersionIdMarker: &versionIdMarker,
+		MaxKeys:         &maxKeys,
+		Versions:        make([]*response_type.ObjectVersion, 0),
+		DeleteMarkers:   make([]*response_type.DeleteMarkerEntry, 0),
+	}
+	if listRequest.Delimiter != nil && *listRequest.Delimiter != "" {
+		result.Delimiter = listRequest.Delimiter
+	}
+	count := int64(0)
+	lastKey := ""
+	lastId := ""
+	// add an entry, returns false once the page is full
+	add := func(entry versionEntry, prefix string) bool {
+		if count >= maxKeys {
+			result.IsTruncated = true
+			result.NextKeyMarker = &lastKey
+			if lastId != "" {
+				result.NextVersionIdMarker = &lastId
+			}
+			return false
+		}
+		count++
+		if prefix != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, &response_type.BucketCommonPrefix{Prefix: prefix})
+			lastKey = prefix
+			lastId = ""
+			return true
+		}
+		if entry.version != nil {
+			result.Versions = append(result.Versions, entry.version)
+		} else {
+			result.DeleteMarkers = append(result.DeleteMarkers, entry.marker)
+		}
+		lastKey = entry.key
+		lastId = entry.id
+		return true
+	}
+	// add all versions of a key past the markers, returns false once the page is full
+	flush := func(generations []*storage.ObjectAttrs) bool {
+		if len(generations) == 0 {
+			return true
+		}
+		key := generations[0].Name
+		if key < keyMarker || (key == keyMarker && versionIdMarker == "") {
+			return true
+		}
+		skipping := key == keyMarker
+		for _, entry := range gcsGenerationsToVersions(generations) {
+			if skipping {
+				skipping = entry.id != versionIdMarker
+				continue
+			}
+			if !add(entry, "") {
+				return false
+			}
+		}
+		return true
+	}
+	if keyMarker != "" {
+		input.StartOffset(KeyBefore(keyMarker))
+	}
+	var generations []*storage.ObjectAttrs
+	pageToken := ""
+	for {
+		if pageToken != "" {
+			input.PageToken(pageToken)
+		}
+		page, err := input.Do()
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range gcsPageEntries(page) {
+			if item.Prefix != "" {
+				if !flush(generations) {
+					return result, nil
+				}
+				generations = nil
+				if item.Prefix > keyMarker && !add(versionEntry{}, item.Prefix) {
+					return result, nil
+				}
+				continue
+			}
+			if strings.HasSuffix(item.Name, "/") {
+				// weird gcs phantom item
+				continue
+			}
+			if len(generations) > 0 && generations[0].Name != item.Name {
+				if !flush(generations) {
+					return result, nil
+				}
+				generations = nil
+			}
+			generations = append(generations, item)
+		}
+		if page.NextPageToken == "" {
+			flush(generations)
+			return result, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
@@ -0,0 +1,113 @@
+package converter
+
+import (
+	"cloudsidecar/pkg/response_type"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+)
+
+// Two GCS pages of versions.  Each page sends its objects before its prefixes
+func versionPages() map[string]string {
+	return map[string]string{
+		"": `{"items":[
+			{"name":"a","generation":"1","updated":"2019-01-02T03:04:05Z"},
+			{"name":"a","generation":"2","updated":"2019-01-02T03:04:05Z"},
+			{"name":"b","generation":"3","updated":"2019-01-02T03:04:05Z","timeDeleted":"2019-01-02T04:04:05Z"}
+		],"nextPageToken":"t"}`,
+		"t": `{"items":[{"name":"d","generation":"4","updated":"2019-01-02T03:04:05Z"}],"prefixes":["c/"]}`,
+	}
+}
+
+// Versions listed from the version pages
+func listVersions(t *testing.T, listRequest *s3.ListObjectVersionsInput) (*response_type.ListVersionsResult, []url.Values) {
+	var queries []url.Values
+	server := listServer(versionPages(), &queries)
+	defer server.Close()
+	bucket := "bucket"
+	listRequest.Bucket = &bucket
+	result, err := GCSVersionsToAWS(listCall(t, server).Versions(true), listRequest)
+	assert.Nil(t, err)
+	return result, queries
+}
+
+func TestVersionIdToGeneration(t *testing.T) {
+	generation, deleteMarker, err := VersionIdToGeneration("12345")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12345), generation)
+	assert.False(t, deleteMarker)
+	generation, deleteMarker, err = VersionIdToGeneration(GenerationToDeleteMarkerId(7))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), generation)
+	assert.True(t, deleteMarker)
+	generation, _, err = VersionIdToGeneration("null")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), generation)
+	_, _, err = VersionIdToGeneration("abc")
+	assert.NotNil(t, err)
+}
+
+func TestGCSVersionsToAWS(t *testing.T) {
+	result, queries := listVersions(t, &s3.ListObjectVersionsInput{})
+	assert.Len(t, queries, 2)
+	assert.Equal(t, "", queries[0].Get("startOffset"))
+	assert.False(t, result.IsTruncated)
+	assert.Len(t, result.Versions, 4)
+	assert.Equal(t, "2", result.Versions[0].VersionId)
+	assert.True(t, result.Versions[0].IsLatest)
+	assert.Equal(t, "1", result.Versions[1].VersionId)
+	assert.False(t, result.Versions[1].IsLatest)
+	assert.Equal(t, "3", result.Versions[2].VersionId)
+	assert.False(t, result.Versions[2].IsLatest)
+	assert.Equal(t, "d", result.Versions[3].Key)
+	assert.Len(t, result.DeleteMarkers, 1)
+	assert.Equal(t, "b", result.DeleteMarkers[0].Key)
+	assert.Equal(t, "dm-3", result.DeleteMarkers[0].VersionId)
+	assert.True(t, result.DeleteMarkers[0].IsLatest)
+	assert.Equal(t, "2019-01-02T04:04:05.000Z", result.DeleteMarkers[0].LastModified)
+	assert.Len(t, result.CommonPrefixes, 1)
+}
+
+func TestGCSVersionsToAWS_Paging(t *testing.T) {
+	maxKeys := int64(2)
+	result, _ := listVersions(t, &s3.ListObjectVersionsInput{MaxKeys: &maxKeys})
+	assert.True(t, result.IsTruncated)
+	assert.Equal(t, "a", *result.NextKeyMarker)
+	assert.Equal(t, "1", *result.NextVersionIdMarker)
+
+	result, queries := listVersions(t, &s3.ListObjectVersionsInput{
+		MaxKeys:         &maxKeys,
+		KeyMarker:       result.NextKeyMarker,
+		VersionIdMarker: result.NextVersionIdMarker,
+	})
+	// GCS starts the listing at the key marker
+	assert.Equal(t, KeyBefore("a"), queries[0].Get("startOffset"))
+	assert.True(t, result.IsTruncated)
+	assert.Len(t, result.DeleteMarkers, 1)
+	assert.Len(t, result.Versions, 1)
+	assert.Equal(t, "3", result.Versions[0].VersionId)
+	assert.Equal(t, "b", *result.NextKeyMarker)
+
+	// the prefix sorts before the key GCS sent ahead of it
+	maxKeys = 1
+	result, _ = listVersions(t, &s3.ListObjectVersionsInput{
+		MaxKeys:         &maxKeys,
+		KeyMarker:       result.NextKeyMarker,
+		VersionIdMarker: result.NextVersionIdMarker,
+	})
+	assert.True(t, result.IsTruncated)
+	assert.Len(t, result.Versions, 0)
+	assert.Equal(t, "c/", result.CommonPrefixes[0].Prefix)
+	assert.Equal(t, "c/", *result.NextKeyMarker)
+	assert.Nil(t, result.NextVersionIdMarker)
+
+	result, _ = listVersions(t, &s3.ListObjectVersionsInput{
+		MaxKeys:   &maxKeys,
+		KeyMarker: result.NextKeyMarker,
+	})
+	assert.False(t, result.IsTruncated)
+	assert.Len(t, result.CommonPrefixes, 0)
+	assert.Len(t, result.Versions, 1)
+	assert.Equal(t, "d", result.Versions[0].Key)
+}
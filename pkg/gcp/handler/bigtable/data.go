@@ -0,0 +1,343 @@
+package bigtable
+
+import (
+	"bytes"
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"context"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	bigtablepb "google.golang.org/genproto/googleapis/bigtable/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Most keys DynamoDB gets in one BatchGetItem call
+const maxBatchGetKeys = 100
+
+// How long to wait before asking again for keys DynamoDB left unprocessed
+const unprocessedBackoff = 50 * time.Millisecond
+
+// A row read from DynamoDB
+type dynamoRow struct {
+	key   []byte
+	cells []converter.BigtableCell
+}
+
+// Stream rows in key order, stopping at the rows limit.  Rows named by key are fetched directly, row ranges and whole
+// table reads query each partition a page at a time
+func (handler *Handler) ReadRows(request *bigtablepb.ReadRowsRequest, stream bigtablepb.Bigtable_ReadRowsServer) error {
+	if !handler.Config.IsSet("aws_destination_config") {
+		return handler.gcpReadRows(request, stream)
+	}
+	ctx := stream.Context()
+	table, err := handler.dynamoTable(request.TableName)
+	if err != nil {
+		return err
+	}
+	var sent int64
+	send := func(row dynamoRow) (bool, error) {
+		cells, err := converter.FilterBigtableCells(row.key, row.cells, request.Filter)
+		if err != nil {
+			return false, filterError(err)
+		}
+		// rows the filter left no cells in aren't sent
+		if len(cells) == 0 {
+			return true, nil
+		}
+		if err := stream.Send(&bigtablepb.ReadRowsResponse{Chunks: converter.BigtableCellChunks(row.key, cells)}); err != nil {
+			return false, err
+		}
+		sent++
+		return request.RowsLimit <= 0 || sent < request.RowsLimit, nil
+	}
+	if request.Rows != nil && len(request.Rows.RowRanges) == 0 && len(request.Rows.RowKeys) > 0 {
+		err = handler.getRows(ctx, table, request.Rows.RowKeys, send)
+	} else {
+		err = handler.queryRows(ctx, table, request.Rows, request.RowsLimit, send)
+	}
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.TableName, err)
+	}
+	return err
+}
+
+// Visit rows with the given keys in key order, fetched in batches, until visit returns false
+func (handler *Handler) getRows(ctx context.Context, table string, keys [][]byte, visit func(row dynamoRow) (bool, error)) error {
+	// DynamoDB rejects batches naming a key twice
+	unique := make([][]byte, 0, len(keys))
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if !seen[string(key)] {
+			seen[string(key)] = true
+			unique = append(unique, key)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool { return bytes.Compare(unique[i], unique[j]) < 0 })
+	consistent := true
+	for start := 0; start < len(unique); start += maxBatchGetKeys {
+		end := start + maxBatchGetKeys
+		if end > len(unique) {
+			end = len(unique)
+		}
+		itemKeys := make([]map[string]dynamodb.AttributeValue, 0, end-start)
+		for _, key := range unique[start:end] {
+			itemKeys = append(itemKeys, handler.itemKey(key))
+		}
+		rows := make([]dynamoRow, 0, len(itemKeys))
+		requestItems := map[string]dynamodb.KeysAndAttributes{table: {Keys: itemKeys, ConsistentRead: &consistent}}
+		for len(requestItems) > 0 {
+			request := handler.DynamoClient.BatchGetItemRequest(&dynamodb.BatchGetItemInput{RequestItems: requestItems})
+			request.SetContext(ctx)
+			output, err := request.Send()
+			if err != nil {
+				return awsError(err)
+			}
+			for _, item := range output.Responses[table] {
+				row, err := handler.itemRow(item)
+				if err != nil {
+					return err
+				}
+				rows = append(rows, row)
+			}
+			requestItems = output.UnprocessedKeys
+			if len(requestItems) > 0 {
+				time.Sleep(unprocessedBackoff)
+			}
+		}
+		// batches come back in any order, but the keys are sorted so each batch follows the last
+		sort.Slice(rows, func(i, j int) bool { return bytes.Compare(rows[i].key, rows[j].key) < 0 })
+		for _, row := range rows {
+			if more, err := visit(row); err != nil || !more {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A partition's rows in a key range, queried a page at a time
+type rowPager struct {
+	input *dynamodb.QueryInput
+	rows  []dynamoRow
+	done  bool
+}
+
+// Visit rows of a table in a row set in key order until visit returns false.  Each range queries every partition,
+// merging their pages by row key, with pages no bigger than the rows limit
+func (handler *Handler) queryRows(ctx context.Context, table string, rowSet *bigtablepb.RowSet, limit int64, visit func(row dynamoRow) (bool, error)) error {
+	var last []byte
+	for _, keyRange := range converter.BigtableKeyRanges(rowSet) {
+		// ranges can overlap, so rows already visited aren't queried again
+		if last != nil && (len(keyRange.Start) == 0 || bytes.Compare(keyRange.Start, last) <= 0) {
+			keyRange.Start, keyRange.StartOpen = last, true
+			if keyRange.Empty() {
+				continue
+			}
+		}
+		pagers := make([]*rowPager, handler.partitions())
+		for partition := range pagers {
+			pagers[partition] = &rowPager{input: handler.queryInput(table, partition, keyRange, limit)}
+		}
+		for {
+			var next *rowPager
+			for _, pager := range pagers {
+				if err := handler.nextPage(ctx, pager); err != nil {
+					return err
+				}
+				if len(pager.rows) > 0 && (next == nil || bytes.Compare(pager.rows[0].key, next.rows[0].key) < 0) {
+					next = pager
+				}
+			}
+			if next == nil {
+				break
+			}
+			row := next.rows[0]
+			next.rows = next.rows[1:]
+			// open bounds are queried as closed ones
+			if !keyRange.Contains(row.key) {
+				continue
+			}
+			last = row.key
+			if more, err := visit(row); err != nil || !more {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Query of a partition's rows in a key range
+func (handler *Handler) queryInput(table string, partition int, keyRange converter.BigtableKeyRange, limit int64) *dynamodb.QueryInput {
+	partitionValue := strconv.Itoa(partition)
+	condition := "#p = :p"
+	names := map[string]string{"#p": handler.partitionAttribute()}
+	values := map[string]dynamodb.AttributeValue{":p": {N: &partitionValue}}
+	switch {
+	case len(keyRange.Start) > 0 && len(keyRange.End) > 0:
+		condition += " AND #k BETWEEN :s AND :e"
+	case len(keyRange.Start) > 0:
+		condition += " AND #k >= :s"
+	case len(keyRange.End) > 0:
+		condition += " AND #k <= :e"
+	}
+	if len(keyRange.Start) > 0 || len(keyRange.End) > 0 {
+		names["#k"] = handler.keyAttribute()
+	}
+	if len(keyRange.Start) > 0 {
+		values[":s"] = dynamodb.AttributeValue{B: keyRange.Start}
+	}
+	if len(keyRange.End) > 0 {
+		values[":e"] = dynamodb.AttributeValue{B: keyRange.End}
+	}
+	consistent := true
+	input := &dynamodb.QueryInput{
+		TableName:                 &table,
+		KeyConditionExpression:    &condition,
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ConsistentRead:            &consistent,
+	}
+	if limit > 0 {
+		input.Limit = &limit
+	}
+	return input
+}
+
+// Query a pager's next page once its rows run out
+func (handler *Handler) nextPage(ctx context.Context, pager *rowPager) error {
+	for len(pager.rows) == 0 && !pager.done {
+		request := handler.DynamoClient.QueryRequest(pager.input)
+		request.SetContext(ctx)
+		output, err := request.Send()
+		if err != nil {
+			return awsError(err)
+		}
+		for _, item := range output.Items {
+			row, err := handler.itemRow(item)
+			if err != nil {
+				return err
+			}
+			pager.rows = append(pager.rows, row)
+		}
+		pager.input.ExclusiveStartKey = output.LastEvaluatedKey
+		pager.done = len(output.LastEvaluatedKey) == 0
+	}
+	return nil
+}
+
+func (handler *Handler) itemRow(item map[string]dynamodb.AttributeValue) (dynamoRow, error) {
+	cells, err := handler.itemCells(item)
+	if err != nil {
+		return dynamoRow{}, err
+	}
+	return dynamoRow{key: item[handler.keyAttribute()].B, cells: cells}, nil
+}
+
+// Apply mutations to a row
+func (handler *Handler) MutateRow(ctx context.Context, request *bigtablepb.MutateRowRequest) (*bigtablepb.MutateRowResponse, error) {
+	if !handler.Config.IsSet("aws_destination_config") {
+		return handler.GCPClient.MutateRow(outgoingContext(ctx), request)
+	}
+	table, err := handler.dynamoTable(request.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if len(request.Mutations) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no mutations")
+	}
+	err = handler.changeRow(ctx, table, request.RowKey, func(cells []converter.BigtableCell, now int64) ([]converter.BigtableCell, error) {
+		return converter.ApplyBigtableMutations(cells, request.Mutations, now, handler.maxVersions())
+	})
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.TableName, err)
+		return nil, err
+	}
+	return &bigtablepb.MutateRowResponse{}, nil
+}
+
+// Apply mutations to many rows, each on its own.  Every entry gets a status in one response
+func (handler *Handler) MutateRows(request *bigtablepb.MutateRowsRequest, stream bigtablepb.Bigtable_MutateRowsServer) error {
+	if !handler.Config.IsSet("aws_destination_config") {
+		return handler.gcpMutateRows(request, stream)
+	}
+	table, err := handler.dynamoTable(request.TableName)
+	if err != nil {
+		return err
+	}
+	if len(request.Entries) == 0 {
+		return status.Error(codes.InvalidArgument, "no entries")
+	}
+	ctx := stream.Context()
+	entries := make([]*bigtablepb.MutateRowsResponse_Entry, len(request.Entries))
+	for i, entry := range request.Entries {
+		mutations := entry.Mutations
+		err := handler.changeRow(ctx, table, entry.RowKey, func(cells []converter.BigtableCell, now int64) ([]converter.BigtableCell, error) {
+			return converter.ApplyBigtableMutations(cells, mutations, now, handler.maxVersions())
+		})
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.TableName, err)
+		}
+		entries[i] = &bigtablepb.MutateRowsResponse_Entry{Index: int64(i), Status: status.Convert(err).Proto()}
+	}
+	return stream.Send(&bigtablepb.MutateRowsResponse{Entries: entries})
+}
+
+// Apply the true or false mutations to a row depending on whether the predicate filter leaves it any cells
+func (handler *Handler) CheckAndMutateRow(ctx context.Context, request *bigtablepb.CheckAndMutateRowRequest) (*bigtablepb.CheckAndMutateRowResponse, error) {
+	if !handler.Config.IsSet("aws_destination_config") {
+		return handler.GCPClient.CheckAndMutateRow(outgoingContext(ctx), request)
+	}
+	table, err := handler.dynamoTable(request.TableName)
+	if err != nil {
+		return nil, err
+	}
+	var matched bool
+	err = handler.changeRow(ctx, table, request.RowKey, func(cells []converter.BigtableCell, now int64) ([]converter.BigtableCell, error) {
+		predicateCells := cells
+		if request.PredicateFilter != nil {
+			var err error
+			if predicateCells, err = converter.FilterBigtableCells(request.RowKey, cells, request.PredicateFilter); err != nil {
+				return nil, filterError(err)
+			}
+		}
+		matched = len(predicateCells) > 0
+		mutations := request.FalseMutations
+		if matched {
+			mutations = request.TrueMutations
+		}
+		return converter.ApplyBigtableMutations(cells, mutations, now, handler.maxVersions())
+	})
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.TableName, err)
+		return nil, err
+	}
+	return &bigtablepb.CheckAndMutateRowResponse{PredicateMatched: matched}, nil
+}
+
+// Append to or increment the latest cells of columns, returning the new cells
+func (handler *Handler) ReadModifyWriteRow(ctx context.Context, request *bigtablepb.ReadModifyWriteRowRequest) (*bigtablepb.ReadModifyWriteRowResponse, error) {
+	if !handler.Config.IsSet("aws_destination_config") {
+		return handler.GCPClient.ReadModifyWriteRow(outgoingContext(ctx), request)
+	}
+	table, err := handler.dynamoTable(request.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if len(request.Rules) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no rules")
+	}
+	var changed []converter.BigtableCell
+	err = handler.changeRow(ctx, table, request.RowKey, func(cells []converter.BigtableCell, now int64) ([]converter.BigtableCell, error) {
+		var err error
+		cells, changed, err = converter.ReadModifyWriteBigtableCells(cells, request.Rules, now, handler.maxVersions())
+		return cells, err
+	})
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.TableName, err)
+		return nil, err
+	}
+	return &bigtablepb.ReadModifyWriteRowResponse{Row: converter.BigtableCellsToRow(request.RowKey, changed)}, nil
+}
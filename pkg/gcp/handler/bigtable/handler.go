@@ -0,0 +1,294 @@
+package bigtable
+
+import (
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/spf13/viper"
+	bigtablepb "google.golang.org/genproto/googleapis/bigtable/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config keys for the DynamoDB tables standing in for Bigtable tables
+const tableMapKey = "aws_destination_config.bigtable_config.table_map"
+const keyAttributeKey = "aws_destination_config.bigtable_config.key_attribute"
+const maxVersionsKey = "aws_destination_config.bigtable_config.max_versions"
+const partitionAttributeKey = "aws_destination_config.bigtable_config.partition_attribute"
+const partitionsKey = "aws_destination_config.bigtable_config.partitions"
+
+// Attribute holding the row key unless key_attribute names another
+const defaultKeyAttribute = "row_key"
+
+// Attribute holding a row's partition unless partition_attribute names another
+const defaultPartitionAttribute = "row_partition"
+
+// Attribute counting a row's writes, so writes that raced another are retried
+const versionAttribute = "row_version"
+
+// How many times a write that raced another is retried
+const maxWriteAttempts = 5
+
+// grpc codes for DynamoDB error codes
+var awsErrorCodes = map[string]codes.Code{
+	dynamodb.ErrCodeResourceNotFoundException:                codes.NotFound,
+	dynamodb.ErrCodeTableNotFoundException:                   codes.NotFound,
+	dynamodb.ErrCodeConditionalCheckFailedException:          codes.Aborted,
+	dynamodb.ErrCodeProvisionedThroughputExceededException:   codes.ResourceExhausted,
+	dynamodb.ErrCodeRequestLimitExceeded:                     codes.ResourceExhausted,
+	dynamodb.ErrCodeLimitExceededException:                   codes.ResourceExhausted,
+	dynamodb.ErrCodeItemCollectionSizeLimitExceededException: codes.ResourceExhausted,
+	dynamodb.ErrCodeInternalServerError:                      codes.Internal,
+	"ValidationException":                                    codes.InvalidArgument,
+	"RequestCanceled":                                        codes.Canceled,
+}
+
+// Bigtable Data API server.  Rows are kept in DynamoDB with the AWS destination, one item per row with a numeric
+// partition hashed from the row key as hash key, the row key as sort key and one map attribute per column family, or
+// sent on to Bigtable with the GCP destination
+type Handler struct {
+	bigtablepb.UnimplementedBigtableServer
+	DynamoClient *dynamodb.DynamoDB
+	GCPClient    bigtablepb.BigtableClient
+	GCPConn      *grpc.ClientConn
+	Context      *context.Context
+	Config       *viper.Viper
+}
+
+func NewHandler(config *viper.Viper) Handler {
+	return Handler{
+		Config: config,
+	}
+}
+
+func (handler *Handler) Shutdown() {
+	logging.Log.Debug("Closing bigtable frontend")
+	if handler.GCPConn != nil {
+		if err := handler.GCPConn.Close(); err != nil {
+			logging.Log.Error("Some error closing bigtable", err)
+		}
+	}
+}
+func (handler *Handler) GetContext() *context.Context {
+	return handler.Context
+}
+func (handler *Handler) GetConfig() *viper.Viper {
+	return handler.Config
+}
+func (handler *Handler) SetContext(context *context.Context) {
+	handler.Context = context
+}
+func (handler *Handler) SetConfig(config *viper.Viper) {
+	handler.Config = config
+}
+
+// Register the Bigtable service
+func (handler *Handler) Register(server *grpc.Server) {
+	bigtablepb.RegisterBigtableServer(server, handler)
+}
+
+// DynamoDB table standing in for a Bigtable table, projects/p/instances/i/tables/t.  Tables table_map doesn't name are
+// DynamoDB tables named for the table id
+func (handler *Handler) dynamoTable(tableName string) (string, error) {
+	pieces := strings.Split(tableName, "/")
+	if len(pieces) != 6 || pieces[0] != "projects" || pieces[2] != "instances" || pieces[4] != "tables" || pieces[5] == "" {
+		return "", status.Error(codes.InvalidArgument, "invalid table "+tableName)
+	}
+	if mapped, ok := handler.Config.GetStringMapString(tableMapKey)[strings.ToLower(tableName)]; ok {
+		return mapped, nil
+	}
+	return pieces[5], nil
+}
+
+func (handler *Handler) keyAttribute() string {
+	if name := handler.Config.GetString(keyAttributeKey); name != "" {
+		return name
+	}
+	return defaultKeyAttribute
+}
+
+func (handler *Handler) partitionAttribute() string {
+	if name := handler.Config.GetString(partitionAttributeKey); name != "" {
+		return name
+	}
+	return defaultPartitionAttribute
+}
+
+// How many partitions rows are hashed into, 1 unless partitions says otherwise.  Reads of ranges query every partition
+func (handler *Handler) partitions() int {
+	if partitions := handler.Config.GetInt(partitionsKey); partitions > 1 {
+		return partitions
+	}
+	return 1
+}
+
+// Partition of a row key
+func (handler *Handler) rowPartition(key []byte) int {
+	partitions := handler.partitions()
+	if partitions == 1 {
+		return 0
+	}
+	hash := fnv.New32a()
+	hash.Write(key)
+	return int(hash.Sum32() % uint32(partitions))
+}
+
+// Cells kept per column, 1 unless max_versions says otherwise.  0 keeps them all
+func (handler *Handler) maxVersions() int {
+	if !handler.Config.IsSet(maxVersionsKey) {
+		return 1
+	}
+	return handler.Config.GetInt(maxVersionsKey)
+}
+
+// Key of a row's item
+func (handler *Handler) itemKey(key []byte) map[string]dynamodb.AttributeValue {
+	partition := strconv.Itoa(handler.rowPartition(key))
+	return map[string]dynamodb.AttributeValue{
+		handler.partitionAttribute(): {N: &partition},
+		handler.keyAttribute():       {B: key},
+	}
+}
+
+// Cells of a row's item
+func (handler *Handler) itemCells(item map[string]dynamodb.AttributeValue) ([]converter.BigtableCell, error) {
+	cells, err := converter.DynamoItemToBigtableCells(item, handler.partitionAttribute(), handler.keyAttribute(), versionAttribute)
+	if err != nil {
+		return nil, status.Error(codes.DataLoss, err.Error())
+	}
+	return cells, nil
+}
+
+// Read a row's cells, whether its item exists, and the version its next write has to find.  Items written by others
+// may have no version
+func (handler *Handler) loadRow(ctx context.Context, table string, key []byte) ([]converter.BigtableCell, bool, *string, error) {
+	consistent := true
+	request := handler.DynamoClient.GetItemRequest(&dynamodb.GetItemInput{
+		TableName:      &table,
+		Key:            handler.itemKey(key),
+		ConsistentRead: &consistent,
+	})
+	request.SetContext(ctx)
+	output, err := request.Send()
+	if err != nil {
+		return nil, false, nil, awsError(err)
+	}
+	if output.Item == nil {
+		return []converter.BigtableCell{}, false, nil, nil
+	}
+	cells, err := handler.itemCells(output.Item)
+	return cells, true, output.Item[versionAttribute].N, err
+}
+
+// Write a row's cells if nothing else wrote it since it was read.  Rows left with no cells are deleted
+func (handler *Handler) saveRow(ctx context.Context, table string, key []byte, cells []converter.BigtableCell, exists bool, version *string) error {
+	condition := "attribute_not_exists(#k)"
+	names := map[string]string{"#k": handler.keyAttribute()}
+	var values map[string]dynamodb.AttributeValue
+	next := "1"
+	if version != nil {
+		condition = "#v = :v"
+		names = map[string]string{"#v": versionAttribute}
+		values = map[string]dynamodb.AttributeValue{":v": {N: version}}
+		if current, err := strconv.ParseInt(*version, 10, 64); err == nil {
+			next = strconv.FormatInt(current+1, 10)
+		}
+	} else if exists {
+		condition = "attribute_not_exists(#v)"
+		names = map[string]string{"#v": versionAttribute}
+	}
+	if len(cells) == 0 {
+		if !exists {
+			return nil
+		}
+		request := handler.DynamoClient.DeleteItemRequest(&dynamodb.DeleteItemInput{
+			TableName:                 &table,
+			Key:                       handler.itemKey(key),
+			ConditionExpression:       &condition,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		})
+		request.SetContext(ctx)
+		_, err := request.Send()
+		return err
+	}
+	item := converter.BigtableCellsToDynamoItem(cells)
+	for name := range item {
+		if name == handler.partitionAttribute() || name == handler.keyAttribute() || name == versionAttribute {
+			return status.Errorf(codes.InvalidArgument, "column family %s is reserved", name)
+		}
+	}
+	for name, value := range handler.itemKey(key) {
+		item[name] = value
+	}
+	item[versionAttribute] = dynamodb.AttributeValue{N: &next}
+	request := handler.DynamoClient.PutItemRequest(&dynamodb.PutItemInput{
+		TableName:                 &table,
+		Item:                      item,
+		ConditionExpression:       &condition,
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	request.SetContext(ctx)
+	_, err := request.Send()
+	return err
+}
+
+// Read, change and write a row, trying again when another write got there first.  DynamoDB has no row mutations to
+// match Bigtable's, so every write is a whole item
+func (handler *Handler) changeRow(ctx context.Context, table string, key []byte, change func(cells []converter.BigtableCell, now int64) ([]converter.BigtableCell, error)) error {
+	if len(key) == 0 {
+		return status.Error(codes.InvalidArgument, "row key is empty")
+	}
+	for attempt := 0; ; attempt++ {
+		cells, exists, version, err := handler.loadRow(ctx, table, key)
+		if err != nil {
+			return err
+		}
+		cells, err = change(cells, time.Now().UnixNano()/int64(time.Microsecond))
+		if err != nil {
+			if _, ok := status.FromError(err); !ok {
+				err = status.Error(codes.InvalidArgument, err.Error())
+			}
+			return err
+		}
+		err = handler.saveRow(ctx, table, key, cells, exists, version)
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException && attempt < maxWriteAttempts {
+			continue
+		}
+		if err != nil {
+			return awsError(err)
+		}
+		return nil
+	}
+}
+
+// grpc status for an error from applying a row filter
+func filterError(err error) error {
+	if errors.Is(err, converter.ErrUnsupportedBigtableFilter) {
+		return status.Error(codes.Unimplemented, err.Error())
+	}
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+// grpc status for an error from AWS
+func awsError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		if code, ok := awsErrorCodes[awsErr.Code()]; ok {
+			return status.Error(code, awsErr.Message())
+		}
+		return status.Error(codes.Internal, awsErr.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
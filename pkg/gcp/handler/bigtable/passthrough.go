@@ -0,0 +1,83 @@
+package bigtable
+
+import (
+	"context"
+	bigtablepb "google.golang.org/genproto/googleapis/bigtable/v2"
+	"google.golang.org/grpc/metadata"
+	"io"
+)
+
+// Context for a call to Bigtable carrying the caller's metadata, like the request params Bigtable routes on.  The
+// caller's credentials are dropped since the connection has the sidecar's own
+func outgoingContext(ctx context.Context) context.Context {
+	incoming, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	outgoing := incoming.Copy()
+	delete(outgoing, "authorization")
+	return metadata.NewOutgoingContext(ctx, outgoing)
+}
+
+// Pass a ReadRows stream through from Bigtable
+func (handler *Handler) gcpReadRows(request *bigtablepb.ReadRowsRequest, stream bigtablepb.Bigtable_ReadRowsServer) error {
+	client, err := handler.GCPClient.ReadRows(outgoingContext(stream.Context()), request)
+	if err != nil {
+		return err
+	}
+	for {
+		response, err := client.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+}
+
+// Pass a MutateRows stream through from Bigtable
+func (handler *Handler) gcpMutateRows(request *bigtablepb.MutateRowsRequest, stream bigtablepb.Bigtable_MutateRowsServer) error {
+	client, err := handler.GCPClient.MutateRows(outgoingContext(stream.Context()), request)
+	if err != nil {
+		return err
+	}
+	for {
+		response, err := client.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+}
+
+// Pass SampleRowKeys through from Bigtable.  DynamoDB tables have no row key samples to give
+func (handler *Handler) SampleRowKeys(request *bigtablepb.SampleRowKeysRequest, stream bigtablepb.Bigtable_SampleRowKeysServer) error {
+	if handler.Config.IsSet("aws_destination_config") {
+		return handler.UnimplementedBigtableServer.SampleRowKeys(request, stream)
+	}
+	client, err := handler.GCPClient.SampleRowKeys(outgoingContext(stream.Context()), request)
+	if err != nil {
+		return err
+	}
+	for {
+		response, err := client.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+}
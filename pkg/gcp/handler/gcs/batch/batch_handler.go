@@ -0,0 +1,154 @@
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	gcs_handler "cloudsidecar/pkg/gcp/handler/gcs"
+	"cloudsidecar/pkg/logging"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+)
+
+// Most requests the JSON API takes in one batch
+const maxBatchRequests = 100
+
+// Interface for batch functions
+type Batch interface {
+	Register(mux *mux.Router)
+	BatchHandle(writer http.ResponseWriter, request *http.Request)
+	BatchParseInput(request *http.Request) ([]*BatchPart, error)
+}
+
+type Handler struct {
+	*gcs_handler.Handler
+	// where the embedded requests are sent
+	Router http.Handler
+}
+
+// One request out of a batch
+type BatchPart struct {
+	ContentId string
+	Request   *http.Request
+}
+
+func New(gcsHandler *gcs_handler.Handler, router http.Handler) *Handler {
+	return &Handler{Handler: gcsHandler, Router: router}
+}
+
+// Register HTTP patterns to functions
+func (wrapper *Handler) Register(mux *mux.Router) {
+	keyFromUrl := wrapper.Config.Get("gcp_destination_config.key_from_url")
+	if keyFromUrl != nil && keyFromUrl == true {
+		mux.HandleFunc("/{creds}/batch/storage/v1", wrapper.BatchHandle).Methods("POST")
+	} else {
+		mux.HandleFunc("/batch/storage/v1", wrapper.BatchHandle).Methods("POST")
+	}
+}
+
+// Run each request of a multipart/mixed batch and answer with a multipart/mixed body of their responses
+func (wrapper *Handler) BatchHandle(writer http.ResponseWriter, request *http.Request) {
+	parts, err := wrapper.BatchParseInput(request)
+	if err != nil {
+		writer.WriteHeader(400)
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		writer.Write([]byte(string(fmt.Sprint(err))))
+		return
+	}
+	multipartWriter := multipart.NewWriter(writer)
+	writer.Header().Set("Content-Type", "multipart/mixed; boundary="+multipartWriter.Boundary())
+	writer.WriteHeader(200)
+	for _, part := range parts {
+		recorder := httptest.NewRecorder()
+		wrapper.Router.ServeHTTP(recorder, part.Request)
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		if part.ContentId != "" {
+			header.Set("Content-ID", "<response-"+part.ContentId+">")
+		}
+		partWriter, err := multipartWriter.CreatePart(header)
+		if err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			return
+		}
+		response := recorder.Result()
+		response.ContentLength = int64(recorder.Body.Len())
+		if err := response.Write(partWriter); err != nil {
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			return
+		}
+	}
+	multipartWriter.Close()
+}
+
+func (wrapper *Handler) BatchParseInput(request *http.Request) ([]*BatchPart, error) {
+	mediaType, params, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return nil, errors.New("batch requests must be multipart/mixed")
+	}
+	// embedded paths leave out the credentials a key_from_url path starts with
+	prefix := ""
+	if creds := mux.Vars(request)["creds"]; creds != "" {
+		prefix = "/" + creds
+	}
+	parts := make([]*BatchPart, 0)
+	reader := multipart.NewReader(request.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) == maxBatchRequests {
+			return nil, fmt.Errorf("a batch holds at most %d requests", maxBatchRequests)
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		embedded, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(body)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid request in batch: %s", err)
+		}
+		if strings.HasPrefix(embedded.URL.Path, "/batch/") {
+			return nil, errors.New("batches can't be nested")
+		}
+		// clients may send absolute urls, the router only needs the path
+		embedded.URL.Scheme = ""
+		embedded.URL.Host = ""
+		embedded.URL.Path = prefix + embedded.URL.Path
+		if embedded.URL.RawPath != "" {
+			embedded.URL.RawPath = prefix + embedded.URL.RawPath
+		}
+		embedded.RequestURI = embedded.URL.RequestURI()
+		embedded.Host = request.Host
+		embedded.RemoteAddr = request.RemoteAddr
+		// headers on the batch apply to every request that doesn't set its own
+		for name, values := range request.Header {
+			if strings.HasPrefix(name, "Content-") {
+				continue
+			}
+			if _, ok := embedded.Header[name]; !ok {
+				embedded.Header[name] = values
+			}
+		}
+		parts = append(parts, &BatchPart{
+			ContentId: strings.Trim(part.Header.Get("Content-ID"), "<>"),
+			Request:   embedded.WithContext(request.Context()),
+		})
+	}
+	return parts, nil
+}
@@ -0,0 +1,110 @@
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	gcs_handler "cloudsidecar/pkg/gcp/handler/gcs"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+)
+
+// Router with the batch handler and object routes answering with what they were asked
+func batchRouter() *mux.Router {
+	router := mux.NewRouter()
+	gcsHandler := gcs_handler.NewHandler(viper.New())
+	New(&gcsHandler, router).Register(router)
+	router.HandleFunc("/storage/v1/b/{bucket}/o/{key}", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(writer, `{"bucket":"%s","name":"%s"}`, mux.Vars(request)["bucket"], mux.Vars(request)["key"])
+	}).Methods("GET")
+	router.HandleFunc("/storage/v1/b/{bucket}/o/{key}", func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNoContent)
+	}).Methods("DELETE")
+	return router
+}
+
+// Batch request of embedded requests, each given as its request line
+func batchRequest(t *testing.T, requestLines ...string) *http.Request {
+	body := &bytes.Buffer{}
+	multipartWriter := multipart.NewWriter(body)
+	for i, requestLine := range requestLines {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-ID", fmt.Sprintf("<item%d>", i+1))
+		partWriter, err := multipartWriter.CreatePart(header)
+		assert.Nil(t, err)
+		fmt.Fprintf(partWriter, "%s\r\nHost: storage.googleapis.com\r\n\r\n", requestLine)
+	}
+	multipartWriter.Close()
+	request := httptest.NewRequest("POST", "http://localhost/batch/storage/v1", body)
+	request.Header.Set("Content-Type", "multipart/mixed; boundary="+multipartWriter.Boundary())
+	return request
+}
+
+func TestHandler_BatchHandle(t *testing.T) {
+	writer := httptest.NewRecorder()
+	batchRouter().ServeHTTP(writer, batchRequest(t,
+		"GET /storage/v1/b/boops/o/meow HTTP/1.1",
+		"DELETE https://storage.googleapis.com/storage/v1/b/boops/o/meow HTTP/1.1",
+	))
+	assert.Equal(t, 200, writer.Code)
+	mediaType, params, err := mime.ParseMediaType(writer.Header().Get("Content-Type"))
+	assert.Nil(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+	reader := multipart.NewReader(writer.Body, params["boundary"])
+
+	part, err := reader.NextPart()
+	assert.Nil(t, err)
+	assert.Equal(t, "<response-item1>", part.Header.Get("Content-ID"))
+	assert.Equal(t, "application/http", part.Header.Get("Content-Type"))
+	response, err := http.ReadResponse(bufio.NewReader(part), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "200 OK", response.Status)
+	body, _ := ioutil.ReadAll(response.Body)
+	assert.Equal(t, `{"bucket":"boops","name":"meow"}`, string(body))
+
+	part, err = reader.NextPart()
+	assert.Nil(t, err)
+	assert.Equal(t, "<response-item2>", part.Header.Get("Content-ID"))
+	response, err = http.ReadResponse(bufio.NewReader(part), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "204 No Content", response.Status)
+
+	_, err = reader.NextPart()
+	assert.NotNil(t, err)
+}
+
+func TestHandler_BatchHandleTooManyRequests(t *testing.T) {
+	requestLines := make([]string, maxBatchRequests+1)
+	for i := range requestLines {
+		requestLines[i] = "GET /storage/v1/b/boops/o/meow HTTP/1.1"
+	}
+	writer := httptest.NewRecorder()
+	batchRouter().ServeHTTP(writer, batchRequest(t, requestLines...))
+	assert.Equal(t, 400, writer.Code)
+	assert.Contains(t, writer.Body.String(), "at most 100 requests")
+
+	// a full batch is fine
+	writer = httptest.NewRecorder()
+	batchRouter().ServeHTTP(writer, batchRequest(t, requestLines[1:]...))
+	assert.Equal(t, 200, writer.Code)
+}
+
+func TestHandler_BatchHandleNested(t *testing.T) {
+	writer := httptest.NewRecorder()
+	batchRouter().ServeHTTP(writer, batchRequest(t,
+		"GET /storage/v1/b/boops/o/meow HTTP/1.1",
+		"POST /batch/storage/v1 HTTP/1.1",
+	))
+	assert.Equal(t, 400, writer.Code)
+	assert.Contains(t, writer.Body.String(), "nested")
+}
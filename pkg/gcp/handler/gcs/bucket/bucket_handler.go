@@ -1,7 +1,7 @@
 package bucket
 
 import (
-	original_storage "cloud.google.com/go/storage"
+	"cloudsidecar/pkg/converter"
 	gcs_handler "cloudsidecar/pkg/gcp/handler/gcs"
 	"cloudsidecar/pkg/logging"
 	"encoding/json"
@@ -9,7 +9,6 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gorilla/mux"
-	"google.golang.org/api/iterator"
 	"google.golang.org/api/storage/v1"
 	"net/http"
 	"strconv"
@@ -20,11 +19,21 @@ type Bucket interface {
 	Register(mux *mux.Router)
 	New(s3Handler *gcs_handler.Handler) *Handler
 	ListHandle(writer http.ResponseWriter, request *http.Request)
-	ListParseInput(request *http.Request) (*s3.ListObjectsV2Input, error)
+	ListParseInput(request *http.Request) (*ListRequest, error)
 	ACLHandle(writer http.ResponseWriter, request *http.Request)
 	ACLParseInput(r *http.Request) (*s3.GetBucketAclInput, error)
+	InsertHandle(writer http.ResponseWriter, request *http.Request)
+	GetHandle(writer http.ResponseWriter, request *http.Request)
+	UpdateHandle(writer http.ResponseWriter, request *http.Request)
+	DeleteHandle(writer http.ResponseWriter, request *http.Request)
+	BucketParseInput(request *http.Request) (*BucketRequest, error)
+	ListBucketsHandle(writer http.ResponseWriter, request *http.Request)
+	ListBucketsParseInput(request *http.Request) (*BucketListRequest, error)
 }
 
+// Most objects and prefixes in one listing page
+const maxListResults = 1000
+
 type Handler struct {
 	*gcs_handler.Handler
 }
@@ -39,9 +48,19 @@ func (wrapper *Handler) Register(mux *mux.Router) {
 	if keyFromUrl != nil && keyFromUrl == true {
 		mux.HandleFunc("/{creds}/storage/v1/b/{bucket}/o", wrapper.ListHandle).Methods("GET")
 		mux.HandleFunc("/{creds}/storage/v1/b/{bucket}/acl", wrapper.ACLHandle).Methods("GET")
+		mux.HandleFunc("/{creds}/storage/v1/b", wrapper.ListBucketsHandle).Methods("GET")
+		mux.HandleFunc("/{creds}/storage/v1/b", wrapper.InsertHandle).Methods("POST")
+		mux.HandleFunc("/{creds}/storage/v1/b/{bucket}", wrapper.GetHandle).Methods("GET")
+		mux.HandleFunc("/{creds}/storage/v1/b/{bucket}", wrapper.UpdateHandle).Methods("PATCH", "PUT")
+		mux.HandleFunc("/{creds}/storage/v1/b/{bucket}", wrapper.DeleteHandle).Methods("DELETE")
 	} else {
 		mux.HandleFunc("/storage/v1/b/{bucket}/o", wrapper.ListHandle).Methods("GET")
 		mux.HandleFunc("/storage/v1/b/{bucket}/acl", wrapper.ACLHandle).Methods("GET")
+		mux.HandleFunc("/storage/v1/b", wrapper.ListBucketsHandle).Methods("GET")
+		mux.HandleFunc("/storage/v1/b", wrapper.InsertHandle).Methods("POST")
+		mux.HandleFunc("/storage/v1/b/{bucket}", wrapper.GetHandle).Methods("GET")
+		mux.HandleFunc("/storage/v1/b/{bucket}", wrapper.UpdateHandle).Methods("PATCH", "PUT")
+		mux.HandleFunc("/storage/v1/b/{bucket}", wrapper.DeleteHandle).Methods("DELETE")
 	}
 }
 
@@ -124,36 +143,144 @@ func (wrapper *Handler) ACLParseInput(r *http.Request) (*s3.GetBucketAclInput, e
 	return &s3.GetBucketAclInput{Bucket: &bucket}, nil
 }
 
-func (wrapper *Handler) awsToGcpList(output *s3.ListObjectsV2Output) ([]*storage.Object, error) {
-	results := make([]*storage.Object, 0)
-	for _, obj := range output.Contents {
-		results = append(results, &storage.Object{
-			Name: *obj.Key,
-		})
-	}
-	for _, obj := range output.CommonPrefixes {
-		results = append(results, &storage.Object{
-			Name: *obj.Prefix,
-		})
-	}
-	if !*output.IsTruncated {
-		return results, nil
+// GCS JSON API object listing request
+type ListRequest struct {
+	Bucket      string
+	Prefix      string
+	Delimiter   string
+	MaxResults  int
+	PageToken   string
+	StartOffset string
+	EndOffset   string
+	Versions    bool
+}
+
+// Whether a listed key or prefix is before the request's end offset
+func (input *ListRequest) beforeEnd(key string) bool {
+	return input.EndOffset == "" || key < input.EndOffset
+}
+
+// One page of an S3 listing as a storage#objects response
+func (wrapper *Handler) awsListPage(input *ListRequest) (*storage.Objects, error) {
+	bucket := wrapper.BucketRename(input.Bucket)
+	position := &converter.S3PageToken{}
+	if input.PageToken != "" {
+		var err error
+		if position, err = converter.DecodeS3PageToken(input.PageToken); err != nil {
+			return nil, err
+		}
+	}
+	maxKeys := int64(input.MaxResults)
+	result := &storage.Objects{Kind: "storage#objects", Items: make([]*storage.Object, 0), Prefixes: make([]string, 0)}
+	var commonPrefixes []s3.CommonPrefix
+	var next *converter.S3PageToken
+	// anything at or after the end offset ends the listing
+	pastEnd := false
+	if input.Versions {
+		listRequest := &s3.ListObjectVersionsInput{
+			Bucket:    &bucket,
+			Prefix:    &input.Prefix,
+			Delimiter: &input.Delimiter,
+			MaxKeys:   &maxKeys,
+		}
+		if input.PageToken != "" {
+			listRequest.KeyMarker = &position.KeyMarker
+			listRequest.VersionIdMarker = &position.VersionIdMarker
+		} else if input.StartOffset != "" {
+			keyMarker := converter.KeyBefore(input.StartOffset)
+			listRequest.KeyMarker = &keyMarker
+		}
+		output, err := wrapper.S3Client.ListObjectVersionsRequest(listRequest).Send()
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range output.Versions {
+			if !input.beforeEnd(*version.Key) {
+				pastEnd = true
+				break
+			}
+			result.Items = append(result.Items, converter.AWSObjectVersionToGCSObject(input.Bucket, version))
+		}
+		commonPrefixes = output.CommonPrefixes
+		if output.IsTruncated != nil && *output.IsTruncated {
+			next = &converter.S3PageToken{}
+			if output.NextKeyMarker != nil {
+				next.KeyMarker = *output.NextKeyMarker
+			}
+			if output.NextVersionIdMarker != nil {
+				next.VersionIdMarker = *output.NextVersionIdMarker
+			}
+		}
 	} else {
-		req := &s3.ListObjectsV2Input{
-			Bucket:            output.Name,
-			ContinuationToken: output.NextContinuationToken,
-			Delimiter:         output.Delimiter,
+		listRequest := &s3.ListObjectsV2Input{
+			Bucket:    &bucket,
+			Prefix:    &input.Prefix,
+			Delimiter: &input.Delimiter,
+			MaxKeys:   &maxKeys,
 		}
-		objects, err := wrapper.S3Client.ListObjectsV2Request(req).Send()
+		if input.PageToken != "" {
+			listRequest.ContinuationToken = &position.ContinuationToken
+		} else if input.StartOffset != "" {
+			startAfter := converter.KeyBefore(input.StartOffset)
+			listRequest.StartAfter = &startAfter
+		}
+		output, err := wrapper.S3Client.ListObjectsV2Request(listRequest).Send()
 		if err != nil {
 			return nil, err
 		}
-		newResults, err := wrapper.awsToGcpList(objects)
-		results = append(results, newResults...)
-		return results, err
+		for _, object := range output.Contents {
+			if !input.beforeEnd(*object.Key) {
+				pastEnd = true
+				break
+			}
+			result.Items = append(result.Items, converter.AWSListObjectToGCSObject(input.Bucket, object))
+		}
+		commonPrefixes = output.CommonPrefixes
+		if output.IsTruncated != nil && *output.IsTruncated && output.NextContinuationToken != nil {
+			next = &converter.S3PageToken{ContinuationToken: *output.NextContinuationToken}
+		}
 	}
+	for _, prefix := range commonPrefixes {
+		if !input.beforeEnd(*prefix.Prefix) {
+			pastEnd = true
+			break
+		}
+		result.Prefixes = append(result.Prefixes, *prefix.Prefix)
+	}
+	if next != nil && !pastEnd {
+		result.NextPageToken = next.Encode()
+	}
+	return result, nil
 }
 
+// One page of a GCS listing as a storage#objects response, with the offsets handed to GCS
+func (wrapper *Handler) gcsListPage(input *ListRequest, request *http.Request) (*storage.Objects, error) {
+	client, err := wrapper.GCPRequestSetup(request)
+	if client != nil {
+		// Return connection to pool after done
+		defer wrapper.ReturnConnection(client, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+	raw, err := wrapper.GCPClientToRaw(client)
+	if err != nil {
+		return nil, err
+	}
+	call := raw.Objects.List(input.Bucket).Projection("full").Delimiter(input.Delimiter).Prefix(input.Prefix).Versions(input.Versions)
+	call.StartOffset(input.StartOffset).EndOffset(input.EndOffset).MaxResults(int64(input.MaxResults)).Context(*wrapper.Context)
+	if input.PageToken != "" {
+		call.PageToken(input.PageToken)
+	}
+	result, err := call.Do()
+	if err != nil {
+		return nil, err
+	}
+	result.Kind = "storage#objects"
+	return result, nil
+}
+
+// One page of objects and prefixes, continued from a page token
 func (wrapper *Handler) ListHandle(writer http.ResponseWriter, request *http.Request) {
 	input, err := wrapper.ListParseInput(request)
 	if err != nil {
@@ -161,89 +288,47 @@ func (wrapper *Handler) ListHandle(writer http.ResponseWriter, request *http.Req
 		writer.Write([]byte(fmt.Sprint(err)))
 		return
 	}
+	var result *storage.Objects
 	if wrapper.Config.IsSet("aws_destination_config") {
 		logging.LogUsingAWS()
-		bucket := wrapper.BucketRename(*input.Bucket)
-		input.Bucket = &bucket
-		objects, err := wrapper.S3Client.ListObjectsV2Request(input).Send()
-		if err != nil {
-			writer.WriteHeader(400)
-			writer.Write([]byte(fmt.Sprint(err)))
-			return
-		}
-		keys, _ := wrapper.awsToGcpList(objects)
-		result := storage.Objects{
-			Items: keys,
-		}
-		js, _ := json.Marshal(result)
-		writer.Write(js)
-		return
+		result, err = wrapper.awsListPage(input)
 	} else if wrapper.Config.IsSet("gcp_destination_config") {
-		client, err := wrapper.GCPRequestSetup(request)
-		if client != nil {
-			// Return connection to pool after done
-			defer wrapper.ReturnConnection(client, request)
-		}
-		if err != nil {
-			writer.WriteHeader(400)
-			logging.Log.Error("Error a %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
-			return
-		}
-		bucket := *input.Bucket
-		bucketObject := wrapper.GCPClientToBucket(bucket, client)
-		it := bucketObject.Objects(*wrapper.Context, &original_storage.Query{
-			Delimiter: *input.Delimiter,
-			Prefix:    *input.Prefix,
-			Versions:  false,
-		})
-		keys := make([]*storage.Object, 0)
-		for {
-			row, err := it.Next()
-			if err == iterator.Done {
-				break
-			} else if err != nil {
-				writer.WriteHeader(400)
-				logging.Log.Error("Error a %s %s", request.RequestURI, err)
-				writer.Write([]byte(string(fmt.Sprint(err))))
-				return
-			}
-			keys = append(keys, &storage.Object{
-				Bucket: bucket,
-				Name:   row.Name,
-			})
-		}
-		result := storage.Objects{
-			Items: keys,
-		}
-		js, _ := json.Marshal(result)
-		writer.Write(js)
+		result, err = wrapper.gcsListPage(input, request)
+	}
+	if err != nil {
+		writer.WriteHeader(400)
+		logging.Log.Error("Error a %s %s", request.RequestURI, err)
+		writer.Write([]byte(string(fmt.Sprint(err))))
 		return
-
 	}
+	gcs_handler.WriteJSON(writer, request, result)
 }
 
-func (wrapper *Handler) ListParseInput(request *http.Request) (*s3.ListObjectsV2Input, error) {
-
+func (wrapper *Handler) ListParseInput(request *http.Request) (*ListRequest, error) {
 	vars := mux.Vars(request)
 	bucket := vars["bucket"]
 	if bucket == "" {
 		return nil, errors.New("no bucket present")
 	}
-	listRequest := &s3.ListObjectsV2Input{Bucket: &bucket}
-	delim := request.URL.Query().Get("delimiter")
-	listRequest.Delimiter = &delim
-	if encodingType := request.URL.Query().Get("encoding-type"); encodingType == "url" {
-		listRequest.EncodingType = s3.EncodingTypeUrl
+	query := request.URL.Query()
+	listRequest := &ListRequest{
+		Bucket:      bucket,
+		Prefix:      query.Get("prefix"),
+		Delimiter:   query.Get("delimiter"),
+		MaxResults:  maxListResults,
+		PageToken:   query.Get("pageToken"),
+		StartOffset: query.Get("startOffset"),
+		EndOffset:   query.Get("endOffset"),
+		Versions:    query.Get("versions") == "true",
 	}
-	if maxKeys := request.URL.Query().Get("max-keys"); maxKeys != "" {
-		maxKeyInt, _ := strconv.ParseInt(maxKeys, 10, 64)
-		listRequest.MaxKeys = &maxKeyInt
-	} else {
-		maxKeyInt := int64(1000)
-		listRequest.MaxKeys = &maxKeyInt
+	if maxResults := query.Get("maxResults"); maxResults != "" {
+		maxResultsInt, err := strconv.Atoi(maxResults)
+		if err != nil || maxResultsInt < 0 {
+			return nil, errors.New("invalid maxResults " + maxResults)
+		}
+		if maxResultsInt > 0 && maxResultsInt < maxListResults {
+			listRequest.MaxResults = maxResultsInt
+		}
 	}
-	prefix := request.URL.Query().Get("prefix")
-	listRequest.Prefix = &prefix
 	return listRequest, nil
 }
@@ -0,0 +1,53 @@
+package bucket
+
+import (
+	"cloudsidecar/pkg/aws/handler/s3"
+	gcs_handler "cloudsidecar/pkg/gcp/handler/gcs"
+	"context"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestHandler_ListHandleGCS(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	var queries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "/storage/v1/b/boops/o", request.URL.Path)
+		queries = append(queries, request.URL.Query())
+		writer.Header().Set("Content-Type", "application/json")
+		// GCS sends a page's objects before its prefixes
+		writer.Write([]byte(`{"items":[{"name":"b","size":"3"}],"prefixes":["a/"],"nextPageToken":"t"}`))
+	}))
+	defer server.Close()
+	ctx := context.Background()
+	config := viper.New()
+	config.Set("gcp_destination_config", "meow")
+	gcsHandler := gcs_handler.NewHandler(config)
+	gcsHandler.Context = &ctx
+	gcsHandler.GCPClient = func() (s3.GCPClient, error) {
+		return s3.NewMockGCPClient(ctrl), nil
+	}
+	gcsHandler.GCPClientToRaw = func(client s3.GCPClient) (*storage.Service, error) {
+		return storage.NewService(ctx, option.WithEndpoint(server.URL+"/storage/v1/"), option.WithHTTPClient(server.Client()))
+	}
+	handler := New(&gcsHandler)
+
+	request := httptest.NewRequest("GET", "http://localhost/storage/v1/b/boops/o?delimiter=%2F&startOffset=a&endOffset=c&maxResults=2", nil)
+	writer := httptest.NewRecorder()
+	handler.ListHandle(writer, mux.SetURLVars(request, map[string]string{"bucket": "boops"}))
+	assert.Equal(t, 200, writer.Code)
+	assert.Len(t, queries, 1)
+	assert.Equal(t, "a", queries[0].Get("startOffset"))
+	assert.Equal(t, "c", queries[0].Get("endOffset"))
+	assert.Equal(t, "2", queries[0].Get("maxResults"))
+	assert.JSONEq(t, `{"kind":"storage#objects","items":[{"name":"b","size":"3"}],"prefixes":["a/"],"nextPageToken":"t"}`, writer.Body.String())
+}
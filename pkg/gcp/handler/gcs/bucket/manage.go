@@ -0,0 +1,484 @@
+package bucket
+
+import (
+	original_storage "cloud.google.com/go/storage"
+	"cloudsidecar/pkg/converter"
+	gcs_handler "cloudsidecar/pkg/gcp/handler/gcs"
+	"cloudsidecar/pkg/logging"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/storage/v1"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A bucket insert, get, update or delete
+type BucketRequest struct {
+	Name    string
+	Project string
+	// PATCH keeps labels the request leaves out, PUT drops them
+	Patch bool
+	// resource from the request body
+	Bucket storage.Bucket
+	// given labels, nil to delete
+	Labels      map[string]*string
+	LabelsGiven bool
+	// whether the body set versioning
+	VersioningGiven bool
+}
+
+// Labels of a bucket after the request
+func (input *BucketRequest) labels(current map[string]string) map[string]string {
+	labels := make(map[string]string)
+	if input.Patch || !input.LabelsGiven {
+		for k, v := range current {
+			labels[k] = v
+		}
+	}
+	for k, v := range input.Labels {
+		if v == nil {
+			delete(labels, k)
+		} else {
+			labels[k] = *v
+		}
+	}
+	return labels
+}
+
+// A project level bucket listing
+type BucketListRequest struct {
+	Project    string
+	Prefix     string
+	MaxResults int
+	PageToken  string
+}
+
+// Location translation with overrides from location_region_map (GCS to AWS) and region_location_map (AWS to GCS)
+func (wrapper *Handler) locations() *converter.LocationMap {
+	return converter.NewLocationMap(
+		wrapper.Config.GetStringMapString("aws_destination_config.s3_config.location_region_map"),
+		wrapper.Config.GetStringMapString("aws_destination_config.s3_config.region_location_map"),
+	)
+}
+
+// Project from the request, or the configured one
+func (wrapper *Handler) project(request *http.Request) string {
+	if project := request.URL.Query().Get("project"); project != "" {
+		return project
+	}
+	return wrapper.Config.GetString("gcp_destination_config.project")
+}
+
+// Whether an AWS error has the given code
+func isAWSCode(err error, code string) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == code
+}
+
+// Bucket resource for an S3 bucket, from its location, tags and versioning
+func (wrapper *Handler) awsBucket(name string) (*storage.Bucket, error) {
+	bucket := wrapper.BucketRename(name)
+	if _, err := wrapper.S3Client.HeadBucketRequest(&s3.HeadBucketInput{Bucket: &bucket}).Send(); err != nil {
+		return nil, err
+	}
+	location, err := wrapper.S3Client.GetBucketLocationRequest(&s3.GetBucketLocationInput{Bucket: &bucket}).Send()
+	if err != nil {
+		return nil, err
+	}
+	var tags []s3.Tag
+	tagging, err := wrapper.S3Client.GetBucketTaggingRequest(&s3.GetBucketTaggingInput{Bucket: &bucket}).Send()
+	if err == nil {
+		tags = tagging.TagSet
+	} else if !isAWSCode(err, "NoSuchTagSet") {
+		return nil, err
+	}
+	versioning, err := wrapper.S3Client.GetBucketVersioningRequest(&s3.GetBucketVersioningInput{Bucket: &bucket}).Send()
+	if err != nil {
+		return nil, err
+	}
+	gcsLocation := wrapper.locations().ToLocation(string(location.LocationConstraint))
+	return converter.AWSBucketToGCSBucket(name, gcsLocation, tags, versioning.Status, nil), nil
+}
+
+// Set an S3 bucket's tags and versioning from a request
+func (wrapper *Handler) awsUpdateBucket(input *BucketRequest, current *storage.Bucket) error {
+	bucket := wrapper.BucketRename(input.Name)
+	if input.LabelsGiven {
+		var currentLabels map[string]string
+		if current != nil {
+			currentLabels = current.Labels
+		}
+		labels := input.labels(currentLabels)
+		if len(labels) == 0 {
+			if _, err := wrapper.S3Client.DeleteBucketTaggingRequest(&s3.DeleteBucketTaggingInput{Bucket: &bucket}).Send(); err != nil {
+				return err
+			}
+		} else {
+			_, err := wrapper.S3Client.PutBucketTaggingRequest(&s3.PutBucketTaggingInput{
+				Bucket:  &bucket,
+				Tagging: &s3.Tagging{TagSet: converter.LabelsToTags(labels)},
+			}).Send()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if input.VersioningGiven {
+		status := s3.BucketVersioningStatusSuspended
+		if input.Bucket.Versioning != nil && input.Bucket.Versioning.Enabled {
+			status = s3.BucketVersioningStatusEnabled
+		}
+		if current == nil && status == s3.BucketVersioningStatusSuspended {
+			// new buckets are unversioned already
+			return nil
+		}
+		_, err := wrapper.S3Client.PutBucketVersioningRequest(&s3.PutBucketVersioningInput{
+			Bucket:                  &bucket,
+			VersioningConfiguration: &s3.VersioningConfiguration{Status: status},
+		}).Send()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create a bucket
+func (wrapper *Handler) InsertHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := wrapper.BucketParseInput(request)
+	if err != nil {
+		writeBucketError(writer, request, 400, err)
+		return
+	}
+	var result *storage.Bucket
+	if wrapper.Config.IsSet("aws_destination_config") {
+		logging.LogUsingAWS()
+		bucket := wrapper.BucketRename(input.Name)
+		createInput := &s3.CreateBucketInput{Bucket: &bucket}
+		// us-east-1 is the one region that can't be asked for
+		if region := wrapper.locations().ToRegion(input.Bucket.Location); region != "us-east-1" {
+			createInput.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+				LocationConstraint: s3.BucketLocationConstraint(region),
+			}
+		}
+		if _, err := wrapper.S3Client.CreateBucketRequest(createInput).Send(); err != nil {
+			writeBucketError(writer, request, 409, err)
+			return
+		}
+		if err := wrapper.awsUpdateBucket(input, nil); err != nil {
+			writeBucketError(writer, request, 400, err)
+			return
+		}
+		if result, err = wrapper.awsBucket(input.Name); err != nil {
+			writeBucketError(writer, request, 400, err)
+			return
+		}
+	} else if wrapper.Config.IsSet("gcp_destination_config") {
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			// Return connection to pool after done
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			writeBucketError(writer, request, 400, err)
+			return
+		}
+		bucketHandle := wrapper.GCPClientToBucket(input.Name, client)
+		attrs := &original_storage.BucketAttrs{
+			Location:     input.Bucket.Location,
+			StorageClass: input.Bucket.StorageClass,
+			Labels:       input.labels(nil),
+		}
+		if input.Bucket.Versioning != nil {
+			attrs.VersioningEnabled = input.Bucket.Versioning.Enabled
+		}
+		if err := bucketHandle.Create(*wrapper.Context, input.Project, attrs); err != nil {
+			writeBucketError(writer, request, 409, err)
+			return
+		}
+		created, err := bucketHandle.Attrs(*wrapper.Context)
+		if err != nil {
+			writeBucketError(writer, request, 400, err)
+			return
+		}
+		result = converter.GCSBucketAttrsToBucket(created)
+	}
+	gcs_handler.WriteJSON(writer, request, result)
+}
+
+// Bucket resource of a bucket
+func (wrapper *Handler) GetHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := wrapper.BucketParseInput(request)
+	if err != nil {
+		writeBucketError(writer, request, 400, err)
+		return
+	}
+	var result *storage.Bucket
+	if wrapper.Config.IsSet("aws_destination_config") {
+		logging.LogUsingAWS()
+		if result, err = wrapper.awsBucket(input.Name); err != nil {
+			writeBucketError(writer, request, 404, err)
+			return
+		}
+	} else if wrapper.Config.IsSet("gcp_destination_config") {
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			// Return connection to pool after done
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			writeBucketError(writer, request, 400, err)
+			return
+		}
+		attrs, err := wrapper.GCPClientToBucket(input.Name, client).Attrs(*wrapper.Context)
+		if err != nil {
+			writeBucketError(writer, request, 404, err)
+			return
+		}
+		result = converter.GCSBucketAttrsToBucket(attrs)
+	}
+	gcs_handler.WriteJSON(writer, request, result)
+}
+
+// Change a bucket's labels, versioning or storage class
+func (wrapper *Handler) UpdateHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := wrapper.BucketParseInput(request)
+	if err != nil {
+		writeBucketError(writer, request, 400, err)
+		return
+	}
+	var result *storage.Bucket
+	if wrapper.Config.IsSet("aws_destination_config") {
+		logging.LogUsingAWS()
+		current, err := wrapper.awsBucket(input.Name)
+		if err != nil {
+			writeBucketError(writer, request, 404, err)
+			return
+		}
+		if err := wrapper.awsUpdateBucket(input, current); err != nil {
+			writeBucketError(writer, request, 400, err)
+			return
+		}
+		if result, err = wrapper.awsBucket(input.Name); err != nil {
+			writeBucketError(writer, request, 400, err)
+			return
+		}
+	} else if wrapper.Config.IsSet("gcp_destination_config") {
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			// Return connection to pool after done
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			writeBucketError(writer, request, 400, err)
+			return
+		}
+		bucketHandle := wrapper.GCPClientToBucket(input.Name, client)
+		current, err := bucketHandle.Attrs(*wrapper.Context)
+		if err != nil {
+			writeBucketError(writer, request, 404, err)
+			return
+		}
+		// the pinned storage client can't change a bucket's default storage class
+		if input.Bucket.StorageClass != "" && input.Bucket.StorageClass != current.StorageClass {
+			writeBucketError(writer, request, 501, errors.New("changing the default storage class is not supported"))
+			return
+		}
+		update := original_storage.BucketAttrsToUpdate{}
+		if input.VersioningGiven {
+			update.VersioningEnabled = input.Bucket.Versioning != nil && input.Bucket.Versioning.Enabled
+		}
+		if input.LabelsGiven {
+			labels := input.labels(current.Labels)
+			for k := range current.Labels {
+				if _, ok := labels[k]; !ok {
+					update.DeleteLabel(k)
+				}
+			}
+			for k, v := range labels {
+				update.SetLabel(k, v)
+			}
+		}
+		attrs, err := bucketHandle.Update(*wrapper.Context, update)
+		if err != nil {
+			writeBucketError(writer, request, 400, err)
+			return
+		}
+		result = converter.GCSBucketAttrsToBucket(attrs)
+	}
+	gcs_handler.WriteJSON(writer, request, result)
+}
+
+// Delete an empty bucket
+func (wrapper *Handler) DeleteHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := wrapper.BucketParseInput(request)
+	if err != nil {
+		writeBucketError(writer, request, 400, err)
+		return
+	}
+	if wrapper.Config.IsSet("aws_destination_config") {
+		logging.LogUsingAWS()
+		bucket := wrapper.BucketRename(input.Name)
+		if _, err := wrapper.S3Client.DeleteBucketRequest(&s3.DeleteBucketInput{Bucket: &bucket}).Send(); err != nil {
+			status := 400
+			if isAWSCode(err, "NoSuchBucket") {
+				status = 404
+			} else if isAWSCode(err, "BucketNotEmpty") {
+				status = 409
+			}
+			writeBucketError(writer, request, status, err)
+			return
+		}
+	} else if wrapper.Config.IsSet("gcp_destination_config") {
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			// Return connection to pool after done
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			writeBucketError(writer, request, 400, err)
+			return
+		}
+		if err := wrapper.GCPClientToBucket(input.Name, client).Delete(*wrapper.Context); err != nil {
+			writeBucketError(writer, request, 409, err)
+			return
+		}
+	}
+	writer.WriteHeader(204)
+}
+
+func (wrapper *Handler) BucketParseInput(request *http.Request) (*BucketRequest, error) {
+	input := &BucketRequest{
+		Name:    mux.Vars(request)["bucket"],
+		Project: wrapper.project(request),
+		Patch:   request.Method == http.MethodPatch,
+	}
+	if request.Method != http.MethodPost && request.Method != http.MethodPatch && request.Method != http.MethodPut {
+		return input, nil
+	}
+	body := make(map[string]json.RawMessage)
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	raw, _ := json.Marshal(body)
+	if err := json.Unmarshal(raw, &input.Bucket); err != nil {
+		return nil, err
+	}
+	if labels, ok := body["labels"]; ok {
+		input.LabelsGiven = true
+		if err := json.Unmarshal(labels, &input.Labels); err != nil {
+			return nil, fmt.Errorf("invalid labels: %s", err)
+		}
+		if input.Labels == nil {
+			// null labels drop them all
+			input.Patch = false
+		}
+	}
+	if request.Method == http.MethodPut {
+		// a full update replaces the labels even when it leaves them out
+		input.LabelsGiven = true
+	}
+	_, input.VersioningGiven = body["versioning"]
+	if request.Method == http.MethodPost {
+		input.Name = input.Bucket.Name
+		if input.Name == "" {
+			return nil, errors.New("no bucket name")
+		}
+	}
+	return input, nil
+}
+
+// Buckets in a project, a page at a time
+func (wrapper *Handler) ListBucketsHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := wrapper.ListBucketsParseInput(request)
+	if err != nil {
+		writeBucketError(writer, request, 400, err)
+		return
+	}
+	result := &storage.Buckets{Kind: "storage#buckets", Items: make([]*storage.Bucket, 0)}
+	if wrapper.Config.IsSet("aws_destination_config") {
+		logging.LogUsingAWS()
+		output, err := wrapper.S3Client.ListBucketsRequest(&s3.ListBucketsInput{}).Send()
+		if err != nil {
+			writeBucketError(writer, request, 400, err)
+			return
+		}
+		buckets := output.Buckets
+		sort.Slice(buckets, func(i, j int) bool {
+			return *buckets[i].Name < *buckets[j].Name
+		})
+		// S3 lists every bucket at once, so pages continue after the last name sent
+		for _, bucket := range buckets {
+			if !strings.HasPrefix(*bucket.Name, input.Prefix) || *bucket.Name <= input.PageToken {
+				continue
+			}
+			if len(result.Items) == input.MaxResults {
+				result.NextPageToken = base64.RawURLEncoding.EncodeToString([]byte(result.Items[len(result.Items)-1].Name))
+				break
+			}
+			result.Items = append(result.Items, converter.AWSBucketToGCSBucket(*bucket.Name, "", nil, "", bucket.CreationDate))
+		}
+	} else if wrapper.Config.IsSet("gcp_destination_config") {
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			// Return connection to pool after done
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			writeBucketError(writer, request, 400, err)
+			return
+		}
+		it := client.Buckets(*wrapper.Context, input.Project)
+		it.Prefix = input.Prefix
+		var rows []*original_storage.BucketAttrs
+		if result.NextPageToken, err = iterator.NewPager(it, input.MaxResults, input.PageToken).NextPage(&rows); err != nil {
+			writeBucketError(writer, request, 400, err)
+			return
+		}
+		for _, row := range rows {
+			result.Items = append(result.Items, converter.GCSBucketAttrsToBucket(row))
+		}
+	}
+	gcs_handler.WriteJSON(writer, request, result)
+}
+
+func (wrapper *Handler) ListBucketsParseInput(request *http.Request) (*BucketListRequest, error) {
+	query := request.URL.Query()
+	input := &BucketListRequest{
+		Project:    wrapper.project(request),
+		Prefix:     query.Get("prefix"),
+		MaxResults: maxListResults,
+		PageToken:  query.Get("pageToken"),
+	}
+	if wrapper.Config.IsSet("aws_destination_config") && input.PageToken != "" {
+		lastName, err := base64.RawURLEncoding.DecodeString(input.PageToken)
+		if err != nil {
+			return nil, errors.New("invalid page token")
+		}
+		input.PageToken = string(lastName)
+	}
+	if maxResults := query.Get("maxResults"); maxResults != "" {
+		maxResultsInt, err := strconv.Atoi(maxResults)
+		if err != nil || maxResultsInt < 0 {
+			return nil, errors.New("invalid maxResults " + maxResults)
+		}
+		if maxResultsInt > 0 && maxResultsInt < maxListResults {
+			input.MaxResults = maxResultsInt
+		}
+	}
+	return input, nil
+}
+
+func writeBucketError(writer http.ResponseWriter, request *http.Request, status int, err error) {
+	writer.WriteHeader(status)
+	logging.Log.Error("Error a %s %s", request.RequestURI, err)
+	writer.Write([]byte(string(fmt.Sprint(err))))
+}
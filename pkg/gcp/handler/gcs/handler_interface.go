@@ -1,7 +1,6 @@
 package gcs
 
 import (
-	"cloud.google.com/go/storage"
 	"cloudsidecar/pkg/aws/handler/s3"
 	"cloudsidecar/pkg/logging"
 	"context"
@@ -10,6 +9,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/spf13/viper"
 	"google.golang.org/api/option"
+	storagev1 "google.golang.org/api/storage/v1"
 	"net/http"
 	"strings"
 	"sync"
@@ -23,6 +23,7 @@ type Handler struct {
 	GCPClientToBucket func(bucket string, client s3.GCPClient) s3.GCPBucket
 	GCPBucketToObject func(name string, bucket s3.GCPBucket) s3.GCPObject
 	GCPObjectToWriter func(object s3.GCPObject, ctx context.Context) s3.GCPObjectWriter
+	GCPClientToRaw    func(client s3.GCPClient) (*storagev1.Service, error)
 	GCPClientPerKey   map[string]s3.GCPClient
 	gcpClientMapLock  sync.Mutex
 	GCPClientPool     map[string][]s3.GCPClient
@@ -95,6 +96,7 @@ func NewHandler(config *viper.Viper) Handler {
 		GCPObjectToWriter: func(object s3.GCPObject, ctx context.Context) s3.GCPObjectWriter {
 			return object.NewWriter(ctx)
 		},
+		GCPClientToRaw: s3.RawGCPClient,
 	}
 }
 
@@ -110,7 +112,7 @@ func (handler *Handler) SetGCPClientFromCreds(creds *string) (s3.GCPClient, erro
 		var doubleCheck bool
 		var err error
 		if client, doubleCheck = handler.GCPClientPerKey[*creds]; !doubleCheck {
-			client, err = storage.NewClient(*handler.GetContext(), option.WithCredentialsJSON([]byte(decrypted)))
+			client, err = s3.NewGCPClient(*handler.GetContext(), option.WithCredentialsJSON([]byte(decrypted)))
 		}
 		return client, err
 	}
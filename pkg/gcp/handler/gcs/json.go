@@ -0,0 +1,26 @@
+package gcs
+
+import (
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Write a JSON API response, trimmed to the request's fields parameter
+func WriteJSON(writer http.ResponseWriter, request *http.Request, value interface{}) {
+	js, err := json.Marshal(value)
+	if err == nil {
+		js, err = converter.ProjectFields(js, request.URL.Query().Get("fields"))
+	}
+	if err != nil {
+		writer.WriteHeader(400)
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		writer.Write([]byte(string(fmt.Sprint(err))))
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	writer.WriteHeader(200)
+	writer.Write(js)
+}
@@ -0,0 +1,181 @@
+package notification
+
+import (
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	uuid2 "github.com/google/uuid"
+	"google.golang.org/api/storage/v1"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Config keys for how many events wait to be sent and how many are sent at once
+const (
+	queueSizeKey = "aws_destination_config.s3_config.notification_queue_size"
+	workersKey   = "aws_destination_config.s3_config.notification_workers"
+)
+
+const (
+	defaultQueueSize = 1000
+	defaultWorkers   = 4
+)
+
+// An object event waiting to be sent
+type objectEvent struct {
+	requestURI string
+	eventType  string
+	bucket     string
+	key        string
+	time       time.Time
+}
+
+// Queue an object event for the stored configs that want it, sent as the Pub/Sub message GCS would have sent.  S3 and
+// GCS send events for the configs they hold themselves.  Events are sent in the background so writes don't wait on
+// them, and are dropped with an error logged when the queue is full
+func (wrapper *Handler) Notify(request *http.Request, eventType string, bucket string, key string) {
+	if !wrapper.Config.IsSet("aws_destination_config") || !wrapper.storeConfigured() {
+		return
+	}
+	wrapper.eventsOnce.Do(func() {
+		wrapper.events = make(chan *objectEvent, wrapper.configuredInt(queueSizeKey, defaultQueueSize))
+	})
+	event := &objectEvent{requestURI: request.RequestURI, eventType: eventType, bucket: bucket, key: key, time: time.Now()}
+	select {
+	case wrapper.events <- event:
+	default:
+		logging.Log.Error("Error %s dropping %s for %s/%s, the notification queue is full", request.RequestURI, eventType, bucket, key)
+		return
+	}
+	wrapper.workersLock.Lock()
+	if wrapper.workers < wrapper.configuredInt(workersKey, defaultWorkers) {
+		wrapper.workers++
+		go wrapper.sendEvents()
+	}
+	wrapper.workersLock.Unlock()
+}
+
+func (wrapper *Handler) configuredInt(key string, fallback int) int {
+	if value := wrapper.Config.GetInt(key); value > 0 {
+		return value
+	}
+	return fallback
+}
+
+// Send queued events until there are none, so workers of a handler that's been replaced don't linger
+func (wrapper *Handler) sendEvents() {
+	for {
+		select {
+		case event := <-wrapper.events:
+			wrapper.send(event)
+		default:
+			wrapper.workersLock.Lock()
+			// an event queued since the check would otherwise wait for the next one to start a worker
+			if len(wrapper.events) > 0 {
+				wrapper.workersLock.Unlock()
+				continue
+			}
+			wrapper.workers--
+			wrapper.workersLock.Unlock()
+			return
+		}
+	}
+}
+
+// Send an object event to the stored configs that want it.  Failures are logged
+func (wrapper *Handler) send(event *objectEvent) {
+	eventType, bucket, key := event.eventType, event.bucket, event.key
+	stored, err := wrapper.loadStored(bucket, false)
+	if err != nil {
+		logging.Log.Error("Error %s loading notification configs %s", event.requestURI, err)
+		return
+	}
+	matching := make([]*storage.Notification, 0)
+	for _, notification := range stored {
+		if converter.NotificationMatches(notification, eventType, key) {
+			matching = append(matching, notification)
+		}
+	}
+	if len(matching) == 0 {
+		return
+	}
+	object := &storage.Object{Kind: "storage#object", Bucket: bucket, Name: key}
+	if eventType != "OBJECT_DELETE" {
+		renamed := wrapper.BucketRename(bucket)
+		head, err := wrapper.S3Client.HeadObjectRequest(&s3.HeadObjectInput{Bucket: &renamed, Key: &key}).Send()
+		if err != nil {
+			logging.Log.Error("Error %s %s", event.requestURI, err)
+			return
+		}
+		object = converter.AWSHeadToGCSObject(bucket, key, head)
+	}
+	now := event.time
+	for _, notification := range matching {
+		data, attributes, err := converter.NotificationMessage(notification, eventType, object, now)
+		if err == nil {
+			var arn string
+			if arn, err = wrapper.topicArn(notification.Topic); err == nil {
+				err = wrapper.publish(arn, converter.EncodePubSubMessage(data, attributes, uuid2.New().String(), now))
+			}
+		}
+		if err != nil {
+			logging.Log.Error("Error %s sending %s to notification config %s %s", event.requestURI, eventType, notification.Id, err)
+		}
+	}
+}
+
+// Publish a message body to an SNS topic or SQS queue ARN
+func (wrapper *Handler) publish(arn string, body string) error {
+	if strings.HasPrefix(arn, "arn:aws:sns:") {
+		if wrapper.SNSClient == nil {
+			return errors.New("no SNS client")
+		}
+		_, err := wrapper.SNSClient.PublishRequest(&sns.PublishInput{TopicArn: &arn, Message: &body}).Send()
+		return err
+	}
+	if wrapper.SqsClient == nil {
+		return errors.New("no SQS client")
+	}
+	queueUrl, err := wrapper.queueUrl(arn)
+	if err != nil {
+		return err
+	}
+	_, err = wrapper.SqsClient.SendMessageRequest(&sqs.SendMessageInput{QueueUrl: &queueUrl, MessageBody: &body}).Send()
+	if err != nil {
+		// the queue may have been recreated somewhere else
+		wrapper.queueUrlsLock.Lock()
+		delete(wrapper.queueUrls, arn)
+		wrapper.queueUrlsLock.Unlock()
+	}
+	return err
+}
+
+// URL of an SQS queue ARN, looked up once
+func (wrapper *Handler) queueUrl(arn string) (string, error) {
+	wrapper.queueUrlsLock.Lock()
+	queueUrl, ok := wrapper.queueUrls[arn]
+	wrapper.queueUrlsLock.Unlock()
+	if ok {
+		return queueUrl, nil
+	}
+	// arn:aws:sqs:region:account:name
+	pieces := strings.Split(arn, ":")
+	if len(pieces) != 6 {
+		return "", errors.New("invalid queue " + arn)
+	}
+	output, err := wrapper.SqsClient.GetQueueUrlRequest(&sqs.GetQueueUrlInput{
+		QueueName:              &pieces[5],
+		QueueOwnerAWSAccountId: &pieces[4],
+	}).Send()
+	if err != nil {
+		return "", err
+	}
+	wrapper.queueUrlsLock.Lock()
+	wrapper.queueUrls[arn] = *output.QueueUrl
+	wrapper.queueUrlsLock.Unlock()
+	return *output.QueueUrl, nil
+}
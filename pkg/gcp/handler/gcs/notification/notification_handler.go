@@ -0,0 +1,387 @@
+package notification
+
+import (
+	"cloudsidecar/pkg/converter"
+	gcs_handler "cloudsidecar/pkg/gcp/handler/gcs"
+	"cloudsidecar/pkg/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	uuid2 "github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"google.golang.org/api/storage/v1"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Interface for notification config functions
+type Notification interface {
+	Register(mux *mux.Router)
+	InsertHandle(writer http.ResponseWriter, request *http.Request)
+	InsertParseInput(request *http.Request) (*storage.Notification, error)
+	ListHandle(writer http.ResponseWriter, request *http.Request)
+	GetHandle(writer http.ResponseWriter, request *http.Request)
+	DeleteHandle(writer http.ResponseWriter, request *http.Request)
+	Notify(request *http.Request, eventType string, bucket string, key string)
+}
+
+// Notification configs mode that always has the sidecar send events itself
+const emitMode = "emit"
+
+type Handler struct {
+	*gcs_handler.Handler
+	// where the sidecar sends events for configs S3 can't send itself
+	SNSClient *sns.SNS
+	SqsClient *sqs.SQS
+	// configs the sidecar sends events for, by bucket
+	stored     map[string]*storedConfigs
+	storedLock sync.Mutex
+	// events waiting to be sent and the workers sending them
+	events      chan *objectEvent
+	eventsOnce  sync.Once
+	workers     int
+	workersLock sync.Mutex
+	// SQS queue URLs by ARN
+	queueUrls     map[string]string
+	queueUrlsLock sync.Mutex
+}
+
+func New(gcsHandler *gcs_handler.Handler) *Handler {
+	return &Handler{Handler: gcsHandler, stored: make(map[string]*storedConfigs), queueUrls: make(map[string]string)}
+}
+
+// Register HTTP patterns to functions
+func (wrapper *Handler) Register(mux *mux.Router) {
+	keyFromUrl := wrapper.Config.Get("gcp_destination_config.key_from_url")
+	if keyFromUrl != nil && keyFromUrl == true {
+		mux.HandleFunc("/{creds}/storage/v1/b/{bucket}/notificationConfigs", wrapper.ListHandle).Methods("GET")
+		mux.HandleFunc("/{creds}/storage/v1/b/{bucket}/notificationConfigs", wrapper.InsertHandle).Methods("POST")
+		mux.HandleFunc("/{creds}/storage/v1/b/{bucket}/notificationConfigs/{notification}", wrapper.GetHandle).Methods("GET")
+		mux.HandleFunc("/{creds}/storage/v1/b/{bucket}/notificationConfigs/{notification}", wrapper.DeleteHandle).Methods("DELETE")
+	} else {
+		mux.HandleFunc("/storage/v1/b/{bucket}/notificationConfigs", wrapper.ListHandle).Methods("GET")
+		mux.HandleFunc("/storage/v1/b/{bucket}/notificationConfigs", wrapper.InsertHandle).Methods("POST")
+		mux.HandleFunc("/storage/v1/b/{bucket}/notificationConfigs/{notification}", wrapper.GetHandle).Methods("GET")
+		mux.HandleFunc("/storage/v1/b/{bucket}/notificationConfigs/{notification}", wrapper.DeleteHandle).Methods("DELETE")
+	}
+}
+
+// SNS topic or SQS queue ARN standing in for a Pub/Sub topic, from notification_topic_map
+func (wrapper *Handler) topicArn(topic string) (string, error) {
+	project, topicId, err := converter.ParsePubSubTopic(topic)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("projects/%s/topics/%s", project, topicId)
+	arn := wrapper.Config.GetStringMapString("aws_destination_config.s3_config.notification_topic_map")[strings.ToLower(name)]
+	if !strings.HasPrefix(arn, "arn:aws:sns:") && !strings.HasPrefix(arn, "arn:aws:sqs:") {
+		return "", errors.New("no SNS topic or SQS queue for " + topic)
+	}
+	return arn, nil
+}
+
+// Pub/Sub topic an ARN stands in for, or the ARN itself for ones notification_topic_map doesn't name
+func (wrapper *Handler) arnTopic(arn string) string {
+	for topic, mapped := range wrapper.Config.GetStringMapString("aws_destination_config.s3_config.notification_topic_map") {
+		if mapped == arn {
+			if project, topicId, err := converter.ParsePubSubTopic(topic); err == nil {
+				return converter.PubSubTopicName(project, topicId)
+			}
+		}
+	}
+	return arn
+}
+
+// Notification configs of an S3 bucket, those S3 sends followed by those the sidecar sends
+func (wrapper *Handler) awsNotifications(bucket string) ([]*storage.Notification, *s3.GetBucketNotificationConfigurationOutput, error) {
+	renamed := wrapper.BucketRename(bucket)
+	s3Config, err := wrapper.S3Client.GetBucketNotificationConfigurationRequest(&s3.GetBucketNotificationConfigurationInput{
+		Bucket: &renamed,
+	}).Send()
+	if err != nil {
+		return nil, nil, err
+	}
+	notifications := make([]*storage.Notification, 0)
+	// S3 sends its own event format in the message body rather than a GCS payload
+	for _, topic := range s3Config.TopicConfigurations {
+		notifications = append(notifications, converter.NewNotification(bucket, &storage.Notification{
+			Id:               stringValue(topic.Id),
+			Topic:            wrapper.arnTopic(*topic.TopicArn),
+			EventTypes:       converter.S3EventsToGCS(topic.Events),
+			ObjectNamePrefix: converter.S3FilterToPrefix(topic.Filter),
+			PayloadFormat:    "NONE",
+		}))
+	}
+	for _, queue := range s3Config.QueueConfigurations {
+		notifications = append(notifications, converter.NewNotification(bucket, &storage.Notification{
+			Id:               stringValue(queue.Id),
+			Topic:            wrapper.arnTopic(*queue.QueueArn),
+			EventTypes:       converter.S3EventsToGCS(queue.Events),
+			ObjectNamePrefix: converter.S3FilterToPrefix(queue.Filter),
+			PayloadFormat:    "NONE",
+		}))
+	}
+	if wrapper.storeConfigured() {
+		stored, err := wrapper.loadStored(bucket, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		notifications = append(notifications, stored...)
+	}
+	return notifications, s3Config, nil
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// Add a notification config.  For S3 destinations, configs S3 can send are added to the bucket's notification
+// configuration, the rest are kept in the notification store for the sidecar to send
+func (wrapper *Handler) InsertHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := wrapper.InsertParseInput(request)
+	if err != nil {
+		writeNotificationError(writer, request, 400, err)
+		return
+	}
+	bucket := mux.Vars(request)["bucket"]
+	var result *storage.Notification
+	if wrapper.Config.IsSet("aws_destination_config") {
+		logging.LogUsingAWS()
+		arn, err := wrapper.topicArn(input.Topic)
+		if err != nil {
+			writeNotificationError(writer, request, 400, err)
+			return
+		}
+		input.Id = uuid2.New().String()
+		result = converter.NewNotification(bucket, input)
+		events, translatable := converter.GCSEventTypesToS3(input.EventTypes)
+		if translatable && len(input.CustomAttributes) == 0 && wrapper.notificationMode() != emitMode {
+			_, s3Config, err := wrapper.awsNotifications(bucket)
+			if err != nil {
+				writeNotificationError(writer, request, 404, err)
+				return
+			}
+			filter := converter.PrefixToS3Filter(input.ObjectNamePrefix)
+			if strings.HasPrefix(arn, "arn:aws:sns:") {
+				s3Config.TopicConfigurations = append(s3Config.TopicConfigurations, s3.TopicConfiguration{
+					Id: &input.Id, TopicArn: &arn, Events: events, Filter: filter,
+				})
+			} else {
+				s3Config.QueueConfigurations = append(s3Config.QueueConfigurations, s3.QueueConfiguration{
+					Id: &input.Id, QueueArn: &arn, Events: events, Filter: filter,
+				})
+			}
+			if err := wrapper.putS3Notifications(bucket, s3Config); err != nil {
+				writeNotificationError(writer, request, 400, err)
+				return
+			}
+			result.PayloadFormat = "NONE"
+		} else {
+			if !wrapper.storeConfigured() {
+				writeNotificationError(writer, request, 400, errors.New("S3 can't send these events and there is no notification_store for the sidecar to keep them in"))
+				return
+			}
+			err := wrapper.updateStored(bucket, func(stored []*storage.Notification) []*storage.Notification {
+				return append(stored, result)
+			})
+			if err != nil {
+				writeNotificationError(writer, request, 400, err)
+				return
+			}
+		}
+	} else if wrapper.Config.IsSet("gcp_destination_config") {
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			// Return connection to pool after done
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			writeNotificationError(writer, request, 400, err)
+			return
+		}
+		gcsNotification, err := converter.ResourceToGCSNotification(input)
+		if err != nil {
+			writeNotificationError(writer, request, 400, err)
+			return
+		}
+		created, err := wrapper.GCPClientToBucket(bucket, client).AddNotification(*wrapper.Context, gcsNotification)
+		if err != nil {
+			writeNotificationError(writer, request, 400, err)
+			return
+		}
+		result = converter.GCSNotificationToResource(bucket, created)
+	}
+	gcs_handler.WriteJSON(writer, request, result)
+}
+
+func (wrapper *Handler) InsertParseInput(request *http.Request) (*storage.Notification, error) {
+	var input storage.Notification
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		return nil, err
+	}
+	if _, _, err := converter.ParsePubSubTopic(input.Topic); err != nil {
+		return nil, err
+	}
+	if input.PayloadFormat == "" {
+		input.PayloadFormat = "JSON_API_V1"
+	}
+	if input.PayloadFormat != "JSON_API_V1" && input.PayloadFormat != "NONE" {
+		return nil, errors.New("invalid payload_format " + input.PayloadFormat)
+	}
+	return &input, nil
+}
+
+// All notification configs of a bucket
+func (wrapper *Handler) ListHandle(writer http.ResponseWriter, request *http.Request) {
+	notifications, status, err := wrapper.notifications(request)
+	if err != nil {
+		writeNotificationError(writer, request, status, err)
+		return
+	}
+	gcs_handler.WriteJSON(writer, request, &storage.Notifications{Kind: "storage#notifications", Items: notifications})
+}
+
+// One notification config
+func (wrapper *Handler) GetHandle(writer http.ResponseWriter, request *http.Request) {
+	notifications, status, err := wrapper.notifications(request)
+	if err != nil {
+		writeNotificationError(writer, request, status, err)
+		return
+	}
+	id := mux.Vars(request)["notification"]
+	for _, notification := range notifications {
+		if notification.Id == id {
+			gcs_handler.WriteJSON(writer, request, notification)
+			return
+		}
+	}
+	writeNotificationError(writer, request, 404, errors.New("no notification config "+id))
+}
+
+func (wrapper *Handler) notifications(request *http.Request) ([]*storage.Notification, int, error) {
+	bucket := mux.Vars(request)["bucket"]
+	notifications := make([]*storage.Notification, 0)
+	if wrapper.Config.IsSet("aws_destination_config") {
+		logging.LogUsingAWS()
+		awsNotifications, _, err := wrapper.awsNotifications(bucket)
+		if err != nil {
+			return nil, 404, err
+		}
+		notifications = awsNotifications
+	} else if wrapper.Config.IsSet("gcp_destination_config") {
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			// Return connection to pool after done
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			return nil, 400, err
+		}
+		gcsNotifications, err := wrapper.GCPClientToBucket(bucket, client).Notifications(*wrapper.Context)
+		if err != nil {
+			return nil, 404, err
+		}
+		for _, notification := range gcsNotifications {
+			notifications = append(notifications, converter.GCSNotificationToResource(bucket, notification))
+		}
+	}
+	return notifications, 200, nil
+}
+
+// Remove a notification config from wherever it is kept
+func (wrapper *Handler) DeleteHandle(writer http.ResponseWriter, request *http.Request) {
+	bucket := mux.Vars(request)["bucket"]
+	id := mux.Vars(request)["notification"]
+	if wrapper.Config.IsSet("aws_destination_config") {
+		logging.LogUsingAWS()
+		_, s3Config, err := wrapper.awsNotifications(bucket)
+		if err != nil {
+			writeNotificationError(writer, request, 404, err)
+			return
+		}
+		topics := make([]s3.TopicConfiguration, 0, len(s3Config.TopicConfigurations))
+		for _, topic := range s3Config.TopicConfigurations {
+			if stringValue(topic.Id) != id {
+				topics = append(topics, topic)
+			}
+		}
+		queues := make([]s3.QueueConfiguration, 0, len(s3Config.QueueConfigurations))
+		for _, queue := range s3Config.QueueConfigurations {
+			if stringValue(queue.Id) != id {
+				queues = append(queues, queue)
+			}
+		}
+		found := len(topics) < len(s3Config.TopicConfigurations) || len(queues) < len(s3Config.QueueConfigurations)
+		if found {
+			s3Config.TopicConfigurations = topics
+			s3Config.QueueConfigurations = queues
+			err = wrapper.putS3Notifications(bucket, s3Config)
+		} else if wrapper.storeConfigured() {
+			err = wrapper.updateStored(bucket, func(stored []*storage.Notification) []*storage.Notification {
+				kept := make([]*storage.Notification, 0, len(stored))
+				for _, notification := range stored {
+					if notification.Id == id {
+						found = true
+					} else {
+						kept = append(kept, notification)
+					}
+				}
+				return kept
+			})
+		}
+		if err != nil {
+			writeNotificationError(writer, request, 400, err)
+			return
+		}
+		if !found {
+			writeNotificationError(writer, request, 404, errors.New("no notification config "+id))
+			return
+		}
+	} else if wrapper.Config.IsSet("gcp_destination_config") {
+		client, err := wrapper.GCPRequestSetup(request)
+		if client != nil {
+			// Return connection to pool after done
+			defer wrapper.ReturnConnection(client, request)
+		}
+		if err != nil {
+			writeNotificationError(writer, request, 400, err)
+			return
+		}
+		if err := wrapper.GCPClientToBucket(bucket, client).DeleteNotification(*wrapper.Context, id); err != nil {
+			writeNotificationError(writer, request, 404, err)
+			return
+		}
+	}
+	writer.WriteHeader(204)
+}
+
+func (wrapper *Handler) putS3Notifications(bucket string, s3Config *s3.GetBucketNotificationConfigurationOutput) error {
+	renamed := wrapper.BucketRename(bucket)
+	_, err := wrapper.S3Client.PutBucketNotificationConfigurationRequest(&s3.PutBucketNotificationConfigurationInput{
+		Bucket: &renamed,
+		NotificationConfiguration: &s3.GetBucketNotificationConfigurationOutput{
+			TopicConfigurations:          s3Config.TopicConfigurations,
+			QueueConfigurations:          s3Config.QueueConfigurations,
+			LambdaFunctionConfigurations: s3Config.LambdaFunctionConfigurations,
+		},
+	}).Send()
+	return err
+}
+
+// s3 (the default) has S3 send the events it can, emit has the sidecar send them all
+func (wrapper *Handler) notificationMode() string {
+	return wrapper.Config.GetString("aws_destination_config.s3_config.notification_mode")
+}
+
+func writeNotificationError(writer http.ResponseWriter, request *http.Request, status int, err error) {
+	writer.WriteHeader(status)
+	logging.Log.Error("Error a %s %s", request.RequestURI, err)
+	writer.Write([]byte(string(fmt.Sprint(err))))
+}
@@ -0,0 +1,96 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"google.golang.org/api/storage/v1"
+	"strings"
+	"time"
+)
+
+// Config key naming the bucket/prefix the sidecar keeps the configs it sends events for under
+const storeKey = "aws_destination_config.s3_config.notification_store"
+
+// How long a replica sends events using configs read from the store before reading them again
+const storedConfigsTTL = 30 * time.Second
+
+// Configs of one bucket read from the store
+type storedConfigs struct {
+	loaded        time.Time
+	notifications []*storage.Notification
+}
+
+func (wrapper *Handler) storeConfigured() bool {
+	return wrapper.Config.GetString(storeKey) != ""
+}
+
+// Store bucket and key holding a bucket's configs.  They are kept in S3 so every replica sees the same ones
+func (wrapper *Handler) storeLocation(bucket string) (string, string) {
+	pieces := strings.SplitN(wrapper.Config.GetString(storeKey), "/", 2)
+	prefix := ""
+	if len(pieces) == 2 {
+		prefix = pieces[1]
+	}
+	return pieces[0], prefix + bucket + ".json"
+}
+
+// Configs the sidecar sends events for, from the cache unless fresh is set or they are stale
+func (wrapper *Handler) loadStored(bucket string, fresh bool) ([]*storage.Notification, error) {
+	wrapper.storedLock.Lock()
+	defer wrapper.storedLock.Unlock()
+	if cached := wrapper.stored[bucket]; cached != nil && !fresh && time.Since(cached.loaded) < storedConfigsTTL {
+		return cached.notifications, nil
+	}
+	return wrapper.fetchStored(bucket)
+}
+
+// Change a bucket's stored configs.  Changes from one replica are applied in turn, replicas changing the same
+// bucket at once can lose one of the changes
+func (wrapper *Handler) updateStored(bucket string, change func([]*storage.Notification) []*storage.Notification) error {
+	wrapper.storedLock.Lock()
+	defer wrapper.storedLock.Unlock()
+	stored, err := wrapper.fetchStored(bucket)
+	if err != nil {
+		return err
+	}
+	stored = change(stored)
+	body, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	storeBucket, key := wrapper.storeLocation(bucket)
+	contentType := "application/json"
+	_, err = wrapper.S3Client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      &storeBucket,
+		Key:         &key,
+		Body:        bytes.NewReader(body),
+		ContentType: &contentType,
+	}).Send()
+	if err != nil {
+		return err
+	}
+	wrapper.stored[bucket] = &storedConfigs{loaded: time.Now(), notifications: stored}
+	return nil
+}
+
+// Read a bucket's configs from the store into the cache.  Callers hold the stored lock
+func (wrapper *Handler) fetchStored(bucket string) ([]*storage.Notification, error) {
+	storeBucket, key := wrapper.storeLocation(bucket)
+	notifications := make([]*storage.Notification, 0)
+	output, err := wrapper.S3Client.GetObjectRequest(&s3.GetObjectInput{Bucket: &storeBucket, Key: &key}).Send()
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != s3.ErrCodeNoSuchKey {
+			return nil, err
+		}
+	} else {
+		defer output.Body.Close()
+		if err := json.NewDecoder(output.Body).Decode(&notifications); err != nil {
+			return nil, errors.New("unreadable notification store " + key + ": " + err.Error())
+		}
+	}
+	wrapper.stored[bucket] = &storedConfigs{loaded: time.Now(), notifications: notifications}
+	return notifications, nil
+}
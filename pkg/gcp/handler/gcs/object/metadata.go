@@ -0,0 +1,317 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	"cloudsidecar/pkg/converter"
+	gcs_handler "cloudsidecar/pkg/gcp/handler/gcs"
+	"cloudsidecar/pkg/logging"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	storage2 "google.golang.org/api/storage/v1"
+	"net/http"
+	"strings"
+)
+
+// Only algorithm GCS and S3 take customer supplied keys for
+var customerKeyAlgorithm = "AES256"
+
+// Writable string fields of an object resource
+var objectStringFields = []string{"contentType", "contentEncoding", "contentDisposition", "contentLanguage", "cacheControl"}
+
+// Object resource changes from a PATCH or PUT
+type ObjectUpdate struct {
+	Bucket string
+	Key    string
+	// PATCH keeps what the request leaves out, PUT clears it
+	Patch bool
+	// given string fields by JSON name, nil to clear
+	Fields map[string]*string
+	// given metadata entries, nil to delete
+	Metadata map[string]*string
+	// metadata was null, dropping all of it
+	ClearMetadata bool
+	// customer supplied key the object is encrypted with, from x-goog-encryption-key
+	CustomerKey []byte
+}
+
+// Writable fields of an object after the update
+func (update *ObjectUpdate) apply(current *storage2.Object) *storage2.Object {
+	values := map[string]string{}
+	if update.Patch {
+		values = map[string]string{
+			"contentType":        current.ContentType,
+			"contentEncoding":    current.ContentEncoding,
+			"contentDisposition": current.ContentDisposition,
+			"contentLanguage":    current.ContentLanguage,
+			"cacheControl":       current.CacheControl,
+		}
+	}
+	for name, value := range update.Fields {
+		if value == nil {
+			delete(values, name)
+		} else {
+			values[name] = *value
+		}
+	}
+	metadata := make(map[string]string)
+	if update.Patch && !update.ClearMetadata {
+		for k, v := range current.Metadata {
+			metadata[k] = v
+		}
+	}
+	for k, v := range update.Metadata {
+		if v == nil {
+			delete(metadata, k)
+		} else {
+			metadata[k] = *v
+		}
+	}
+	return &storage2.Object{
+		ContentType:        values["contentType"],
+		ContentEncoding:    values["contentEncoding"],
+		ContentDisposition: values["contentDisposition"],
+		ContentLanguage:    values["contentLanguage"],
+		CacheControl:       values["cacheControl"],
+		Metadata:           metadata,
+	}
+}
+
+// Metadata maps to update a GCS object with.  GCS merges what it is sent into the metadata the object has and the
+// pinned client can only delete all of it, so when the update drops keys everything is cleared first and what
+// is left written back after
+func metadataUpdates(current map[string]string, updated map[string]string) []map[string]string {
+	for k := range current {
+		if _, ok := updated[k]; !ok {
+			if len(updated) == 0 {
+				return []map[string]string{{}}
+			}
+			return []map[string]string{{}, updated}
+		}
+	}
+	return []map[string]string{updated}
+}
+
+// Whether a GET wants the object's content rather than its resource
+func isMediaRequest(request *http.Request) bool {
+	return request.URL.Query().Get("alt") == "media" || strings.Contains(request.URL.Path, "/download/storage/v1/")
+}
+
+// Object resource of an object
+func (handler *Handler) MetadataHandle(writer http.ResponseWriter, request *http.Request) {
+	input, _ := handler.GetParseInput(request)
+	var object *storage2.Object
+	if handler.Config.IsSet("aws_destination_config") {
+		logging.LogUsingAWS()
+		bucket := handler.BucketRename(*input.Bucket)
+		head, err := handler.S3Client.HeadObjectRequest(&s3.HeadObjectInput{Bucket: &bucket, Key: input.Key}).Send()
+		if err != nil {
+			writer.WriteHeader(404)
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			return
+		}
+		object = converter.AWSHeadToGCSObject(*input.Bucket, *input.Key, head)
+	} else if handler.Config.IsSet("gcp_destination_config") {
+		client, err := handler.GCPRequestSetup(request)
+		if client != nil {
+			// return connection to pool after done
+			defer handler.ReturnConnection(client, request)
+		}
+		if err != nil {
+			writer.WriteHeader(400)
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			writer.Write([]byte(string(fmt.Sprint(err))))
+			return
+		}
+		bucketHandle := handler.GCPClientToBucket(*input.Bucket, client)
+		attrs, err := handler.GCPBucketToObject(*input.Key, bucketHandle).Attrs(*handler.Context)
+		if err != nil {
+			writer.WriteHeader(404)
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			return
+		}
+		object = converter.GCSAttrsToObject(attrs)
+	}
+	gcs_handler.WriteJSON(writer, request, object)
+}
+
+// Update an object's metadata and content headers.  S3 objects are copied onto themselves with the new values
+func (handler *Handler) UpdateHandle(writer http.ResponseWriter, request *http.Request) {
+	update, err := handler.UpdateParseInput(request)
+	if err != nil {
+		writer.WriteHeader(400)
+		logging.Log.Error("Error at %s %s", request.RequestURI, err)
+		writer.Write([]byte(string(fmt.Sprint(err))))
+		return
+	}
+	var object *storage2.Object
+	if handler.Config.IsSet("aws_destination_config") {
+		logging.LogUsingAWS()
+		bucket := handler.BucketRename(update.Bucket)
+		headInput := &s3.HeadObjectInput{Bucket: &bucket, Key: &update.Key}
+		if len(update.CustomerKey) > 0 {
+			headInput.SSECustomerAlgorithm = &customerKeyAlgorithm
+			headInput.SSECustomerKey = aws.String(string(update.CustomerKey))
+		}
+		head, err := handler.S3Client.HeadObjectRequest(headInput).Send()
+		if err != nil {
+			writer.WriteHeader(404)
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			return
+		}
+		// a copy gets the bucket's default ACL, so the object's grants are sent along with it
+		acl, err := handler.S3Client.GetObjectAclRequest(&s3.GetObjectAclInput{Bucket: &bucket, Key: &update.Key}).Send()
+		if err != nil {
+			writer.WriteHeader(400)
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			writer.Write([]byte(string(fmt.Sprint(err))))
+			return
+		}
+		grants := converter.GrantsToHeaders(acl.Grants)
+		updated := update.apply(converter.AWSHeadToGCSObject(update.Bucket, update.Key, head))
+		source := fmt.Sprintf("%s/%s", bucket, update.Key)
+		copyInput := &s3.CopyObjectInput{
+			Bucket:            &bucket,
+			Key:               &update.Key,
+			CopySource:        &source,
+			MetadataDirective: s3.MetadataDirectiveReplace,
+			Metadata:          updated.Metadata,
+			StorageClass:      head.StorageClass,
+			// encryption is not copied unless asked for again
+			ServerSideEncryption: head.ServerSideEncryption,
+			SSEKMSKeyId:          head.SSEKMSKeyId,
+			TaggingDirective:     s3.TaggingDirectiveCopy,
+			GrantFullControl:     grants[s3.PermissionFullControl],
+			GrantRead:            grants[s3.PermissionRead],
+			GrantReadACP:         grants[s3.PermissionReadAcp],
+			GrantWriteACP:        grants[s3.PermissionWriteAcp],
+		}
+		if len(update.CustomerKey) > 0 {
+			copyInput.CopySourceSSECustomerAlgorithm = &customerKeyAlgorithm
+			copyInput.CopySourceSSECustomerKey = aws.String(string(update.CustomerKey))
+			copyInput.SSECustomerAlgorithm = &customerKeyAlgorithm
+			copyInput.SSECustomerKey = aws.String(string(update.CustomerKey))
+		}
+		if updated.ContentType != "" {
+			copyInput.ContentType = &updated.ContentType
+		}
+		if updated.ContentEncoding != "" {
+			copyInput.ContentEncoding = &updated.ContentEncoding
+		}
+		if updated.ContentDisposition != "" {
+			copyInput.ContentDisposition = &updated.ContentDisposition
+		}
+		if updated.ContentLanguage != "" {
+			copyInput.ContentLanguage = &updated.ContentLanguage
+		}
+		if updated.CacheControl != "" {
+			copyInput.CacheControl = &updated.CacheControl
+		}
+		if _, err := handler.S3Client.CopyObjectRequest(copyInput).Send(); err != nil {
+			writer.WriteHeader(400)
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			writer.Write([]byte(string(fmt.Sprint(err))))
+			return
+		}
+		head, err = handler.S3Client.HeadObjectRequest(headInput).Send()
+		if err != nil {
+			writer.WriteHeader(404)
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			return
+		}
+		object = converter.AWSHeadToGCSObject(update.Bucket, update.Key, head)
+	} else if handler.Config.IsSet("gcp_destination_config") {
+		client, err := handler.GCPRequestSetup(request)
+		if client != nil {
+			// return connection to pool after done
+			defer handler.ReturnConnection(client, request)
+		}
+		if err != nil {
+			writer.WriteHeader(400)
+			logging.Log.Error("Error a %s %s", request.RequestURI, err)
+			writer.Write([]byte(string(fmt.Sprint(err))))
+			return
+		}
+		bucketHandle := handler.GCPClientToBucket(update.Bucket, client)
+		objectHandle := handler.GCPBucketToObject(update.Key, bucketHandle)
+		if len(update.CustomerKey) > 0 {
+			objectHandle = objectHandle.Key(update.CustomerKey)
+		}
+		attrs, err := objectHandle.Attrs(*handler.Context)
+		if err != nil {
+			writer.WriteHeader(404)
+			logging.Log.Error("Error %s %s", request.RequestURI, err)
+			return
+		}
+		updated := update.apply(converter.GCSAttrsToObject(attrs))
+		for _, metadata := range metadataUpdates(attrs.Metadata, updated.Metadata) {
+			attrs, err = objectHandle.Update(*handler.Context, storage.ObjectAttrsToUpdate{
+				ContentType:        updated.ContentType,
+				ContentEncoding:    updated.ContentEncoding,
+				ContentDisposition: updated.ContentDisposition,
+				ContentLanguage:    updated.ContentLanguage,
+				CacheControl:       updated.CacheControl,
+				// an empty map deletes all metadata
+				Metadata: metadata,
+			})
+			if err != nil {
+				writer.WriteHeader(400)
+				logging.Log.Error("Error %s %s", request.RequestURI, err)
+				writer.Write([]byte(string(fmt.Sprint(err))))
+				return
+			}
+		}
+		object = converter.GCSAttrsToObject(attrs)
+	}
+	handler.notify(request, storage.ObjectMetadataUpdateEvent, update.Bucket, update.Key)
+	gcs_handler.WriteJSON(writer, request, object)
+}
+
+func (handler *Handler) UpdateParseInput(r *http.Request) (*ObjectUpdate, error) {
+	vars := mux.Vars(r)
+	update := &ObjectUpdate{
+		Bucket:   vars["bucket"],
+		Key:      vars["key"],
+		Patch:    r.Method == http.MethodPatch,
+		Fields:   make(map[string]*string),
+		Metadata: make(map[string]*string),
+	}
+	if key := r.Header.Get("x-goog-encryption-key"); key != "" {
+		if algorithm := r.Header.Get("x-goog-encryption-algorithm"); algorithm != customerKeyAlgorithm {
+			return nil, fmt.Errorf("invalid encryption algorithm %s", algorithm)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(decoded) != 32 {
+			return nil, errors.New("invalid encryption key")
+		}
+		update.CustomerKey = decoded
+	}
+	body := make(map[string]json.RawMessage)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	for _, name := range objectStringFields {
+		raw, ok := body[name]
+		if !ok {
+			continue
+		}
+		var value *string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", name, err)
+		}
+		update.Fields[name] = value
+	}
+	if raw, ok := body["metadata"]; ok {
+		var metadata map[string]*string
+		if err := json.Unmarshal(raw, &metadata); err != nil {
+			return nil, fmt.Errorf("invalid metadata: %s", err)
+		}
+		update.Metadata = metadata
+		update.ClearMetadata = metadata == nil
+	}
+	return update, nil
+}
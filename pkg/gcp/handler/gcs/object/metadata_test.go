@@ -0,0 +1,88 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	gcs_handler "cloudsidecar/pkg/gcp/handler/gcs"
+	"context"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gcsConfig() *viper.Viper {
+	config := viper.New()
+	config.Set("gcp_destination_config", "meow")
+	return config
+}
+
+// Handler over a single mocked GCS object
+func mockObjectHandler(ctrl *gomock.Controller, objectMock *s3_handler.MockGCPObject) *Handler {
+	ctx := context.Background()
+	clientMock := s3_handler.NewMockGCPClient(ctrl)
+	bucketMock := s3_handler.NewMockGCPBucket(ctrl)
+	gcsHandler := gcs_handler.NewHandler(gcsConfig())
+	gcsHandler.Context = &ctx
+	gcsHandler.GCPClient = func() (s3_handler.GCPClient, error) {
+		return clientMock, nil
+	}
+	gcsHandler.GCPClientToBucket = func(bucket string, client s3_handler.GCPClient) s3_handler.GCPBucket {
+		return bucketMock
+	}
+	gcsHandler.GCPBucketToObject = func(name string, bucket s3_handler.GCPBucket) s3_handler.GCPObject {
+		return objectMock
+	}
+	return &Handler{Handler: &gcsHandler}
+}
+
+func TestMetadataUpdates(t *testing.T) {
+	current := map[string]string{"a": "1", "b": "2"}
+	assert.Equal(t, []map[string]string{{"a": "1", "b": "3"}}, metadataUpdates(current, map[string]string{"a": "1", "b": "3"}))
+	assert.Equal(t, []map[string]string{{}, {"b": "2"}}, metadataUpdates(current, map[string]string{"b": "2"}))
+	assert.Equal(t, []map[string]string{{}}, metadataUpdates(current, map[string]string{}))
+}
+
+func TestHandler_UpdateHandle_DeletesMetadataKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	objectMock := s3_handler.NewMockGCPObject(ctrl)
+	handler := mockObjectHandler(ctrl, objectMock)
+	current := &storage.ObjectAttrs{
+		Bucket:      "bucket",
+		Name:        "key",
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"keep": "1", "drop": "2"},
+	}
+	objectMock.EXPECT().Attrs(gomock.Any()).Return(current, nil)
+	cleared := &storage.ObjectAttrs{Bucket: "bucket", Name: "key", ContentType: "text/plain"}
+	updated := &storage.ObjectAttrs{Bucket: "bucket", Name: "key", ContentType: "text/plain", Metadata: map[string]string{"keep": "1"}}
+	gomock.InOrder(
+		objectMock.EXPECT().Update(gomock.Any(), storage.ObjectAttrsToUpdate{
+			ContentType:        "text/plain",
+			ContentEncoding:    "",
+			ContentDisposition: "",
+			ContentLanguage:    "",
+			CacheControl:       "",
+			Metadata:           map[string]string{},
+		}).Return(cleared, nil),
+		objectMock.EXPECT().Update(gomock.Any(), storage.ObjectAttrsToUpdate{
+			ContentType:        "text/plain",
+			ContentEncoding:    "",
+			ContentDisposition: "",
+			ContentLanguage:    "",
+			CacheControl:       "",
+			Metadata:           map[string]string{"keep": "1"},
+		}).Return(updated, nil),
+	)
+	request := httptest.NewRequest("PATCH", "http://localhost/storage/v1/b/bucket/o/key", strings.NewReader(`{"metadata":{"drop":null}}`))
+	request = mux.SetURLVars(request, map[string]string{"bucket": "bucket", "key": "key"})
+	writer := httptest.NewRecorder()
+	handler.UpdateHandle(writer, request)
+	assert.Equal(t, 200, writer.Code)
+	assert.Contains(t, writer.Body.String(), `"metadata":{"keep":"1"}`)
+	assert.NotContains(t, writer.Body.String(), "drop")
+}
@@ -2,6 +2,7 @@ package object
 
 import (
 	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
 	"cloudsidecar/pkg/converter"
 	gcs_handler "cloudsidecar/pkg/gcp/handler/gcs"
 	"cloudsidecar/pkg/logging"
@@ -29,20 +30,41 @@ import (
 type Handler struct {
 	*gcs_handler.Handler
 	fileMutex sync.Mutex
+	// locks of resumable sessions in use by upload id
+	sessionLocks     map[string]*sessionLock
+	uploadsLock      sync.Mutex
+	lastSessionSweep time.Time
+	// sends object change events, nil when nothing needs sending
+	Notifier Notifier
+}
+
+// Sends object change events for destinations that don't send their own
+type Notifier interface {
+	Notify(request *http.Request, eventType string, bucket string, key string)
+}
+
+func (handler *Handler) notify(request *http.Request, eventType string, bucket string, key string) {
+	if handler.Notifier != nil {
+		handler.Notifier.Notify(request, eventType, bucket, key)
+	}
 }
 
 // Interface for object functions
 type Object interface {
 	GetHandle(writer http.ResponseWriter, request *http.Request)
 	GetParseInput(r *http.Request) (*s3.GetObjectInput, error)
+	MetadataHandle(writer http.ResponseWriter, request *http.Request)
+	UpdateHandle(writer http.ResponseWriter, request *http.Request)
+	UpdateParseInput(r *http.Request) (*ObjectUpdate, error)
 	UploadMultipartHandle(writer http.ResponseWriter, request *http.Request)
 	UploadMultipartParseInput(r *http.Request) (*s3manager.UploadInput, *multipart.Reader, error)
 	ResumableHandle(writer http.ResponseWriter, request *http.Request)
 	ResumableParseInput(r *http.Request) (*s3manager.UploadInput, error)
 	UploadResumableHandle(writer http.ResponseWriter, request *http.Request)
-	UploadResumableParseInput(r *http.Request) (*s3manager.UploadInput, error)
+	UploadResumableParseInput(r *http.Request) (*s3manager.UploadInput, string, *contentRange, error)
+	CancelResumableHandle(writer http.ResponseWriter, request *http.Request)
 	CopyHandle(writer http.ResponseWriter, request *http.Request)
-	CopyParseInput(r *http.Request) (*s3.CopyObjectInput, error)
+	CopyParseInput(r *http.Request) (*RewriteRequest, error)
 	DeleteHandle(writer http.ResponseWriter, request *http.Request)
 	DeleteParseInput(r *http.Request) (*s3.DeleteObjectInput, error)
 	ComposeHandle(writer http.ResponseWriter, request *http.Request)
@@ -60,9 +82,11 @@ func (wrapper *Handler) Register(mux *mux.Router) {
 		mux.HandleFunc("/{creds}/upload/storage/v1/b/{bucket}/o", wrapper.UploadMultipartHandle).Queries("uploadType", "multipart").Methods("POST")
 		mux.HandleFunc("/{creds}/upload/storage/v1/b/{bucket}/o", wrapper.ResumableHandle).Queries("uploadType", "resumable").Methods("POST")
 		mux.HandleFunc("/{creds}/upload/storage/v1/b/{bucket}/o", wrapper.UploadResumableHandle).Queries("uploadType", "resumable", "upload_id", "{uploadId}").Methods("PUT")
+		mux.HandleFunc("/{creds}/upload/storage/v1/b/{bucket}/o", wrapper.CancelResumableHandle).Queries("uploadType", "resumable", "upload_id", "{uploadId}").Methods("DELETE")
 		mux.HandleFunc("/{creds}/storage/v1/b/{bucket}/o/{key:[^#?\\s]+}/rewriteTo/b/{destBucket}/o/{destKey:[^#?\\s]+}", wrapper.CopyHandle).Methods("POST")
 		mux.HandleFunc("/{creds}/storage/v1/b/{bucket}/o/{key:[^#?\\s]+}/copyTo/b/{destBucket}/o/{destKey:[^#?\\s]+}", wrapper.CopyHandle).Methods("POST")
 		mux.HandleFunc("/{creds}/storage/v1/b/{bucket}/o/{key:[^#?\\s]+}", wrapper.DeleteHandle).Methods("DELETE")
+		mux.HandleFunc("/{creds}/storage/v1/b/{bucket}/o/{key:[^#?\\s]+}", wrapper.UpdateHandle).Methods("PATCH", "PUT")
 		mux.HandleFunc("/{creds}/storage/v1/b/{bucket}/o/{key:[^#?\\s]+}/compose", wrapper.ComposeHandle).Methods("POST")
 	} else {
 		mux.HandleFunc("/storage/v1/b/{bucket}/o/{key:[^#?\\s]+}", wrapper.GetHandle).Methods("GET")
@@ -70,14 +94,21 @@ func (wrapper *Handler) Register(mux *mux.Router) {
 		mux.HandleFunc("/upload/storage/v1/b/{bucket}/o", wrapper.UploadMultipartHandle).Queries("uploadType", "multipart").Methods("POST")
 		mux.HandleFunc("/upload/storage/v1/b/{bucket}/o", wrapper.ResumableHandle).Queries("uploadType", "resumable").Methods("POST")
 		mux.HandleFunc("/upload/storage/v1/b/{bucket}/o", wrapper.UploadResumableHandle).Queries("uploadType", "resumable", "upload_id", "{uploadId}").Methods("PUT")
+		mux.HandleFunc("/upload/storage/v1/b/{bucket}/o", wrapper.CancelResumableHandle).Queries("uploadType", "resumable", "upload_id", "{uploadId}").Methods("DELETE")
 		mux.HandleFunc("/storage/v1/b/{bucket}/o/{key:[^#?\\s]+}/rewriteTo/b/{destBucket}/o/{destKey:[^#?\\s]+}", wrapper.CopyHandle).Methods("POST")
 		mux.HandleFunc("/storage/v1/b/{bucket}/o/{key:[^#?\\s]+}/copyTo/b/{destBucket}/o/{destKey:[^#?\\s]+}", wrapper.CopyHandle).Methods("POST")
 		mux.HandleFunc("/storage/v1/b/{bucket}/o/{key:[^#?\\s]+}", wrapper.DeleteHandle).Methods("DELETE")
+		mux.HandleFunc("/storage/v1/b/{bucket}/o/{key:[^#?\\s]+}", wrapper.UpdateHandle).Methods("PATCH", "PUT")
 		mux.HandleFunc("/storage/v1/b/{bucket}/o/{key:[^#?\\s]+}/compose", wrapper.ComposeHandle).Methods("POST")
 	}
 }
 
+// Object content for alt=media and downloads, otherwise the object resource
 func (handler *Handler) GetHandle(writer http.ResponseWriter, request *http.Request) {
+	if !isMediaRequest(request) {
+		handler.MetadataHandle(writer, request)
+		return
+	}
 	input, _ := handler.GetParseInput(request)
 	identifier := rand.Int()
 	if handler.Config.IsSet("aws_destination_config") {
@@ -109,6 +140,9 @@ func (handler *Handler) GetHandle(writer http.ResponseWriter, request *http.Requ
 		if header := resp.ContentLength; header != nil {
 			writer.Header().Set("Content-Length", strconv.FormatInt(*header, 10))
 		}
+		if header := resp.ContentType; header != nil {
+			writer.Header().Set("Content-Type", *header)
+		}
 		if n, writeErr := io.Copy(writer, resp.Body); writeErr != nil {
 			logging.Log.Error("Some error writing", n, identifier, request.RequestURI, writeErr)
 		}
@@ -136,6 +170,8 @@ func (handler *Handler) GetHandle(writer http.ResponseWriter, request *http.Requ
 			return
 		}
 		defer reader.Close()
+		writer.Header().Set("Content-Type", reader.ContentType())
+		writer.Header().Set("Content-Length", strconv.FormatInt(reader.Size(), 10))
 		if n, writeErr := io.Copy(writer, reader); writeErr != nil {
 			logging.Log.Error("Some error writing", n, identifier, request.RequestURI, writeErr)
 			return
@@ -268,6 +304,7 @@ func (handler *Handler) UploadMultipartHandle(writer http.ResponseWriter, reques
 		return
 	}
 	logging.Log.Debugf("Upload result %s", jsonAttrs)
+	handler.notify(request, storage.ObjectFinalizeEvent, mux.Vars(request)["bucket"], attrs.Name)
 	writer.WriteHeader(200)
 	writer.Write(jsonAttrs)
 }
@@ -276,17 +313,32 @@ func (handler *Handler) ResumableParseInput(r *http.Request) (*s3manager.UploadI
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 	jsonMap := make(map[string]interface{})
-	if err := json.NewDecoder(r.Body).Decode(&jsonMap); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&jsonMap); err != nil && err != io.EOF {
 		return nil, err
 	}
-	if jsonMap["name"] == nil {
+	// name may be given as a query parameter when there is no metadata
+	key, _ := jsonMap["name"].(string)
+	if key == "" {
+		key = r.URL.Query().Get("name")
+	}
+	if key == "" {
 		return nil, errors.New("No name field in json body")
 	}
-	key := jsonMap["name"].(string)
 	s3Req := &s3manager.UploadInput{
 		Bucket: &bucket,
 		Key:    &key,
 	}
+	if contentType, ok := jsonMap["contentType"].(string); ok && contentType != "" {
+		s3Req.ContentType = &contentType
+	} else if contentType := r.Header.Get("X-Upload-Content-Type"); contentType != "" {
+		s3Req.ContentType = &contentType
+	}
+	if metadata, ok := jsonMap["metadata"].(map[string]interface{}); ok {
+		s3Req.Metadata = make(map[string]string)
+		for k, v := range metadata {
+			s3Req.Metadata[k] = fmt.Sprint(v)
+		}
+	}
 	return s3Req, nil
 }
 func (handler *Handler) ResumableHandle(writer http.ResponseWriter, request *http.Request) {
@@ -297,26 +349,10 @@ func (handler *Handler) ResumableHandle(writer http.ResponseWriter, request *htt
 		writer.Write([]byte(string(fmt.Sprint(err))))
 		return
 	}
+	handler.expireSessions(request)
 	uuid := uuid2.New().String()
-	var path string
-	if handler.Config.IsSet("aws_destination_config") {
-		logging.LogUsingAWS()
-		path = fmt.Sprintf("%s/%s", handler.Config.GetString("aws_destination_config.s3_config.multipart_db_directory"), uuid)
-	} else if handler.Config.IsSet("gcp_destination_config") {
-		logging.LogUsingGCP()
-		path = fmt.Sprintf("%s/%s", handler.Config.GetString("gcp_destination_config.gcs_config.multipart_db_directory"), uuid)
-	}
-	f, fileErr := os.Create(path)
-	if fileErr != nil {
+	if err := handler.saveSession(uuid, &resumableSession{Input: s3Req}); err != nil {
 		writer.WriteHeader(400)
-		logging.Log.Error("Error %s %s", request.RequestURI, fileErr)
-		writer.Write([]byte(string(fmt.Sprint(err))))
-		return
-	}
-	defer f.Close()
-	jsonReq, _ := json.Marshal(s3Req)
-	if _, err := f.Write(jsonReq); err != nil {
-		writer.WriteHeader(404)
 		logging.Log.Error("Error %s %s", request.RequestURI, err)
 		writer.Write([]byte(string(fmt.Sprint(err))))
 		return
@@ -326,199 +362,171 @@ func (handler *Handler) ResumableHandle(writer http.ResponseWriter, request *htt
 	writer.WriteHeader(200)
 }
 
+// Upload a chunk of a resumable upload, or ask how much has arrived.  Chunks go on to the destination as they
+// arrive and the session file records how far the upload got, anything but the last chunk is answered with 308
+// and the range received so far
 func (handler *Handler) UploadResumableHandle(writer http.ResponseWriter, request *http.Request) {
-	s3Req, path, err := handler.UploadResumableParseInput(request)
+	handler.expireSessions(request)
+	_, uploadId, byteRange, err := handler.UploadResumableParseInput(request)
 	if err != nil {
-		writer.WriteHeader(400)
+		if os.IsNotExist(err) {
+			writer.WriteHeader(404)
+		} else {
+			writer.WriteHeader(400)
+		}
 		logging.Log.Error("Error at %s %s", request.RequestURI, err)
 		writer.Write([]byte(string(fmt.Sprint(err))))
 		return
 	}
-	var attrs *storage.ObjectAttrs
-	if handler.Config.IsSet("aws_destination_config") {
-		logging.LogUsingAWS()
-		uploader := s3manager.NewUploaderWithClient(handler.S3Client)
-		bucket := handler.BucketRename(*s3Req.Bucket)
-		s3Req.Bucket = &bucket
-		_, err := uploader.Upload(s3Req)
-		if err != nil {
-			writer.WriteHeader(400)
-			logging.Log.Error("Error %s %s", request.RequestURI, err)
-			return
-		}
-		attrs = &storage.ObjectAttrs{
-			Bucket: *s3Req.Bucket,
-			Name:   *s3Req.Key,
-		}
-	} else if handler.Config.IsSet("gcp_destination_config") {
-		// Use GCS
-		logging.Log.Info("Begin PUT request", request.RequestURI)
-		// Log that we are using GCP, get a client based on configurations.  This is from a pool
-		client, err := handler.GCPRequestSetup(request)
-		if client != nil {
-			// return connection to pool after done
-			defer handler.ReturnConnection(client, request)
-		}
-		if err != nil {
+	unlock := handler.lockSession(uploadId)
+	defer unlock()
+	// another request may have moved the session on while this one waited
+	session, err := handler.loadSession(uploadId)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writer.WriteHeader(404)
+		} else {
 			writer.WriteHeader(400)
-			logging.Log.Error("Error a %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
-			return
 		}
-		bucketHandle := handler.GCPClientToBucket(*s3Req.Bucket, client)
-		uploader := handler.GCPBucketToObject(*s3Req.Key, bucketHandle).NewWriter(*handler.Context)
-		_, err = converter.GCPUpload(s3Req, uploader)
-		uploaderErr := uploader.Close()
-		if err != nil {
-			writer.WriteHeader(400)
-			logging.Log.Error("Error %s %s", request.RequestURI, err)
-			return
+		logging.Log.Error("Error at %s %s", request.RequestURI, err)
+		return
+	}
+	now := time.Now()
+	os.Chtimes(handler.sessionPath(uploadId), now, now)
+	if session.Attrs == nil {
+		var client s3_handler.GCPClient
+		if !handler.Config.IsSet("aws_destination_config") {
+			client, err = handler.GCPRequestSetup(request)
+			if client != nil {
+				defer handler.ReturnConnection(client, request)
+			}
+			if err != nil {
+				writer.WriteHeader(400)
+				logging.Log.Error("Error a %s %s", request.RequestURI, err)
+				writer.Write([]byte(string(fmt.Sprint(err))))
+				return
+			}
 		}
-		if uploaderErr != nil {
-			writer.WriteHeader(400)
-			logging.Log.Error("Error %s %s", request.RequestURI, uploaderErr)
+		if !handler.resumeUpload(writer, request, uploadId, session, client, byteRange) {
 			return
 		}
-		attrs = uploader.Attrs()
-		converter.GCSMD5ToEtag(attrs, writer)
-		logging.Log.Info("Finish PUT request", request.RequestURI)
 	}
+	attrs := session.Attrs
+	converter.GCSMD5ToEtag(attrs, writer)
 	jsonAttrs, err := json.Marshal(attrs)
 	if err != nil {
 		writer.WriteHeader(404)
 		logging.Log.Error("Error %s %s", request.RequestURI, err)
 		return
 	}
-	defer os.Remove(path)
 	logging.Log.Debugf("Upload result %s", jsonAttrs)
 	writer.WriteHeader(200)
 	writer.Write(jsonAttrs)
 }
-func (handler *Handler) UploadResumableParseInput(r *http.Request) (*s3manager.UploadInput, string, error) {
-	vars := mux.Vars(r)
-	uploadId := vars["uploadId"]
-	var path string
-	if handler.Config.IsSet("aws_destination_config") {
-		logging.LogUsingAWS()
-		path = fmt.Sprintf("%s/%s", handler.Config.GetString("aws_destination_config.s3_config.multipart_db_directory"), uploadId)
-	} else if handler.Config.IsSet("gcp_destination_config") {
-		logging.LogUsingGCP()
-		path = fmt.Sprintf("%s/%s", handler.Config.GetString("gcp_destination_config.gcs_config.multipart_db_directory"), uploadId)
-	}
-	f, fileErr := os.Open(path)
-	if fileErr != nil {
-		logging.Log.Errorf("Error opening %s %v", path, fileErr)
-		return nil, "", fileErr
-	}
-	defer f.Close()
-	var req s3manager.UploadInput
-	byteValue, _ := ioutil.ReadAll(f)
-	logging.Log.Debugf("Resuming %s %s", path, byteValue)
-	if err := json.Unmarshal(byteValue, &req); err != nil {
-		return nil, "", err
-	}
-	req.Body = r.Body
-	return &req, path, nil
-}
 
-func (handler *Handler) CopyHandle(writer http.ResponseWriter, request *http.Request) {
-	s3Req, err := handler.CopyParseInput(request)
+// Apply a chunk to a session and finish the object once all of it has arrived.  Returns false when the response
+// has been written, because the upload is incomplete or failed
+func (handler *Handler) resumeUpload(writer http.ResponseWriter, request *http.Request, uploadId string, session *resumableSession, client s3_handler.GCPClient, byteRange *contentRange) bool {
+	offset, err := handler.sessionOffset(uploadId, session, client)
 	if err != nil {
 		writer.WriteHeader(400)
-		logging.Log.Error("Error at %s %s", request.RequestURI, err)
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
 		writer.Write([]byte(string(fmt.Sprint(err))))
-		return
+		return false
 	}
-	var copyResult CopyResponse
-	if handler.Config.IsSet("aws_destination_config") {
-		logging.LogUsingAWS()
-		req := handler.S3Client.CopyObjectRequest(s3Req)
-		_, err := req.Send()
-		if err != nil {
-			writer.WriteHeader(404)
-			logging.Log.Error("Error %s %s", request.RequestURI, err)
-			return
-		}
-		bucket := handler.BucketRename(*s3Req.Bucket)
-		s3Req.Bucket = &bucket
-		headInfo, err := handler.S3Client.HeadObjectRequest(&s3.HeadObjectInput{
-			Bucket: s3Req.Bucket,
-			Key:    s3Req.Key,
-		}).Send()
-		if err != nil {
+	if byteRange.hasData() {
+		if byteRange.First > offset {
+			writeResumeIncomplete(writer, offset)
+			return false
+		}
+		readErr, writeErr := handler.writeChunk(uploadId, session, client, request.Body, offset-byteRange.First, offset)
+		if writeErr != nil {
+			handler.cancelSession(uploadId, request)
 			writer.WriteHeader(400)
-			logging.Log.Error("Error %s %s", request.RequestURI, err)
-			return
-		}
-		copyResult = CopyResponse{
-			Kind:                "storage#rewriteResponse",
-			TotalBytesRewritten: *headInfo.ContentLength,
-			ObjectSize:          *headInfo.ContentLength,
-			Done:                true,
-			Resource:            "moo",
+			logging.Log.Error("Error %s %s", request.RequestURI, writeErr)
+			writer.Write([]byte(string(fmt.Sprint(writeErr))))
+			return false
 		}
-	} else if handler.Config.IsSet("gcp_destination_config") {
-		// Use GCS
-		// Log that we are using GCP, get a client based on configurations.  This is from a pool
-		client, err := handler.GCPRequestSetup(request)
-		if client != nil {
-			// return connection to pool after done
-			defer handler.ReturnConnection(client, request)
-		}
-		if err != nil {
+		if offset, err = handler.sessionOffset(uploadId, session, client); err != nil {
 			writer.WriteHeader(400)
-			logging.Log.Error("Error a %s %s", request.RequestURI, err)
-			writer.Write([]byte(string(fmt.Sprint(err))))
-			return
-		}
-		source := *s3Req.CopySource
-		if strings.Index(source, "/") == 0 {
-			source = source[1:]
-		}
-		sourcePieces := strings.SplitN(source, "/", 2)
-		sourceBucket := sourcePieces[0]
-		sourceKey := sourcePieces[1]
-		bucket := *s3Req.Bucket
-		bucketHandle := handler.GCPClientToBucket(bucket, client)
-		sourceHandle := handler.GCPClientToBucket(sourceBucket, client).Object(sourceKey)
-		logging.Log.Debugf("Copying %s %s to %s %s", sourceBucket, sourceKey, bucket, *s3Req.Key)
-		uploader := handler.GCPBucketToObject(*s3Req.Key, bucketHandle).CopierFrom(sourceHandle)
-		attrs, err := uploader.Run(*handler.Context)
-		if err != nil {
-			writer.WriteHeader(404)
 			logging.Log.Error("Error %s %s", request.RequestURI, err)
-			return
+			writer.Write([]byte(string(fmt.Sprint(err))))
+			return false
 		}
-		copyResult = CopyResponse{
-			Kind:                "storage#rewriteResponse",
-			TotalBytesRewritten: attrs.Size,
-			ObjectSize:          attrs.Size,
-			Done:                true,
-			Resource:            "moo",
+		if readErr != nil {
+			// keep what arrived, the client can ask how much that was and resend the rest
+			logging.Log.Error("Error %s %s", request.RequestURI, readErr)
+			writeResumeIncomplete(writer, offset)
+			return false
 		}
 	}
-	jsonResult, err := json.Marshal(copyResult)
+	if byteRange.Total >= 0 && offset > byteRange.Total {
+		handler.cancelSession(uploadId, request)
+		writer.WriteHeader(400)
+		logging.Log.Error("Error %s received more than %d bytes", request.RequestURI, byteRange.Total)
+		return false
+	}
+	// without a Content-Range the request held the whole object
+	if byteRange.Total != offset && request.Header.Get("Content-Range") != "" {
+		writeResumeIncomplete(writer, offset)
+		return false
+	}
+	attrs, err := handler.finishSession(uploadId, session, client, offset)
 	if err != nil {
+		handler.cancelSession(uploadId, request)
 		writer.WriteHeader(400)
 		logging.Log.Error("Error %s %s", request.RequestURI, err)
-		return
+		writer.Write([]byte(string(fmt.Sprint(err))))
+		return false
 	}
-	writer.WriteHeader(200)
-	writer.Write(jsonResult)
+	if attrs.Size == 0 {
+		attrs.Size = offset
+	}
+	session.Attrs = attrs
+	if err := handler.saveSession(uploadId, session); err != nil {
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+	}
+	handler.notify(request, storage.ObjectFinalizeEvent, mux.Vars(request)["bucket"], attrs.Name)
+	return true
 }
 
-func (handler *Handler) CopyParseInput(r *http.Request) (*s3.CopyObjectInput, error) {
+// Session request, upload id and Content-Range of a resumable upload chunk.  No Content-Range means the whole
+// object is in this request
+func (handler *Handler) UploadResumableParseInput(r *http.Request) (*s3manager.UploadInput, string, *contentRange, error) {
 	vars := mux.Vars(r)
-	bucket := vars["bucket"]
-	key := vars["key"]
-	destBucket := vars["destBucket"]
-	destKey := vars["destKey"]
-	source := fmt.Sprintf("%s/%s", bucket, key)
-	return &s3.CopyObjectInput{
-		Bucket:     &destBucket,
-		Key:        &destKey,
-		CopySource: &source,
-	}, nil
+	uploadId := vars["uploadId"]
+	var byteRange *contentRange
+	if header := r.Header.Get("Content-Range"); header != "" {
+		var err error
+		if byteRange, err = parseContentRange(header); err != nil {
+			return nil, "", nil, err
+		}
+	} else {
+		byteRange = &contentRange{First: 0, Last: -1, Total: -1}
+		if r.ContentLength >= 0 {
+			byteRange.Total = r.ContentLength
+			byteRange.Last = r.ContentLength - 1
+		}
+	}
+	session, err := handler.loadSession(uploadId)
+	if err != nil {
+		logging.Log.Errorf("Error opening session %s %v", uploadId, err)
+		return nil, "", nil, err
+	}
+	return session.Input, uploadId, byteRange, nil
+}
+
+// Cancel a resumable upload
+func (handler *Handler) CancelResumableHandle(writer http.ResponseWriter, request *http.Request) {
+	uploadId := mux.Vars(request)["uploadId"]
+	unlock := handler.lockSession(uploadId)
+	defer unlock()
+	if !handler.cancelSession(uploadId, request) {
+		writer.WriteHeader(404)
+		logging.Log.Error("Error %s no upload %s", request.RequestURI, uploadId)
+		return
+	}
+	writer.WriteHeader(statusClientClosedRequest)
 }
 
 func (handler *Handler) DeleteHandle(writer http.ResponseWriter, request *http.Request) {
@@ -562,6 +570,7 @@ func (handler *Handler) DeleteHandle(writer http.ResponseWriter, request *http.R
 			return
 		}
 	}
+	handler.notify(request, storage.ObjectDeleteEvent, mux.Vars(request)["bucket"], *s3Req.Key)
 	writer.WriteHeader(200)
 }
 func (handler *Handler) DeleteParseInput(r *http.Request) (*s3.DeleteObjectInput, error) {
@@ -643,6 +652,7 @@ func (handler *Handler) ComposeHandle(writer http.ResponseWriter, request *http.
 			return
 		}
 	}
+	handler.notify(request, storage.ObjectFinalizeEvent, req.Destination.Bucket, req.Destination.Name)
 	writer.WriteHeader(200)
 }
 func (handler *Handler) ComposeParseInput(r *http.Request) (*storage2.ComposeRequest, error) {
@@ -659,5 +669,5 @@ func (handler *Handler) ComposeParseInput(r *http.Request) (*storage2.ComposeReq
 }
 
 func New(gcsHandler *gcs_handler.Handler) *Handler {
-	return &Handler{gcsHandler, sync.Mutex{}}
+	return &Handler{Handler: gcsHandler, sessionLocks: make(map[string]*sessionLock)}
 }
@@ -0,0 +1,488 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	s3_handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3manager"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// GCS forgets resumable sessions after a week
+	defaultResumableExpiry = 7 * 24 * time.Hour
+	// Status GCS answers a cancelled upload with
+	statusClientClosedRequest = 499
+	// Status asking the client to send the rest of the object
+	statusResumeIncomplete = 308
+	// Suffix of session files in the multipart db directory
+	sessionFileSuffix = ".resumable"
+	// Expired sessions are looked for at most this often
+	sessionSweepInterval = time.Minute
+)
+
+// Content-Range of a resumable upload request
+type contentRange struct {
+	// first and last byte sent, -1 when the request only asks how much has arrived
+	First int64
+	Last  int64
+	// object size, -1 while the client doesn't know it yet
+	Total int64
+}
+
+func (r *contentRange) hasData() bool {
+	return r.First >= 0
+}
+
+// Parse bytes a-b/total, bytes a-b/*, bytes */total and bytes */*
+func parseContentRange(header string) (*contentRange, error) {
+	if !strings.HasPrefix(header, "bytes ") {
+		return nil, errors.New("invalid Content-Range " + header)
+	}
+	pieces := strings.SplitN(strings.TrimPrefix(header, "bytes "), "/", 2)
+	if len(pieces) != 2 {
+		return nil, errors.New("invalid Content-Range " + header)
+	}
+	result := &contentRange{First: -1, Last: -1, Total: -1}
+	if pieces[1] != "*" {
+		total, err := strconv.ParseInt(pieces[1], 10, 64)
+		if err != nil || total < 0 {
+			return nil, errors.New("invalid Content-Range " + header)
+		}
+		result.Total = total
+	}
+	if pieces[0] == "*" {
+		return result, nil
+	}
+	bounds := strings.SplitN(pieces[0], "-", 2)
+	if len(bounds) != 2 {
+		return nil, errors.New("invalid Content-Range " + header)
+	}
+	first, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid Content-Range " + header)
+	}
+	last, err := strconv.ParseInt(bounds[1], 10, 64)
+	if err != nil || first < 0 || last < first || (result.Total >= 0 && last >= result.Total) {
+		return nil, errors.New("invalid Content-Range " + header)
+	}
+	result.First = first
+	result.Last = last
+	return result, nil
+}
+
+// A resumable upload as kept in its session file.  Every chunk request loads the session, hands the chunk to the
+// destination and saves the session again, so no client is held between chunks and sessions outlive restarts.
+// For S3 the object goes into a multipart upload, with bytes that don't fill a part yet kept in a local file until
+// they do.  For GCS every chunk is composed onto a temporary object that becomes the object once it is complete
+type resumableSession struct {
+	Input *s3manager.UploadInput
+	// S3 multipart upload and the parts written to it so far
+	UploadId  string             `json:",omitempty"`
+	Parts     []s3.CompletedPart `json:",omitempty"`
+	PartsSize int64              `json:",omitempty"`
+	// the finished object, kept until the session expires so clients asking after a lost response still get it
+	Attrs *storage.ObjectAttrs `json:",omitempty"`
+}
+
+// Serializes requests for one session
+type sessionLock struct {
+	sync.Mutex
+	users int
+}
+
+// Answer with how much of the object has arrived
+func writeResumeIncomplete(writer http.ResponseWriter, received int64) {
+	if received > 0 {
+		writer.Header().Set("Range", fmt.Sprintf("bytes=0-%d", received-1))
+	}
+	writer.Header().Set("Content-Length", "0")
+	writer.WriteHeader(statusResumeIncomplete)
+}
+
+// Config section holding upload settings for the destination
+func (handler *Handler) uploadConfig() string {
+	if handler.Config.IsSet("aws_destination_config") {
+		return "aws_destination_config.s3_config"
+	}
+	return "gcp_destination_config.gcs_config"
+}
+
+// Local file holding a resumable session
+func (handler *Handler) sessionPath(uploadId string) string {
+	return fmt.Sprintf("%s/%s%s", handler.Config.GetString(handler.uploadConfig()+".multipart_db_directory"), uploadId, sessionFileSuffix)
+}
+
+// Local file holding the bytes of an S3 part that isn't full yet
+func (handler *Handler) pendingPartPath(uploadId string, partNumber int) string {
+	return fmt.Sprintf("%s.part-%d", handler.sessionPath(uploadId), partNumber)
+}
+
+// GCS object a session's chunks are composed onto
+func (handler *Handler) sessionObjectName(uploadId string) string {
+	name := uploadId + "-resumable"
+	if prefix := handler.Config.GetString("gcp_destination_config.gcs_config.multipart_temp_path_prefix"); prefix != "" {
+		return strings.TrimSuffix(prefix, "/") + "/" + name
+	}
+	return name
+}
+
+func (handler *Handler) resumableExpiry() time.Duration {
+	if expiry := handler.Config.GetDuration(handler.uploadConfig() + ".resumable_session_expiry"); expiry > 0 {
+		return expiry
+	}
+	return defaultResumableExpiry
+}
+
+// Take a session's lock, the returned function releases it
+func (handler *Handler) lockSession(uploadId string) func() {
+	handler.uploadsLock.Lock()
+	lock := handler.sessionLocks[uploadId]
+	if lock == nil {
+		lock = &sessionLock{}
+		handler.sessionLocks[uploadId] = lock
+	}
+	lock.users++
+	handler.uploadsLock.Unlock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		handler.uploadsLock.Lock()
+		if lock.users--; lock.users == 0 {
+			delete(handler.sessionLocks, uploadId)
+		}
+		handler.uploadsLock.Unlock()
+	}
+}
+
+func (handler *Handler) loadSession(uploadId string) (*resumableSession, error) {
+	data, err := ioutil.ReadFile(handler.sessionPath(uploadId))
+	if err != nil {
+		return nil, err
+	}
+	var session resumableSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	if session.Input == nil {
+		return nil, errors.New("invalid session " + uploadId)
+	}
+	return &session, nil
+}
+
+// Write a session file, replacing the old one in one step so a crash never leaves half of it
+func (handler *Handler) saveSession(uploadId string, session *resumableSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	path := handler.sessionPath(uploadId)
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Bytes of a session the destination has
+func (handler *Handler) sessionOffset(uploadId string, session *resumableSession, client s3_handler.GCPClient) (int64, error) {
+	if handler.Config.IsSet("aws_destination_config") {
+		info, err := os.Stat(handler.pendingPartPath(uploadId, len(session.Parts)+1))
+		if os.IsNotExist(err) {
+			return session.PartsSize, nil
+		} else if err != nil {
+			return 0, err
+		}
+		return session.PartsSize + info.Size(), nil
+	}
+	bucketHandle := handler.GCPClientToBucket(*session.Input.Bucket, client)
+	attrs, err := handler.GCPBucketToObject(handler.sessionObjectName(uploadId), bucketHandle).Attrs(*handler.Context)
+	if err == storage.ErrObjectNotExist {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return attrs.Size, nil
+}
+
+// Copy body to writer, telling errors reading the client's body apart from errors writing it on
+func copyChunk(writer io.Writer, body io.Reader) (readErr error, writeErr error) {
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buffer)
+		if n > 0 {
+			if _, writeErr := writer.Write(buffer[:n]); writeErr != nil {
+				return nil, writeErr
+			}
+		}
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return err, nil
+		}
+	}
+}
+
+// Hand a chunk to the destination, skipping the bytes it already has.  Errors reading the chunk are the client's,
+// errors writing it mean the destination failed
+func (handler *Handler) writeChunk(uploadId string, session *resumableSession, client s3_handler.GCPClient, body io.Reader, skip int64, offset int64) (readErr error, writeErr error) {
+	if skip > 0 {
+		if _, err := io.CopyN(ioutil.Discard, body, skip); err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return err, nil
+		}
+	}
+	if handler.Config.IsSet("aws_destination_config") {
+		// whatever reaches the file is kept, the client resends the rest
+		path := handler.pendingPartPath(uploadId, len(session.Parts)+1)
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		readErr, writeErr = copyChunk(f, body)
+		if err := f.Close(); err != nil && writeErr == nil {
+			writeErr = err
+		}
+		if writeErr != nil {
+			return readErr, writeErr
+		}
+		if info, err := os.Stat(path); err != nil {
+			return readErr, err
+		} else if info.Size() >= s3manager.MinUploadPartSize {
+			return readErr, handler.uploadSessionPart(uploadId, session)
+		}
+		return readErr, nil
+	}
+	bucketHandle := handler.GCPClientToBucket(*session.Input.Bucket, client)
+	target := handler.sessionObjectName(uploadId)
+	name := target
+	if offset > 0 {
+		name = target + "-chunk"
+	}
+	chunk := handler.GCPBucketToObject(name, bucketHandle)
+	uploader := handler.GCPObjectToWriter(chunk, *handler.Context)
+	readErr, writeErr = copyChunk(uploader, body)
+	if readErr != nil || writeErr != nil {
+		// nothing of a failed chunk is kept
+		uploader.CloseWithError(errors.New("chunk not received"))
+		return readErr, writeErr
+	}
+	if err := uploader.Close(); err != nil {
+		return nil, err
+	}
+	if name == target {
+		return nil, nil
+	}
+	defer chunk.Delete(*handler.Context)
+	sessionObject := handler.GCPBucketToObject(target, bucketHandle)
+	if _, err := sessionObject.ComposerFrom(bucketHandle.Object(target), bucketHandle.Object(name)).Run(*handler.Context); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// Start the session's S3 multipart upload if it hasn't been
+func (handler *Handler) startSessionUpload(uploadId string, session *resumableSession) error {
+	if session.UploadId != "" {
+		return nil
+	}
+	input := session.Input
+	bucket := handler.BucketRename(*input.Bucket)
+	resp, err := handler.S3Client.CreateMultipartUploadRequest(&s3.CreateMultipartUploadInput{
+		Bucket:      &bucket,
+		Key:         input.Key,
+		ContentType: input.ContentType,
+		Metadata:    input.Metadata,
+	}).Send()
+	if err != nil {
+		return err
+	}
+	session.UploadId = *resp.UploadId
+	return handler.saveSession(uploadId, session)
+}
+
+// Send the pending bytes on to S3 as the next part
+func (handler *Handler) uploadSessionPart(uploadId string, session *resumableSession) error {
+	if err := handler.startSessionUpload(uploadId, session); err != nil {
+		return err
+	}
+	partNumber := int64(len(session.Parts) + 1)
+	path := handler.pendingPartPath(uploadId, int(partNumber))
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	bucket := handler.BucketRename(*session.Input.Bucket)
+	resp, err := handler.S3Client.UploadPartRequest(&s3.UploadPartInput{
+		Bucket:     &bucket,
+		Key:        session.Input.Key,
+		UploadId:   &session.UploadId,
+		PartNumber: &partNumber,
+		Body:       f,
+	}).Send()
+	if err != nil {
+		return err
+	}
+	session.Parts = append(session.Parts, s3.CompletedPart{ETag: resp.ETag, PartNumber: &partNumber})
+	session.PartsSize += info.Size()
+	if err := handler.saveSession(uploadId, session); err != nil {
+		return err
+	}
+	// a crash before this leaves a file for a part the session already has, which is never read
+	os.Remove(path)
+	return nil
+}
+
+// Make the object out of everything a session received
+func (handler *Handler) finishSession(uploadId string, session *resumableSession, client s3_handler.GCPClient, size int64) (*storage.ObjectAttrs, error) {
+	input := session.Input
+	if handler.Config.IsSet("aws_destination_config") {
+		logging.LogUsingAWS()
+		bucket := handler.BucketRename(*input.Bucket)
+		path := handler.pendingPartPath(uploadId, len(session.Parts)+1)
+		if session.UploadId == "" {
+			// small enough to never have needed a part
+			body, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+			if err != nil {
+				return nil, err
+			}
+			defer body.Close()
+			if _, err := handler.S3Client.PutObjectRequest(&s3.PutObjectInput{
+				Bucket:      &bucket,
+				Key:         input.Key,
+				ContentType: input.ContentType,
+				Metadata:    input.Metadata,
+				Body:        body,
+			}).Send(); err != nil {
+				return nil, err
+			}
+		} else {
+			if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+				if err := handler.uploadSessionPart(uploadId, session); err != nil {
+					return nil, err
+				}
+			}
+			if _, err := handler.S3Client.CompleteMultipartUploadRequest(&s3.CompleteMultipartUploadInput{
+				Bucket:          &bucket,
+				Key:             input.Key,
+				UploadId:        &session.UploadId,
+				MultipartUpload: &s3.CompletedMultipartUpload{Parts: session.Parts},
+			}).Send(); err != nil {
+				return nil, err
+			}
+		}
+		os.Remove(path)
+		return &storage.ObjectAttrs{Bucket: *input.Bucket, Name: *input.Key, Size: size}, nil
+	}
+	bucketHandle := handler.GCPClientToBucket(*input.Bucket, client)
+	target := handler.GCPBucketToObject(*input.Key, bucketHandle)
+	if size == 0 {
+		uploader := handler.GCPObjectToWriter(target, *handler.Context)
+		if gcsWriter, ok := uploader.(*storage.Writer); ok {
+			if input.ContentType != nil {
+				gcsWriter.ContentType = *input.ContentType
+			}
+			gcsWriter.Metadata = input.Metadata
+		}
+		if err := uploader.Close(); err != nil {
+			return nil, err
+		}
+		return uploader.Attrs(), nil
+	}
+	sessionObject := handler.sessionObjectName(uploadId)
+	composer := target.ComposerFrom(bucketHandle.Object(sessionObject))
+	if input.ContentType != nil {
+		composer.ContentType = *input.ContentType
+	}
+	composer.Metadata = input.Metadata
+	attrs, err := composer.Run(*handler.Context)
+	if err != nil {
+		return nil, err
+	}
+	if err := handler.GCPBucketToObject(sessionObject, bucketHandle).Delete(*handler.Context); err != nil {
+		logging.Log.Errorf("Could not delete %s %s", sessionObject, err)
+	}
+	return attrs, nil
+}
+
+// Remove a session, abandoning what its destination received.  Returns false if there was no such session
+func (handler *Handler) cancelSession(uploadId string, request *http.Request) bool {
+	session, err := handler.loadSession(uploadId)
+	if os.IsNotExist(err) {
+		return false
+	}
+	if session != nil && session.Attrs == nil {
+		if handler.Config.IsSet("aws_destination_config") {
+			if session.UploadId != "" {
+				bucket := handler.BucketRename(*session.Input.Bucket)
+				if _, err := handler.S3Client.AbortMultipartUploadRequest(&s3.AbortMultipartUploadInput{
+					Bucket:   &bucket,
+					Key:      session.Input.Key,
+					UploadId: &session.UploadId,
+				}).Send(); err != nil {
+					logging.Log.Errorf("Could not abort upload %s %s", uploadId, err)
+				}
+			}
+		} else if client, err := handler.GCPRequestSetup(request); err != nil {
+			logging.Log.Errorf("Could not remove upload %s %s", uploadId, err)
+		} else {
+			bucketHandle := handler.GCPClientToBucket(*session.Input.Bucket, client)
+			for _, name := range []string{handler.sessionObjectName(uploadId), handler.sessionObjectName(uploadId) + "-chunk"} {
+				if err := handler.GCPBucketToObject(name, bucketHandle).Delete(*handler.Context); err != nil && err != storage.ErrObjectNotExist {
+					logging.Log.Errorf("Could not delete %s %s", name, err)
+				}
+			}
+			handler.ReturnConnection(client, request)
+		}
+	}
+	pending, _ := filepath.Glob(handler.sessionPath(uploadId) + ".part-*")
+	for _, path := range pending {
+		os.Remove(path)
+	}
+	os.Remove(handler.sessionPath(uploadId))
+	return true
+}
+
+// Cancel sessions nobody has used for longer than sessions live.  Session files are touched by every request, and
+// finished ones are kept until then so clients asking after a lost response still get the object
+func (handler *Handler) expireSessions(request *http.Request) {
+	handler.uploadsLock.Lock()
+	if time.Since(handler.lastSessionSweep) < sessionSweepInterval {
+		handler.uploadsLock.Unlock()
+		return
+	}
+	handler.lastSessionSweep = time.Now()
+	handler.uploadsLock.Unlock()
+	expiry := handler.resumableExpiry()
+	paths, _ := filepath.Glob(handler.sessionPath("*"))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || time.Since(info.ModTime()) <= expiry {
+			continue
+		}
+		uploadId := strings.TrimSuffix(filepath.Base(path), sessionFileSuffix)
+		logging.Log.Infof("Expiring resumable upload %s", uploadId)
+		unlock := handler.lockSession(uploadId)
+		handler.cancelSession(uploadId, request)
+		unlock()
+	}
+}
@@ -0,0 +1,157 @@
+package object
+
+import (
+	gcs_handler "cloudsidecar/pkg/gcp/handler/gcs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   *contentRange
+	}{
+		{"bytes 0-99/1000", &contentRange{First: 0, Last: 99, Total: 1000}},
+		{"bytes 100-199/*", &contentRange{First: 100, Last: 199, Total: -1}},
+		{"bytes */1000", &contentRange{First: -1, Last: -1, Total: 1000}},
+		{"bytes */*", &contentRange{First: -1, Last: -1, Total: -1}},
+		{"bytes 0-0/1", &contentRange{First: 0, Last: 0, Total: 1}},
+		{"0-99/1000", nil},
+		{"bytes 0-99", nil},
+		{"bytes 99-0/1000", nil},
+		{"bytes 0-1000/1000", nil},
+		{"bytes a-b/*", nil},
+		{"bytes 0-99/-1", nil},
+		{"bytes -1-99/*", nil},
+	}
+	for _, test := range tests {
+		result, err := parseContentRange(test.header)
+		if test.want == nil {
+			assert.NotNil(t, err, test.header)
+			continue
+		}
+		assert.Nil(t, err, test.header)
+		assert.Equal(t, test.want, result, test.header)
+	}
+}
+
+// Handler sending resumable uploads to an S3 served by server, with sessions kept in directory
+func resumableHandler(directory string, server *httptest.Server) *Handler {
+	config := viper.New()
+	config.Set("aws_destination_config.s3_config.multipart_db_directory", directory)
+	gcsHandler := gcs_handler.NewHandler(config)
+	if server != nil {
+		awsConfig := defaults.Config()
+		awsConfig.Region = "us-east-1"
+		awsConfig.Credentials = aws.NewStaticCredentialsProvider("MY_KEY", "SUPER_SECRET", "")
+		awsConfig.EndpointResolver = aws.ResolveWithEndpointURL(server.URL)
+		awsConfig.HTTPClient = server.Client()
+		client := s3.New(awsConfig)
+		client.ForcePathStyle = true
+		gcsHandler.S3Client = client
+	}
+	return New(&gcsHandler)
+}
+
+func resumableRequest(method string, uploadId string, contentRange string, body string) *http.Request {
+	request := httptest.NewRequest(method, "http://localhost/upload/storage/v1/b/bucket/o?uploadType=resumable&upload_id="+uploadId, strings.NewReader(body))
+	if contentRange != "" {
+		request.Header.Set("Content-Range", contentRange)
+	}
+	return mux.SetURLVars(request, map[string]string{"bucket": "bucket", "uploadId": uploadId})
+}
+
+// Start a session and return its upload id
+func startResumable(t *testing.T, handler *Handler) string {
+	request := httptest.NewRequest("POST", "http://localhost/upload/storage/v1/b/bucket/o?uploadType=resumable", strings.NewReader(`{"name":"obj","contentType":"text/plain"}`))
+	request = mux.SetURLVars(request, map[string]string{"bucket": "bucket"})
+	writer := httptest.NewRecorder()
+	handler.ResumableHandle(writer, request)
+	assert.Equal(t, 200, writer.Code)
+	location, err := url.Parse(writer.Header().Get("location"))
+	assert.Nil(t, err)
+	return location.Query().Get("upload_id")
+}
+
+func sendResumable(handler *Handler, method string, uploadId string, contentRange string, body string) *httptest.ResponseRecorder {
+	writer := httptest.NewRecorder()
+	request := resumableRequest(method, uploadId, contentRange, body)
+	if method == "DELETE" {
+		handler.CancelResumableHandle(writer, request)
+	} else {
+		handler.UploadResumableHandle(writer, request)
+	}
+	return writer
+}
+
+func TestHandler_UploadResumableHandle(t *testing.T) {
+	directory, err := ioutil.TempDir("", "resumable")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+	var uploaded string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "PUT", request.Method)
+		assert.Equal(t, "/bucket/obj", request.URL.Path)
+		assert.Equal(t, "text/plain", request.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(request.Body)
+		uploaded = string(body)
+	}))
+	defer server.Close()
+	handler := resumableHandler(directory, server)
+	uploadId := startResumable(t, handler)
+
+	writer := sendResumable(handler, "PUT", uploadId, "bytes 0-3/*", "abcd")
+	assert.Equal(t, statusResumeIncomplete, writer.Code)
+	assert.Equal(t, "bytes=0-3", writer.Header().Get("Range"))
+
+	// a chunk past what arrived gets the range to resend from
+	writer = sendResumable(handler, "PUT", uploadId, "bytes 8-9/*", "ij")
+	assert.Equal(t, statusResumeIncomplete, writer.Code)
+	assert.Equal(t, "bytes=0-3", writer.Header().Get("Range"))
+
+	// the session lives in its file, so a restarted sidecar picks up where it was
+	handler = resumableHandler(directory, server)
+	writer = sendResumable(handler, "PUT", uploadId, "bytes */*", "")
+	assert.Equal(t, statusResumeIncomplete, writer.Code)
+	assert.Equal(t, "bytes=0-3", writer.Header().Get("Range"))
+
+	// resent bytes are skipped
+	writer = sendResumable(handler, "PUT", uploadId, "bytes 2-5/6", "cdef")
+	assert.Equal(t, 200, writer.Code)
+	assert.Equal(t, "abcdef", uploaded)
+	assert.Contains(t, writer.Body.String(), `"Name":"obj"`)
+
+	// a client that lost the response can still ask
+	writer = sendResumable(handler, "PUT", uploadId, "bytes */6", "")
+	assert.Equal(t, 200, writer.Code)
+}
+
+func TestHandler_CancelResumableHandle(t *testing.T) {
+	directory, err := ioutil.TempDir("", "resumable")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+	handler := resumableHandler(directory, nil)
+	uploadId := startResumable(t, handler)
+	writer := sendResumable(handler, "PUT", uploadId, "bytes 0-3/*", "abcd")
+	assert.Equal(t, statusResumeIncomplete, writer.Code)
+
+	writer = sendResumable(handler, "DELETE", uploadId, "", "")
+	assert.Equal(t, statusClientClosedRequest, writer.Code)
+	files, _ := ioutil.ReadDir(directory)
+	assert.Empty(t, files)
+	writer = sendResumable(handler, "PUT", uploadId, "bytes */*", "")
+	assert.Equal(t, 404, writer.Code)
+	writer = sendResumable(handler, "DELETE", uploadId, "", "")
+	assert.Equal(t, 404, writer.Code)
+}
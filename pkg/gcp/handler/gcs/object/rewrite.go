@@ -0,0 +1,353 @@
+package object
+
+import (
+	"cloud.google.com/go/storage"
+	s3handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
+	gcs_handler "cloudsidecar/pkg/gcp/handler/gcs"
+	"cloudsidecar/pkg/logging"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Bytes copied per rewrite call when the client doesn't say, small enough to finish inside client timeouts
+	defaultRewriteBytesPerCall = 512 * 1024 * 1024
+	// Largest object S3 copies in a single request
+	maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+	// Key rewrite tokens into S3 are signed with.  Replicas need the same key to continue each other's rewrites
+	rewriteTokenKeyKey = "aws_destination_config.s3_config.rewrite_token_key"
+)
+
+// Signing key when none is configured, so tokens only work on the replica that made them
+var processRewriteTokenKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+func (handler *Handler) rewriteTokenKey() []byte {
+	if key := handler.Config.GetString(rewriteTokenKeyKey); key != "" {
+		return []byte(key)
+	}
+	return processRewriteTokenKey
+}
+
+// A rewrite or copy of one object onto another
+type RewriteRequest struct {
+	SourceBucket string
+	SourceKey    string
+	Bucket       string
+	Key          string
+	// token from the previous call of a rewrite, empty on the first
+	RewriteToken string
+	// most bytes one call copies, 0 for the default
+	MaxBytesPerCall int64
+	// copyTo runs to the end in one call and answers with the object
+	Copy bool
+}
+
+func (input *RewriteRequest) bytesPerCall() int64 {
+	if input.Copy {
+		return math.MaxInt64
+	}
+	if input.MaxBytesPerCall > 0 {
+		return input.MaxBytesPerCall
+	}
+	return defaultRewriteBytesPerCall
+}
+
+// Copy an object a call's worth at a time.  Each response carries a token for the next call until the copy is done
+func (handler *Handler) CopyHandle(writer http.ResponseWriter, request *http.Request) {
+	input, err := handler.CopyParseInput(request)
+	if err != nil {
+		writer.WriteHeader(400)
+		logging.Log.Error("Error at %s %s", request.RequestURI, err)
+		writer.Write([]byte(string(fmt.Sprint(err))))
+		return
+	}
+	var result *CopyResponse
+	if handler.Config.IsSet("aws_destination_config") {
+		logging.LogUsingAWS()
+		result, err = handler.awsRewrite(input)
+	} else if handler.Config.IsSet("gcp_destination_config") {
+		// Use GCS
+		// Log that we are using GCP, get a client based on configurations.  This is from a pool
+		client, setupErr := handler.GCPRequestSetup(request)
+		if client != nil {
+			// return connection to pool after done
+			defer handler.ReturnConnection(client, request)
+		}
+		if setupErr != nil {
+			writer.WriteHeader(400)
+			logging.Log.Error("Error a %s %s", request.RequestURI, setupErr)
+			writer.Write([]byte(string(fmt.Sprint(setupErr))))
+			return
+		}
+		result, err = handler.gcsRewrite(input, client)
+	}
+	if err != nil {
+		writer.WriteHeader(rewriteErrorStatus(err))
+		logging.Log.Error("Error %s %s", request.RequestURI, err)
+		writer.Write([]byte(string(fmt.Sprint(err))))
+		return
+	}
+	if result != nil && result.Done {
+		handler.notify(request, storage.ObjectFinalizeEvent, input.Bucket, input.Key)
+	}
+	if input.Copy {
+		gcs_handler.WriteJSON(writer, request, result.Resource)
+		return
+	}
+	gcs_handler.WriteJSON(writer, request, result)
+}
+
+// Missing sources are 404s like GCS answers, anything else a 400
+func rewriteErrorStatus(err error) int {
+	if err == storage.ErrObjectNotExist || err == storage.ErrBucketNotExist {
+		return 404
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case "NotFound", s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchBucket, s3.ErrCodeNoSuchUpload:
+			return 404
+		case "PreconditionFailed":
+			return 412
+		}
+	}
+	return 400
+}
+
+// One call of a rewrite into S3.  Objects that fit in the call are copied whole, larger ones are copied a part at
+// a time into a multipart upload, which is aborted if a call fails so its parts aren't left behind
+func (handler *Handler) awsRewrite(input *RewriteRequest) (*CopyResponse, error) {
+	sourceBucket := handler.BucketRename(input.SourceBucket)
+	bucket := handler.BucketRename(input.Bucket)
+	source := fmt.Sprintf("%s/%s", sourceBucket, input.SourceKey)
+	destination := fmt.Sprintf("%s/%s", bucket, input.Key)
+	budget := input.bytesPerCall()
+	var token *converter.S3RewriteToken
+	if input.RewriteToken == "" {
+		head, err := handler.S3Client.HeadObjectRequest(&s3.HeadObjectInput{
+			Bucket: &sourceBucket,
+			Key:    &input.SourceKey,
+		}).Send()
+		if err != nil {
+			return nil, err
+		}
+		size := *head.ContentLength
+		if size <= budget && size <= maxCopyObjectSize {
+			_, err := handler.S3Client.CopyObjectRequest(&s3.CopyObjectInput{
+				Bucket:     &bucket,
+				Key:        &input.Key,
+				CopySource: &source,
+			}).Send()
+			if err != nil {
+				return nil, err
+			}
+			return handler.awsRewriteDone(input, bucket)
+		}
+		upload, err := handler.S3Client.CreateMultipartUploadRequest(&s3.CreateMultipartUploadInput{
+			Bucket:             &bucket,
+			Key:                &input.Key,
+			ContentType:        head.ContentType,
+			ContentEncoding:    head.ContentEncoding,
+			ContentDisposition: head.ContentDisposition,
+			ContentLanguage:    head.ContentLanguage,
+			CacheControl:       head.CacheControl,
+			Metadata:           head.Metadata,
+			StorageClass:       head.StorageClass,
+		}).Send()
+		if err != nil {
+			return nil, err
+		}
+		token = &converter.S3RewriteToken{
+			UploadId:    *upload.UploadId,
+			Source:      source,
+			Destination: destination,
+			SourceETag:  *head.ETag,
+			Size:        size,
+			PartSize:    converter.RewritePartSize(size, budget),
+		}
+	} else {
+		var err error
+		if token, err = converter.DecodeS3RewriteToken(input.RewriteToken, handler.rewriteTokenKey()); err != nil {
+			return nil, err
+		}
+		if token.Source != source || token.Destination != destination {
+			return nil, errors.New("rewrite token is for a different copy")
+		}
+	}
+	result, err := handler.awsRewriteParts(input, bucket, source, budget, token)
+	if err != nil {
+		_, abortErr := handler.S3Client.AbortMultipartUploadRequest(&s3.AbortMultipartUploadInput{
+			Bucket:   &bucket,
+			Key:      &input.Key,
+			UploadId: &token.UploadId,
+		}).Send()
+		if abortErr != nil {
+			logging.Log.Errorf("Could not abort rewrite upload %s %s", token.UploadId, abortErr)
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// Copy a call's worth of parts of a rewrite into S3, completing the upload once they're all there
+func (handler *Handler) awsRewriteParts(input *RewriteRequest, bucket string, source string, budget int64, token *converter.S3RewriteToken) (*CopyResponse, error) {
+	// whole parts until the call's bytes are used up, always at least one
+	for copied := int64(0); token.Offset < token.Size && copied < budget; {
+		last := token.Offset + token.PartSize - 1
+		if last >= token.Size {
+			last = token.Size - 1
+		}
+		copyRange := fmt.Sprintf("bytes=%d-%d", token.Offset, last)
+		partNumber := token.PartNumber()
+		_, err := handler.S3Client.UploadPartCopyRequest(&s3.UploadPartCopyInput{
+			Bucket:            &bucket,
+			Key:               &input.Key,
+			CopySource:        &source,
+			CopySourceRange:   &copyRange,
+			CopySourceIfMatch: &token.SourceETag,
+			PartNumber:        &partNumber,
+			UploadId:          &token.UploadId,
+		}).Send()
+		if err != nil {
+			return nil, err
+		}
+		copied += last + 1 - token.Offset
+		token.Offset = last + 1
+	}
+	if token.Offset < token.Size {
+		return &CopyResponse{
+			Kind:                "storage#rewriteResponse",
+			TotalBytesRewritten: token.Offset,
+			ObjectSize:          token.Size,
+			RewriteToken:        token.Encode(handler.rewriteTokenKey()),
+		}, nil
+	}
+	// parts come from S3 rather than the token, so the token stays small however many there are
+	parts := make([]s3.CompletedPart, 0)
+	var marker *int64
+	for {
+		listed, err := handler.S3Client.ListPartsRequest(&s3.ListPartsInput{
+			Bucket:           &bucket,
+			Key:              &input.Key,
+			UploadId:         &token.UploadId,
+			PartNumberMarker: marker,
+		}).Send()
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range listed.Parts {
+			parts = append(parts, s3.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber})
+		}
+		if listed.IsTruncated == nil || !*listed.IsTruncated {
+			break
+		}
+		marker = listed.NextPartNumberMarker
+	}
+	_, err := handler.S3Client.CompleteMultipartUploadRequest(&s3.CompleteMultipartUploadInput{
+		Bucket:          &bucket,
+		Key:             &input.Key,
+		UploadId:        &token.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	}).Send()
+	if err != nil {
+		return nil, err
+	}
+	return handler.awsRewriteDone(input, bucket)
+}
+
+// Response for a finished rewrite into S3
+func (handler *Handler) awsRewriteDone(input *RewriteRequest, bucket string) (*CopyResponse, error) {
+	head, err := handler.S3Client.HeadObjectRequest(&s3.HeadObjectInput{
+		Bucket: &bucket,
+		Key:    &input.Key,
+	}).Send()
+	if err != nil {
+		return nil, err
+	}
+	return &CopyResponse{
+		Kind:                "storage#rewriteResponse",
+		TotalBytesRewritten: *head.ContentLength,
+		ObjectSize:          *head.ContentLength,
+		Done:                true,
+		Resource:            converter.AWSHeadToGCSObject(input.Bucket, input.Key, head),
+	}, nil
+}
+
+// One call of a native GCS rewrite.  GCS decides how much each call copies, so maxBytesRewrittenPerCall isn't
+// passed on
+func (handler *Handler) gcsRewrite(input *RewriteRequest, client s3handler.GCPClient) (*CopyResponse, error) {
+	bucketHandle := handler.GCPClientToBucket(input.Bucket, client)
+	sourceHandle := handler.GCPClientToBucket(input.SourceBucket, client).Object(input.SourceKey)
+	logging.Log.Debugf("Copying %s %s to %s %s", input.SourceBucket, input.SourceKey, input.Bucket, input.Key)
+	copier := handler.GCPBucketToObject(input.Key, bucketHandle).CopierFrom(sourceHandle)
+	copier.RewriteToken = input.RewriteToken
+	ctx, cancel := context.WithCancel(*handler.Context)
+	defer cancel()
+	var copied, total uint64
+	stopped := false
+	if !input.Copy {
+		// Run keeps calling until the copy is done, so stop it after the first call that leaves some to copy
+		copier.ProgressFunc = func(copiedBytes uint64, totalBytes uint64) {
+			copied, total = copiedBytes, totalBytes
+			if copiedBytes < totalBytes {
+				stopped = true
+				cancel()
+			}
+		}
+	}
+	attrs, err := copier.Run(ctx)
+	if err != nil {
+		if stopped && copier.RewriteToken != "" {
+			return &CopyResponse{
+				Kind:                "storage#rewriteResponse",
+				TotalBytesRewritten: int64(copied),
+				ObjectSize:          int64(total),
+				RewriteToken:        copier.RewriteToken,
+			}, nil
+		}
+		return nil, err
+	}
+	return &CopyResponse{
+		Kind:                "storage#rewriteResponse",
+		TotalBytesRewritten: attrs.Size,
+		ObjectSize:          attrs.Size,
+		Done:                true,
+		Resource:            converter.GCSAttrsToObject(attrs),
+	}, nil
+}
+
+func (handler *Handler) CopyParseInput(r *http.Request) (*RewriteRequest, error) {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	input := &RewriteRequest{
+		SourceBucket: vars["bucket"],
+		SourceKey:    vars["key"],
+		Bucket:       vars["destBucket"],
+		Key:          vars["destKey"],
+		RewriteToken: query.Get("rewriteToken"),
+		Copy:         strings.Contains(r.URL.Path, "/copyTo/"),
+	}
+	if maxBytes := query.Get("maxBytesRewrittenPerCall"); maxBytes != "" && !input.Copy {
+		maxBytesInt, err := strconv.ParseInt(maxBytes, 10, 64)
+		if err != nil || maxBytesInt <= 0 || maxBytesInt%converter.RewriteChunk != 0 {
+			return nil, fmt.Errorf("maxBytesRewrittenPerCall must be a positive multiple of %d", converter.RewriteChunk)
+		}
+		input.MaxBytesPerCall = maxBytesInt
+	}
+	return input, nil
+}
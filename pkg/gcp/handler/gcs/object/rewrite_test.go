@@ -0,0 +1,67 @@
+package object
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func rewriteRequest(rewriteToken string) *http.Request {
+	request := httptest.NewRequest("POST", "http://localhost/storage/v1/b/src/o/big/rewriteTo/b/dst/o/copy?maxBytesRewrittenPerCall=5242880&rewriteToken="+rewriteToken, nil)
+	return mux.SetURLVars(request, map[string]string{"bucket": "src", "key": "big", "destBucket": "dst", "destKey": "copy"})
+}
+
+func TestHandler_CopyHandleAWSRewrite(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		calls = append(calls, request.Method+" "+request.URL.Path+" "+query.Get("partNumber"))
+		switch {
+		case request.Method == "HEAD":
+			writer.Header().Set("Content-Length", "12582912")
+			writer.Header().Set("ETag", `"source"`)
+		case request.Method == "POST":
+			writer.Write([]byte(`<InitiateMultipartUploadResult><Bucket>dst</Bucket><Key>copy</Key><UploadId>upload</UploadId></InitiateMultipartUploadResult>`))
+		case request.Method == "PUT" && query.Get("partNumber") == "1":
+			assert.Equal(t, "bytes=0-5242879", request.Header.Get("x-amz-copy-source-range"))
+			writer.Write([]byte(`<CopyPartResult><ETag>"part"</ETag></CopyPartResult>`))
+		case request.Method == "PUT":
+			// the source changed between calls
+			writer.WriteHeader(http.StatusPreconditionFailed)
+			writer.Write([]byte(`<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`))
+		case request.Method == "DELETE":
+			assert.Equal(t, "upload", query.Get("uploadId"))
+			writer.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	handler := resumableHandler("", server)
+
+	writer := httptest.NewRecorder()
+	handler.CopyHandle(writer, rewriteRequest(""))
+	assert.Equal(t, 200, writer.Code)
+	var response CopyResponse
+	assert.Nil(t, json.Unmarshal(writer.Body.Bytes(), &response))
+	assert.False(t, response.Done)
+	assert.Equal(t, int64(5242880), response.TotalBytesRewritten)
+	assert.Equal(t, []string{"HEAD /src/big ", "POST /dst/copy ", "PUT /dst/copy 1"}, calls)
+
+	// tokens changed by the client are refused before S3 is asked anything
+	calls = nil
+	assert.True(t, strings.HasPrefix(response.RewriteToken, "e"))
+	forged := "f" + response.RewriteToken[1:]
+	writer = httptest.NewRecorder()
+	handler.CopyHandle(writer, rewriteRequest(forged))
+	assert.Equal(t, 400, writer.Code)
+	assert.Empty(t, calls)
+
+	// a failed call aborts the upload so its parts don't linger
+	writer = httptest.NewRecorder()
+	handler.CopyHandle(writer, rewriteRequest(response.RewriteToken))
+	assert.Equal(t, 412, writer.Code)
+	assert.Equal(t, []string{"PUT /dst/copy 2", "DELETE /dst/copy "}, calls)
+}
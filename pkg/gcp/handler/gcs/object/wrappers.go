@@ -1,15 +1,19 @@
 package object
 
 import (
+	storage2 "google.golang.org/api/storage/v1"
 	"io"
 )
 
 type CopyResponse struct {
 	Kind                string `json:"kind"`
-	TotalBytesRewritten int64  `json:"totalBytesRewritten"`
-	ObjectSize          int64  `json:"objectSize"`
+	TotalBytesRewritten int64  `json:"totalBytesRewritten,string"`
+	ObjectSize          int64  `json:"objectSize,string"`
 	Done                bool   `json:"done"`
-	Resource            string `json:"resource"`
+	// set until the copy is done, to pass back for the next call
+	RewriteToken string `json:"rewriteToken,omitempty"`
+	// the new object once the copy is done
+	Resource *storage2.Object `json:"resource,omitempty"`
 }
 
 type MultiObjectReader struct {
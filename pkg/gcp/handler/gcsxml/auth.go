@@ -0,0 +1,298 @@
+package gcsxml
+
+import (
+	"cloudsidecar/pkg/response_type"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config key mapping HMAC access ids to their secrets
+var inboundCredentialsKey = "inbound_credentials"
+
+const (
+	timestampFormat = "20060102T150405Z"
+	// how far a signed request's time may be from ours
+	maxClockSkew = 15 * time.Minute
+	// longest a signed url may be valid for
+	maxExpires = 7 * 24 * 60 * 60
+)
+
+// The GCS and AWS flavors of V4 signing differ only in names
+type signingScheme struct {
+	algorithm    string
+	keyPrefix    string
+	terminator   string
+	headerPrefix string
+	queryPrefix  string
+}
+
+var (
+	goog4 = signingScheme{"GOOG4-HMAC-SHA256", "GOOG4", "goog4_request", "x-goog-", "X-Goog-"}
+	aws4  = signingScheme{"AWS4-HMAC-SHA256", "AWS4", "aws4_request", "x-amz-", "X-Amz-"}
+)
+
+// An authentication failure and the S3 error it is reported as
+type authError struct {
+	code    response_type.S3ErrorCode
+	message string
+}
+
+func (err *authError) Error() string {
+	return err.message
+}
+
+func malformed(message string) *authError {
+	return &authError{response_type.S3AccessDenied, message}
+}
+
+// Signature fields from an Authorization header or a signed url
+type signature struct {
+	scheme        signingScheme
+	accessId      string
+	scope         string
+	signedHeaders []string
+	signature     string
+	timestamp     string
+	// seconds a signed url is valid for, 0 for header signatures
+	expires int
+	presign bool
+}
+
+// Find the signature of a request, from its Authorization header or its query
+func parseSignature(request *http.Request) (*signature, error) {
+	authorization := request.Header.Get("Authorization")
+	for _, scheme := range []signingScheme{goog4, aws4} {
+		if strings.HasPrefix(authorization, scheme.algorithm+" ") {
+			return parseAuthorization(request, scheme, strings.TrimPrefix(authorization, scheme.algorithm+" "))
+		}
+		if request.URL.Query().Get(scheme.queryPrefix+"Algorithm") == scheme.algorithm {
+			return parseSignedUrl(request, scheme)
+		}
+	}
+	if authorization == "" {
+		return nil, malformed("Anonymous requests are not allowed")
+	}
+	return nil, malformed("Only GOOG4-HMAC-SHA256 and AWS4-HMAC-SHA256 signatures are supported")
+}
+
+func parseAuthorization(request *http.Request, scheme signingScheme, authorization string) (*signature, error) {
+	result := &signature{scheme: scheme, timestamp: request.Header.Get(scheme.headerPrefix + "date")}
+	var credential, signedHeaders string
+	for _, field := range strings.Split(authorization, ",") {
+		field = strings.TrimSpace(field)
+		if strings.HasPrefix(field, "Credential=") {
+			credential = strings.TrimPrefix(field, "Credential=")
+		} else if strings.HasPrefix(field, "SignedHeaders=") {
+			signedHeaders = strings.TrimPrefix(field, "SignedHeaders=")
+		} else if strings.HasPrefix(field, "Signature=") {
+			result.signature = strings.TrimPrefix(field, "Signature=")
+		}
+	}
+	return result, result.setCredential(credential, signedHeaders)
+}
+
+func parseSignedUrl(request *http.Request, scheme signingScheme) (*signature, error) {
+	query := request.URL.Query()
+	result := &signature{
+		scheme:    scheme,
+		signature: query.Get(scheme.queryPrefix + "Signature"),
+		timestamp: query.Get(scheme.queryPrefix + "Date"),
+		presign:   true,
+	}
+	expires, err := strconv.Atoi(query.Get(scheme.queryPrefix + "Expires"))
+	if err != nil || expires <= 0 || expires > maxExpires {
+		return nil, malformed("Invalid " + scheme.queryPrefix + "Expires")
+	}
+	result.expires = expires
+	return result, result.setCredential(query.Get(scheme.queryPrefix+"Credential"), query.Get(scheme.queryPrefix+"SignedHeaders"))
+}
+
+// Split access id/date/region/service/terminator
+func (sig *signature) setCredential(credential string, signedHeaders string) error {
+	pieces := strings.SplitN(credential, "/", 2)
+	if len(pieces) != 2 || sig.signature == "" || signedHeaders == "" {
+		return malformed("Authorization is malformed")
+	}
+	sig.accessId = pieces[0]
+	sig.scope = pieces[1]
+	scope := strings.Split(sig.scope, "/")
+	if len(scope) != 4 || scope[3] != sig.scheme.terminator {
+		return malformed("Credential scope is malformed")
+	}
+	if !strings.HasPrefix(sig.timestamp, scope[0]) {
+		return malformed("Credential date does not match the request date")
+	}
+	sig.signedHeaders = strings.Split(strings.ToLower(signedHeaders), ";")
+	for _, name := range sig.signedHeaders {
+		if name == "host" {
+			return nil
+		}
+	}
+	return malformed("SignedHeaders must include host")
+}
+
+// Check a request was signed with one of the credentials, which map access ids to secrets
+func Verify(request *http.Request, credentials map[string]string, now time.Time) error {
+	sig, err := parseSignature(request)
+	if err != nil {
+		return err
+	}
+	secret, ok := credentials[strings.ToLower(sig.accessId)]
+	if !ok {
+		return &authError{response_type.S3InvalidAccessKeyId, response_type.S3InvalidAccessKeyId.Message}
+	}
+	signedAt, err := time.Parse(timestampFormat, sig.timestamp)
+	if err != nil {
+		return malformed("Invalid request date " + sig.timestamp)
+	}
+	if sig.presign {
+		if now.Before(signedAt.Add(-maxClockSkew)) || now.After(signedAt.Add(time.Duration(sig.expires)*time.Second)) {
+			return &authError{response_type.S3AccessDenied, "Request has expired"}
+		}
+	} else if now.Sub(signedAt) > maxClockSkew || signedAt.Sub(now) > maxClockSkew {
+		return &authError{response_type.S3RequestTimeTooSkewed, response_type.S3RequestTimeTooSkewed.Message}
+	}
+	expected := sig.sign(secret, canonicalRequest(request, sig))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(sig.signature))) {
+		return &authError{response_type.S3SignatureDoesNotMatch, response_type.S3SignatureDoesNotMatch.Message}
+	}
+	return sig.checkBody(request)
+}
+
+// Make the body of a request signed with its hash fail at its end when it doesn't match.  Signed urls, unsigned
+// payloads and streaming uploads, whose chunks are signed, have no hash to check
+func (sig *signature) checkBody(request *http.Request) error {
+	if sig.presign {
+		return nil
+	}
+	payloadHash := request.Header.Get(sig.scheme.headerPrefix + "content-sha256")
+	if payloadHash == "" || payloadHash == "UNSIGNED-PAYLOAD" || strings.HasPrefix(payloadHash, "STREAMING-") {
+		return nil
+	}
+	if decoded, err := hex.DecodeString(payloadHash); err != nil || len(decoded) != sha256.Size {
+		return &authError{response_type.S3InvalidArgument, "Invalid " + sig.scheme.headerPrefix + "content-sha256 " + payloadHash}
+	}
+	body := request.Body
+	if body == nil {
+		body = http.NoBody
+	}
+	request.Body = &contentHashReader{body: body, hash: sha256.New(), expected: strings.ToLower(payloadHash)}
+	return nil
+}
+
+// Request body that fails at its end when it doesn't hash to what the client signed
+type contentHashReader struct {
+	body     io.ReadCloser
+	hash     hash.Hash
+	expected string
+	mismatch bool
+}
+
+var errContentSHA256Mismatch = &authError{response_type.S3ContentSHA256Mismatch, response_type.S3ContentSHA256Mismatch.Message}
+
+func (reader *contentHashReader) Read(p []byte) (int, error) {
+	n, err := reader.body.Read(p)
+	reader.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(reader.hash.Sum(nil)) != reader.expected {
+		reader.mismatch = true
+		return n, errContentSHA256Mismatch
+	}
+	return n, err
+}
+
+func (reader *contentHashReader) Close() error {
+	return reader.body.Close()
+}
+
+func (sig *signature) sign(secret string, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := strings.Join([]string{sig.scheme.algorithm, sig.timestamp, sig.scope, hex.EncodeToString(hash[:])}, "\n")
+	key := []byte(sig.scheme.keyPrefix + secret)
+	for _, part := range strings.Split(sig.scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Method, path, query, signed headers and payload hash in the form both V4 schemes sign
+func canonicalRequest(request *http.Request, sig *signature) string {
+	headers := make([]string, len(sig.signedHeaders))
+	for i, name := range sig.signedHeaders {
+		var value string
+		if name == "host" {
+			value = request.Host
+		} else {
+			value = strings.Join(request.Header[http.CanonicalHeaderKey(name)], ",")
+		}
+		headers[i] = name + ":" + strings.Join(strings.Fields(value), " ")
+	}
+	payloadHash := "UNSIGNED-PAYLOAD"
+	if !sig.presign {
+		if header := request.Header.Get(sig.scheme.headerPrefix + "content-sha256"); header != "" {
+			payloadHash = header
+		}
+	}
+	return strings.Join([]string{
+		request.Method,
+		uriEncode(request.URL.Path, false),
+		canonicalQuery(request.URL.Query(), sig.scheme.queryPrefix+"Signature"),
+		strings.Join(headers, "\n") + "\n",
+		strings.Join(sig.signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+// Query parameters sorted and encoded, leaving out the signature of a signed url
+func canonicalQuery(query url.Values, signatureParam string) string {
+	params := make([][2]string, 0, len(query))
+	for key, values := range query {
+		if key == signatureParam {
+			continue
+		}
+		for _, value := range values {
+			params = append(params, [2]string{uriEncode(key, true), uriEncode(value, true)})
+		}
+	}
+	// by encoded name, then value
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	encoded := make([]string, len(params))
+	for i, param := range params {
+		encoded[i] = param[0] + "=" + param[1]
+	}
+	return strings.Join(encoded, "&")
+}
+
+// Percent encode everything but unreserved characters, and slashes unless encodeSlash is set
+func uriEncode(value string, encodeSlash bool) string {
+	var builder strings.Builder
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' || (b == '/' && !encodeSlash) {
+			builder.WriteByte(b)
+		} else {
+			builder.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,168 @@
+package gcsxml
+
+import (
+	"cloudsidecar/pkg/response_type"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testCredentials = map[string]string{"goog1access": "secret"}
+
+func awsSigner() *v4.Signer {
+	return v4.NewSigner(aws.NewStaticCredentialsProvider("GOOG1ACCESS", "secret", ""), func(signer *v4.Signer) {
+		signer.DisableURIPathEscaping = true
+	})
+}
+
+func TestVerifyAWS4(t *testing.T) {
+	signedAt := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	request := httptest.NewRequest("PUT", "http://storage.googleapis.com/bucket/some/key.txt?acl=&max-keys=5", strings.NewReader("body"))
+	_, err := awsSigner().Sign(request, strings.NewReader("body"), "s3", "auto", signedAt)
+	assert.Nil(t, err)
+	assert.Nil(t, Verify(request, testCredentials, signedAt.Add(time.Minute)))
+
+	err = Verify(request, testCredentials, signedAt.Add(time.Hour))
+	assert.Equal(t, response_type.S3RequestTimeTooSkewed, err.(*authError).code)
+	err = Verify(request, map[string]string{"goog1access": "other"}, signedAt)
+	assert.Equal(t, response_type.S3SignatureDoesNotMatch, err.(*authError).code)
+	err = Verify(request, map[string]string{"someone": "secret"}, signedAt)
+	assert.Equal(t, response_type.S3InvalidAccessKeyId, err.(*authError).code)
+	request.URL.RawQuery = "acl=&max-keys=6"
+	err = Verify(request, testCredentials, signedAt)
+	assert.Equal(t, response_type.S3SignatureDoesNotMatch, err.(*authError).code)
+}
+
+func TestVerifyPresigned(t *testing.T) {
+	signedAt := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	request := httptest.NewRequest("GET", "http://storage.googleapis.com/bucket/key", nil)
+	_, err := awsSigner().Presign(request, nil, "s3", "auto", time.Hour, signedAt)
+	assert.Nil(t, err)
+	request = httptest.NewRequest("GET", request.URL.String(), nil)
+	assert.Nil(t, Verify(request, testCredentials, signedAt.Add(30*time.Minute)))
+	err = Verify(request, testCredentials, signedAt.Add(2*time.Hour))
+	assert.Equal(t, response_type.S3AccessDenied, err.(*authError).code)
+}
+
+func TestVerifyGOOG4(t *testing.T) {
+	signedAt := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	request := httptest.NewRequest("GET", "http://storage.googleapis.com/bucket/key%20with%20space?prefix=a/b", nil)
+	request.Header.Set("x-goog-date", signedAt.Format(timestampFormat))
+	request.Header.Set("x-goog-content-sha256", "UNSIGNED-PAYLOAD")
+	sig := &signature{
+		scheme:        goog4,
+		scope:         "20210501/auto/storage/goog4_request",
+		signedHeaders: []string{"host", "x-goog-content-sha256", "x-goog-date"},
+		timestamp:     signedAt.Format(timestampFormat),
+	}
+	canonical := canonicalRequest(request, sig)
+	assert.Equal(t, strings.Join([]string{
+		"GET",
+		"/bucket/key%20with%20space",
+		"prefix=a%2Fb",
+		"host:storage.googleapis.com",
+		"x-goog-content-sha256:UNSIGNED-PAYLOAD",
+		"x-goog-date:20210501T120000Z",
+		"",
+		"host;x-goog-content-sha256;x-goog-date",
+		"UNSIGNED-PAYLOAD",
+	}, "\n"), canonical)
+	request.Header.Set("Authorization", "GOOG4-HMAC-SHA256 Credential=GOOG1ACCESS/20210501/auto/storage/goog4_request, "+
+		"SignedHeaders=host;x-goog-content-sha256;x-goog-date, Signature="+sig.sign("secret", canonical))
+	assert.Nil(t, Verify(request, testCredentials, signedAt))
+
+	// the AWS terminator isn't valid in a GOOG4 scope
+	request.Header.Set("Authorization", strings.Replace(request.Header.Get("Authorization"), "goog4_request", "aws4_request", 1))
+	assert.NotNil(t, Verify(request, testCredentials, signedAt))
+	request.Header.Set("Authorization", "GOOG1 GOOG1ACCESS:signature")
+	err := Verify(request, testCredentials, signedAt)
+	assert.Equal(t, response_type.S3AccessDenied, err.(*authError).code)
+}
+
+func TestTranslateHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("x-goog-storage-class", "nearline")
+	header.Set("x-goog-acl", "project-private")
+	header.Set("x-goog-meta-color", "blue")
+	header.Set("Content-Type", "text/plain")
+	translateRequestHeaders(header)
+	assert.Equal(t, "STANDARD_IA", header.Get("x-amz-storage-class"))
+	assert.Equal(t, "private", header.Get("x-amz-acl"))
+	assert.Equal(t, "blue", header.Get("x-amz-meta-color"))
+	assert.Equal(t, "", header.Get("x-goog-meta-color"))
+	assert.Equal(t, "text/plain", header.Get("Content-Type"))
+
+	recorder := httptest.NewRecorder()
+	writer := &responseWriter{ResponseWriter: recorder}
+	writer.Header().Set("x-amz-storage-class", "GLACIER")
+	writer.Header().Set("x-amz-meta-color", "blue")
+	writer.Write([]byte("hi"))
+	assert.Equal(t, "COLDLINE", recorder.Header().Get("x-goog-storage-class"))
+	assert.Equal(t, "blue", recorder.Header().Get("x-goog-meta-color"))
+	assert.Equal(t, "", recorder.Header().Get("x-amz-meta-color"))
+}
+
+func TestVerifyRequiresHost(t *testing.T) {
+	signedAt := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	request := httptest.NewRequest("GET", "http://storage.googleapis.com/bucket/key", nil)
+	request.Header.Set("x-goog-date", signedAt.Format(timestampFormat))
+	request.Header.Set("Authorization", "GOOG4-HMAC-SHA256 Credential=GOOG1ACCESS/20210501/auto/storage/goog4_request, "+
+		"SignedHeaders=x-goog-date, Signature=abc")
+	err := Verify(request, testCredentials, signedAt)
+	assert.Equal(t, response_type.S3AccessDenied, err.(*authError).code)
+	assert.Equal(t, "SignedHeaders must include host", err.Error())
+}
+
+func TestVerifyBodyHash(t *testing.T) {
+	signedAt := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	request := httptest.NewRequest("PUT", "http://storage.googleapis.com/bucket/key", strings.NewReader("bodx"))
+	_, err := awsSigner().Sign(request, strings.NewReader("body"), "s3", "auto", signedAt)
+	assert.Nil(t, err)
+	request.Body = ioutil.NopCloser(strings.NewReader("bodx"))
+	assert.Nil(t, Verify(request, testCredentials, signedAt))
+	_, err = ioutil.ReadAll(request.Body)
+	assert.Equal(t, response_type.S3ContentSHA256Mismatch, err.(*authError).code)
+
+	request.Header.Set("x-amz-content-sha256", "nothex")
+	err = Verify(request, testCredentials, signedAt)
+	assert.NotNil(t, err)
+}
+
+func TestMiddlewareBodyHash(t *testing.T) {
+	config := viper.New()
+	config.Set(inboundCredentialsKey, testCredentials)
+	handler := Middleware(config)(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if _, err := ioutil.ReadAll(request.Body); err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			writer.Write([]byte("upload failed"))
+			return
+		}
+		writer.Header().Set("x-amz-meta-color", "blue")
+		writer.WriteHeader(http.StatusOK)
+	}))
+	for _, test := range []struct {
+		body   string
+		status int
+	}{{"body", http.StatusOK}, {"bodx", http.StatusBadRequest}} {
+		request := httptest.NewRequest("PUT", "http://storage.googleapis.com/bucket/key", strings.NewReader(test.body))
+		_, err := awsSigner().Sign(request, strings.NewReader("body"), "s3", "auto", time.Now())
+		assert.Nil(t, err)
+		request.Body = ioutil.NopCloser(strings.NewReader(test.body))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		assert.Equal(t, test.status, recorder.Code, test.body)
+		if test.status == http.StatusBadRequest {
+			assert.Contains(t, recorder.Body.String(), "XAmzContentSHA256Mismatch")
+			assert.NotContains(t, recorder.Body.String(), "upload failed")
+		} else {
+			assert.Equal(t, "blue", recorder.Header().Get("x-goog-meta-color"))
+		}
+	}
+}
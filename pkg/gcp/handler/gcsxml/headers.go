@@ -0,0 +1,122 @@
+package gcsxml
+
+import (
+	s3handler "cloudsidecar/pkg/aws/handler/s3"
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"github.com/spf13/viper"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	googPrefix = "X-Goog-"
+	amzPrefix  = "X-Amz-"
+)
+
+// GCS canned ACLs S3 has no name for
+var gcsCannedACLs = map[string]string{
+	"project-private": "private",
+}
+
+// Rename x-goog- request headers to the x-amz- ones the S3 handlers read, with GCS storage classes and canned
+// ACLs in their S3 form
+func translateRequestHeaders(header http.Header) {
+	for name, values := range header {
+		if !strings.HasPrefix(name, googPrefix) {
+			continue
+		}
+		delete(header, name)
+		header[amzPrefix+strings.TrimPrefix(name, googPrefix)] = values
+	}
+	if storageClass := header.Get("x-amz-storage-class"); storageClass != "" {
+		header.Set("x-amz-storage-class", converter.GCSStorageClassToS3(strings.ToUpper(storageClass)))
+	}
+	if acl, ok := gcsCannedACLs[header.Get("x-amz-acl")]; ok {
+		header.Set("x-amz-acl", acl)
+	}
+}
+
+// Response writer that renames x-amz- response headers to x-goog- ones before they are sent.  When the body
+// turned out not to match its signed hash that error is sent instead of whatever the handler answers with
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	request     *http.Request
+	body        *contentHashReader
+	rejected    bool
+}
+
+func (writer *responseWriter) WriteHeader(statusCode int) {
+	if writer.rejected {
+		return
+	}
+	if !writer.wroteHeader && writer.body != nil && writer.body.mismatch {
+		writer.body = nil
+		header := writer.ResponseWriter.Header()
+		for name := range header {
+			delete(header, name)
+		}
+		s3handler.WriteErrorCode(writer, writer.request, errContentSHA256Mismatch.code, errContentSHA256Mismatch.message)
+		writer.rejected = true
+		return
+	}
+	if !writer.wroteHeader {
+		writer.wroteHeader = true
+		header := writer.ResponseWriter.Header()
+		for name, values := range header {
+			if !strings.HasPrefix(name, amzPrefix) {
+				continue
+			}
+			delete(header, name)
+			header[googPrefix+strings.TrimPrefix(name, amzPrefix)] = values
+		}
+		if storageClass := header.Get("x-goog-storage-class"); storageClass != "" {
+			if gcsClass, ok := converter.S3StorageClassToGCS(storageClass); ok {
+				header.Set("x-goog-storage-class", gcsClass)
+			}
+		}
+	}
+	writer.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (writer *responseWriter) Write(p []byte) (int, error) {
+	if !writer.wroteHeader {
+		writer.WriteHeader(http.StatusOK)
+	}
+	if writer.rejected {
+		return len(p), nil
+	}
+	return writer.ResponseWriter.Write(p)
+}
+
+func (writer *responseWriter) Flush() {
+	if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+		if !writer.wroteHeader {
+			writer.WriteHeader(http.StatusOK)
+		}
+		flusher.Flush()
+	}
+}
+
+// Middleware making GCS XML API requests look like S3 ones.  Requests must be HMAC signed with one of the
+// inbound credentials when any are configured
+func Middleware(config *viper.Viper) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writer := &responseWriter{ResponseWriter: w, request: r}
+			if config != nil && config.IsSet(inboundCredentialsKey) {
+				if err := Verify(r, config.GetStringMapString(inboundCredentialsKey), time.Now()); err != nil {
+					logging.Log.Error("Error %s %s", r.RequestURI, err)
+					authErr := err.(*authError)
+					s3handler.WriteErrorCode(writer, r, authErr.code, authErr.message)
+					return
+				}
+				writer.body, _ = r.Body.(*contentHashReader)
+			}
+			translateRequestHeaders(r.Header)
+			next.ServeHTTP(writer, r)
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package pubsub
+
+import (
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/spf13/viper"
+	pubsubpb "google.golang.org/genproto/googleapis/pubsub/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+)
+
+// Config keys mapping topics and subscriptions to the AWS resources standing in for them
+const topicMapKey = "aws_destination_config.pubsub_config.topic_map"
+const subscriptionMapKey = "aws_destination_config.pubsub_config.subscription_map"
+
+// grpc codes for AWS error codes
+var awsErrorCodes = map[string]codes.Code{
+	sns.ErrCodeNotFoundException:                          codes.NotFound,
+	sqs.ErrCodeQueueDoesNotExist:                          codes.NotFound,
+	kinesis.ErrCodeResourceNotFoundException:              codes.NotFound,
+	sqs.ErrCodeQueueNameExists:                            codes.AlreadyExists,
+	sqs.ErrCodeQueueDeletedRecently:                       codes.FailedPrecondition,
+	kinesis.ErrCodeResourceInUseException:                 codes.AlreadyExists,
+	sns.ErrCodeInvalidParameterException:                  codes.InvalidArgument,
+	sns.ErrCodeInvalidParameterValueException:             codes.InvalidArgument,
+	sqs.ErrCodeInvalidAttributeName:                       codes.InvalidArgument,
+	sqs.ErrCodeReceiptHandleIsInvalid:                     codes.InvalidArgument,
+	kinesis.ErrCodeInvalidArgumentException:               codes.InvalidArgument,
+	sns.ErrCodeAuthorizationErrorException:                codes.PermissionDenied,
+	sns.ErrCodeThrottledException:                         codes.ResourceExhausted,
+	sqs.ErrCodeOverLimit:                                  codes.ResourceExhausted,
+	kinesis.ErrCodeLimitExceededException:                 codes.ResourceExhausted,
+	kinesis.ErrCodeProvisionedThroughputExceededException: codes.ResourceExhausted,
+	"RequestCanceled":                                     codes.Canceled,
+}
+
+// Pub/Sub Publisher and Subscriber servers.  Topics are SNS topics or Kinesis streams and subscriptions are SQS queues
+// subscribed to the topic's SNS topic
+type Handler struct {
+	pubsubpb.UnimplementedPublisherServer
+	pubsubpb.UnimplementedSubscriberServer
+	SNSClient     *sns.SNS
+	SqsClient     *sqs.SQS
+	KinesisClient *kinesis.Kinesis
+	Context       *context.Context
+	Config        *viper.Viper
+	topicArns     map[string]string
+	queueUrls     map[string]string
+	lookupLock    sync.Mutex
+}
+
+func NewHandler(config *viper.Viper) Handler {
+	return Handler{
+		Config:    config,
+		topicArns: make(map[string]string),
+		queueUrls: make(map[string]string),
+	}
+}
+
+func (handler *Handler) Shutdown() {
+	logging.Log.Debug("Closing pubsub frontend")
+}
+func (handler *Handler) GetContext() *context.Context {
+	return handler.Context
+}
+func (handler *Handler) GetConfig() *viper.Viper {
+	return handler.Config
+}
+func (handler *Handler) SetContext(context *context.Context) {
+	handler.Context = context
+}
+func (handler *Handler) SetConfig(config *viper.Viper) {
+	handler.Config = config
+}
+
+// Register the Publisher and Subscriber services
+func (handler *Handler) Register(server *grpc.Server) {
+	pubsubpb.RegisterPublisherServer(server, handler)
+	pubsubpb.RegisterSubscriberServer(server, handler)
+}
+
+func topicName(project string, topic string) string {
+	return fmt.Sprintf("projects/%s/topics/%s", project, topic)
+}
+
+func isKinesis(arn string) bool {
+	return strings.HasPrefix(arn, "arn:aws:kinesis:")
+}
+
+// SNS topic or Kinesis stream ARN standing in for a topic.  Topics topic_map doesn't name are SNS topics named for the
+// topic id
+func (handler *Handler) topicArn(ctx context.Context, topic string) (string, error) {
+	project, topicId, err := converter.ParsePubSubTopic(topic)
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	name := topicName(project, topicId)
+	if arn, ok := handler.Config.GetStringMapString(topicMapKey)[strings.ToLower(name)]; ok {
+		return arn, nil
+	}
+	handler.lookupLock.Lock()
+	arn, ok := handler.topicArns[name]
+	handler.lookupLock.Unlock()
+	if ok {
+		return arn, nil
+	}
+	var next *string
+	for {
+		request := handler.SNSClient.ListTopicsRequest(&sns.ListTopicsInput{NextToken: next})
+		request.SetContext(ctx)
+		output, err := request.Send()
+		if err != nil {
+			return "", awsError(err)
+		}
+		for _, snsTopic := range output.Topics {
+			if snsTopic.TopicArn != nil && strings.HasSuffix(*snsTopic.TopicArn, ":"+topicId) {
+				handler.cacheTopic(name, *snsTopic.TopicArn)
+				return *snsTopic.TopicArn, nil
+			}
+		}
+		if output.NextToken == nil || *output.NextToken == "" {
+			return "", status.Errorf(codes.NotFound, "topic %s not found", name)
+		}
+		next = output.NextToken
+	}
+}
+
+func (handler *Handler) cacheTopic(name string, arn string) {
+	handler.lookupLock.Lock()
+	defer handler.lookupLock.Unlock()
+	handler.topicArns[name] = arn
+}
+
+// SQS queue name and owning account standing in for a subscription.  Subscriptions subscription_map doesn't name are
+// queues in the caller's account named for the subscription id
+func (handler *Handler) subscriptionQueue(subscription string) (string, string, error) {
+	project, subscriptionId, err := converter.ParsePubSubSubscription(subscription)
+	if err != nil {
+		return "", "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	name := converter.PubSubSubscriptionName(project, subscriptionId)
+	if mapped, ok := handler.Config.GetStringMapString(subscriptionMapKey)[strings.ToLower(name)]; ok {
+		// arn:aws:sqs:region:account:name
+		if pieces := strings.Split(mapped, ":"); len(pieces) == 6 {
+			return pieces[5], pieces[4], nil
+		}
+		return mapped, "", nil
+	}
+	queueName, err := converter.SubscriptionToQueueName(subscriptionId)
+	if err != nil {
+		return "", "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	return queueName, "", nil
+}
+
+// Url of the SQS queue standing in for a subscription
+func (handler *Handler) queueUrl(ctx context.Context, subscription string) (string, error) {
+	handler.lookupLock.Lock()
+	url, ok := handler.queueUrls[subscription]
+	handler.lookupLock.Unlock()
+	if ok {
+		return url, nil
+	}
+	queueName, owner, err := handler.subscriptionQueue(subscription)
+	if err != nil {
+		return "", err
+	}
+	input := &sqs.GetQueueUrlInput{QueueName: &queueName}
+	if owner != "" {
+		input.QueueOwnerAWSAccountId = &owner
+	}
+	request := handler.SqsClient.GetQueueUrlRequest(input)
+	request.SetContext(ctx)
+	output, err := request.Send()
+	if err != nil {
+		return "", awsError(err)
+	}
+	handler.cacheQueue(subscription, *output.QueueUrl)
+	return *output.QueueUrl, nil
+}
+
+func (handler *Handler) cacheQueue(subscription string, url string) {
+	handler.lookupLock.Lock()
+	defer handler.lookupLock.Unlock()
+	handler.queueUrls[subscription] = url
+}
+
+// grpc status for an error from AWS
+func awsError(err error) error {
+	if awsErr, ok := err.(awserr.Error); ok {
+		if code, ok := awsErrorCodes[awsErr.Code()]; ok {
+			return status.Error(code, awsErr.Message())
+		}
+		return status.Error(codes.Internal, awsErr.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,146 @@
+package pubsub
+
+import (
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	uuid2 "github.com/google/uuid"
+	pubsubpb "google.golang.org/genproto/googleapis/pubsub/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+// Most records Kinesis takes in one PutRecords call
+const maxKinesisRecords = 500
+
+// Create a topic.  Topics are SNS topics named for the topic id unless topic_map names an SNS topic or Kinesis stream.
+// SNS creates topics idempotently, so only Kinesis streams that exist already fail with AlreadyExists
+func (handler *Handler) CreateTopic(ctx context.Context, topic *pubsubpb.Topic) (*pubsubpb.Topic, error) {
+	project, topicId, err := converter.ParsePubSubTopic(topic.Name)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	name := topicName(project, topicId)
+	arn, mapped := handler.Config.GetStringMapString(topicMapKey)[strings.ToLower(name)]
+	if mapped && isKinesis(arn) {
+		// arn:aws:kinesis:region:account:stream/name, streams start with one shard
+		streamName := arn[strings.LastIndex(arn, "/")+1:]
+		shards := int64(1)
+		request := handler.KinesisClient.CreateStreamRequest(&kinesis.CreateStreamInput{
+			StreamName: &streamName,
+			ShardCount: &shards,
+		})
+		request.SetContext(ctx)
+		if _, err := request.Send(); err != nil {
+			logging.Log.Error("Error %s %s", name, err)
+			return nil, awsError(err)
+		}
+	} else {
+		snsName := topicId
+		if mapped {
+			snsName = arn[strings.LastIndex(arn, ":")+1:]
+		}
+		request := handler.SNSClient.CreateTopicRequest(&sns.CreateTopicInput{Name: &snsName})
+		request.SetContext(ctx)
+		output, err := request.Send()
+		if err != nil {
+			logging.Log.Error("Error %s %s", name, err)
+			return nil, awsError(err)
+		}
+		if !mapped {
+			handler.cacheTopic(name, *output.TopicArn)
+		}
+	}
+	return &pubsubpb.Topic{Name: name}, nil
+}
+
+// Publish messages to a topic's SNS topic or Kinesis stream.  Message bodies are Pub/Sub messages in the REST API's
+// JSON, the same ones GCS notifications sent by the sidecar use
+func (handler *Handler) Publish(ctx context.Context, request *pubsubpb.PublishRequest) (*pubsubpb.PublishResponse, error) {
+	if len(request.Messages) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no messages to publish")
+	}
+	arn, err := handler.topicArn(ctx, request.Topic)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	ids := make([]string, len(request.Messages))
+	bodies := make([]string, len(request.Messages))
+	for i, message := range request.Messages {
+		if len(message.Data) == 0 && len(message.Attributes) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "message %d has no data or attributes", i)
+		}
+		ids[i] = uuid2.New().String()
+		body, err := json.Marshal(converter.PubSubMessage{
+			Data:        base64.StdEncoding.EncodeToString(message.Data),
+			Attributes:  message.Attributes,
+			MessageId:   ids[i],
+			PublishTime: now.UTC().Format(time.RFC3339Nano),
+			OrderingKey: message.OrderingKey,
+		})
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		bodies[i] = string(body)
+	}
+	if isKinesis(arn) {
+		err = handler.putRecords(ctx, arn, request.Messages, ids, bodies)
+	} else {
+		for i := range bodies {
+			publishRequest := handler.SNSClient.PublishRequest(&sns.PublishInput{TopicArn: &arn, Message: &bodies[i]})
+			publishRequest.SetContext(ctx)
+			if _, err = publishRequest.Send(); err != nil {
+				err = awsError(err)
+				break
+			}
+		}
+	}
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.Topic, err)
+		return nil, err
+	}
+	return &pubsubpb.PublishResponse{MessageIds: ids}, nil
+}
+
+// Put messages on a Kinesis stream, partitioned by ordering key so ordered messages stay on one shard
+func (handler *Handler) putRecords(ctx context.Context, arn string, messages []*pubsubpb.PubsubMessage, ids []string, bodies []string) error {
+	streamName := arn[strings.LastIndex(arn, "/")+1:]
+	for start := 0; start < len(bodies); start += maxKinesisRecords {
+		end := start + maxKinesisRecords
+		if end > len(bodies) {
+			end = len(bodies)
+		}
+		records := make([]kinesis.PutRecordsRequestEntry, 0, end-start)
+		for i := start; i < end; i++ {
+			partitionKey := ids[i]
+			if messages[i].OrderingKey != "" {
+				partitionKey = messages[i].OrderingKey
+			}
+			records = append(records, kinesis.PutRecordsRequestEntry{
+				Data:         []byte(bodies[i]),
+				PartitionKey: &partitionKey,
+			})
+		}
+		request := handler.KinesisClient.PutRecordsRequest(&kinesis.PutRecordsInput{
+			StreamName: &streamName,
+			Records:    records,
+		})
+		request.SetContext(ctx)
+		output, err := request.Send()
+		if err != nil {
+			return awsError(err)
+		}
+		if output.FailedRecordCount != nil && *output.FailedRecordCount > 0 {
+			// publishers retry the whole batch, the records that made it are delivered again
+			return status.Errorf(codes.Unavailable, "kinesis rejected %d of %d messages", *output.FailedRecordCount, len(records))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,464 @@
+package pubsub
+
+import (
+	"cloudsidecar/pkg/converter"
+	"cloudsidecar/pkg/logging"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	pubsubpb "google.golang.org/genproto/googleapis/pubsub/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Ack deadline of subscriptions that don't set one, and the range Pub/Sub allows
+const defaultAckDeadlineSeconds = 10
+const minAckDeadlineSeconds = 10
+const maxAckDeadlineSeconds = 600
+
+// Most messages SQS receives, deletes or changes in one call
+const maxSqsBatch = 10
+
+// Longest SQS long poll
+const maxWaitSeconds = 20
+
+// How long a streaming pull with max_outstanding_messages leased waits before checking again
+const streamingPullBackoff = 100 * time.Millisecond
+
+// Leases a streaming pull holds, by ack id
+type streamingPull struct {
+	lock           sync.Mutex
+	deadline       int64
+	maxOutstanding int64
+	outstanding    map[string]time.Time
+}
+
+// Create a subscription as an SQS queue subscribed to the topic's SNS topic with raw message delivery, so message
+// bodies are the Pub/Sub messages published to the topic.  The queue's policy is replaced by one letting the topic send
+func (handler *Handler) CreateSubscription(ctx context.Context, subscription *pubsubpb.Subscription) (*pubsubpb.Subscription, error) {
+	if subscription.PushConfig != nil && subscription.PushConfig.PushEndpoint != "" {
+		return nil, status.Error(codes.Unimplemented, "push subscriptions are not supported")
+	}
+	if subscription.Filter != "" {
+		return nil, status.Error(codes.Unimplemented, "subscription filters are not supported")
+	}
+	ackDeadline := subscription.AckDeadlineSeconds
+	if ackDeadline == 0 {
+		ackDeadline = defaultAckDeadlineSeconds
+	}
+	if ackDeadline < minAckDeadlineSeconds || ackDeadline > maxAckDeadlineSeconds {
+		return nil, status.Errorf(codes.InvalidArgument, "ack deadline must be between %d and %d seconds", minAckDeadlineSeconds, maxAckDeadlineSeconds)
+	}
+	topicArn, err := handler.topicArn(ctx, subscription.Topic)
+	if err != nil {
+		return nil, err
+	}
+	if isKinesis(topicArn) {
+		return nil, status.Error(codes.FailedPrecondition, "subscriptions to topics on kinesis streams are not supported, read the stream with a kinesis consumer")
+	}
+	queueName, _, err := handler.subscriptionQueue(subscription.Name)
+	if err != nil {
+		return nil, err
+	}
+	attributes := map[string]string{
+		string(sqs.QueueAttributeNameVisibilityTimeout): strconv.Itoa(int(ackDeadline)),
+	}
+	if subscription.MessageRetentionDuration != nil {
+		attributes[string(sqs.QueueAttributeNameMessageRetentionPeriod)] = strconv.FormatInt(subscription.MessageRetentionDuration.Seconds, 10)
+	}
+	createRequest := handler.SqsClient.CreateQueueRequest(&sqs.CreateQueueInput{QueueName: &queueName, Attributes: attributes})
+	createRequest.SetContext(ctx)
+	created, err := createRequest.Send()
+	if err != nil {
+		logging.Log.Error("Error %s %s", subscription.Name, err)
+		return nil, awsError(err)
+	}
+	attributesRequest := handler.SqsClient.GetQueueAttributesRequest(&sqs.GetQueueAttributesInput{
+		QueueUrl:       created.QueueUrl,
+		AttributeNames: []sqs.QueueAttributeName{sqs.QueueAttributeNameQueueArn},
+	})
+	attributesRequest.SetContext(ctx)
+	queueAttributes, err := attributesRequest.Send()
+	if err != nil {
+		logging.Log.Error("Error %s %s", subscription.Name, err)
+		return nil, awsError(err)
+	}
+	queueArn := queueAttributes.Attributes[string(sqs.QueueAttributeNameQueueArn)]
+	policy := fmt.Sprintf(
+		`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"sns.amazonaws.com"},"Action":"sqs:SendMessage","Resource":%q,"Condition":{"ArnEquals":{"aws:SourceArn":%q}}}]}`,
+		queueArn, topicArn,
+	)
+	policyRequest := handler.SqsClient.SetQueueAttributesRequest(&sqs.SetQueueAttributesInput{
+		QueueUrl:   created.QueueUrl,
+		Attributes: map[string]string{string(sqs.QueueAttributeNamePolicy): policy},
+	})
+	policyRequest.SetContext(ctx)
+	if _, err := policyRequest.Send(); err != nil {
+		logging.Log.Error("Error %s %s", subscription.Name, err)
+		return nil, awsError(err)
+	}
+	protocol := "sqs"
+	subscribeRequest := handler.SNSClient.SubscribeRequest(&sns.SubscribeInput{
+		TopicArn:   &topicArn,
+		Protocol:   &protocol,
+		Endpoint:   &queueArn,
+		Attributes: map[string]string{"RawMessageDelivery": "true"},
+	})
+	subscribeRequest.SetContext(ctx)
+	if _, err := subscribeRequest.Send(); err != nil {
+		logging.Log.Error("Error %s %s", subscription.Name, err)
+		return nil, awsError(err)
+	}
+	handler.cacheQueue(subscription.Name, *created.QueueUrl)
+	project, topicId, _ := converter.ParsePubSubTopic(subscription.Topic)
+	return &pubsubpb.Subscription{
+		Name:                     subscription.Name,
+		Topic:                    topicName(project, topicId),
+		AckDeadlineSeconds:       ackDeadline,
+		MessageRetentionDuration: subscription.MessageRetentionDuration,
+	}, nil
+}
+
+// Pull messages from a subscription's queue, long polling unless asked to return immediately
+func (handler *Handler) Pull(ctx context.Context, request *pubsubpb.PullRequest) (*pubsubpb.PullResponse, error) {
+	if request.MaxMessages <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "max_messages must be positive")
+	}
+	queueUrl, err := handler.queueUrl(ctx, request.Subscription)
+	if err != nil {
+		return nil, err
+	}
+	wait := int64(maxWaitSeconds)
+	if request.ReturnImmediately {
+		wait = 0
+	}
+	received, err := handler.receive(ctx, queueUrl, int64(request.MaxMessages), wait, nil)
+	if err != nil {
+		logging.Log.Error("Error %s %s", request.Subscription, err)
+		return nil, err
+	}
+	return &pubsubpb.PullResponse{ReceivedMessages: received}, nil
+}
+
+// Stream messages from a subscription's queue until the subscriber goes away.  Acks and ack deadline changes come in on
+// the same stream, and messages are received with the stream's ack deadline as their visibility timeout
+func (handler *Handler) StreamingPull(stream pubsubpb.Subscriber_StreamingPullServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.StreamAckDeadlineSeconds < minAckDeadlineSeconds || first.StreamAckDeadlineSeconds > maxAckDeadlineSeconds {
+		return status.Errorf(codes.InvalidArgument, "stream ack deadline must be between %d and %d seconds", minAckDeadlineSeconds, maxAckDeadlineSeconds)
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	queueUrl, err := handler.queueUrl(ctx, first.Subscription)
+	if err != nil {
+		return err
+	}
+	pull := &streamingPull{
+		deadline:       int64(first.StreamAckDeadlineSeconds),
+		maxOutstanding: first.MaxOutstandingMessages,
+		outstanding:    make(map[string]time.Time),
+	}
+	if err := handler.streamRequest(ctx, queueUrl, pull, first); err != nil {
+		return err
+	}
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			request, err := stream.Recv()
+			if err == nil {
+				err = handler.streamRequest(ctx, queueUrl, pull, request)
+			}
+			if err != nil {
+				recvErr <- err
+				cancel()
+				return
+			}
+		}
+	}()
+	for ctx.Err() == nil {
+		room, deadline := pull.room()
+		if room == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(streamingPullBackoff):
+			}
+			continue
+		}
+		received, err := handler.receive(ctx, queueUrl, room, maxWaitSeconds, &deadline)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logging.Log.Error("Error %s %s", first.Subscription, err)
+			return err
+		}
+		if len(received) == 0 {
+			continue
+		}
+		pull.lease(received, deadline)
+		if err := stream.Send(&pubsubpb.StreamingPullResponse{ReceivedMessages: received}); err != nil {
+			return err
+		}
+	}
+	if err := <-recvErr; err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Apply the acks and ack deadline changes of a streaming pull request
+func (handler *Handler) streamRequest(ctx context.Context, queueUrl string, pull *streamingPull, request *pubsubpb.StreamingPullRequest) error {
+	if len(request.ModifyDeadlineAckIds) != len(request.ModifyDeadlineSeconds) {
+		return status.Error(codes.InvalidArgument, "modify_deadline_ack_ids and modify_deadline_seconds differ in length")
+	}
+	if request.StreamAckDeadlineSeconds != 0 {
+		pull.setDeadline(int64(request.StreamAckDeadlineSeconds))
+	}
+	if len(request.AckIds) > 0 {
+		pull.release(request.AckIds)
+		if err := handler.deleteMessages(ctx, queueUrl, request.AckIds); err != nil {
+			logging.Log.Error("Error %s %s", request.Subscription, err)
+		}
+	}
+	bySeconds := make(map[int32][]string)
+	for i, ackId := range request.ModifyDeadlineAckIds {
+		bySeconds[request.ModifyDeadlineSeconds[i]] = append(bySeconds[request.ModifyDeadlineSeconds[i]], ackId)
+	}
+	for seconds, ackIds := range bySeconds {
+		if seconds == 0 {
+			pull.release(ackIds)
+		} else {
+			pull.extend(ackIds, int64(seconds))
+		}
+		if err := handler.changeVisibility(ctx, queueUrl, ackIds, int64(seconds)); err != nil {
+			logging.Log.Error("Error %s %s", request.Subscription, err)
+		}
+	}
+	return nil
+}
+
+// Acknowledge messages by deleting them from the subscription's queue
+func (handler *Handler) Acknowledge(ctx context.Context, request *pubsubpb.AcknowledgeRequest) (*emptypb.Empty, error) {
+	if len(request.AckIds) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no ack ids")
+	}
+	queueUrl, err := handler.queueUrl(ctx, request.Subscription)
+	if err != nil {
+		return nil, err
+	}
+	if err := handler.deleteMessages(ctx, queueUrl, request.AckIds); err != nil {
+		logging.Log.Error("Error %s %s", request.Subscription, err)
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Change how long until messages are redelivered by changing their visibility timeout.  A deadline of 0 redelivers
+// them right away
+func (handler *Handler) ModifyAckDeadline(ctx context.Context, request *pubsubpb.ModifyAckDeadlineRequest) (*emptypb.Empty, error) {
+	if len(request.AckIds) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no ack ids")
+	}
+	if request.AckDeadlineSeconds < 0 || request.AckDeadlineSeconds > maxAckDeadlineSeconds {
+		return nil, status.Errorf(codes.InvalidArgument, "ack deadline must be between 0 and %d seconds", maxAckDeadlineSeconds)
+	}
+	queueUrl, err := handler.queueUrl(ctx, request.Subscription)
+	if err != nil {
+		return nil, err
+	}
+	if err := handler.changeVisibility(ctx, queueUrl, request.AckIds, int64(request.AckDeadlineSeconds)); err != nil {
+		logging.Log.Error("Error %s %s", request.Subscription, err)
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Receive up to max messages, waiting up to wait seconds for the first, or less when the caller's deadline is sooner
+func (handler *Handler) receive(ctx context.Context, queueUrl string, max int64, wait int64, visibility *int64) ([]*pubsubpb.ReceivedMessage, error) {
+	if max > maxSqsBatch {
+		max = maxSqsBatch
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := int64(time.Until(deadline)/time.Second) - 1; remaining < wait {
+			wait = remaining
+		}
+		if wait < 0 {
+			wait = 0
+		}
+	}
+	request := handler.SqsClient.ReceiveMessageRequest(&sqs.ReceiveMessageInput{
+		QueueUrl:              &queueUrl,
+		MaxNumberOfMessages:   &max,
+		WaitTimeSeconds:       &wait,
+		VisibilityTimeout:     visibility,
+		AttributeNames:        []sqs.QueueAttributeName{sqs.QueueAttributeNameAll},
+		MessageAttributeNames: []string{"All"},
+	})
+	request.SetContext(ctx)
+	output, err := request.Send()
+	if err != nil {
+		return nil, awsError(err)
+	}
+	received := make([]*pubsubpb.ReceivedMessage, 0, len(output.Messages))
+	for _, message := range output.Messages {
+		received = append(received, receivedMessage(message))
+	}
+	return received, nil
+}
+
+// Pub/Sub message for an SQS message.  Bodies that aren't Pub/Sub messages keep their SQS string attributes
+func receivedMessage(message sqs.Message) *pubsubpb.ReceivedMessage {
+	var body, id string
+	if message.Body != nil {
+		body = *message.Body
+	}
+	if message.MessageId != nil {
+		id = *message.MessageId
+	}
+	data, decoded := converter.DecodePubSubMessage(body, id)
+	attributes := decoded.Attributes
+	if attributes == nil {
+		attributes = make(map[string]string)
+		for name, value := range message.MessageAttributes {
+			if value.StringValue != nil {
+				attributes[name] = *value.StringValue
+			}
+		}
+	}
+	published, err := time.Parse(time.RFC3339Nano, decoded.PublishTime)
+	if err != nil {
+		sent, _ := strconv.ParseInt(message.Attributes[string(sqs.MessageSystemAttributeNameSentTimestamp)], 10, 64)
+		published = time.Unix(0, sent*int64(time.Millisecond))
+	}
+	return &pubsubpb.ReceivedMessage{
+		AckId: *message.ReceiptHandle,
+		Message: &pubsubpb.PubsubMessage{
+			Data:        data,
+			Attributes:  attributes,
+			MessageId:   decoded.MessageId,
+			PublishTime: timestamppb.New(published),
+			OrderingKey: decoded.OrderingKey,
+		},
+	}
+}
+
+// Delete acked messages.  Acks are best effort in Pub/Sub too, so messages SQS can't delete, like ones already
+// redelivered, are only logged
+func (handler *Handler) deleteMessages(ctx context.Context, queueUrl string, ackIds []string) error {
+	for start := 0; start < len(ackIds); start += maxSqsBatch {
+		entries := make([]sqs.DeleteMessageBatchRequestEntry, 0, maxSqsBatch)
+		for i := start; i < len(ackIds) && i < start+maxSqsBatch; i++ {
+			entries = append(entries, sqs.DeleteMessageBatchRequestEntry{Id: batchId(i), ReceiptHandle: &ackIds[i]})
+		}
+		request := handler.SqsClient.DeleteMessageBatchRequest(&sqs.DeleteMessageBatchInput{QueueUrl: &queueUrl, Entries: entries})
+		request.SetContext(ctx)
+		output, err := request.Send()
+		if err != nil {
+			return awsError(err)
+		}
+		for _, failed := range output.Failed {
+			logging.Log.Error("Error deleting %s %s", queueUrl, failed.String())
+		}
+	}
+	return nil
+}
+
+// Change the visibility timeout of received messages, logging the ones SQS can't change
+func (handler *Handler) changeVisibility(ctx context.Context, queueUrl string, ackIds []string, seconds int64) error {
+	for start := 0; start < len(ackIds); start += maxSqsBatch {
+		entries := make([]sqs.ChangeMessageVisibilityBatchRequestEntry, 0, maxSqsBatch)
+		for i := start; i < len(ackIds) && i < start+maxSqsBatch; i++ {
+			entries = append(entries, sqs.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                batchId(i),
+				ReceiptHandle:     &ackIds[i],
+				VisibilityTimeout: &seconds,
+			})
+		}
+		request := handler.SqsClient.ChangeMessageVisibilityBatchRequest(&sqs.ChangeMessageVisibilityBatchInput{
+			QueueUrl: &queueUrl,
+			Entries:  entries,
+		})
+		request.SetContext(ctx)
+		output, err := request.Send()
+		if err != nil {
+			return awsError(err)
+		}
+		for _, failed := range output.Failed {
+			logging.Log.Error("Error changing visibility %s %s", queueUrl, failed.String())
+		}
+	}
+	return nil
+}
+
+func batchId(i int) *string {
+	id := strconv.Itoa(i)
+	return &id
+}
+
+// How many more messages the stream can lease, and the deadline to lease them for.  Leases that ran out without an ack
+// or deadline change no longer count
+func (pull *streamingPull) room() (int64, int64) {
+	pull.lock.Lock()
+	defer pull.lock.Unlock()
+	if pull.maxOutstanding <= 0 {
+		return maxSqsBatch, pull.deadline
+	}
+	now := time.Now()
+	for ackId, expires := range pull.outstanding {
+		if now.After(expires) {
+			delete(pull.outstanding, ackId)
+		}
+	}
+	room := pull.maxOutstanding - int64(len(pull.outstanding))
+	if room < 0 {
+		room = 0
+	}
+	return room, pull.deadline
+}
+
+func (pull *streamingPull) setDeadline(seconds int64) {
+	pull.lock.Lock()
+	defer pull.lock.Unlock()
+	pull.deadline = seconds
+}
+
+func (pull *streamingPull) lease(received []*pubsubpb.ReceivedMessage, seconds int64) {
+	pull.lock.Lock()
+	defer pull.lock.Unlock()
+	if pull.maxOutstanding <= 0 {
+		return
+	}
+	expires := time.Now().Add(time.Duration(seconds) * time.Second)
+	for _, message := range received {
+		pull.outstanding[message.AckId] = expires
+	}
+}
+
+func (pull *streamingPull) extend(ackIds []string, seconds int64) {
+	pull.lock.Lock()
+	defer pull.lock.Unlock()
+	expires := time.Now().Add(time.Duration(seconds) * time.Second)
+	for _, ackId := range ackIds {
+		if _, ok := pull.outstanding[ackId]; ok {
+			pull.outstanding[ackId] = expires
+		}
+	}
+}
+
+func (pull *streamingPull) release(ackIds []string) {
+	pull.lock.Lock()
+	defer pull.lock.Unlock()
+	for _, ackId := range ackIds {
+		delete(pull.outstanding, ackId)
+	}
+}
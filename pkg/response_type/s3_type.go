@@ -3,12 +3,19 @@ package response_type
 import (
 	"cloud.google.com/go/datastore"
 	"encoding/xml"
+	"net/http"
 	"reflect"
 )
 
 const (
 	ACLXmlNs  string = "http://www.w3.org/2001/XMLSchema-instance"
 	ACLXmlXsi string = "CanonicalUser"
+	// Grantee types for groups and email addresses
+	ACLXmlGroup string = "Group"
+	ACLXmlEmail string = "AmazonCustomerByEmail"
+	// Groups S3 grants can name
+	ACLAllUsers           string = "http://acs.amazonaws.com/groups/global/AllUsers"
+	ACLAuthenticatedUsers string = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
 )
 
 type Map map[string]interface{}
@@ -84,11 +91,35 @@ type Grant struct {
 }
 
 type Grantee struct {
-	XMLName     xml.Name `xml:"Grantee"`
-	Id          string   `xml:"ID"`
-	DisplayName string   `xml:"DisplayName"`
-	XmlNS       string   `xml:"xmlns:xsi,attr"`
-	Xsi         string   `xml:"xsi:type,attr"`
+	XMLName      xml.Name `xml:"Grantee"`
+	Id           string   `xml:"ID,omitempty"`
+	DisplayName  string   `xml:"DisplayName,omitempty"`
+	EmailAddress string   `xml:"EmailAddress,omitempty"`
+	URI          string   `xml:"URI,omitempty"`
+	XmlNS        string   `xml:"xmlns:xsi,attr"`
+	Xsi          string   `xml:"xsi:type,attr"`
+}
+
+// Access control policy sent to put ACL requests.  The grantee type is a namespaced attribute, which has to
+// be matched by namespace when reading
+type AccessControlPolicyInput struct {
+	XMLName          xml.Name      `xml:"AccessControlPolicy"`
+	OwnerId          string        `xml:"Owner>ID"`
+	OwnerDisplayName string        `xml:"Owner>DisplayName"`
+	Grants           []*GrantInput `xml:"AccessControlList>Grant"`
+}
+
+type GrantInput struct {
+	Grantee    GranteeInput `xml:"Grantee"`
+	Permission string       `xml:"Permission"`
+}
+
+type GranteeInput struct {
+	Type         string `xml:"http://www.w3.org/2001/XMLSchema-instance type,attr"`
+	Id           string `xml:"ID"`
+	DisplayName  string `xml:"DisplayName"`
+	EmailAddress string `xml:"EmailAddress"`
+	URI          string `xml:"URI"`
 }
 
 type AWSListBucketResponse struct {
@@ -97,22 +128,31 @@ type AWSListBucketResponse struct {
 	Name                  *string               `xml:"Name"`
 	Prefix                *string               `xml:"Prefix"`
 	Delimiter             *string               `xml:"Delimiter,omitempty"`
-	Marker                *string               `xml:"Marker"`
+	EncodingType          *string               `xml:"EncodingType,omitempty"`
+	Marker                *string               `xml:"Marker,omitempty"`
+	NextMarker            *string               `xml:"NextMarker,omitempty"`
+	StartAfter            *string               `xml:"StartAfter,omitempty"`
 	KeyCount              int64                 `xml:"KeyCount"`
 	MaxKeys               *int64                `xml:"MaxKeys"`
 	IsTruncated           *bool                 `xml:"IsTruncated"`
 	Contents              []*BucketContent      `xml:"Contents"`
 	CommonPrefixes        []*BucketCommonPrefix `xml:"CommonPrefixes,omitempty"`
-	ContinuationToken     *string               `xml:"ContinuationToken"`
-	NextContinuationToken *string               `xml:"NextContinuationToken"`
+	ContinuationToken     *string               `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken *string               `xml:"NextContinuationToken,omitempty"`
 }
 
 type BucketContent struct {
-	Key          string `xml:"Key"`
-	LastModified string `xml:"LastModified"`
-	ETag         string `xml:"ETag"`
-	Size         int64  `xml:"Size"`
-	StorageClass string `xml:"StorageClass"`
+	Key          string       `xml:"Key"`
+	LastModified string       `xml:"LastModified"`
+	ETag         string       `xml:"ETag"`
+	Size         int64        `xml:"Size"`
+	Owner        *BucketOwner `xml:"Owner,omitempty"`
+	StorageClass string       `xml:"StorageClass"`
+}
+
+type BucketOwner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
 }
 
 type BucketCommonPrefix struct {
@@ -139,12 +179,13 @@ type CompleteMultipartUploadInput struct {
 }
 
 type CompleteMultipartUploadResult struct {
-	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
-	XmlNS    string   `xml:"xmlns,attr"`
-	Bucket   *string  `xml:"Bucket"`
-	Key      *string  `xml:"Key"`
-	ETag     *string  `xml:"ETag"`
-	Location *string  `xml:"Location"`
+	XMLName        xml.Name `xml:"CompleteMultipartUploadResult"`
+	XmlNS          string   `xml:"xmlns,attr"`
+	Bucket         *string  `xml:"Bucket"`
+	Key            *string  `xml:"Key"`
+	ETag           *string  `xml:"ETag"`
+	Location       *string  `xml:"Location"`
+	ChecksumCRC32C *string  `xml:"ChecksumCRC32C,omitempty"`
 }
 
 type CopyResult struct {
@@ -154,7 +195,10 @@ type CopyResult struct {
 }
 
 type DeleteObject struct {
-	Key *string `xml:"Key"`
+	Key                   *string `xml:"Key"`
+	VersionId             *string `xml:"VersionId,omitempty"`
+	DeleteMarker          *bool   `xml:"DeleteMarker,omitempty"`
+	DeleteMarkerVersionId *string `xml:"DeleteMarkerVersionId,omitempty"`
 }
 
 type MultiDeleteRequest struct {
@@ -178,7 +222,334 @@ type AWSACLResponseError struct {
 }
 
 type ErrorResult struct {
-	Key     *string `xml:"Key"`
-	Code    *string `xml:"Code"`
-	Message *string `xml:"Message"`
+	Key       *string `xml:"Key"`
+	VersionId *string `xml:"VersionId,omitempty"`
+	Code      *string `xml:"Code"`
+	Message   *string `xml:"Message"`
+}
+
+type ListVersionsResult struct {
+	XMLName             xml.Name              `xml:"ListVersionsResult"`
+	XmlNS               string                `xml:"xmlns,attr"`
+	Name                *string               `xml:"Name"`
+	Prefix              *string               `xml:"Prefix"`
+	KeyMarker           *string               `xml:"KeyMarker"`
+	VersionIdMarker     *string               `xml:"VersionIdMarker"`
+	NextKeyMarker       *string               `xml:"NextKeyMarker,omitempty"`
+	NextVersionIdMarker *string               `xml:"NextVersionIdMarker,omitempty"`
+	MaxKeys             *int64                `xml:"MaxKeys"`
+	Delimiter           *string               `xml:"Delimiter,omitempty"`
+	IsTruncated         bool                  `xml:"IsTruncated"`
+	Versions            []*ObjectVersion      `xml:"Version"`
+	DeleteMarkers       []*DeleteMarkerEntry  `xml:"DeleteMarker"`
+	CommonPrefixes      []*BucketCommonPrefix `xml:"CommonPrefixes,omitempty"`
+}
+
+type ObjectVersion struct {
+	Key          string `xml:"Key"`
+	VersionId    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type DeleteMarkerEntry struct {
+	Key          string `xml:"Key"`
+	VersionId    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+}
+
+type VersioningConfiguration struct {
+	XMLName   xml.Name `xml:"VersioningConfiguration"`
+	XmlNS     string   `xml:"xmlns,attr"`
+	Status    string   `xml:"Status,omitempty"`
+	MfaDelete string   `xml:"MfaDelete,omitempty"`
+}
+
+type LifecycleConfiguration struct {
+	XMLName xml.Name         `xml:"LifecycleConfiguration"`
+	XmlNS   string           `xml:"xmlns,attr,omitempty"`
+	Rules   []*LifecycleRule `xml:"Rule"`
+}
+
+type LifecycleRule struct {
+	ID                             *string                         `xml:"ID,omitempty"`
+	Prefix                         *string                         `xml:"Prefix,omitempty"`
+	Filter                         *LifecycleFilter                `xml:"Filter,omitempty"`
+	Status                         string                          `xml:"Status"`
+	Expiration                     *LifecycleExpiration            `xml:"Expiration,omitempty"`
+	Transitions                    []*LifecycleTransition          `xml:"Transition,omitempty"`
+	NoncurrentVersionExpiration    *NoncurrentVersionExpiration    `xml:"NoncurrentVersionExpiration,omitempty"`
+	NoncurrentVersionTransitions   []*NoncurrentVersionTransition  `xml:"NoncurrentVersionTransition,omitempty"`
+	AbortIncompleteMultipartUpload *AbortIncompleteMultipartUpload `xml:"AbortIncompleteMultipartUpload,omitempty"`
+}
+
+type LifecycleFilter struct {
+	Prefix *string       `xml:"Prefix,omitempty"`
+	Tag    *Tag          `xml:"Tag,omitempty"`
+	And    *LifecycleAnd `xml:"And,omitempty"`
+}
+
+type LifecycleAnd struct {
+	Prefix *string `xml:"Prefix,omitempty"`
+	Tags   []*Tag  `xml:"Tag,omitempty"`
+}
+
+type Tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+type LifecycleExpiration struct {
+	Date                      *string `xml:"Date,omitempty"`
+	Days                      *int64  `xml:"Days,omitempty"`
+	ExpiredObjectDeleteMarker *bool   `xml:"ExpiredObjectDeleteMarker,omitempty"`
+}
+
+type LifecycleTransition struct {
+	Date         *string `xml:"Date,omitempty"`
+	Days         *int64  `xml:"Days,omitempty"`
+	StorageClass string  `xml:"StorageClass"`
+}
+
+type NoncurrentVersionExpiration struct {
+	NoncurrentDays *int64 `xml:"NoncurrentDays"`
+}
+
+type NoncurrentVersionTransition struct {
+	NoncurrentDays *int64 `xml:"NoncurrentDays"`
+	StorageClass   string `xml:"StorageClass"`
+}
+
+type AbortIncompleteMultipartUpload struct {
+	DaysAfterInitiation *int64 `xml:"DaysAfterInitiation"`
+}
+
+type CORSConfiguration struct {
+	XMLName xml.Name    `xml:"CORSConfiguration"`
+	XmlNS   string      `xml:"xmlns,attr,omitempty"`
+	Rules   []*CORSRule `xml:"CORSRule"`
+}
+
+type CORSRule struct {
+	ID             *string  `xml:"ID,omitempty"`
+	AllowedHeaders []string `xml:"AllowedHeader,omitempty"`
+	AllowedMethods []string `xml:"AllowedMethod"`
+	AllowedOrigins []string `xml:"AllowedOrigin"`
+	ExposeHeaders  []string `xml:"ExposeHeader,omitempty"`
+	MaxAgeSeconds  *int64   `xml:"MaxAgeSeconds,omitempty"`
+}
+
+// S3 bucket policy document, which is JSON rather than XML
+type BucketPolicy struct {
+	Version   string                   `json:"Version,omitempty"`
+	Id        string                   `json:"Id,omitempty"`
+	Statement []*BucketPolicyStatement `json:"Statement"`
+}
+
+// Principal, Action and Resource can each be a single value or a list, and Principal can also be a map
+type BucketPolicyStatement struct {
+	Sid       string      `json:"Sid,omitempty"`
+	Effect    string      `json:"Effect"`
+	Principal interface{} `json:"Principal,omitempty"`
+	Action    interface{} `json:"Action,omitempty"`
+	NotAction interface{} `json:"NotAction,omitempty"`
+	Resource  interface{} `json:"Resource,omitempty"`
+	Condition interface{} `json:"Condition,omitempty"`
+}
+
+type S3Error struct {
+	XMLName    xml.Name `xml:"Error"`
+	Code       string   `xml:"Code"`
+	Message    string   `xml:"Message"`
+	BucketName string   `xml:"BucketName,omitempty"`
+	Key        string   `xml:"Key,omitempty"`
+	Resource   string   `xml:"Resource,omitempty"`
+	RequestId  string   `xml:"RequestId"`
+}
+
+type ObjectLockConfiguration struct {
+	XMLName           xml.Name        `xml:"ObjectLockConfiguration"`
+	XmlNS             string          `xml:"xmlns,attr,omitempty"`
+	ObjectLockEnabled string          `xml:"ObjectLockEnabled,omitempty"`
+	Rule              *ObjectLockRule `xml:"Rule,omitempty"`
+}
+
+type ObjectLockRule struct {
+	DefaultRetention *DefaultRetention `xml:"DefaultRetention"`
+}
+
+type DefaultRetention struct {
+	Mode  string `xml:"Mode,omitempty"`
+	Days  *int64 `xml:"Days,omitempty"`
+	Years *int64 `xml:"Years,omitempty"`
+}
+
+type ObjectLockRetention struct {
+	XMLName         xml.Name `xml:"Retention"`
+	XmlNS           string   `xml:"xmlns,attr,omitempty"`
+	Mode            string   `xml:"Mode,omitempty"`
+	RetainUntilDate string   `xml:"RetainUntilDate,omitempty"`
+}
+
+type ObjectLockLegalHold struct {
+	XMLName xml.Name `xml:"LegalHold"`
+	XmlNS   string   `xml:"xmlns,attr,omitempty"`
+	Status  string   `xml:"Status"`
+}
+
+type RestoreRequest struct {
+	XMLName              xml.Name              `xml:"RestoreRequest"`
+	Days                 *int64                `xml:"Days"`
+	GlacierJobParameters *GlacierJobParameters `xml:"GlacierJobParameters"`
+}
+
+type GlacierJobParameters struct {
+	Tier string `xml:"Tier"`
+}
+
+type SelectObjectContentRequest struct {
+	XMLName             xml.Name                  `xml:"SelectObjectContentRequest"`
+	Bucket              string                    `xml:"-"`
+	Key                 string                    `xml:"-"`
+	Expression          string                    `xml:"Expression"`
+	ExpressionType      string                    `xml:"ExpressionType"`
+	RequestProgress     *SelectRequestProgress    `xml:"RequestProgress,omitempty"`
+	InputSerialization  SelectInputSerialization  `xml:"InputSerialization"`
+	OutputSerialization SelectOutputSerialization `xml:"OutputSerialization"`
+	ScanRange           *SelectScanRange          `xml:"ScanRange,omitempty"`
+}
+
+type SelectRequestProgress struct {
+	Enabled bool `xml:"Enabled"`
+}
+
+type SelectInputSerialization struct {
+	CompressionType string          `xml:"CompressionType,omitempty"`
+	CSV             *SelectCSVInput `xml:"CSV,omitempty"`
+	JSON            *SelectJSON     `xml:"JSON,omitempty"`
+	Parquet         *struct{}       `xml:"Parquet,omitempty"`
+}
+
+type SelectCSVInput struct {
+	Comments             string `xml:"Comments,omitempty"`
+	FieldDelimiter       string `xml:"FieldDelimiter,omitempty"`
+	FileHeaderInfo       string `xml:"FileHeaderInfo,omitempty"`
+	QuoteCharacter       string `xml:"QuoteCharacter,omitempty"`
+	QuoteEscapeCharacter string `xml:"QuoteEscapeCharacter,omitempty"`
+	RecordDelimiter      string `xml:"RecordDelimiter,omitempty"`
+}
+
+type SelectJSON struct {
+	Type            string `xml:"Type,omitempty"`
+	RecordDelimiter string `xml:"RecordDelimiter,omitempty"`
+}
+
+type SelectOutputSerialization struct {
+	CSV  *SelectCSVOutput `xml:"CSV,omitempty"`
+	JSON *SelectJSON      `xml:"JSON,omitempty"`
+}
+
+type SelectCSVOutput struct {
+	FieldDelimiter       string `xml:"FieldDelimiter,omitempty"`
+	QuoteCharacter       string `xml:"QuoteCharacter,omitempty"`
+	QuoteEscapeCharacter string `xml:"QuoteEscapeCharacter,omitempty"`
+	QuoteFields          string `xml:"QuoteFields,omitempty"`
+	RecordDelimiter      string `xml:"RecordDelimiter,omitempty"`
+}
+
+type SelectScanRange struct {
+	Start *int64 `xml:"Start,omitempty"`
+	End   *int64 `xml:"End,omitempty"`
+}
+
+// Byte counts reported in select stats and progress events
+type SelectStats struct {
+	XMLName        xml.Name
+	BytesScanned   int64 `xml:"BytesScanned"`
+	BytesProcessed int64 `xml:"BytesProcessed"`
+	BytesReturned  int64 `xml:"BytesReturned"`
+}
+
+// An S3 error code along with the http status and message AWS sends with it
+type S3ErrorCode struct {
+	Code       string
+	StatusCode int
+	Message    string
+}
+
+var (
+	S3AccessDenied          = S3ErrorCode{"AccessDenied", http.StatusForbidden, "Access Denied"}
+	S3BucketAlreadyExists   = S3ErrorCode{"BucketAlreadyExists", http.StatusConflict, "The requested bucket name is not available."}
+	S3BucketNotEmpty        = S3ErrorCode{"BucketNotEmpty", http.StatusConflict, "The bucket you tried to delete is not empty."}
+	S3EntityTooLarge        = S3ErrorCode{"EntityTooLarge", http.StatusBadRequest, "Your proposed upload exceeds the maximum allowed size."}
+	S3InternalError         = S3ErrorCode{"InternalError", http.StatusInternalServerError, "We encountered an internal error. Please try again."}
+	S3InvalidArgument       = S3ErrorCode{"InvalidArgument", http.StatusBadRequest, "Invalid Argument"}
+	S3InvalidBucketName     = S3ErrorCode{"InvalidBucketName", http.StatusBadRequest, "The specified bucket is not valid."}
+	S3InvalidPart           = S3ErrorCode{"InvalidPart", http.StatusBadRequest, "One or more of the specified parts could not be found."}
+	S3InvalidPartNumber     = S3ErrorCode{"InvalidPartNumber", http.StatusRequestedRangeNotSatisfiable, "The requested partnumber is not satisfiable"}
+	S3InvalidRange          = S3ErrorCode{"InvalidRange", http.StatusRequestedRangeNotSatisfiable, "The requested range is not satisfiable"}
+	S3InvalidRequest        = S3ErrorCode{"InvalidRequest", http.StatusBadRequest, "Invalid Request"}
+	S3MalformedACLError     = S3ErrorCode{"MalformedACLError", http.StatusBadRequest, "The XML you provided was not well-formed or did not validate against our published schema."}
+	S3MalformedPolicy       = S3ErrorCode{"MalformedPolicy", http.StatusBadRequest, "Policies must be valid JSON and the first byte must be '{'"}
+	S3MalformedXML          = S3ErrorCode{"MalformedXML", http.StatusBadRequest, "The XML you provided was not well-formed or did not validate against our published schema."}
+	S3MethodNotAllowed      = S3ErrorCode{"MethodNotAllowed", http.StatusMethodNotAllowed, "The specified method is not allowed against this resource."}
+	S3NoSuchBucket          = S3ErrorCode{"NoSuchBucket", http.StatusNotFound, "The specified bucket does not exist"}
+	S3NoSuchBucketPolicy    = S3ErrorCode{"NoSuchBucketPolicy", http.StatusNotFound, "The bucket policy does not exist"}
+	S3NoSuchCORS            = S3ErrorCode{"NoSuchCORSConfiguration", http.StatusNotFound, "The CORS configuration does not exist"}
+	S3NoSuchLifecycle       = S3ErrorCode{"NoSuchLifecycleConfiguration", http.StatusNotFound, "The lifecycle configuration does not exist"}
+	S3NoSuchKey             = S3ErrorCode{"NoSuchKey", http.StatusNotFound, "The specified key does not exist."}
+	S3NoSuchUpload          = S3ErrorCode{"NoSuchUpload", http.StatusNotFound, "The specified upload does not exist."}
+	S3NoSuchVersion         = S3ErrorCode{"NoSuchVersion", http.StatusNotFound, "The specified version does not exist."}
+	S3NotImplemented        = S3ErrorCode{"NotImplemented", http.StatusNotImplemented, "A header you provided implies functionality that is not implemented."}
+	S3NotModified           = S3ErrorCode{"NotModified", http.StatusNotModified, "Not Modified"}
+	S3PreconditionFailed    = S3ErrorCode{"PreconditionFailed", http.StatusPreconditionFailed, "At least one of the pre-conditions you specified did not hold"}
+	S3ServiceUnavailable    = S3ErrorCode{"ServiceUnavailable", http.StatusServiceUnavailable, "Please reduce your request rate."}
+	S3SlowDown              = S3ErrorCode{"SlowDown", http.StatusServiceUnavailable, "Please reduce your request rate."}
+	S3RequestTimeout        = S3ErrorCode{"RequestTimeout", http.StatusBadRequest, "Your socket connection to the server was not read from or written to within the timeout period."}
+	S3BadDigest             = S3ErrorCode{"BadDigest", http.StatusBadRequest, "The Content-MD5 you specified did not match what we received."}
+	S3IncompleteBody        = S3ErrorCode{"IncompleteBody", http.StatusBadRequest, "You did not provide the number of bytes specified by the Content-Length HTTP header."}
+	S3MissingContentLength  = S3ErrorCode{"MissingContentLength", http.StatusLengthRequired, "You must provide the Content-Length HTTP header."}
+	S3InvalidObjectState    = S3ErrorCode{"InvalidObjectState", http.StatusForbidden, "The operation is not valid for the current state of the object."}
+	S3OperationAborted      = S3ErrorCode{"OperationAborted", http.StatusConflict, "A conflicting conditional operation is currently in progress against this resource. Try again."}
+	S3InvalidStorageClass   = S3ErrorCode{"InvalidStorageClass", http.StatusBadRequest, "The storage class you specified is not valid."}
+	S3InvalidDigest         = S3ErrorCode{"InvalidDigest", http.StatusBadRequest, "The Content-MD5 you specified is not valid."}
+	S3SignatureDoesNotMatch = S3ErrorCode{"SignatureDoesNotMatch", http.StatusForbidden, "The request signature we calculated does not match the signature you provided."}
+	S3InvalidExpressionType = S3ErrorCode{"InvalidExpressionType", http.StatusBadRequest, "The ExpressionType is invalid. Only SQL expressions are supported."}
+	S3InvalidCompression    = S3ErrorCode{"InvalidCompressionFormat", http.StatusBadRequest, "The file is not in a supported compression format. Only GZIP and BZIP2 are supported."}
+	S3ParseUnexpectedToken  = S3ErrorCode{"ParseUnexpectedToken", http.StatusBadRequest, "Unexpected token found while parsing SQL expression."}
+	S3UnsupportedSQL        = S3ErrorCode{"UnsupportedSqlOperation", http.StatusBadRequest, "Encountered an unsupported SQL operation."}
+	S3CSVParsingError       = S3ErrorCode{"CSVParsingError", http.StatusBadRequest, "Encountered an error parsing the CSV file."}
+	S3JSONParsingError      = S3ErrorCode{"JSONParsingError", http.StatusBadRequest, "Encountered an error parsing the JSON file."}
+	S3InvalidAccessKeyId    = S3ErrorCode{"InvalidAccessKeyId", http.StatusForbidden, "The AWS Access Key Id you provided does not exist in our records."}
+	S3NoSuchObjectLock      = S3ErrorCode{"ObjectLockConfigurationNotFoundError", http.StatusNotFound, "Object Lock configuration does not exist for this bucket"}
+	S3NoSuchObjectRetention = S3ErrorCode{"NoSuchObjectLockConfiguration", http.StatusNotFound, "The specified object does not have a ObjectLock configuration"}
+	S3InvalidBucketState    = S3ErrorCode{"InvalidBucketState", http.StatusConflict, "The request is not valid with the current state of the bucket."}
+	S3RequestTimeTooSkewed  = S3ErrorCode{"RequestTimeTooSkewed", http.StatusForbidden, "The difference between the request time and the current time is too large."}
+	S3ContentSHA256Mismatch = S3ErrorCode{"XAmzContentSHA256Mismatch", http.StatusBadRequest, "The provided 'x-amz-content-sha256' header does not match what was computed."}
+)
+
+// All known error codes
+var s3ErrorCodeList = []S3ErrorCode{
+	S3AccessDenied, S3BucketAlreadyExists, S3BucketNotEmpty, S3EntityTooLarge, S3InternalError, S3InvalidArgument,
+	S3InvalidBucketName, S3InvalidPart, S3InvalidPartNumber, S3InvalidRange, S3InvalidRequest, S3MalformedACLError, S3MalformedPolicy, S3MalformedXML, S3MethodNotAllowed,
+	S3NoSuchBucket, S3NoSuchBucketPolicy, S3NoSuchCORS, S3NoSuchLifecycle, S3NoSuchKey, S3NoSuchUpload, S3NoSuchVersion, S3NotImplemented, S3NotModified, S3PreconditionFailed,
+	S3ServiceUnavailable, S3SlowDown, S3RequestTimeout, S3BadDigest, S3IncompleteBody, S3MissingContentLength,
+	S3InvalidObjectState, S3OperationAborted, S3InvalidStorageClass, S3InvalidDigest, S3SignatureDoesNotMatch,
+	S3InvalidExpressionType, S3InvalidCompression, S3ParseUnexpectedToken, S3UnsupportedSQL, S3CSVParsingError, S3JSONParsingError,
+	S3InvalidAccessKeyId, S3NoSuchObjectLock, S3NoSuchObjectRetention, S3InvalidBucketState, S3RequestTimeTooSkewed,
+	S3ContentSHA256Mismatch,
+}
+
+// Find a known error by its S3 code
+func LookupS3ErrorCode(code string) (S3ErrorCode, bool) {
+	for _, errorCode := range s3ErrorCodeList {
+		if errorCode.Code == code {
+			return errorCode, true
+		}
+	}
+	return S3ErrorCode{}, false
 }
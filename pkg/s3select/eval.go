@@ -0,0 +1,172 @@
+package s3select
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A value is nil, a string, a float64, a bool or, for json, an object or a list
+type value interface{}
+
+// A record a statement is evaluated against
+type record interface {
+	// value of a column path, false when the record has no such column
+	get(path []string) (value, bool)
+	// every column in order, for select *
+	columns() ([]string, []value)
+}
+
+type expr interface {
+	eval(rec record) value
+}
+
+type literal struct {
+	value value
+}
+
+func (l *literal) eval(rec record) value {
+	return l.value
+}
+
+type columnRef struct {
+	path []string
+}
+
+func (c *columnRef) eval(rec record) value {
+	v, _ := rec.get(c.path)
+	return v
+}
+
+type logical struct {
+	and   bool
+	left  expr
+	right expr
+}
+
+func (l *logical) eval(rec record) value {
+	left := truthy(l.left.eval(rec))
+	if l.and {
+		return left && truthy(l.right.eval(rec))
+	}
+	return left || truthy(l.right.eval(rec))
+}
+
+type not struct {
+	inner expr
+}
+
+func (n *not) eval(rec record) value {
+	return !truthy(n.inner.eval(rec))
+}
+
+type isNull struct {
+	value  expr
+	negate bool
+}
+
+func (i *isNull) eval(rec record) value {
+	null := i.value.eval(rec) == nil
+	return null != i.negate
+}
+
+type like struct {
+	value   expr
+	pattern *regexp.Regexp
+	negate  bool
+}
+
+func (l *like) eval(rec record) value {
+	v := l.value.eval(rec)
+	if v == nil {
+		return false
+	}
+	return l.pattern.MatchString(formatValue(v)) != l.negate
+}
+
+type comparison struct {
+	op    string
+	left  expr
+	right expr
+}
+
+// Compare two values.  Anything compared with null is false, numbers compare as numbers, which includes csv
+// text that parses as one when the other side is a number, and everything else compares as text
+func (c *comparison) eval(rec record) value {
+	left := c.left.eval(rec)
+	right := c.right.eval(rec)
+	if left == nil || right == nil {
+		return false
+	}
+	var order int
+	leftNumber, leftOk := toNumber(left)
+	rightNumber, rightOk := toNumber(right)
+	_, leftIsNumber := left.(float64)
+	_, rightIsNumber := right.(float64)
+	leftBool, leftIsBool := left.(bool)
+	rightBool, rightIsBool := right.(bool)
+	switch {
+	case leftOk && rightOk && (leftIsNumber || rightIsNumber):
+		if leftNumber < rightNumber {
+			order = -1
+		} else if leftNumber > rightNumber {
+			order = 1
+		}
+	case leftIsBool && rightIsBool:
+		if leftBool != rightBool {
+			if c.op == "=" {
+				return false
+			}
+			return c.op == "!=" || c.op == "<>"
+		}
+	default:
+		order = strings.Compare(formatValue(left), formatValue(right))
+	}
+	switch c.op {
+	case "=":
+		return order == 0
+	case "!=", "<>":
+		return order != 0
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	case ">=":
+		return order >= 0
+	}
+	return false
+}
+
+func truthy(v value) bool {
+	b, ok := v.(bool)
+	return ok && b
+}
+
+func toNumber(v value) (float64, bool) {
+	switch typed := v.(type) {
+	case float64:
+		return typed, true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(typed), 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+// Text of a value as it is written to csv output
+func formatValue(v value) string {
+	switch typed := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(typed)
+	}
+	output, _ := marshalValue(v)
+	return string(output)
+}
@@ -0,0 +1,74 @@
+package s3select
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// Header value type for strings in the event stream encoding
+const eventStreamString = 7
+
+type eventHeader struct {
+	name  string
+	value string
+}
+
+// Write one message of the AWS event stream encoding.  A message is its total and header lengths, a checksum of
+// those, the headers, the payload and a checksum of everything before it
+func writeMessage(writer io.Writer, headers []eventHeader, payload []byte) error {
+	var headerBytes bytes.Buffer
+	for _, header := range headers {
+		headerBytes.WriteByte(byte(len(header.name)))
+		headerBytes.WriteString(header.name)
+		headerBytes.WriteByte(eventStreamString)
+		binary.Write(&headerBytes, binary.BigEndian, uint16(len(header.value)))
+		headerBytes.WriteString(header.value)
+	}
+	var message bytes.Buffer
+	totalLength := 12 + headerBytes.Len() + len(payload) + 4
+	binary.Write(&message, binary.BigEndian, uint32(totalLength))
+	binary.Write(&message, binary.BigEndian, uint32(headerBytes.Len()))
+	binary.Write(&message, binary.BigEndian, crc32.ChecksumIEEE(message.Bytes()))
+	message.Write(headerBytes.Bytes())
+	message.Write(payload)
+	binary.Write(&message, binary.BigEndian, crc32.ChecksumIEEE(message.Bytes()))
+	_, err := writer.Write(message.Bytes())
+	return err
+}
+
+// Event carrying a chunk of result records
+func writeRecords(writer io.Writer, payload []byte) error {
+	return writeMessage(writer, []eventHeader{
+		{":event-type", "Records"},
+		{":content-type", "application/octet-stream"},
+		{":message-type", "event"},
+	}, payload)
+}
+
+// Event carrying xml, used for stats and progress
+func writeXMLEvent(writer io.Writer, eventType string, payload []byte) error {
+	return writeMessage(writer, []eventHeader{
+		{":event-type", eventType},
+		{":content-type", "text/xml"},
+		{":message-type", "event"},
+	}, payload)
+}
+
+// Event marking the end of the results
+func writeEnd(writer io.Writer) error {
+	return writeMessage(writer, []eventHeader{
+		{":event-type", "End"},
+		{":message-type", "event"},
+	}, nil)
+}
+
+// Error message, sent in place of the end event when the select fails part way
+func writeError(writer io.Writer, code string, message string) error {
+	return writeMessage(writer, []eventHeader{
+		{":error-code", code},
+		{":error-message", message},
+		{":message-type", "error"},
+	}, nil)
+}
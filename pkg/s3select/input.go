@@ -0,0 +1,321 @@
+package s3select
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Reads records one at a time, io.EOF after the last
+type recordReader interface {
+	next() (record, error)
+}
+
+// A json object that remembers the order of its keys
+type object struct {
+	keys   []string
+	values map[string]value
+}
+
+// Value of a key, falling back to a case insensitive match like S3 does for unquoted names
+func (o *object) get(key string) (value, bool) {
+	if v, ok := o.values[key]; ok {
+		return v, true
+	}
+	for _, name := range o.keys {
+		if strings.EqualFold(name, key) {
+			return o.values[name], true
+		}
+	}
+	return nil, false
+}
+
+type csvRecord struct {
+	fields []string
+	// header names to field positions, nil when the file has no header in use
+	header map[string]int
+	names  []string
+}
+
+func (c *csvRecord) get(path []string) (value, bool) {
+	if len(path) != 1 {
+		return nil, false
+	}
+	name := path[0]
+	index := -1
+	if position, ok := c.header[name]; ok {
+		index = position
+	} else if position, err := strconv.Atoi(strings.TrimPrefix(name, "_")); err == nil && strings.HasPrefix(name, "_") {
+		// positional columns count from one
+		index = position - 1
+	} else {
+		for headerName, position := range c.header {
+			if strings.EqualFold(headerName, name) {
+				index = position
+				break
+			}
+		}
+	}
+	if index < 0 || index >= len(c.fields) {
+		return nil, false
+	}
+	return c.fields[index], true
+}
+
+func (c *csvRecord) columns() ([]string, []value) {
+	names := make([]string, len(c.fields))
+	values := make([]value, len(c.fields))
+	for i, field := range c.fields {
+		if i < len(c.names) {
+			names[i] = c.names[i]
+		} else {
+			names[i] = fmt.Sprintf("_%d", i+1)
+		}
+		values[i] = field
+	}
+	return names, values
+}
+
+type jsonRecord struct {
+	value value
+}
+
+func (j *jsonRecord) get(path []string) (value, bool) {
+	current := j.value
+	for _, key := range path {
+		obj, ok := current.(*object)
+		if !ok {
+			return nil, false
+		}
+		if current, ok = obj.get(key); !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func (j *jsonRecord) columns() ([]string, []value) {
+	if obj, ok := j.value.(*object); ok {
+		values := make([]value, len(obj.keys))
+		for i, key := range obj.keys {
+			values[i] = obj.values[key]
+		}
+		return obj.keys, values
+	}
+	return []string{"_1"}, []value{j.value}
+}
+
+type csvReader struct {
+	reader *csv.Reader
+	header map[string]int
+	names  []string
+}
+
+// Csv records, using the first line as column names when the header info is USE and skipping it for IGNORE
+func newCSVReader(input io.Reader, fieldDelimiter string, recordDelimiter string, comments string, headerInfo string) (*csvReader, error) {
+	switch recordDelimiter {
+	case "", "\n", "\r\n":
+	default:
+		if len(recordDelimiter) != 1 {
+			return nil, errors.New("only single character record delimiters are supported")
+		}
+		input = &delimiterReader{reader: bufio.NewReader(input), delimiter: recordDelimiter[0]}
+	}
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	if fieldDelimiter != "" {
+		runes := []rune(fieldDelimiter)
+		if len(runes) != 1 {
+			return nil, errors.New("only single character field delimiters are supported")
+		}
+		reader.Comma = runes[0]
+	}
+	if comments != "" {
+		reader.Comment = []rune(comments)[0]
+	}
+	csvInput := &csvReader{reader: reader}
+	switch strings.ToUpper(headerInfo) {
+	case "", "NONE":
+	case "USE", "IGNORE":
+		header, err := reader.Read()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if strings.ToUpper(headerInfo) == "USE" {
+			csvInput.header = make(map[string]int)
+			for i, name := range header {
+				csvInput.header[name] = i
+			}
+			csvInput.names = header
+		}
+	default:
+		return nil, fmt.Errorf("invalid FileHeaderInfo %s", headerInfo)
+	}
+	return csvInput, nil
+}
+
+func (c *csvReader) next() (record, error) {
+	fields, err := c.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	return &csvRecord{fields: fields, header: c.header, names: c.names}, nil
+}
+
+// Rewrites a single character record delimiter as a newline so the csv reader can split records
+type delimiterReader struct {
+	reader    *bufio.Reader
+	delimiter byte
+}
+
+func (d *delimiterReader) Read(p []byte) (int, error) {
+	n, err := d.reader.Read(p)
+	for i := 0; i < n; i++ {
+		if p[i] == d.delimiter {
+			p[i] = '\n'
+		}
+	}
+	return n, err
+}
+
+type jsonReader struct {
+	decoder *json.Decoder
+	// elements of a top level array still to be returned
+	pending []value
+}
+
+// Json records, each top level value is one.  A top level array is a list of records
+func newJSONReader(input io.Reader) *jsonReader {
+	decoder := json.NewDecoder(input)
+	decoder.UseNumber()
+	return &jsonReader{decoder: decoder}
+}
+
+func (j *jsonReader) next() (record, error) {
+	if len(j.pending) > 0 {
+		v := j.pending[0]
+		j.pending = j.pending[1:]
+		return &jsonRecord{v}, nil
+	}
+	v, err := decodeValue(j.decoder)
+	if err != nil {
+		return nil, err
+	}
+	if list, ok := v.([]value); ok {
+		j.pending = list
+		return j.next()
+	}
+	return &jsonRecord{v}, nil
+}
+
+// Decode the next json value keeping object key order
+func decodeValue(decoder *json.Decoder) (value, error) {
+	t, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch typed := t.(type) {
+	case json.Delim:
+		switch typed {
+		case '{':
+			obj := &object{values: make(map[string]value)}
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, ok := keyToken.(string)
+				if !ok {
+					return nil, errors.New("invalid object key")
+				}
+				v, err := decodeValue(decoder)
+				if err != nil {
+					return nil, unexpectedEOF(err)
+				}
+				if _, exists := obj.values[key]; !exists {
+					obj.keys = append(obj.keys, key)
+				}
+				obj.values[key] = v
+			}
+			_, err := decoder.Token()
+			return obj, unexpectedEOF(err)
+		case '[':
+			list := make([]value, 0)
+			for decoder.More() {
+				v, err := decodeValue(decoder)
+				if err != nil {
+					return nil, unexpectedEOF(err)
+				}
+				list = append(list, v)
+			}
+			_, err := decoder.Token()
+			return list, unexpectedEOF(err)
+		}
+		return nil, fmt.Errorf("unexpected %s", typed)
+	case json.Number:
+		return typed.Float64()
+	}
+	return t, nil
+}
+
+// An object or list cut off by the end of input is an error, not the end of the records
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Json text of a value, keeping object key order
+func marshalValue(v value) ([]byte, error) {
+	switch typed := v.(type) {
+	case *object:
+		var buffer bytes.Buffer
+		buffer.WriteByte('{')
+		for i, key := range typed.keys {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			if err := writeJSONField(&buffer, key, typed.values[key]); err != nil {
+				return nil, err
+			}
+		}
+		buffer.WriteByte('}')
+		return buffer.Bytes(), nil
+	case []value:
+		var buffer bytes.Buffer
+		buffer.WriteByte('[')
+		for i, item := range typed {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			output, err := marshalValue(item)
+			if err != nil {
+				return nil, err
+			}
+			buffer.Write(output)
+		}
+		buffer.WriteByte(']')
+		return buffer.Bytes(), nil
+	}
+	return json.Marshal(v)
+}
+
+func writeJSONField(buffer *bytes.Buffer, key string, v value) error {
+	name, _ := json.Marshal(key)
+	buffer.Write(name)
+	buffer.WriteByte(':')
+	output, err := marshalValue(v)
+	if err != nil {
+		return err
+	}
+	buffer.Write(output)
+	return nil
+}
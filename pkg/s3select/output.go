@@ -0,0 +1,84 @@
+package s3select
+
+import (
+	"bytes"
+	"cloudsidecar/pkg/response_type"
+	"strings"
+)
+
+// Writes one result record
+type recordWriter interface {
+	write(buffer *bytes.Buffer, names []string, values []value) error
+}
+
+type csvWriter struct {
+	fieldDelimiter  string
+	recordDelimiter string
+	quote           string
+	escape          string
+	alwaysQuote     bool
+}
+
+func newCSVWriter(config *response_type.SelectCSVOutput) *csvWriter {
+	writer := &csvWriter{fieldDelimiter: ",", recordDelimiter: "\n", quote: "\"", escape: "\""}
+	if config.FieldDelimiter != "" {
+		writer.fieldDelimiter = config.FieldDelimiter
+	}
+	if config.RecordDelimiter != "" {
+		writer.recordDelimiter = config.RecordDelimiter
+	}
+	if config.QuoteCharacter != "" {
+		writer.quote = config.QuoteCharacter
+	}
+	if config.QuoteEscapeCharacter != "" {
+		writer.escape = config.QuoteEscapeCharacter
+	}
+	writer.alwaysQuote = strings.ToUpper(config.QuoteFields) == "ALWAYS"
+	return writer
+}
+
+func (c *csvWriter) write(buffer *bytes.Buffer, names []string, values []value) error {
+	for i, v := range values {
+		if i > 0 {
+			buffer.WriteString(c.fieldDelimiter)
+		}
+		field := formatValue(v)
+		if c.alwaysQuote || strings.Contains(field, c.fieldDelimiter) || strings.Contains(field, c.quote) ||
+			strings.ContainsAny(field, "\r\n") || strings.Contains(field, c.recordDelimiter) {
+			buffer.WriteString(c.quote)
+			buffer.WriteString(strings.Replace(field, c.quote, c.escape+c.quote, -1))
+			buffer.WriteString(c.quote)
+		} else {
+			buffer.WriteString(field)
+		}
+	}
+	buffer.WriteString(c.recordDelimiter)
+	return nil
+}
+
+type jsonWriter struct {
+	recordDelimiter string
+}
+
+func newJSONWriter(config *response_type.SelectJSON) *jsonWriter {
+	writer := &jsonWriter{recordDelimiter: "\n"}
+	if config.RecordDelimiter != "" {
+		writer.recordDelimiter = config.RecordDelimiter
+	}
+	return writer
+}
+
+func (j *jsonWriter) write(buffer *bytes.Buffer, names []string, values []value) error {
+	buffer.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		if err := writeJSONField(buffer, name, values[i]); err != nil {
+			return err
+		}
+	}
+	buffer.WriteByte('}')
+	buffer.WriteString(j.recordDelimiter)
+	return nil
+}
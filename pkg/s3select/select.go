@@ -0,0 +1,201 @@
+package s3select
+
+import (
+	"bytes"
+	"cloudsidecar/pkg/response_type"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Results are sent once this much is buffered
+const recordsChunkSize = 64 * 1024
+
+// A select request that can't be run, with the S3 error to report
+type Error struct {
+	Code    response_type.S3ErrorCode
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Code.Code + ": " + e.Message
+}
+
+// A validated select request, ready to run over an object
+type Selection struct {
+	query   *Query
+	request *response_type.SelectObjectContentRequest
+}
+
+// Check a select request and parse its expression
+func New(request *response_type.SelectObjectContentRequest) (*Selection, error) {
+	if strings.ToUpper(request.ExpressionType) != "SQL" {
+		return nil, &Error{response_type.S3InvalidExpressionType, response_type.S3InvalidExpressionType.Message}
+	}
+	input := request.InputSerialization
+	switch strings.ToUpper(input.CompressionType) {
+	case "", "NONE", "GZIP", "BZIP2":
+	default:
+		return nil, &Error{response_type.S3InvalidCompression, response_type.S3InvalidCompression.Message}
+	}
+	if input.Parquet != nil {
+		return nil, &Error{response_type.S3NotImplemented, "Parquet input is not supported"}
+	}
+	if request.ScanRange != nil {
+		return nil, &Error{response_type.S3NotImplemented, "Scan ranges are not supported"}
+	}
+	if (input.CSV == nil) == (input.JSON == nil) {
+		return nil, &Error{response_type.S3InvalidRequest, "Exactly one of CSV or JSON input serialization is required"}
+	}
+	output := request.OutputSerialization
+	if (output.CSV == nil) == (output.JSON == nil) {
+		return nil, &Error{response_type.S3InvalidRequest, "Exactly one of CSV or JSON output serialization is required"}
+	}
+	if input.CSV != nil && input.CSV.QuoteCharacter != "" && input.CSV.QuoteCharacter != "\"" {
+		return nil, &Error{response_type.S3NotImplemented, "Only double quotes are supported as the CSV quote character"}
+	}
+	query, err := Parse(request.Expression)
+	if err != nil {
+		return nil, &Error{response_type.S3ParseUnexpectedToken, err.Error()}
+	}
+	return &Selection{query: query, request: request}, nil
+}
+
+// Counts bytes read through it
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// Run the selection over an object and write the results as an event stream.  Failures after the stream has
+// started are sent as an error message, so only errors writing the stream are returned
+func (s *Selection) Run(body io.Reader, writer io.Writer) error {
+	scanned := &countingReader{reader: body}
+	var input io.Reader = scanned
+	switch strings.ToUpper(s.request.InputSerialization.CompressionType) {
+	case "GZIP":
+		gzipReader, err := gzip.NewReader(scanned)
+		if err != nil {
+			return writeError(writer, response_type.S3InvalidCompression.Code, err.Error())
+		}
+		defer gzipReader.Close()
+		input = gzipReader
+	case "BZIP2":
+		input = bzip2.NewReader(scanned)
+	}
+	processed := &countingReader{reader: input}
+	reader, parseError, err := s.recordReader(processed)
+	if err != nil {
+		return writeError(writer, parseError.Code, err.Error())
+	}
+	var output recordWriter
+	if s.request.OutputSerialization.CSV != nil {
+		output = newCSVWriter(s.request.OutputSerialization.CSV)
+	} else {
+		output = newJSONWriter(s.request.OutputSerialization.JSON)
+	}
+	var buffer bytes.Buffer
+	var returned, emitted, matched int64
+	flush := func() error {
+		if buffer.Len() == 0 {
+			return nil
+		}
+		returned += int64(buffer.Len())
+		if err := writeRecords(writer, buffer.Bytes()); err != nil {
+			return err
+		}
+		buffer.Reset()
+		if flusher, ok := writer.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		return nil
+	}
+	query := s.query
+	for query.Count || query.Limit < 0 || emitted < query.Limit {
+		rec, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if flushErr := flush(); flushErr != nil {
+				return flushErr
+			}
+			return writeError(writer, parseError.Code, err.Error())
+		}
+		if query.Where != nil && !truthy(query.Where.eval(rec)) {
+			continue
+		}
+		if query.Count {
+			matched++
+			continue
+		}
+		names, values := s.project(rec)
+		if err := output.write(&buffer, names, values); err != nil {
+			return writeError(writer, response_type.S3InternalError.Code, err.Error())
+		}
+		emitted++
+		if buffer.Len() >= recordsChunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if query.Count && query.Limit != 0 {
+		if err := output.write(&buffer, []string{"_1"}, []value{float64(matched)}); err != nil {
+			return writeError(writer, response_type.S3InternalError.Code, err.Error())
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	stats := response_type.SelectStats{
+		BytesScanned:   scanned.count,
+		BytesProcessed: processed.count,
+		BytesReturned:  returned,
+	}
+	if s.request.RequestProgress != nil && s.request.RequestProgress.Enabled {
+		stats.XMLName = xml.Name{Local: "Progress"}
+		progress, _ := xml.Marshal(stats)
+		if err := writeXMLEvent(writer, "Progress", progress); err != nil {
+			return err
+		}
+	}
+	stats.XMLName = xml.Name{Local: "Stats"}
+	payload, _ := xml.Marshal(stats)
+	if err := writeXMLEvent(writer, "Stats", payload); err != nil {
+		return err
+	}
+	return writeEnd(writer)
+}
+
+// Reader for the input format, along with the error code for records it can't parse
+func (s *Selection) recordReader(input io.Reader) (recordReader, response_type.S3ErrorCode, error) {
+	if csvInput := s.request.InputSerialization.CSV; csvInput != nil {
+		reader, err := newCSVReader(input, csvInput.FieldDelimiter, csvInput.RecordDelimiter, csvInput.Comments, csvInput.FileHeaderInfo)
+		return reader, response_type.S3CSVParsingError, err
+	}
+	return newJSONReader(input), response_type.S3JSONParsingError, nil
+}
+
+// Names and values a record is output as
+func (s *Selection) project(rec record) ([]string, []value) {
+	if s.query.Star {
+		return rec.columns()
+	}
+	names := make([]string, len(s.query.Columns))
+	values := make([]value, len(s.query.Columns))
+	for i, column := range s.query.Columns {
+		names[i] = column.Name
+		values[i] = column.value.eval(rec)
+	}
+	return names, values
+}
@@ -0,0 +1,152 @@
+package s3select
+
+import (
+	"bytes"
+	"cloudsidecar/pkg/response_type"
+	"compress/gzip"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+type event struct {
+	headers map[string]string
+	payload string
+}
+
+// Split an event stream back into messages, checking the framing
+func readEvents(t *testing.T, stream []byte) []event {
+	var events []event
+	for len(stream) > 0 {
+		total := binary.BigEndian.Uint32(stream[0:4])
+		headerLength := binary.BigEndian.Uint32(stream[4:8])
+		assert.Equal(t, crc32.ChecksumIEEE(stream[0:8]), binary.BigEndian.Uint32(stream[8:12]))
+		assert.Equal(t, crc32.ChecksumIEEE(stream[0:total-4]), binary.BigEndian.Uint32(stream[total-4:total]))
+		headers := make(map[string]string)
+		rest := stream[12 : 12+headerLength]
+		for len(rest) > 0 {
+			nameLength := int(rest[0])
+			name := string(rest[1 : 1+nameLength])
+			assert.Equal(t, byte(eventStreamString), rest[1+nameLength])
+			valueLength := int(binary.BigEndian.Uint16(rest[2+nameLength : 4+nameLength]))
+			headers[name] = string(rest[4+nameLength : 4+nameLength+valueLength])
+			rest = rest[4+nameLength+valueLength:]
+		}
+		events = append(events, event{headers, string(stream[12+headerLength : total-4])})
+		stream = stream[total:]
+	}
+	return events
+}
+
+func csvRequest(expression string) *response_type.SelectObjectContentRequest {
+	request := &response_type.SelectObjectContentRequest{Expression: expression, ExpressionType: "SQL"}
+	request.InputSerialization.CSV = &response_type.SelectCSVInput{FileHeaderInfo: "USE"}
+	request.OutputSerialization.CSV = &response_type.SelectCSVOutput{}
+	return request
+}
+
+func runSelect(t *testing.T, request *response_type.SelectObjectContentRequest, input string) []event {
+	selection, err := New(request)
+	assert.Nil(t, err)
+	var output bytes.Buffer
+	assert.Nil(t, selection.Run(strings.NewReader(input), &output))
+	return readEvents(t, output.Bytes())
+}
+
+const people = "name,age,city\nalice,30,nyc\nbob,25,\"san francisco, ca\"\ncarol,41,nyc\n"
+
+func TestParse(t *testing.T) {
+	query, err := Parse("select s.name, s.age as years from S3Object s where s.age > 26 limit 5")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(query.Columns))
+	assert.Equal(t, "name", query.Columns[0].Name)
+	assert.Equal(t, "years", query.Columns[1].Name)
+	assert.Equal(t, int64(5), query.Limit)
+
+	query, err = Parse("SELECT COUNT(*) FROM S3Object")
+	assert.Nil(t, err)
+	assert.True(t, query.Count)
+	assert.Equal(t, int64(-1), query.Limit)
+
+	_, err = Parse("SELECT * FROM S3Object WHERE")
+	assert.NotNil(t, err)
+	_, err = Parse("DELETE FROM S3Object")
+	assert.NotNil(t, err)
+}
+
+func TestNew_Errors(t *testing.T) {
+	request := csvRequest("SELECT * FROM S3Object")
+	request.ExpressionType = "XPATH"
+	_, err := New(request)
+	assert.Equal(t, response_type.S3InvalidExpressionType, err.(*Error).Code)
+
+	request = csvRequest("SELECT * FROM S3Object")
+	request.InputSerialization.CompressionType = "ZIP"
+	_, err = New(request)
+	assert.Equal(t, response_type.S3InvalidCompression, err.(*Error).Code)
+
+	request = csvRequest("SELECT * FROM S3Object")
+	request.ScanRange = &response_type.SelectScanRange{}
+	_, err = New(request)
+	assert.Equal(t, response_type.S3NotImplemented, err.(*Error).Code)
+
+	_, err = New(csvRequest("SELECT FROM S3Object"))
+	assert.Equal(t, response_type.S3ParseUnexpectedToken, err.(*Error).Code)
+}
+
+func TestRun_CSV(t *testing.T) {
+	events := runSelect(t, csvRequest("SELECT name, city FROM S3Object WHERE age > 26 AND city = 'nyc'"), people)
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, "Records", events[0].headers[":event-type"])
+	assert.Equal(t, "alice,nyc\ncarol,nyc\n", events[0].payload)
+	assert.Equal(t, "Stats", events[1].headers[":event-type"])
+	assert.Contains(t, events[1].payload, "<BytesScanned>67</BytesScanned>")
+	assert.Contains(t, events[1].payload, "<BytesReturned>20</BytesReturned>")
+	assert.Equal(t, "End", events[2].headers[":event-type"])
+
+	events = runSelect(t, csvRequest("SELECT * FROM S3Object s WHERE s.name LIKE 'b%'"), people)
+	assert.Equal(t, "bob,25,\"san francisco, ca\"\n", events[0].payload)
+
+	events = runSelect(t, csvRequest("SELECT _1 FROM S3Object LIMIT 2"), people)
+	assert.Equal(t, "alice\nbob\n", events[0].payload)
+
+	events = runSelect(t, csvRequest("SELECT COUNT(*) FROM S3Object WHERE city <> 'nyc' OR age >= 41"), people)
+	assert.Equal(t, "2\n", events[0].payload)
+}
+
+func TestRun_JSON(t *testing.T) {
+	request := &response_type.SelectObjectContentRequest{
+		Expression:     "SELECT s.id, s.user.name FROM S3Object[*] s WHERE s.user.admin = true",
+		ExpressionType: "SQL",
+	}
+	request.InputSerialization.JSON = &response_type.SelectJSON{Type: "LINES"}
+	request.OutputSerialization.JSON = &response_type.SelectJSON{}
+	input := "{\"id\":1,\"user\":{\"name\":\"ann\",\"admin\":true}}\n{\"id\":2,\"user\":{\"name\":\"ben\",\"admin\":false}}\n"
+	events := runSelect(t, request, input)
+	assert.Equal(t, "{\"id\":1,\"name\":\"ann\"}\n", events[0].payload)
+
+	request.Expression = "SELECT * FROM S3Object"
+	events = runSelect(t, request, "{\"b\":1,\"a\":[1,2]}")
+	assert.Equal(t, "{\"b\":1,\"a\":[1,2]}\n", events[0].payload)
+
+	events = runSelect(t, request, "{\"b\":1,")
+	assert.Equal(t, "error", events[0].headers[":message-type"])
+	assert.Equal(t, "JSONParsingError", events[0].headers[":error-code"])
+}
+
+func TestRun_Gzip(t *testing.T) {
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	gzipWriter.Write([]byte(people))
+	gzipWriter.Close()
+	request := csvRequest("SELECT name FROM S3Object WHERE age < 30")
+	request.InputSerialization.CompressionType = "GZIP"
+	request.RequestProgress = &response_type.SelectRequestProgress{Enabled: true}
+	events := runSelect(t, request, compressed.String())
+	assert.Equal(t, "bob\n", events[0].payload)
+	assert.Equal(t, "Progress", events[1].headers[":event-type"])
+	assert.Contains(t, events[1].payload, "<BytesProcessed>67</BytesProcessed>")
+	assert.Equal(t, "Stats", events[2].headers[":event-type"])
+}
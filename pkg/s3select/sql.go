@@ -0,0 +1,474 @@
+// Evaluate the S3 Select SQL subset over CSV and JSON objects
+
+package s3select
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenQuotedIdent
+	tokenString
+	tokenNumber
+	tokenSymbol
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+// Words that can't be column names
+var keywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "LIMIT": true, "AND": true, "OR": true, "NOT": true, "AS": true,
+	"IS": true, "NULL": true, "TRUE": true, "FALSE": true, "LIKE": true, "COUNT": true,
+}
+
+// A parsed select statement
+type Query struct {
+	// all columns of the record
+	Star bool
+	// count of matching records instead of the records
+	Count bool
+	// projected columns when not star or count
+	Columns []*Column
+	Where   expr
+	// negative when there is no limit
+	Limit int64
+}
+
+// A projected column and the name it is output as
+type Column struct {
+	Name  string
+	value expr
+}
+
+// Split an expression into tokens
+func tokenize(input string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			// strings are single quoted, identifiers double quoted.  a doubled quote is an escaped one
+			var value strings.Builder
+			j := i + 1
+			for ; j < len(runes); j++ {
+				if runes[j] == r {
+					if j+1 < len(runes) && runes[j+1] == r {
+						value.WriteRune(r)
+						j++
+						continue
+					}
+					break
+				}
+				value.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated quote at position %d", i)
+			}
+			kind := tokenString
+			if r == '"' {
+				kind = tokenQuotedIdent
+			}
+			tokens = append(tokens, token{kind, value.String()})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]) && followsOperator(tokens)):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokenNumber, string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, token{tokenIdent, string(runes[i:j])})
+			i = j
+		default:
+			symbol := string(r)
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "!=", "<>", "<=", ">=":
+					symbol = two
+				}
+			}
+			if !strings.Contains("*,.()[]=<>!=", symbol[:1]) {
+				return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+			}
+			tokens = append(tokens, token{tokenSymbol, symbol})
+			i += len(symbol)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// A minus is a sign unless it follows something that has a value
+func followsOperator(tokens []token) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	last := tokens[len(tokens)-1]
+	return last.kind == tokenSymbol && last.value != ")" && last.value != "]" ||
+		last.kind == tokenIdent && keywords[strings.ToUpper(last.value)]
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	// alias the statement gives S3Object, stripped from column paths
+	alias string
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isKeyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && strings.EqualFold(t.value, word)
+}
+
+func (p *parser) isSymbol(symbol string) bool {
+	t := p.peek()
+	return t.kind == tokenSymbol && t.value == symbol
+}
+
+func (p *parser) expectKeyword(word string) error {
+	if !p.isKeyword(word) {
+		return p.unexpected(word)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) expectSymbol(symbol string) error {
+	if !p.isSymbol(symbol) {
+		return p.unexpected(symbol)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) unexpected(expected string) error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return fmt.Errorf("expected %s but the expression ended", expected)
+	}
+	return fmt.Errorf("expected %s but found %q", expected, t.value)
+}
+
+// Parse a select statement
+func Parse(expression string) (*Query, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	query := &Query{Limit: -1}
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	// the select list can name the alias, so find it before parsing columns
+	start := p.pos
+	for !p.isKeyword("FROM") && p.peek().kind != tokenEOF {
+		p.next()
+	}
+	if err := p.parseFrom(); err != nil {
+		return nil, err
+	}
+	afterFrom := p.pos
+	p.pos = start
+	if err := p.parseSelectList(query); err != nil {
+		return nil, err
+	}
+	if !p.isKeyword("FROM") {
+		return nil, p.unexpected("FROM")
+	}
+	p.pos = afterFrom
+	if p.isKeyword("WHERE") {
+		p.next()
+		where, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		query.Where = where
+	}
+	if p.isKeyword("LIMIT") {
+		p.next()
+		t := p.next()
+		limit, err := strconv.ParseInt(t.value, 10, 64)
+		if t.kind != tokenNumber || err != nil || limit < 0 {
+			return nil, fmt.Errorf("LIMIT must be a non negative integer, found %q", t.value)
+		}
+		query.Limit = limit
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.unexpected("the end of the expression")
+	}
+	return query, nil
+}
+
+// FROM S3Object, optionally S3Object[*] for json arrays, and an optional alias
+func (p *parser) parseFrom() error {
+	if err := p.expectKeyword("FROM"); err != nil {
+		return err
+	}
+	if !p.isKeyword("S3Object") {
+		return p.unexpected("S3Object")
+	}
+	p.next()
+	if p.isSymbol("[") {
+		p.next()
+		if err := p.expectSymbol("*"); err != nil {
+			return err
+		}
+		if err := p.expectSymbol("]"); err != nil {
+			return err
+		}
+	}
+	if p.isKeyword("AS") {
+		p.next()
+	}
+	if t := p.peek(); t.kind == tokenIdent && !keywords[strings.ToUpper(t.value)] {
+		p.alias = t.value
+		p.next()
+	}
+	return nil
+}
+
+func (p *parser) parseSelectList(query *Query) error {
+	if p.isSymbol("*") {
+		p.next()
+		query.Star = true
+		return nil
+	}
+	if p.isKeyword("COUNT") {
+		p.next()
+		if err := p.expectSymbol("("); err != nil {
+			return err
+		}
+		if err := p.expectSymbol("*"); err != nil {
+			return err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return err
+		}
+		query.Count = true
+		return nil
+	}
+	for {
+		value, err := p.parseOperand()
+		if err != nil {
+			return err
+		}
+		column := &Column{value: value, Name: fmt.Sprintf("_%d", len(query.Columns)+1)}
+		if ref, ok := value.(*columnRef); ok {
+			column.Name = ref.path[len(ref.path)-1]
+		}
+		if p.isKeyword("AS") {
+			p.next()
+			t := p.next()
+			if t.kind != tokenIdent && t.kind != tokenQuotedIdent {
+				return fmt.Errorf("expected a column alias but found %q", t.value)
+			}
+			column.Name = t.value
+		}
+		query.Columns = append(query.Columns, column)
+		if !p.isSymbol(",") {
+			return nil
+		}
+		p.next()
+	}
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.isKeyword("NOT") {
+		p.next()
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &not{inner}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.isKeyword("IS") {
+		p.next()
+		negate := false
+		if p.isKeyword("NOT") {
+			p.next()
+			negate = true
+		}
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		return &isNull{value: left, negate: negate}, nil
+	}
+	negate := false
+	if p.isKeyword("NOT") {
+		p.next()
+		negate = true
+		if !p.isKeyword("LIKE") {
+			return nil, p.unexpected("LIKE")
+		}
+	}
+	if p.isKeyword("LIKE") {
+		p.next()
+		t := p.next()
+		if t.kind != tokenString {
+			return nil, fmt.Errorf("LIKE needs a string pattern, found %q", t.value)
+		}
+		return &like{value: left, pattern: likePattern(t.value), negate: negate}, nil
+	}
+	if t := p.peek(); t.kind == tokenSymbol {
+		switch t.value {
+		case "=", "!=", "<>", "<", "<=", ">", ">=":
+			p.next()
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return &comparison{op: t.value, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseOperand() (expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenString:
+		p.next()
+		return &literal{t.value}, nil
+	case tokenNumber:
+		p.next()
+		number, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.value)
+		}
+		return &literal{number}, nil
+	case tokenSymbol:
+		if t.value == "(" {
+			p.next()
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expectSymbol(")")
+		}
+	case tokenIdent, tokenQuotedIdent:
+		if t.kind == tokenIdent {
+			switch strings.ToUpper(t.value) {
+			case "TRUE":
+				p.next()
+				return &literal{true}, nil
+			case "FALSE":
+				p.next()
+				return &literal{false}, nil
+			case "NULL":
+				p.next()
+				return &literal{nil}, nil
+			}
+			if keywords[strings.ToUpper(t.value)] {
+				return nil, p.unexpected("a column or value")
+			}
+		}
+		return p.parsePath()
+	}
+	return nil, p.unexpected("a column or value")
+}
+
+// A column path like s.name, s."first name", s.a.b or _1
+func (p *parser) parsePath() (expr, error) {
+	path := []string{p.next().value}
+	for p.isSymbol(".") {
+		p.next()
+		t := p.next()
+		if t.kind != tokenIdent && t.kind != tokenQuotedIdent {
+			return nil, fmt.Errorf("expected a field name but found %q", t.value)
+		}
+		path = append(path, t.value)
+	}
+	if len(path) > 1 && (strings.EqualFold(path[0], p.alias) || strings.EqualFold(path[0], "S3Object")) {
+		path = path[1:]
+	}
+	return &columnRef{path: path}, nil
+}
+
+// Regular expression for a LIKE pattern, % is any run of characters and _ any single one
+func likePattern(pattern string) *regexp.Regexp {
+	var expression strings.Builder
+	expression.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			expression.WriteString(".*")
+		case '_':
+			expression.WriteString(".")
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expression.WriteString("$")
+	return regexp.MustCompile(expression.String())
+}
@@ -5,6 +5,7 @@ import (
 	"cloud.google.com/go/pubsub"
 	"cloud.google.com/go/storage"
 	awshandler "cloudsidecar/pkg/aws/handler"
+	s3handler "cloudsidecar/pkg/aws/handler/s3"
 	conf "cloudsidecar/pkg/config"
 	"cloudsidecar/pkg/enterprise"
 	gcpHandler "cloudsidecar/pkg/gcp/handler"
@@ -14,11 +15,14 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"google.golang.org/api/option"
+	gtransport "google.golang.org/api/transport/grpc"
 	googleHttp "google.golang.org/api/transport/http"
+	"google.golang.org/grpc"
 	"net"
 	"net/http"
 	"plugin"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 )
@@ -36,6 +40,9 @@ var routes map[string]*RouteWrapper
 var awsServers map[string]*http.Server
 var gcpServers map[string]*http.Server
 
+// Grpc servers map, for service types spoken over grpc
+var grpcServers map[string]*grpc.Server
+
 // Creates an http client for GCP.  This is needed so we can set timeouts and not
 // share http/2 connections between gcp uses, which helps with GCS
 func httpClientForGCP(ctx context.Context, opts ...option.ClientOption) *http.Client {
@@ -67,21 +74,21 @@ func httpClientForGCP(ctx context.Context, opts ...option.ClientOption) *http.Cl
 }
 
 // GCS client based on keyfile
-func newGCPStorage(ctx context.Context, keyFileLocation string) (*storage.Client, error) {
+func newGCPStorage(ctx context.Context, keyFileLocation string) (s3handler.GCPClient, error) {
 	client := httpClientForGCP(ctx, option.WithCredentialsFile(keyFileLocation))
-	return storage.NewClient(ctx, option.WithCredentialsFile(keyFileLocation), option.WithHTTPClient(client))
+	return s3handler.NewGCPClient(ctx, option.WithCredentialsFile(keyFileLocation), option.WithHTTPClient(client))
 }
 
 // GCS client without creds.  Those will be added later
-func newGCPStorageNoCreds(ctx context.Context) (*storage.Client, error) {
+func newGCPStorageNoCreds(ctx context.Context) (s3handler.GCPClient, error) {
 	client := httpClientForGCP(ctx)
-	return storage.NewClient(ctx, option.WithHTTPClient(client))
+	return s3handler.NewGCPClient(ctx, option.WithHTTPClient(client))
 }
 
 // GCS client with raw key - string that has contents of key file
-func newGCPStorageRawKey(ctx context.Context, rawKey string) (*storage.Client, error) {
+func newGCPStorageRawKey(ctx context.Context, rawKey string) (s3handler.GCPClient, error) {
 	client := httpClientForGCP(ctx, option.WithCredentialsJSON([]byte(rawKey)))
-	return storage.NewClient(ctx, option.WithCredentialsJSON([]byte(rawKey)), option.WithHTTPClient(client))
+	return s3handler.NewGCPClient(ctx, option.WithCredentialsJSON([]byte(rawKey)), option.WithHTTPClient(client))
 }
 
 // PubSub client
@@ -94,6 +101,51 @@ func newGCPKmsClient(ctx context.Context, keyFileLocation string) (*kms.KeyManag
 	return kms.NewKeyManagementClient(ctx, option.WithCredentialsFile(keyFileLocation))
 }
 
+// Bigtable connection
+func newGCPBigtable(ctx context.Context, destination *conf.GCPDestinationConfig) (*grpc.ClientConn, error) {
+	opts := []option.ClientOption{
+		option.WithEndpoint("bigtable.googleapis.com:443"),
+		option.WithScopes("https://www.googleapis.com/auth/bigtable.data"),
+	}
+	if destination.KeyFileLocation != nil {
+		opts = append(opts, option.WithCredentialsFile(*destination.KeyFileLocation))
+	} else if destination.RawKey != nil {
+		opts = append(opts, option.WithCredentialsJSON([]byte(*destination.RawKey)))
+	}
+	return gtransport.Dial(ctx, opts...)
+}
+
+// Serve grpc services for a config.  Services can't be swapped on a running grpc server, so when the config changes the
+// old server is stopped for the new one to bind
+func listenGRPC(key string, port int, handler gcpHandler.HandlerInterface, register func(*grpc.Server), serverWaitGroup *sync.WaitGroup) {
+	serverWaitGroup.Add(1)
+	if existingSrv, ok := grpcServers[key]; ok {
+		existingSrv.Stop()
+		delete(grpcServers, key)
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		logging.Log.Error("", err)
+		if viper.GetBool("panic_on_bind_error") && strings.Contains(err.Error(), "bind: address already in use") {
+			panic("Could not bind, exiting")
+		}
+		handler.Shutdown()
+		serverWaitGroup.Done()
+		return
+	}
+	srv := grpc.NewServer()
+	register(srv)
+	grpcServers[key] = srv
+	logging.Log.Debug("Listening on %s", listener.Addr())
+	go func() {
+		if serveErr := srv.Serve(listener); serveErr != nil {
+			logging.Log.Error("", serveErr)
+		}
+		handler.Shutdown()
+		serverWaitGroup.Done()
+	}()
+}
+
 // Simple logging middleware for access log
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -136,6 +188,7 @@ func Main(config *conf.Config, onConfigChange <-chan string, cmd *cobra.Command,
 	gcpHandlers = make(map[string]gcpHandler.HandlerInterface)
 	awsServers = make(map[string]*http.Server)
 	gcpServers = make(map[string]*http.Server)
+	grpcServers = make(map[string]*grpc.Server)
 	routes = make(map[string]*RouteWrapper)
 	enterprise.RegisterType(reflect.TypeOf(enterprise.Noop{}))
 	enterpriseSystem = enterprise.GetSingleton()
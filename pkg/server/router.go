@@ -10,21 +10,29 @@ import (
 	conf "cloudsidecar/pkg/config"
 	"cloudsidecar/pkg/enterprise"
 	gcpHandler "cloudsidecar/pkg/gcp/handler"
+	bigtableHandler "cloudsidecar/pkg/gcp/handler/bigtable"
 	gcsHandler "cloudsidecar/pkg/gcp/handler/gcs"
+	gcsBatch "cloudsidecar/pkg/gcp/handler/gcs/batch"
 	gcsBucket "cloudsidecar/pkg/gcp/handler/gcs/bucket"
+	gcsNotification "cloudsidecar/pkg/gcp/handler/gcs/notification"
 	gcsObject "cloudsidecar/pkg/gcp/handler/gcs/object"
+	"cloudsidecar/pkg/gcp/handler/gcsxml"
+	pubsubHandler "cloudsidecar/pkg/gcp/handler/pubsub"
 	"cloudsidecar/pkg/logging"
 	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/defaults"
 	"github.com/aws/aws-sdk-go-v2/aws/endpoints"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/kinesis"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/gorilla/mux"
 	"github.com/spf13/viper"
 	"golang.org/x/net/http2"
+	bigtablepb "google.golang.org/genproto/googleapis/bigtable/v2"
 	"net/http"
 	"plugin"
 	"strings"
@@ -93,6 +101,24 @@ func createAWSConfigs(awsConfig *conf.AWSConfig) aws.Config {
 	return configs
 }
 
+// Function making GCS clients for a destination
+func gcpStorageClient(ctx context.Context, destination *conf.GCPDestinationConfig) func() (s3handler.GCPClient, error) {
+	if destination.KeyFileLocation != nil {
+		credInput := *destination.KeyFileLocation
+		return func() (s3handler.GCPClient, error) {
+			return newGCPStorage(ctx, credInput)
+		}
+	} else if destination.KeyFromUrl != nil && *destination.KeyFromUrl {
+		return func() (s3handler.GCPClient, error) {
+			return newGCPStorageNoCreds(ctx)
+		}
+	}
+	credInput := *destination.RawKey
+	return func() (s3handler.GCPClient, error) {
+		return newGCPStorageRawKey(ctx, credInput)
+	}
+}
+
 // Create a handler from config
 func CreateHandlerGCP(key string, gcpConfig *conf.AWSConfig, enterpriseSystem enterprise.Enterprise, serverWaitGroup *sync.WaitGroup) (handler awshandler.HandlerInterface, router *mux.Router, toListen bool) {
 	var gcpHandler gcpHandler.HandlerInterface
@@ -109,31 +135,80 @@ func CreateHandlerGCP(key string, gcpConfig *conf.AWSConfig, enterpriseSystem en
 		}
 		if gcpConfig.DestinationGCPConfig != nil {
 			// use GCS
-			var gcpClient func() (s3handler.GCPClient, error)
-			if gcpConfig.DestinationGCPConfig.KeyFileLocation != nil {
-				credInput := *gcpConfig.DestinationGCPConfig.KeyFileLocation
-				gcpClient = func() (s3handler.GCPClient, error) {
-					return newGCPStorage(ctx, credInput)
-				}
-			} else if gcpConfig.DestinationGCPConfig.KeyFromUrl != nil && *gcpConfig.DestinationGCPConfig.KeyFromUrl {
-				gcpClient = func() (s3handler.GCPClient, error) {
-					return newGCPStorageNoCreds(ctx)
-				}
-			} else {
-				credInput := *gcpConfig.DestinationGCPConfig.RawKey
-				gcpClient = func() (s3handler.GCPClient, error) {
-					return newGCPStorageRawKey(ctx, credInput)
-				}
-			}
-			handler.GCPClient = gcpClient
+			handler.GCPClient = gcpStorageClient(ctx, gcpConfig.DestinationGCPConfig)
 			handler.Context = &ctx
 		}
 		gcpHandler = &handler
 		bucketHandler := gcsBucket.New(&handler)
 		objectHandler := gcsObject.New(&handler)
+		notificationHandler := gcsNotification.New(&handler)
+		if gcpConfig.DestinationAWSConfig != nil {
+			// for events the sidecar sends itself
+			configs := createAWSConfigs(gcpConfig)
+			notificationHandler.SNSClient = sns.New(configs)
+			notificationHandler.SqsClient = sqs.New(configs)
+		}
+		objectHandler.Notifier = notificationHandler
 		// register http handlers for bucket requests and object requests
 		bucketHandler.Register(r)
 		objectHandler.Register(r)
+		notificationHandler.Register(r)
+		// batched requests are run through the same routes
+		gcsBatch.New(&handler, r).Register(r)
+	} else if gcpConfig.ServiceType == "gcs_xml" {
+		// the XML API is S3's with GCS names, so the S3 handlers serve it once headers are renamed
+		config := viper.Sub(fmt.Sprint("gcp_configs.", key))
+		handler := s3handler.NewHandler(config)
+		if gcpConfig.DestinationAWSConfig != nil {
+			configs := createAWSConfigs(gcpConfig)
+			svc := s3.New(configs)
+			handler.S3Client = svc
+		}
+		if gcpConfig.DestinationGCPConfig != nil {
+			// use GCS
+			handler.GCPClient = gcpStorageClient(ctx, gcpConfig.DestinationGCPConfig)
+			handler.Context = &ctx
+		}
+		gcpHandler = &handler
+		// headers are renamed before routing since some routes match on them
+		xmlRouter := mux.NewRouter()
+		bucket.New(&handler).Register(xmlRouter)
+		object.New(&handler).Register(xmlRouter)
+		r.PathPrefix("/").Handler(gcsxml.Middleware(config)(xmlRouter))
+	} else if gcpConfig.ServiceType == "pubsub" {
+		// spoken over grpc, so it listens itself rather than through the router
+		toListen = false
+		if gcpConfig.DestinationAWSConfig == nil {
+			logging.Log.Error("No aws destination configured for pubsub on port ", gcpConfig.Port)
+		} else {
+			handler := pubsubHandler.NewHandler(viper.Sub(fmt.Sprint("gcp_configs.", key)))
+			configs := createAWSConfigs(gcpConfig)
+			handler.SNSClient = sns.New(configs)
+			handler.SqsClient = sqs.New(configs)
+			handler.KinesisClient = kinesis.New(configs)
+			handler.Context = &ctx
+			gcpHandler = &handler
+			listenGRPC(key, gcpConfig.Port, &handler, handler.Register, serverWaitGroup)
+		}
+	} else if gcpConfig.ServiceType == "bigtable" {
+		// spoken over grpc too
+		toListen = false
+		handler := bigtableHandler.NewHandler(viper.Sub(fmt.Sprint("gcp_configs.", key)))
+		if gcpConfig.DestinationAWSConfig != nil {
+			handler.DynamoClient = dynamodb.New(createAWSConfigs(gcpConfig))
+		}
+		if gcpConfig.DestinationGCPConfig != nil {
+			// use bigtable
+			conn, err := newGCPBigtable(ctx, gcpConfig.DestinationGCPConfig)
+			if err != nil {
+				panic(fmt.Sprintln("Error setting up gcp client", err))
+			}
+			handler.GCPConn = conn
+			handler.GCPClient = bigtablepb.NewBigtableClient(conn)
+		}
+		handler.Context = &ctx
+		gcpHandler = &handler
+		listenGRPC(key, gcpConfig.Port, &handler, handler.Register, serverWaitGroup)
 	}
 	r.PathPrefix("/").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		logging.Log.Info("Catch all %s %s %s", request.URL, request.Method, request.Header)
@@ -159,23 +234,7 @@ func CreateHandlerAWS(key string, awsConfig *conf.AWSConfig, enterpriseSystem en
 		}
 		if awsConfig.DestinationGCPConfig != nil {
 			// use GCS
-			var gcpClient func() (s3handler.GCPClient, error)
-			if awsConfig.DestinationGCPConfig.KeyFileLocation != nil {
-				credInput := *awsConfig.DestinationGCPConfig.KeyFileLocation
-				gcpClient = func() (s3handler.GCPClient, error) {
-					return newGCPStorage(ctx, credInput)
-				}
-			} else if awsConfig.DestinationGCPConfig.KeyFromUrl != nil && *awsConfig.DestinationGCPConfig.KeyFromUrl {
-				gcpClient = func() (s3handler.GCPClient, error) {
-					return newGCPStorageNoCreds(ctx)
-				}
-			} else {
-				credInput := *awsConfig.DestinationGCPConfig.RawKey
-				gcpClient = func() (s3handler.GCPClient, error) {
-					return newGCPStorageRawKey(ctx, credInput)
-				}
-			}
-			handler.GCPClient = gcpClient
+			handler.GCPClient = gcpStorageClient(ctx, awsConfig.DestinationGCPConfig)
 			handler.Context = &ctx
 		}
 		bucketHandler := bucket.New(&handler)
@@ -408,6 +467,13 @@ func Listen(config *conf.Config, serverWaitGroup *sync.WaitGroup, enterpriseSyst
 			delete(awsHandlers, key)
 		}
 	}
+	for key, srv := range grpcServers {
+		if _, ok := config.GcpConfigs[key]; !ok {
+			logging.Log.Infof("Removing grpc server %s", key)
+			srv.Stop()
+			delete(grpcServers, key)
+		}
+	}
 	for key, srv := range gcpServers {
 		if _, ok := config.GcpConfigs[key]; !ok {
 			logging.Log.Infof("Removing server %s on %s", key, srv.Addr)